@@ -0,0 +1,676 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultAccountsPath = "/etc/psas/accounts.json"
+
+// psasAccount links the per-service identities of one person. Empty fields mean
+// the service is not part of the account; MTProxy is shared, so only a flag is
+// kept for it.
+type psasAccount struct {
+	Name          string `json:"name"`
+	HiddifyUUID   string `json:"hiddify_uuid,omitempty"`
+	SocksLogin    string `json:"socks_login,omitempty"`
+	TrustUsername string `json:"trust_username,omitempty"`
+	MTProxy       bool   `json:"mtproxy,omitempty"`
+	CreatedAt     string `json:"created_at,omitempty"`
+	// SubToken is the secret path segment of the subscription page (psasctl sub).
+	SubToken string `json:"sub_token,omitempty"`
+}
+
+type accountServices struct {
+	Hiddify bool
+	Socks   bool
+	Trust   bool
+	MTProxy bool
+}
+
+func accountsPath() string {
+	return envOr("PSAS_ACCOUNTS", defaultAccountsPath)
+}
+
+func loadAccounts() ([]psasAccount, error) {
+	p := accountsPath()
+	raw, err := loadStateFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(raw)) == "" {
+		return []psasAccount{}, nil
+	}
+	var accounts []psasAccount
+	if err := json.Unmarshal(raw, &accounts); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts, nil
+}
+
+func writeAccounts(accounts []psasAccount) error {
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	payload, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := accountsPath()
+	return saveStateFile(p, append(payload, '\n'), 0o600)
+}
+
+// resolveAccount finds an account by name, Hiddify UUID, SOCKS login or
+// TrustTunnel username (all exact, case-insensitive).
+func resolveAccount(accounts []psasAccount, id string) (psasAccount, int, error) {
+	key := strings.TrimSpace(id)
+	if key == "" {
+		return psasAccount{}, -1, errors.New("empty ACCOUNT")
+	}
+	for i, a := range accounts {
+		if strings.EqualFold(a.Name, key) {
+			return a, i, nil
+		}
+	}
+	var matched []int
+	for i, a := range accounts {
+		if strings.EqualFold(a.HiddifyUUID, key) || strings.EqualFold(a.SocksLogin, key) || strings.EqualFold(a.TrustUsername, key) {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 1 {
+		return accounts[matched[0]], matched[0], nil
+	}
+	if len(matched) > 1 {
+		return psasAccount{}, -1, fmt.Errorf("multiple accounts match %q", key)
+	}
+	return psasAccount{}, -1, fmt.Errorf("account not found: %s", key)
+}
+
+// findAccountByUUID returns the account owning a Hiddify user, if any.
+func findAccountByUUID(accounts []psasAccount, uuid string) (psasAccount, bool) {
+	for _, a := range accounts {
+		if a.HiddifyUUID != "" && strings.EqualFold(a.HiddifyUUID, uuid) {
+			return a, true
+		}
+	}
+	return psasAccount{}, false
+}
+
+func parseAccountServices(raw string) (accountServices, error) {
+	var s accountServices
+	for _, part := range strings.Split(raw, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "":
+		case "hiddify", "hy":
+			s.Hiddify = true
+		case "socks", "socks5":
+			s.Socks = true
+		case "trust", "trusttunnel", "tt":
+			s.Trust = true
+		case "mtproxy", "mtp":
+			s.MTProxy = true
+		default:
+			return s, fmt.Errorf("unknown service %q (expected hiddify,socks,trust,mtproxy)", strings.TrimSpace(part))
+		}
+	}
+	if !s.Hiddify && !s.Socks && !s.Trust && !s.MTProxy {
+		return s, errors.New("--services must name at least one service")
+	}
+	return s, nil
+}
+
+func (s accountServices) String() string {
+	var parts []string
+	if s.Hiddify {
+		parts = append(parts, "hiddify")
+	}
+	if s.Socks {
+		parts = append(parts, "socks")
+	}
+	if s.Trust {
+		parts = append(parts, "trust")
+	}
+	if s.MTProxy {
+		parts = append(parts, "mtproxy")
+	}
+	return strings.Join(parts, ",")
+}
+
+func accountPassword(raw string) string {
+	if pass := strings.TrimSpace(raw); pass != "" {
+		return pass
+	}
+	return newSecureToken(24)
+}
+
+func runAccounts(args []string) {
+	if len(args) < 1 {
+		fatalf("accounts requires subcommand: list|add|show|del")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "list", "ls":
+		fs := flag.NewFlagSet("accounts list", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("accounts list takes no positional args")
+		}
+		accounts, err := loadAccounts()
+		must(err)
+		if *jsonOut {
+			printJSON(accounts)
+			return
+		}
+		printAccounts(accounts)
+	case "add":
+		runAccountsAdd(subArgs)
+	case "show", "get":
+		runAccountsShow(subArgs)
+	case "del", "delete", "rm":
+		runAccountsDel(subArgs)
+	default:
+		fatalf("unknown accounts subcommand: %s", sub)
+	}
+}
+
+func runAccountsAdd(args []string) {
+	fs := flag.NewFlagSet("accounts add", flag.ExitOnError)
+	name := fs.String("name", "", "account name (also default login for every service)")
+	services := fs.String("services", "", "comma-separated services: hiddify,socks,trust,mtproxy (default: hiddify + installed ones)")
+	planName := fs.String("plan", "", "Hiddify plan template from plans.json")
+	days := fs.Int("days", 30, "Hiddify package days")
+	gb := fs.Float64("gb", 100, "Hiddify usage limit in GB")
+	mode := fs.String("mode", "no_reset", "Hiddify user mode: no_reset|daily|weekly|monthly")
+	uuid := fs.String("uuid", "", "custom Hiddify UUID (optional)")
+	socksLogin := fs.String("socks-login", "", "SOCKS login (default: --name)")
+	trustUsername := fs.String("trust-username", "", "TrustTunnel username (default: --name)")
+	password := fs.String("password", "", "password for SOCKS and TrustTunnel (empty = auto-generated per service)")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("accounts add takes only flags")
+	}
+	must(requireRoot("accounts add"))
+
+	accountName := strings.TrimSpace(*name)
+	if accountName == "" {
+		fatalf("--name is required")
+	}
+	accounts, err := loadAccounts()
+	must(err)
+	if _, _, err := resolveAccount(accounts, accountName); err == nil {
+		fatalf("account already exists: %s", accountName)
+	}
+
+	sc := newSocksClient()
+	tt := newTrustClient()
+	mp := newMTProxyClient()
+	var svc accountServices
+	if strings.TrimSpace(*services) != "" {
+		svc, err = parseAccountServices(*services)
+		must(err)
+	} else {
+		svc = accountServices{Hiddify: true, Socks: sc.installed(), Trust: tt.installed(), MTProxy: mp.installed()}
+	}
+	if svc.Socks && !sc.installed() {
+		fatalf("SOCKS5 (danted) is not installed")
+	}
+	if svc.Trust && !tt.installed() {
+		fatalf("TrustTunnel is not installed")
+	}
+	if svc.MTProxy && !mp.installed() {
+		fatalf("MTProxy is not installed")
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Validate everything before touching any backend.
+	acc := psasAccount{Name: accountName, MTProxy: svc.MTProxy, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	var c *client
+	var hiddifyPayload map[string]any
+	useTrueUnlimited := false
+	if svc.Hiddify {
+		c = mustClient(true)
+		daysValue, gbValue, modeValue := *days, *gb, *mode
+		if strings.TrimSpace(*planName) != "" {
+			plan, err := loadUserPlan(*planName)
+			must(err)
+			if !explicit["days"] {
+				daysValue = plan.packageDays()
+				useTrueUnlimited = useTrueUnlimited || (plan.TrueUnlimited && plan.UnlimitedDays)
+			}
+			if !explicit["gb"] {
+				gbValue = plan.usageLimitGB()
+				useTrueUnlimited = useTrueUnlimited || (plan.TrueUnlimited && plan.UnlimitedGB)
+			}
+			if !explicit["mode"] && plan.Mode != "" {
+				modeValue = plan.Mode
+			}
+		}
+		if !isValidMode(modeValue) {
+			fatalf("invalid --mode: %s", modeValue)
+		}
+		if daysValue < 1 {
+			fatalf("--days must be >= 1")
+		}
+		if gbValue <= 0 {
+			fatalf("--gb must be > 0")
+		}
+		newID := strings.ToLower(strings.TrimSpace(*uuid))
+		if newID == "" {
+			newID = newUUID()
+		} else {
+			mustValidUUID(newID)
+		}
+		hiddifyPayload = map[string]any{
+			"uuid":           newID,
+			"name":           accountName,
+			"package_days":   daysValue,
+			"usage_limit_GB": gbValue,
+			"mode":           modeValue,
+			"enable":         true,
+		}
+	}
+
+	var socksUsers []socksUser
+	if svc.Socks {
+		login := normalizeSocksLogin(*socksLogin)
+		if login == "" {
+			login = normalizeSocksLogin(accountName)
+		}
+		if err := validateSocksLogin(login); err != nil {
+			fatalf("%v (use --socks-login)", err)
+		}
+		socksUsers, err = sc.usersList()
+		must(err)
+		if hasSocksUserExact(socksUsers, login) {
+			fatalf("socks user already exists: %s", login)
+		}
+		if sc.linuxUserExists(login) {
+			fatalf("linux user already exists: %s", login)
+		}
+		acc.SocksLogin = login
+	}
+
+	var trustUsers []trustUser
+	if svc.Trust {
+		username := strings.TrimSpace(*trustUsername)
+		if username == "" {
+			username = accountName
+		}
+		if err := validateTrustUsername(username); err != nil {
+			fatalf("%v (use --trust-username)", err)
+		}
+		trustUsers, err = tt.usersList()
+		must(err)
+		if hasTrustUserExact(trustUsers, username) {
+			fatalf("trust user already exists: %s", username)
+		}
+		acc.TrustUsername = username
+	}
+
+	// Create backends one by one; on failure undo the completed steps in
+	// reverse order so no half-provisioned account is left behind.
+	var rollback []func() error
+	var warnings []string
+	fail := func(step string, err error) {
+		for i := len(rollback) - 1; i >= 0; i-- {
+			if rerr := rollback[i](); rerr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: rollback failed: %v\n", rerr)
+			}
+		}
+		fatalf("accounts add: %s: %v (changes rolled back)", step, err)
+	}
+
+	result := map[string]any{}
+	if svc.Hiddify {
+		if useTrueUnlimited {
+			if err := c.ensureTrueUnlimitedSupport(); err != nil {
+				fail("hiddify", err)
+			}
+		}
+		u, err := c.userAdd(hiddifyPayload)
+		if err != nil {
+			fail("hiddify", err)
+		}
+		acc.HiddifyUUID = u.UUID
+		rollback = append(rollback, func() error { return c.userDelete(u) })
+		result["hiddify"] = u
+	}
+
+	if svc.Socks {
+		pass := accountPassword(*password)
+		original := append([]socksUser(nil), socksUsers...)
+		if err := sc.addUser(socksUsers, socksUser{Name: acc.SocksLogin, Password: pass, SystemUser: acc.SocksLogin}); err != nil {
+			fail("socks", err)
+		}
+		rollback = append(rollback, func() error {
+			if err := sc.writeUsers(original); err != nil {
+				return err
+			}
+			return sc.deleteCreatedLinuxUser(acc.SocksLogin)
+		})
+		result["socks"] = socksUser{Name: acc.SocksLogin, Password: pass, SystemUser: acc.SocksLogin}
+	}
+
+	if svc.Trust {
+		pass := accountPassword(*password)
+		original := append([]trustUser(nil), trustUsers...)
+		next := append(trustUsers, trustUser{Username: acc.TrustUsername, Password: pass})
+		if err := tt.writeUsers(next); err != nil {
+			fail("trust", err)
+		}
+		rollback = append(rollback, func() error {
+			if err := tt.writeUsers(original); err != nil {
+				return err
+			}
+			return tt.restartService()
+		})
+		if warn := trustRestartWarning(tt.service, tt.restartService()); warn != "" {
+			warnings = append(warnings, warn)
+		}
+		result["trust"] = trustUser{Username: acc.TrustUsername, Password: pass}
+	}
+
+	if svc.MTProxy {
+		info, err := mp.connectionInfo("", 0, "")
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("mtproxy: %v", err))
+		} else {
+			result["mtproxy"] = info
+		}
+	}
+
+	accounts = append(accounts, acc)
+	if err := writeAccounts(accounts); err != nil {
+		fail("save accounts", err)
+	}
+
+	if *jsonOut {
+		result["account"] = acc
+		if len(warnings) > 0 {
+			result["warnings"] = warnings
+		}
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Account created: %s (%s)\n", acc.Name, svc)
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	fmt.Println()
+	printAccountDetails(c, sc, tt, mp, acc, "")
+}
+
+func runAccountsShow(args []string) {
+	fs := flag.NewFlagSet("accounts show", flag.ExitOnError)
+	host := fs.String("host", "", "domain for generated Hiddify links")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	rest := fs.Args()
+	if len(rest) != 1 {
+		fatalf("accounts show requires ACCOUNT")
+	}
+	accounts, err := loadAccounts()
+	must(err)
+	acc, _, err := resolveAccount(accounts, rest[0])
+	must(err)
+
+	var c *client
+	if acc.HiddifyUUID != "" {
+		c = mustClient(true)
+	}
+	sc, tt, mp := newSocksClient(), newTrustClient(), newMTProxyClient()
+	if *jsonOut {
+		printJSON(collectAccountDetails(c, sc, tt, mp, acc, strings.TrimSpace(*host)))
+		return
+	}
+	printAccountDetails(c, sc, tt, mp, acc, strings.TrimSpace(*host))
+}
+
+func runAccountsDel(args []string) {
+	fs := flag.NewFlagSet("accounts del", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	rest := fs.Args()
+	if len(rest) != 1 {
+		fatalf("accounts del requires ACCOUNT")
+	}
+	must(requireRoot("accounts del"))
+	accounts, err := loadAccounts()
+	must(err)
+	acc, idx, err := resolveAccount(accounts, rest[0])
+	must(err)
+
+	var failures []string
+	var removed []string
+	if acc.TrustUsername != "" {
+		tt := newTrustClient()
+		err := func() error {
+			users, err := tt.usersList()
+			if err != nil {
+				return err
+			}
+			next := make([]trustUser, 0, len(users))
+			for _, u := range users {
+				if !strings.EqualFold(strings.TrimSpace(u.Username), acc.TrustUsername) {
+					next = append(next, u)
+				}
+			}
+			if len(next) == len(users) {
+				return nil
+			}
+			if err := tt.writeUsers(next); err != nil {
+				return err
+			}
+			if warn := trustRestartWarning(tt.service, tt.restartService()); warn != "" {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", warn)
+			}
+			return nil
+		}()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("trust %s: %v", acc.TrustUsername, err))
+		} else {
+			removed = append(removed, "trust")
+			acc.TrustUsername = ""
+		}
+	}
+	if acc.SocksLogin != "" {
+		sc := newSocksClient()
+		err := func() error {
+			users, err := sc.usersList()
+			if err != nil {
+				return err
+			}
+			if i := findSocksUserIndex(users, acc.SocksLogin); i >= 0 {
+				return sc.deleteUser(users, i)
+			}
+			// No JSON entry: the login may belong to another account now.
+			return sc.deleteCreatedLinuxUser(acc.SocksLogin)
+		}()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("socks %s: %v", acc.SocksLogin, err))
+		} else {
+			removed = append(removed, "socks")
+			acc.SocksLogin = ""
+		}
+	}
+	if acc.HiddifyUUID != "" {
+		c := mustClient(true)
+		if err := c.userDelete(apiUser{UUID: acc.HiddifyUUID}); err != nil {
+			failures = append(failures, fmt.Sprintf("hiddify %s: %v", acc.HiddifyUUID, err))
+		} else {
+			removed = append(removed, "hiddify")
+			acc.HiddifyUUID = ""
+		}
+	}
+
+	// Keep the record while something is left, so the deletion can be retried.
+	if len(failures) == 0 {
+		accounts = append(accounts[:idx], accounts[idx+1:]...)
+	} else {
+		accounts[idx] = acc
+	}
+	must(writeAccounts(accounts))
+
+	if *jsonOut {
+		printJSON(map[string]any{
+			"account":  acc.Name,
+			"removed":  removed,
+			"failures": failures,
+		})
+	} else {
+		for _, f := range failures {
+			fmt.Printf("FAILED %s\n", f)
+		}
+		if len(failures) == 0 {
+			fmt.Printf("Account deleted: %s\n", acc.Name)
+		} else {
+			fmt.Printf("Account %s partially deleted; run accounts del again to retry.\n", acc.Name)
+		}
+	}
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// collectAccountDetails gathers credentials and links from every linked
+// service; per-service errors are reported as warnings.
+func collectAccountDetails(c *client, sc *socksClient, tt *trustClient, mp *mtproxyClient, acc psasAccount, host string) map[string]any {
+	out := map[string]any{"account": acc}
+	var warnings []string
+	if acc.HiddifyUUID != "" && c != nil {
+		u, err := c.userShow(acc.HiddifyUUID)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("hiddify: %v", err))
+		} else {
+			u.RemainingDays = userRemainingDays(u, time.Now())
+			h := host
+			if h == "" {
+				h, err = c.mainDomainOrErr()
+			}
+			entry := map[string]any{"user": u}
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("hiddify links: %v", err))
+			} else {
+				entry["links"] = buildLinks(c.clientPath(), u.UUID, h)
+			}
+			out["hiddify"] = entry
+		}
+	}
+	if acc.SocksLogin != "" {
+		users, err := sc.usersList()
+		if err == nil {
+			var u socksUser
+			if u, _, err = resolveSocksUser(users, acc.SocksLogin); err == nil {
+				entry := map[string]any{"user": u}
+				if cfg, cerr := sc.connectionConfig(u, "", 0); cerr != nil {
+					warnings = append(warnings, fmt.Sprintf("socks config: %v", cerr))
+				} else {
+					entry["config"] = cfg
+				}
+				out["socks"] = entry
+			}
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("socks: %v", err))
+		}
+	}
+	if acc.TrustUsername != "" {
+		users, err := tt.usersList()
+		if err == nil {
+			var u trustUser
+			if u, _, err = resolveTrustUser(users, acc.TrustUsername); err == nil {
+				entry := map[string]any{"user": u}
+				if cfg, cerr := tt.exportClientConfig(u.Username, ""); cerr != nil {
+					warnings = append(warnings, fmt.Sprintf("trust config: %v", cerr))
+				} else {
+					entry["client_config"] = cfg
+					entry["address"] = tt.lastExportAddress
+				}
+				out["trust"] = entry
+			}
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("trust: %v", err))
+		}
+	}
+	if acc.MTProxy {
+		info, err := mp.connectionInfo("", 0, "")
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("mtproxy: %v", err))
+		} else {
+			out["mtproxy"] = info
+		}
+	}
+	if len(warnings) > 0 {
+		out["warnings"] = warnings
+	}
+	return out
+}
+
+func printAccountDetails(c *client, sc *socksClient, tt *trustClient, mp *mtproxyClient, acc psasAccount, host string) {
+	d := collectAccountDetails(c, sc, tt, mp, acc, host)
+	fmt.Printf("%s: %s\n", uiText("Account"), acc.Name)
+	if acc.CreatedAt != "" {
+		fmt.Printf("%s: %s\n", uiText("Created"), acc.CreatedAt)
+	}
+	if entry, ok := d["hiddify"].(map[string]any); ok {
+		if u, ok := entry["user"].(apiUser); ok {
+			printUser(u)
+		}
+		if links, ok := entry["links"].(linkSet); ok {
+			fmt.Println()
+			printLinksFromSet(links)
+		}
+	}
+	if entry, ok := d["socks"].(map[string]any); ok {
+		fmt.Println()
+		if cfg, ok := entry["config"].(socksConnInfo); ok {
+			printSocksConnInfo(cfg)
+		} else if u, ok := entry["user"].(socksUser); ok {
+			printSocksUser(u)
+		}
+	}
+	if entry, ok := d["trust"].(map[string]any); ok {
+		if u, ok := entry["user"].(trustUser); ok {
+			printTrustUser(u)
+		}
+		if cfg, ok := entry["client_config"].(string); ok {
+			fmt.Println()
+			fmt.Println("Client config")
+			fmt.Println("=============")
+			fmt.Println(cfg)
+		}
+	}
+	if info, ok := d["mtproxy"].(mtproxyConnInfo); ok {
+		fmt.Println()
+		printMTProxyConnInfo(info)
+	}
+	if warnings, ok := d["warnings"].([]string); ok {
+		fmt.Println()
+		for _, w := range warnings {
+			fmt.Printf("Warning: %s\n", w)
+		}
+	}
+}
+
+func printAccounts(accounts []psasAccount) {
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tHIDDIFY_UUID\tSOCKS\tTRUST\tMTPROXY\tCREATED")
+	for _, a := range accounts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\n", a.Name, valueOrDash(a.HiddifyUUID), valueOrDash(a.SocksLogin), valueOrDash(a.TrustUsername), a.MTProxy, valueOrDash(a.CreatedAt))
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultAgentStatePath = "/etc/psas/agent-state.json"
+	defaultAgentUnitPath  = "/etc/systemd/system/psas-agent.service"
+	agentHookTimeout      = 30 * time.Second
+)
+
+// Agent event types.
+const (
+	agentEventExpiring      = "expiring"
+	agentEventExpired       = "expired"
+	agentEventQuotaWarning  = "quota_warning"
+	agentEventQuotaExceeded = "quota_exceeded"
+	agentEventDisabled      = "disabled"
+	agentEventServiceDown   = "service_down"
+	agentEventServiceUp     = "service_up"
+)
+
+type agentConfig struct {
+	Interval       time.Duration
+	ExpiryDays     int
+	UsageThreshold float64
+	AutoDisable    bool
+	Hooks          []string
+	DryRun         bool
+}
+
+type agentEvent struct {
+	Type          string  `json:"type"`
+	UUID          string  `json:"uuid,omitempty"`
+	Name          string  `json:"name,omitempty"`
+	Service       string  `json:"service,omitempty"`
+	RemainingDays int     `json:"remaining_days,omitempty"`
+	UsedGB        float64 `json:"used_gb,omitempty"`
+	LimitGB       float64 `json:"limit_gb,omitempty"`
+	UsagePercent  float64 `json:"usage_percent,omitempty"`
+	Time          string  `json:"time"`
+}
+
+// agentState remembers which events already fired. The value is a package
+// fingerprint, so the same event fires again after the user is renewed.
+type agentState struct {
+	Fired    map[string]map[string]string `json:"fired"`
+	Services map[string]bool              `json:"services,omitempty"`
+	LastRun  string                       `json:"last_run,omitempty"`
+}
+
+// stringListFlag collects a repeatable string flag.
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringListFlag) Set(v string) error {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return errors.New("empty value")
+	}
+	*s = append(*s, v)
+	return nil
+}
+
+func agentStatePath() string {
+	return envOr("PSAS_AGENT_STATE", defaultAgentStatePath)
+}
+
+func loadAgentState() (agentState, error) {
+	st := agentState{Fired: map[string]map[string]string{}, Services: map[string]bool{}}
+	p := agentStatePath()
+	raw, err := loadStateFile(p)
+	if err != nil {
+		return st, err
+	}
+	if strings.TrimSpace(string(raw)) == "" {
+		return st, nil
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return st, fmt.Errorf("parse %s: %w", p, err)
+	}
+	if st.Fired == nil {
+		st.Fired = map[string]map[string]string{}
+	}
+	if st.Services == nil {
+		st.Services = map[string]bool{}
+	}
+	return st, nil
+}
+
+func writeAgentState(st agentState) error {
+	payload, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := agentStatePath()
+	return saveStateFile(p, append(payload, '\n'), 0o600)
+}
+
+// bindAgentFlags registers the polling/threshold flags shared by `agent run`
+// and `agent install`.
+func bindAgentFlags(fs *flag.FlagSet) func() (agentConfig, error) {
+	interval := fs.Duration("interval", 5*time.Minute, "poll interval")
+	expiry := fs.String("expiry-days", "3d", "emit 'expiring' when a package ends within this many days")
+	threshold := fs.String("usage-threshold", "90%", "emit 'quota_warning' at this share of the traffic limit")
+	autoDisable := fs.Bool("auto-disable", false, "disable users that expired or exceeded their traffic limit")
+	var hooks stringListFlag
+	fs.Var(&hooks, "hook", "command run via sh -c for every event (repeatable); event JSON on stdin, PSAS_EVENT* in env")
+	return func() (agentConfig, error) {
+		cfg := agentConfig{Interval: *interval, AutoDisable: *autoDisable, Hooks: hooks}
+		if cfg.Interval < 10*time.Second {
+			return cfg, errors.New("--interval must be at least 10s")
+		}
+		days, err := parseDaysValue(*expiry)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.ExpiryDays = days
+		pct, err := parsePercentValue(*threshold)
+		if err != nil {
+			return cfg, err
+		}
+		if pct <= 0 || pct > 100 {
+			return cfg, errors.New("--usage-threshold must be within (0, 100]%")
+		}
+		cfg.UsageThreshold = pct
+		return cfg, nil
+	}
+}
+
+// args renders cfg back into `agent run` flags for the systemd unit.
+func (cfg agentConfig) args() []string {
+	out := []string{
+		"--interval", cfg.Interval.String(),
+		"--expiry-days", strconv.Itoa(cfg.ExpiryDays) + "d",
+		"--usage-threshold", strconv.FormatFloat(cfg.UsageThreshold, 'f', -1, 64) + "%",
+	}
+	if cfg.AutoDisable {
+		out = append(out, "--auto-disable")
+	}
+	for _, h := range cfg.Hooks {
+		out = append(out, "--hook", h)
+	}
+	return out
+}
+
+func runAgent(args []string) {
+	if len(args) < 1 {
+		fatalf("agent requires subcommand: run|status|unit|install")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "run":
+		fs := flag.NewFlagSet("agent run", flag.ExitOnError)
+		config := bindAgentFlags(fs)
+		once := fs.Bool("once", false, "poll once and exit")
+		dryRun := fs.Bool("dry-run", false, "print events only: no disabling, no hooks, no state changes")
+		jsonOut := fs.Bool("json", false, "print events as JSON lines")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("agent run takes only flags")
+		}
+		cfg, err := config()
+		must(err)
+		cfg.DryRun = *dryRun
+		c := mustClient(true)
+		setAuditContext(auditContext{Via: "agent", Command: "agent run"})
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		for {
+			if err := agentPoll(ctx, c, cfg, *jsonOut); err != nil {
+				agentLogf("poll failed: %v", err)
+				if *once {
+					os.Exit(1)
+				}
+			}
+			if *once {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cfg.Interval):
+			}
+		}
+	case "status":
+		fs := flag.NewFlagSet("agent status", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		st, err := loadAgentState()
+		must(err)
+		if *jsonOut {
+			printJSON(st)
+			return
+		}
+		printAgentState(st)
+	case "unit":
+		fs := flag.NewFlagSet("agent unit", flag.ExitOnError)
+		config := bindAgentFlags(fs)
+		must(fs.Parse(subArgs))
+		cfg, err := config()
+		must(err)
+		fmt.Print(renderAgentUnit(cfg))
+	case "install":
+		fs := flag.NewFlagSet("agent install", flag.ExitOnError)
+		config := bindAgentFlags(fs)
+		unitPath := fs.String("unit-path", defaultAgentUnitPath, "systemd unit file path")
+		noStart := fs.Bool("no-start", false, "write the unit but do not enable/start it")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("agent install takes only flags")
+		}
+		must(requireRoot("agent install"))
+		cfg, err := config()
+		must(err)
+		must(installPSASUnit(*unitPath, renderAgentUnit(cfg), !*noStart))
+	default:
+		fatalf("unknown agent subcommand: %s", sub)
+	}
+}
+
+// agentPoll checks proxy services and all users once, fires new events and
+// persists state. Events are only queued while polling; the outbox, with
+// whatever earlier polls left in it, is delivered at the end of the poll.
+func agentPoll(ctx context.Context, c *client, cfg agentConfig, jsonOut bool) error {
+	st, err := loadAgentState()
+	if err != nil {
+		return err
+	}
+	if !cfg.DryRun {
+		defer agentFlushNotify()
+	}
+	now := time.Now()
+	agentCheckServices(&st, cfg, now, jsonOut)
+	users, err := c.usersList()
+	if err != nil {
+		if !cfg.DryRun {
+			_ = writeAgentState(st)
+		}
+		return err
+	}
+	seen := map[string]bool{}
+	for _, u := range users {
+		if ctx.Err() != nil {
+			return nil
+		}
+		seen[u.UUID] = true
+		u.RemainingDays = userRemainingDays(u, now)
+		fired := st.Fired[u.UUID]
+		if fired == nil {
+			fired = map[string]string{}
+		}
+		active := agentUserEvents(u, cfg)
+		fp := agentFingerprint(u)
+		// Forget events whose condition cleared so they can fire again later.
+		for typ := range fired {
+			if typ != agentEventDisabled && !active[typ] {
+				delete(fired, typ)
+			}
+		}
+		for _, typ := range []string{agentEventExpiring, agentEventExpired, agentEventQuotaWarning, agentEventQuotaExceeded} {
+			if !active[typ] || fired[typ] == fp {
+				continue
+			}
+			agentEmit(cfg, newAgentEvent(typ, u, now), jsonOut)
+			fired[typ] = fp
+		}
+		if cfg.AutoDisable && u.Enable && (active[agentEventExpired] || active[agentEventQuotaExceeded]) {
+			if cfg.DryRun {
+				agentEmit(cfg, newAgentEvent(agentEventDisabled, u, now), jsonOut)
+			} else if _, err := c.userPatch(u, map[string]any{"enable": false}); err != nil {
+				agentLogf("disable %s (%s) failed: %v", u.Name, u.UUID, err)
+			} else {
+				agentEmit(cfg, newAgentEvent(agentEventDisabled, u, now), jsonOut)
+				fired[agentEventDisabled] = fp
+			}
+		}
+		if u.Enable {
+			delete(fired, agentEventDisabled)
+		}
+		if len(fired) == 0 {
+			delete(st.Fired, u.UUID)
+		} else {
+			st.Fired[u.UUID] = fired
+		}
+	}
+	for id := range st.Fired {
+		if !seen[id] {
+			delete(st.Fired, id)
+		}
+	}
+	if cfg.DryRun {
+		return nil
+	}
+	st.LastRun = now.UTC().Format(time.RFC3339)
+	return writeAgentState(st)
+}
+
+func agentFlushNotify() {
+	if _, failed, _, err := flushNotifyOutbox(); err != nil {
+		agentLogf("notify outbox: %v", err)
+	} else if failed > 0 {
+		agentLogf("notify outbox: %d notification(s) still queued", failed)
+	}
+}
+
+// agentCheckServices emits service_down/service_up when an installed PSAS
+// managed service changes state. A service seen for the first time only
+// fires when it is down.
+func agentCheckServices(st *agentState, cfg agentConfig, now time.Time, jsonOut bool) {
+	type svc struct {
+		installed bool
+		name      string
+		active    func() (bool, error)
+	}
+	tt := newTrustClient()
+	sc := newSocksClient()
+	mp := newMTProxyClient()
+	services := []svc{
+		{tt.installed(), tt.service, tt.serviceIsActive},
+		{sc.installed(), sc.service, sc.serviceIsActive},
+		{mp.installed(), mp.service, mp.serviceIsActive},
+	}
+	for _, s := range services {
+		if !s.installed {
+			delete(st.Services, s.name)
+			continue
+		}
+		active, err := s.active()
+		if err != nil {
+			agentLogf("service %s: %v", s.name, err)
+			continue
+		}
+		prev, known := st.Services[s.name]
+		st.Services[s.name] = active
+		switch {
+		case !active && (!known || prev):
+			agentEmit(cfg, agentEvent{Type: agentEventServiceDown, Service: s.name, Time: now.UTC().Format(time.RFC3339)}, jsonOut)
+		case active && known && !prev:
+			agentEmit(cfg, agentEvent{Type: agentEventServiceUp, Service: s.name, Time: now.UTC().Format(time.RFC3339)}, jsonOut)
+		}
+	}
+}
+
+// agentUserEvents returns the event conditions currently true for u.
+func agentUserEvents(u apiUser, cfg agentConfig) map[string]bool {
+	active := map[string]bool{}
+	if !u.Enable {
+		return active
+	}
+	if !isUnlimitedDays(u) {
+		switch {
+		case u.RemainingDays <= 0:
+			active[agentEventExpired] = true
+		case u.RemainingDays <= cfg.ExpiryDays:
+			active[agentEventExpiring] = true
+		}
+	}
+	if !isUnlimitedUsage(u) && u.UsageLimitGB > 0 {
+		pct := userUsagePercent(u)
+		switch {
+		case pct >= 100:
+			active[agentEventQuotaExceeded] = true
+		case pct >= cfg.UsageThreshold:
+			active[agentEventQuotaWarning] = true
+		}
+	}
+	return active
+}
+
+// agentFingerprint identifies the current package of a user; renewing or
+// resetting the package changes it.
+func agentFingerprint(u apiUser) string {
+	return fmt.Sprintf("%s|%d|%g", strings.TrimSpace(u.StartDate), u.PackageDays, u.UsageLimitGB)
+}
+
+func newAgentEvent(typ string, u apiUser, now time.Time) agentEvent {
+	return agentEvent{
+		Type:          typ,
+		UUID:          u.UUID,
+		Name:          u.Name,
+		RemainingDays: u.RemainingDays,
+		UsedGB:        u.CurrentUsageGB,
+		LimitGB:       u.UsageLimitGB,
+		UsagePercent:  userUsagePercent(u),
+		Time:          now.UTC().Format(time.RFC3339),
+	}
+}
+
+func agentEmit(cfg agentConfig, ev agentEvent, jsonOut bool) {
+	switch {
+	case jsonOut:
+		payload, _ := json.Marshal(ev)
+		fmt.Println(string(payload))
+	case ev.Service != "":
+		fmt.Printf("%s event=%s service=%s\n", ev.Time, ev.Type, ev.Service)
+	default:
+		fmt.Printf("%s event=%s user=%s uuid=%s remaining_days=%d usage=%.1f%%\n",
+			ev.Time, ev.Type, ev.Name, ev.UUID, ev.RemainingDays, ev.UsagePercent)
+	}
+	if cfg.DryRun {
+		return
+	}
+	queueNotify(agentNotifyEvent(ev))
+	for _, hook := range cfg.Hooks {
+		if err := runAgentHook(hook, ev); err != nil {
+			agentLogf("hook %q failed for %s/%s: %v", hook, ev.Type, ev.Name, err)
+		}
+	}
+}
+
+func agentNotifyEvent(ev agentEvent) notifyEvent {
+	if ev.Service != "" {
+		return notifyEvent{
+			Type:    ev.Type,
+			Source:  "agent",
+			Message: fmt.Sprintf("%s: %s", ev.Type, ev.Service),
+			Details: map[string]any{"service": ev.Service},
+			Time:    ev.Time,
+		}
+	}
+	return notifyEvent{
+		Type:   ev.Type,
+		Source: "agent",
+		User:   ev.Name,
+		UUID:   ev.UUID,
+		Details: map[string]any{
+			"remaining_days": ev.RemainingDays,
+			"used_gb":        ev.UsedGB,
+			"limit_gb":       ev.LimitGB,
+			"usage_percent":  ev.UsagePercent,
+		},
+		Time: ev.Time,
+	}
+}
+
+func runAgentHook(hook string, ev agentEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), agentHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Stdin = strings.NewReader(string(payload) + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PSAS_EVENT="+ev.Type,
+		"PSAS_EVENT_USER_UUID="+ev.UUID,
+		"PSAS_EVENT_USER_NAME="+ev.Name,
+		"PSAS_EVENT_REMAINING_DAYS="+strconv.Itoa(ev.RemainingDays),
+		"PSAS_EVENT_USAGE_PERCENT="+strconv.FormatFloat(ev.UsagePercent, 'f', 1, 64),
+		"PSAS_EVENT_SERVICE="+ev.Service,
+	)
+	return cmd.Run()
+}
+
+func agentLogf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "%s agent: %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+func psasExecutable() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "/usr/local/bin/psasctl"
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		return resolved
+	}
+	return exe
+}
+
+func renderAgentUnit(cfg agentConfig) string {
+	return renderPSASUnit("PSAS user expiry/quota agent", append([]string{"agent", "run"}, cfg.args()...))
+}
+
+// renderPSASUnit renders a systemd unit running psasctl with args, in the
+// same layout the installer uses for PSAS managed services.
+func renderPSASUnit(description string, args []string) string {
+	parts := []string{systemdQuote(psasExecutable())}
+	for _, a := range args {
+		parts = append(parts, systemdQuote(a))
+	}
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=" + description + " (PSAS managed)\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=simple\n")
+	b.WriteString("ExecStart=" + strings.Join(parts, " ") + "\n")
+	b.WriteString("Restart=always\n")
+	b.WriteString("RestartSec=10\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// installPSASUnit writes a unit file and optionally enables and (re)starts it.
+func installPSASUnit(path, unit string, start bool) error {
+	if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Unit written: %s\n", path)
+	if !start {
+		return nil
+	}
+	name := filepath.Base(path)
+	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runCommand("systemctl", "enable", name); err != nil {
+		return err
+	}
+	if err := runServiceAction("restart", name); err != nil {
+		return err
+	}
+	fmt.Printf("Service enabled: %s\n", name)
+	return nil
+}
+
+// systemdQuote quotes one ExecStart argument when it contains spaces or
+// characters systemd would interpret.
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func printAgentState(st agentState) {
+	fmt.Printf("State file: %s\n", agentStatePath())
+	fmt.Printf("Last run  : %s\n", valueOrDash(st.LastRun))
+	names := make([]string, 0, len(st.Services))
+	for name := range st.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "active"
+		if !st.Services[name] {
+			state = "down"
+		}
+		fmt.Printf("Service   : %s (%s)\n", name, state)
+	}
+	if len(st.Fired) == 0 {
+		fmt.Println("No active events.")
+		return
+	}
+	ids := make([]string, 0, len(st.Fired))
+	for id := range st.Fired {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Println()
+	for _, id := range ids {
+		types := make([]string, 0, len(st.Fired[id]))
+		for typ := range st.Fired[id] {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		fmt.Printf("%s  %s\n", id, strings.Join(types, ","))
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultAuditLogPath = "/var/log/psas/audit.jsonl"
+	auditRedacted       = "[redacted]"
+)
+
+// auditEntry is one line of the append-only audit log.
+type auditEntry struct {
+	Time     string        `json:"time"`
+	User     string        `json:"user,omitempty"`
+	SudoUser string        `json:"sudo_user,omitempty"`
+	Via      string        `json:"via"`
+	Actor    string        `json:"actor,omitempty"`
+	Command  string        `json:"command,omitempty"`
+	Action   string        `json:"action"`
+	Target   string        `json:"target,omitempty"`
+	Changes  []auditChange `json:"changes,omitempty"`
+	Result   string        `json:"result"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type auditChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// auditContext says who drives the current process: the CLI, the REST API
+// (token or web session), the Telegram bot or the agent. Long-running modes
+// update it per request; they all handle requests one at a time.
+type auditContext struct {
+	Via     string
+	Actor   string
+	Command string
+}
+
+var (
+	auditMu      sync.Mutex
+	auditCurrent = auditContext{Via: "cli"}
+	auditWarned  bool
+)
+
+func auditLogPath() string {
+	return envOr("PSAS_AUDIT_LOG", defaultAuditLogPath)
+}
+
+func setAuditContext(ctx auditContext) {
+	auditMu.Lock()
+	auditCurrent = ctx
+	auditMu.Unlock()
+}
+
+// auditRecord appends one entry. Failing to write the log never fails the
+// action itself; the first failure is reported on stderr.
+func auditRecord(action, target string, changes []auditChange, actionErr error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	e := auditEntry{
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		SudoUser: os.Getenv("SUDO_USER"),
+		Via:      auditCurrent.Via,
+		Actor:    auditCurrent.Actor,
+		Command:  auditCurrent.Command,
+		Action:   action,
+		Target:   target,
+		Changes:  changes,
+		Result:   "ok",
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	if e.Command == "" && e.Via == "cli" {
+		e.Command = redactCommandLine(os.Args[1:])
+	}
+	if actionErr != nil {
+		e.Result = "error"
+		e.Error = shortText(actionErr.Error(), 300)
+	}
+	if err := appendAuditEntry(auditLogPath(), e); err != nil && !auditWarned {
+		auditWarned = true
+		fmt.Fprintf(os.Stderr, "Warning: audit log %s: %v\n", auditLogPath(), err)
+	}
+}
+
+func appendAuditEntry(path string, e auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	// A single write on an O_APPEND file keeps concurrent writers' lines whole.
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// auditSensitive reports whether a field or flag name holds a credential.
+func auditSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "passwd", "secret", "token", "passphrase", "api_key", "apikey", "private", "hash"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCommandLine hides values of credential flags (--password x,
+// --secret=x) and the positional secret of `mtproxy secret set`.
+func redactCommandLine(args []string) string {
+	out := make([]string, 0, len(args))
+	redactNext := false
+	for _, a := range args {
+		if redactNext {
+			out = append(out, auditRedacted)
+			redactNext = false
+			continue
+		}
+		if strings.HasPrefix(a, "-") {
+			name, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+			if auditSensitive(name) {
+				if hasValue {
+					out = append(out, a[:strings.Index(a, "=")+1]+auditRedacted)
+				} else {
+					out = append(out, a)
+					redactNext = true
+				}
+				continue
+			}
+		}
+		out = append(out, a)
+	}
+	if len(out) >= 4 && out[0] == "mtproxy" && out[1] == "secret" && out[2] == "set" {
+		for i := 3; i < len(out); i++ {
+			if !strings.HasPrefix(out[i], "-") {
+				out[i] = auditRedacted
+			}
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func auditValue(field string, v any) any {
+	if v == nil {
+		return nil
+	}
+	if auditSensitive(field) {
+		return auditRedacted
+	}
+	return v
+}
+
+// auditMapChanges diffs two flat maps; keys only present in after are
+// treated as set, keys only in before are ignored (partial updates).
+func auditMapChanges(before, after map[string]any) []auditChange {
+	keys := make([]string, 0, len(after))
+	for k := range after {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []auditChange
+	for _, k := range keys {
+		old, ok := before[k]
+		if ok && auditEqual(old, after[k]) {
+			continue
+		}
+		out = append(out, auditChange{Field: k, Old: auditValue(k, old), New: auditValue(k, after[k])})
+	}
+	return out
+}
+
+// auditEqual compares JSON-ish values, treating 5 and 5.0 as equal.
+func auditEqual(a, b any) bool {
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	var na, nb any
+	if json.Unmarshal(ra, &na) != nil || json.Unmarshal(rb, &nb) != nil {
+		return string(ra) == string(rb)
+	}
+	return reflect.DeepEqual(na, nb)
+}
+
+// auditStructMap turns a struct into its JSON map for auditMapChanges.
+func auditStructMap(v any) map[string]any {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out map[string]any
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+// auditCredentialChanges diffs name->password lists (SOCKS, TrustTunnel).
+func auditCredentialChanges(before, after map[string]string) []auditChange {
+	names := map[string]bool{}
+	for k := range before {
+		names[k] = true
+	}
+	for k := range after {
+		names[k] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var out []auditChange
+	for _, name := range sorted {
+		oldPass, hadOld := before[name]
+		newPass, hasNew := after[name]
+		switch {
+		case !hadOld && hasNew:
+			out = append(out, auditChange{Field: "user:" + name, New: "added"})
+		case hadOld && !hasNew:
+			out = append(out, auditChange{Field: "user:" + name, Old: "removed"})
+		case oldPass != newPass:
+			out = append(out, auditChange{Field: "user:" + name + ".password", Old: auditRedacted, New: auditRedacted})
+		}
+	}
+	return out
+}
+
+// runServiceAction runs `systemctl ACTION SERVICE` and records it.
+func runServiceAction(action, service string) error {
+	out, err := runCommandOutput("systemctl", action, service)
+	if err != nil {
+		err = fmt.Errorf("systemctl %s %s: %w: %s", action, service, err, shortText(out, 300))
+	}
+	auditRecord("service."+action, service, nil, err)
+	return err
+}
+
+func runAudit(args []string) {
+	if len(args) == 0 {
+		fatalf("audit requires subcommand: show")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	switch sub {
+	case "show", "list", "log":
+		runAuditShow(args[1:])
+	default:
+		fatalf("unknown audit subcommand: %s", sub)
+	}
+}
+
+func runAuditShow(args []string) {
+	fs := flag.NewFlagSet("audit show", flag.ExitOnError)
+	since := fs.String("since", "", "only entries newer than a duration (24h, 7d) or RFC3339/YYYY-MM-DD time")
+	who := fs.String("user", "", "filter by Unix user, SUDO_USER or actor")
+	action := fs.String("action", "", "filter by action prefix (e.g. hiddify.user, socks, service.restart)")
+	target := fs.String("target", "", "filter by target substring")
+	via := fs.String("via", "", "filter by origin: cli|api|web|bot|agent")
+	errorsOnly := fs.Bool("errors", false, "only failed actions")
+	limit := fs.Int("limit", 50, "show at most N newest entries (0 = all)")
+	jsonOut := fs.Bool("json", false, "output JSON lines")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("audit show takes only flags")
+	}
+	var sinceTime time.Time
+	if s := strings.TrimSpace(*since); s != "" {
+		t, err := parseAuditSince(s, time.Now())
+		must(err)
+		sinceTime = t
+	}
+
+	entries, err := readAuditLog(auditLogPath())
+	must(err)
+	filtered := make([]auditEntry, 0, len(entries))
+	for _, e := range entries {
+		if !sinceTime.IsZero() {
+			t, err := time.Parse(time.RFC3339Nano, e.Time)
+			if err != nil || t.Before(sinceTime) {
+				continue
+			}
+		}
+		if w := strings.TrimSpace(*who); w != "" && e.User != w && e.SudoUser != w && e.Actor != w {
+			continue
+		}
+		if a := strings.TrimSpace(*action); a != "" && !strings.HasPrefix(e.Action, a) {
+			continue
+		}
+		if t := strings.TrimSpace(*target); t != "" && !strings.Contains(strings.ToLower(e.Target), strings.ToLower(t)) {
+			continue
+		}
+		if v := strings.TrimSpace(*via); v != "" && e.Via != v {
+			continue
+		}
+		if *errorsOnly && e.Result != "error" {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	if *limit > 0 && len(filtered) > *limit {
+		filtered = filtered[len(filtered)-*limit:]
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range filtered {
+			must(enc.Encode(e))
+		}
+		return
+	}
+	if len(filtered) == 0 {
+		fmt.Println("No audit entries.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tWHO\tVIA\tACTION\tTARGET\tRESULT\tCHANGES")
+	for _, e := range filtered {
+		whoText := e.User
+		if e.SudoUser != "" {
+			whoText = e.SudoUser + "(" + e.User + ")"
+		}
+		if e.Actor != "" {
+			whoText = e.Actor
+		}
+		result := e.Result
+		if e.Error != "" {
+			result += ": " + shortText(e.Error, 40)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatAuditTime(e.Time), valueOrDash(whoText), e.Via, e.Action, valueOrDash(e.Target), result, valueOrDash(formatAuditChanges(e.Changes)))
+	}
+	_ = tw.Flush()
+}
+
+func parseAuditSince(s string, now time.Time) (time.Time, error) {
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		var days int
+		if _, err := fmt.Sscanf(d, "%d", &days); err == nil && days >= 0 {
+			return now.Add(-time.Duration(days) * 24 * time.Hour), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (use 24h, 7d, 2006-01-02 or RFC3339)", s)
+}
+
+func readAuditLog(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []auditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+func formatAuditTime(s string) string {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return s
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatAuditChanges(changes []auditChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			parts = append(parts, fmt.Sprintf("%s=%v", c.Field, c.New))
+		case c.New == nil:
+			parts = append(parts, fmt.Sprintf("%s: %v", c.Field, c.Old))
+		default:
+			parts = append(parts, fmt.Sprintf("%s: %v->%v", c.Field, c.Old, c.New))
+		}
+	}
+	return shortText(strings.Join(parts, ", "), 120)
+}
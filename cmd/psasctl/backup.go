@@ -0,0 +1,1083 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultBackupDir     = "/var/backups/psas"
+	defaultBackupKeep    = 14
+	backupFormat         = "psas-backup"
+	backupFormatVersion  = 1
+	backupManifestName   = "manifest.json"
+	backupFilePrefix     = "psas-backup-"
+	backupPlainExt       = ".tar.gz"
+	backupPassphraseExt  = ".enc"
+	backupAgeExt         = ".age"
+	backupEncMagic       = "PSASENC1"
+	backupPBKDF2Iter     = 600000
+	backupMaxEntrySize   = 256 << 20
+	backupHiddifyPanel   = "hiddify/panel.json"
+	backupHiddifyUsers   = "hiddify/users.json"
+	backupComponentPSAS  = "psas"
+	backupComponentSocks = "socks"
+	backupComponentTrust = "trust"
+	backupComponentMTP   = "mtproxy"
+	backupComponentHidd  = "hiddify"
+)
+
+var backupComponents = []string{backupComponentPSAS, backupComponentSocks, backupComponentTrust, backupComponentMTP, backupComponentHidd}
+
+// backupManifest is stored as manifest.json at the root of every archive.
+type backupManifest struct {
+	Format     string       `json:"format"`
+	Version    int          `json:"version"`
+	CreatedAt  string       `json:"created_at"`
+	Hostname   string       `json:"hostname,omitempty"`
+	Components []string     `json:"components"`
+	Files      []backupFile `json:"files"`
+	Warnings   []string     `json:"warnings,omitempty"`
+}
+
+// backupFile is one archive entry. Path is where the file is restored to;
+// it is empty for generated entries (the Hiddify panel export).
+type backupFile struct {
+	Component string `json:"component"`
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Mode      uint32 `json:"mode"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+type backupSource struct {
+	Component string
+	Name      string
+	Path      string
+}
+
+type backupListEntry struct {
+	File       string   `json:"file"`
+	Size       int64    `json:"size"`
+	ModTime    string   `json:"mod_time"`
+	Encryption string   `json:"encryption,omitempty"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+	Components []string `json:"components,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func backupDir() string {
+	return envOr("PSAS_BACKUP_DIR", defaultBackupDir)
+}
+
+func runBackup(args []string) {
+	if len(args) == 0 {
+		fatalf("backup requires subcommand: create|restore|list")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+	switch sub {
+	case "create", "new":
+		runBackupCreate(subArgs)
+	case "restore":
+		runBackupRestore(subArgs)
+	case "list", "ls":
+		runBackupList(subArgs)
+	default:
+		fatalf("unknown backup subcommand: %s", sub)
+	}
+}
+
+func runBackupCreate(args []string) {
+	fs := flag.NewFlagSet("backup create", flag.ExitOnError)
+	dir := fs.String("dir", backupDir(), "directory for backup archives")
+	only := fs.String("only", "", "comma-separated components: "+strings.Join(backupComponents, ","))
+	skip := fs.String("skip", "", "comma-separated components to leave out")
+	passFile := fs.String("passphrase-file", "", "encrypt with the passphrase from this file (or set PSAS_BACKUP_PASSPHRASE)")
+	ageRecipient := fs.String("age-recipient", "", "encrypt with age for this recipient (requires the age binary)")
+	keep := fs.Int("keep", defaultBackupKeep, "keep only the newest N archives in --dir (0 = keep all)")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("backup create takes only flags")
+	}
+	must(requireRoot("backup create"))
+	comps, err := backupSelectComponents(*only, *skip)
+	must(err)
+	passphrase, err := backupPassphrase(*passFile)
+	must(err)
+	if passphrase != "" && strings.TrimSpace(*ageRecipient) != "" {
+		fatalf("use either --passphrase-file/PSAS_BACKUP_PASSPHRASE or --age-recipient, not both")
+	}
+
+	archive, manifest, err := buildBackupArchive(comps)
+	must(err)
+	ext := backupPlainExt
+	switch {
+	case passphrase != "":
+		archive, err = backupEncrypt(archive, passphrase)
+		ext += backupPassphraseExt
+	case strings.TrimSpace(*ageRecipient) != "":
+		archive, err = runAge(archive, "--encrypt", "--recipient", strings.TrimSpace(*ageRecipient))
+		ext += backupAgeExt
+	}
+	must(err)
+
+	must(os.MkdirAll(*dir, 0o700))
+	name := backupFilePrefix + time.Now().UTC().Format("20060102-150405") + ext
+	path := filepath.Join(*dir, name)
+	must(writeFileAtomic(path, archive, 0o600))
+	removed, err := rotateBackups(*dir, *keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: rotate backups: %v\n", err)
+	}
+
+	if *jsonOut {
+		printJSON(map[string]any{"file": path, "size": len(archive), "manifest": manifest, "removed": removed})
+		return
+	}
+	fmt.Printf("Backup created: %s (%d bytes)\n", path, len(archive))
+	fmt.Printf("Components: %s\n", strings.Join(manifest.Components, ", "))
+	fmt.Printf("Files: %d\n", len(manifest.Files))
+	for _, w := range manifest.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	for _, r := range removed {
+		fmt.Printf("Removed old backup: %s\n", r)
+	}
+}
+
+func backupSelectComponents(only, skip string) ([]string, error) {
+	parse := func(raw string) (map[string]bool, error) {
+		out := map[string]bool{}
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			if part == "" {
+				continue
+			}
+			known := false
+			for _, c := range backupComponents {
+				known = known || c == part
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown backup component %q (expected %s)", part, strings.Join(backupComponents, ","))
+			}
+			out[part] = true
+		}
+		return out, nil
+	}
+	onlySet, err := parse(only)
+	if err != nil {
+		return nil, err
+	}
+	skipSet, err := parse(skip)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, c := range backupComponents {
+		if len(onlySet) > 0 && !onlySet[c] {
+			continue
+		}
+		if skipSet[c] {
+			continue
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no backup components selected")
+	}
+	return out, nil
+}
+
+// backupSources lists the on-disk files of each component. Paths honour the
+// same PSAS_* overrides as the rest of psasctl.
+func backupSources(component string) []backupSource {
+	var out []backupSource
+	add := func(name, path string) {
+		if strings.TrimSpace(path) == "" {
+			return
+		}
+		out = append(out, backupSource{Component: component, Name: component + "/" + name, Path: path})
+	}
+	switch component {
+	case backupComponentPSAS:
+		add("plans.json", plansPath())
+		add("accounts.json", accountsPath())
+		add("agent-state.json", agentStatePath())
+		add("notify.json", notifyConfigPath())
+		add("bot.json", botConfigPath())
+		add("api-token", apiTokenPath())
+		add("web.json", webAuthPath())
+	case backupComponentSocks:
+		sc := newSocksClient()
+		add("danted.conf", sc.config)
+		add("socks-users.json", sc.users)
+		add("socks-server.json", sc.serverConfig)
+		add("socks-acl.json", socksACLPath())
+	case backupComponentTrust:
+		tt := newTrustClient()
+		add("vpn.toml", tt.vpnPath())
+		add("hosts.toml", tt.hostsPath())
+		if p, err := tt.credentialsPath(); err == nil {
+			add("credentials.toml", p)
+		}
+	case backupComponentMTP:
+		add("mtproxy.json", newMTProxyClient().config)
+	}
+	return out
+}
+
+func buildBackupArchive(comps []string) ([]byte, backupManifest, error) {
+	host, _ := os.Hostname()
+	manifest := backupManifest{
+		Format:     backupFormat,
+		Version:    backupFormatVersion,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Hostname:   host,
+		Components: []string{},
+		Files:      []backupFile{},
+	}
+	contents := map[string][]byte{}
+	addEntry := func(component, name, path string, mode os.FileMode, data []byte) {
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, backupFile{
+			Component: component,
+			Name:      name,
+			Path:      path,
+			Mode:      uint32(mode.Perm()),
+			Size:      int64(len(data)),
+			SHA256:    hex.EncodeToString(sum[:]),
+		})
+		contents[name] = data
+	}
+
+	for _, comp := range comps {
+		before := len(manifest.Files)
+		if comp == backupComponentHidd {
+			panel, users, err := exportHiddifyPanel()
+			if err != nil {
+				manifest.Warnings = append(manifest.Warnings, "hiddify: "+err.Error())
+			}
+			if len(panel) > 0 {
+				addEntry(comp, backupHiddifyPanel, "", 0o600, panel)
+			}
+			if len(users) > 0 {
+				addEntry(comp, backupHiddifyUsers, "", 0o600, users)
+			}
+		}
+		for _, src := range backupSources(comp) {
+			st, err := os.Stat(src.Path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, manifest, err
+			}
+			if st.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(src.Path)
+			if err != nil {
+				return nil, manifest, err
+			}
+			addEntry(comp, src.Name, src.Path, st.Mode(), data)
+		}
+		if len(manifest.Files) > before {
+			manifest.Components = append(manifest.Components, comp)
+		}
+	}
+	if len(manifest.Files) == 0 {
+		return nil, manifest, errors.New("nothing to back up: no files found for the selected components")
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	writeEntry := func(name string, mode int64, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, manifest, err
+	}
+	if err := writeEntry(backupManifestName, 0o600, append(rawManifest, '\n')); err != nil {
+		return nil, manifest, err
+	}
+	for _, f := range manifest.Files {
+		if err := writeEntry(f.Name, int64(f.Mode), contents[f.Name]); err != nil {
+			return nil, manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, manifest, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, manifest, err
+	}
+	return buf.Bytes(), manifest, nil
+}
+
+const hiddifyPanelDumpPy = `
+import json, sys
+try:
+    from hiddifypanel import create_app_cli
+except ImportError:
+    from hiddifypanel.base import create_app_cli
+app = create_app_cli()
+with app.app_context():
+    from hiddifypanel.panel import hiddify
+    data = hiddify.dump_db_to_dict()
+sys.stdout.write("\n" + json.dumps(data, default=str) + "\n")
+`
+
+const hiddifyPanelImportPy = `
+import inspect, json, sys
+try:
+    from hiddifypanel import create_app_cli
+except ImportError:
+    from hiddifypanel.base import create_app_cli
+with open(sys.argv[1], encoding="utf-8") as f:
+    data = json.load(f)
+app = create_app_cli()
+with app.app_context():
+    from hiddifypanel.panel import hiddify
+    try:
+        from hiddifypanel.database import db
+    except ImportError:
+        from hiddifypanel.panel.database import db
+    want = dict(set_users=True, set_domains=True, set_proxies=True, set_settings=True, set_admins=True,
+                override_unique_id=True, override_child_unique_id=0, override_root_admin=True,
+                replace_owner_admin=False, remove_domains=False, remove_users=False)
+    params = inspect.signature(hiddify.set_db_from_json).parameters
+    hiddify.set_db_from_json(data, **{k: v for k, v in want.items() if k in params})
+    db.session.commit()
+print("ok")
+`
+
+// runPanelScript runs a Python snippet with the panel interpreter and config.
+func (c *client) runPanelScript(script string, args ...string) ([]byte, error) {
+	cmd := exec.Command(c.panelPy, append([]string{"-c", script}, args...)...)
+	cmd.Env = append(os.Environ(), "HIDDIFY_CFG_PATH="+c.panelCfg)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		return nil, fmt.Errorf("panel script failed: %w\n%s", err, shortText(msg, 600))
+	}
+	return stdout.Bytes(), nil
+}
+
+// exportHiddifyPanel returns the full panel database export and the raw
+// admin API user list. Either may be empty when that path is unavailable;
+// the users list is kept as a fallback for restore.
+func exportHiddifyPanel() ([]byte, []byte, error) {
+	c := mustClient(false)
+	if !fileExists(c.panelCfg) {
+		return nil, nil, nil
+	}
+	var errs []string
+	var panel, users []byte
+	if out, err := c.runPanelScript(hiddifyPanelDumpPy); err != nil {
+		errs = append(errs, "panel export: "+err.Error())
+	} else if obj, err := extractJSONObject(out); err != nil {
+		errs = append(errs, "panel export: "+err.Error())
+	} else {
+		panel = obj
+	}
+	if err := c.ensureState(); err != nil {
+		errs = append(errs, "users export: "+err.Error())
+	} else if raw, err := c.api(http.MethodGet, "user/", nil); err != nil {
+		errs = append(errs, "users export: "+err.Error())
+	} else if !json.Valid(raw) {
+		errs = append(errs, "users export: invalid JSON from panel API")
+	} else {
+		users = raw
+	}
+	if len(errs) > 0 {
+		return panel, users, errors.New(strings.Join(errs, "; "))
+	}
+	return panel, users, nil
+}
+
+func backupPassphrase(file string) (string, error) {
+	if strings.TrimSpace(file) != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		line, _, _ := strings.Cut(string(raw), "\n")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return "", fmt.Errorf("empty passphrase in %s", file)
+		}
+		return line, nil
+	}
+	return os.Getenv("PSAS_BACKUP_PASSPHRASE"), nil
+}
+
+// backupEncrypt seals data with AES-256-GCM under a PBKDF2-SHA256 key:
+// MAGIC | iter (uint32 BE) | salt (16) | nonce (12) | ciphertext.
+func backupEncrypt(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	mustReadRand(salt)
+	gcm, err := backupGCM(passphrase, salt, backupPBKDF2Iter)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	mustReadRand(nonce)
+	var out bytes.Buffer
+	out.WriteString(backupEncMagic)
+	_ = binary.Write(&out, binary.BigEndian, uint32(backupPBKDF2Iter))
+	out.Write(salt)
+	out.Write(nonce)
+	out.Write(gcm.Seal(nil, nonce, data, []byte(backupEncMagic)))
+	return out.Bytes(), nil
+}
+
+func backupDecrypt(data []byte, passphrase string) ([]byte, error) {
+	head := len(backupEncMagic) + 4 + 16 + 12
+	if len(data) < head || string(data[:len(backupEncMagic)]) != backupEncMagic {
+		return nil, errors.New("not a psasctl encrypted backup")
+	}
+	iter := binary.BigEndian.Uint32(data[len(backupEncMagic):])
+	if iter == 0 || iter > 10_000_000 {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", iter)
+	}
+	salt := data[len(backupEncMagic)+4 : len(backupEncMagic)+20]
+	nonce := data[len(backupEncMagic)+20 : head]
+	gcm, err := backupGCM(passphrase, salt, int(iter))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, data[head:], []byte(backupEncMagic))
+	if err != nil {
+		return nil, errors.New("decrypt backup: wrong passphrase or corrupted file")
+	}
+	return plain, nil
+}
+
+func backupGCM(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iter, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// runAge pipes data through the age binary.
+func runAge(data []byte, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("age")
+	if err != nil {
+		return nil, errors.New("age binary not found in PATH")
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("age: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// rotateBackups removes the oldest archives in dir beyond keep. Archive
+// names embed a UTC timestamp, so lexical order is chronological.
+func rotateBackups(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	names, err := backupArchiveNames(dir)
+	if err != nil || len(names) <= keep {
+		return nil, err
+	}
+	var removed []string
+	for _, name := range names[:len(names)-keep] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func backupArchiveNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.Contains(name, backupPlainExt) {
+			continue
+		}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func backupEncryption(path string) string {
+	switch {
+	case strings.HasSuffix(path, backupPlainExt+backupPassphraseExt):
+		return "passphrase"
+	case strings.HasSuffix(path, backupPlainExt+backupAgeExt):
+		return "age"
+	default:
+		return ""
+	}
+}
+
+func runBackupList(args []string) {
+	fs := flag.NewFlagSet("backup list", flag.ExitOnError)
+	dir := fs.String("dir", backupDir(), "directory with backup archives")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	names, err := backupArchiveNames(*dir)
+	must(err)
+	list := make([]backupListEntry, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		path := filepath.Join(*dir, names[i])
+		entry := backupListEntry{File: path, Encryption: backupEncryption(path)}
+		if st, err := os.Stat(path); err == nil {
+			entry.Size = st.Size()
+			entry.ModTime = st.ModTime().UTC().Format(time.RFC3339)
+		}
+		if entry.Encryption == "" {
+			if raw, err := os.ReadFile(path); err != nil {
+				entry.Error = err.Error()
+			} else if m, _, err := readBackupArchive(raw); err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.CreatedAt = m.CreatedAt
+				entry.Components = m.Components
+			}
+		}
+		list = append(list, entry)
+	}
+	if *jsonOut {
+		printJSON(list)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Printf("No backups in %s\n", *dir)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSIZE\tMODIFIED\tENCRYPTION\tCOMPONENTS")
+	for _, e := range list {
+		comps := strings.Join(e.Components, ",")
+		if e.Error != "" {
+			comps = "error: " + shortText(e.Error, 60)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", filepath.Base(e.File), e.Size, valueOrDash(e.ModTime), valueOrDash(e.Encryption), valueOrDash(comps))
+	}
+	_ = tw.Flush()
+}
+
+// readBackupArchive unpacks a plain .tar.gz and verifies every entry against
+// the manifest checksums.
+func readBackupArchive(raw []byte) (backupManifest, map[string][]byte, error) {
+	var manifest backupManifest
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return manifest, nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	contents := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > backupMaxEntrySize {
+			return manifest, nil, fmt.Errorf("archive entry %s is too large", hdr.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, backupMaxEntrySize))
+		if err != nil {
+			return manifest, nil, err
+		}
+		contents[hdr.Name] = data
+	}
+	rawManifest, ok := contents[backupManifestName]
+	if !ok {
+		return manifest, nil, errors.New("manifest.json is missing")
+	}
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if manifest.Format != backupFormat {
+		return manifest, nil, fmt.Errorf("unexpected backup format %q", manifest.Format)
+	}
+	if manifest.Version > backupFormatVersion {
+		return manifest, nil, fmt.Errorf("backup format version %d is newer than supported %d", manifest.Version, backupFormatVersion)
+	}
+	for _, f := range manifest.Files {
+		data, ok := contents[f.Name]
+		if !ok {
+			return manifest, nil, fmt.Errorf("%s is listed in the manifest but missing", f.Name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.SHA256 || int64(len(data)) != f.Size {
+			return manifest, nil, fmt.Errorf("checksum mismatch for %s", f.Name)
+		}
+	}
+	return manifest, contents, nil
+}
+
+func runBackupRestore(args []string) {
+	fs := flag.NewFlagSet("backup restore", flag.ExitOnError)
+	only := fs.String("only", "", "comma-separated components to restore (default: all in the archive)")
+	passFile := fs.String("passphrase-file", "", "passphrase file for .enc archives (or set PSAS_BACKUP_PASSPHRASE)")
+	ageIdentity := fs.String("age-identity", "", "age identity file for .age archives")
+	dryRun := fs.Bool("dry-run", false, "validate the archive and print what would be restored")
+	noRestart := fs.Bool("no-restart", false, "do not restart services or apply Hiddify after restore")
+	noSafety := fs.Bool("no-safety-backup", false, "do not back up the current state before restoring")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 1 {
+		fatalf("usage: psasctl backup restore [flags] <FILE>")
+	}
+	path := fs.Args()[0]
+	if !filepath.IsAbs(path) && !fileExists(path) {
+		path = filepath.Join(backupDir(), path)
+	}
+	raw, err := os.ReadFile(path)
+	must(err)
+	switch backupEncryption(path) {
+	case "passphrase":
+		passphrase, err := backupPassphrase(*passFile)
+		must(err)
+		raw, err = backupDecrypt(raw, passphrase)
+		must(err)
+	case "age":
+		if strings.TrimSpace(*ageIdentity) == "" {
+			fatalf("--age-identity is required for .age archives")
+		}
+		raw, err = runAge(raw, "--decrypt", "--identity", *ageIdentity)
+		must(err)
+	}
+	manifest, contents, err := readBackupArchive(raw)
+	must(err)
+
+	comps := manifest.Components
+	if strings.TrimSpace(*only) != "" {
+		selected, err := backupSelectComponents(*only, "")
+		must(err)
+		comps = nil
+		for _, c := range selected {
+			for _, have := range manifest.Components {
+				if c == have {
+					comps = append(comps, c)
+				}
+			}
+		}
+		if len(comps) == 0 {
+			fatalf("archive has none of the requested components (has: %s)", strings.Join(manifest.Components, ","))
+		}
+	}
+	files := backupFilesFor(manifest, comps)
+	for i, f := range files {
+		if err := validateBackupEntry(f, contents[f.Name]); err != nil {
+			fatalf("invalid %s: %v", f.Name, err)
+		}
+		// Show where the entry goes on this host, not where it came from.
+		files[i].Path, _ = backupRestorePath(f)
+	}
+
+	if *dryRun && *jsonOut {
+		printJSON(map[string]any{"file": path, "manifest": manifest, "components": comps, "dry_run": true})
+		return
+	}
+	if !*jsonOut {
+		fmt.Printf("Backup: %s\nCreated: %s on %s\nComponents: %s\n", path, manifest.CreatedAt, valueOrDash(manifest.Hostname), strings.Join(comps, ", "))
+		for _, f := range files {
+			fmt.Printf("  %s -> %s\n", f.Name, valueOrDash(f.Path))
+		}
+	}
+	if *dryRun {
+		fmt.Println("Archive is valid. Dry run, nothing restored.")
+		return
+	}
+	must(requireRoot("backup restore"))
+	if !*yes {
+		if !isInteractiveTerminal() {
+			fatalf("refusing to restore without confirmation; pass --yes")
+		}
+		ok, err := promptYesNo(bufio.NewReader(os.Stdin), "Overwrite current configuration?", false)
+		must(err)
+		if !ok {
+			fmt.Println(uiText("Canceled."))
+			return
+		}
+	}
+	if !*noSafety {
+		if safety, _, err := buildBackupArchive(comps); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: safety backup skipped: %v\n", err)
+		} else {
+			dst := filepath.Join(backupDir(), backupFilePrefix+time.Now().UTC().Format("20060102-150405")+"-pre-restore"+backupPlainExt)
+			if err := os.MkdirAll(backupDir(), 0o700); err == nil {
+				err = writeFileAtomic(dst, safety, 0o600)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: safety backup failed: %v\n", err)
+			} else if !*jsonOut {
+				fmt.Printf("Current state saved to %s\n", dst)
+			}
+		}
+	}
+
+	results := restoreBackupComponents(comps, files, contents, !*noRestart)
+	failed := false
+	for _, r := range results {
+		failed = failed || r.Error != ""
+	}
+	if *jsonOut {
+		printJSON(map[string]any{"file": path, "manifest": manifest, "results": results})
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("%s: FAILED: %s\n", r.Component, r.Error)
+				continue
+			}
+			fmt.Printf("%s: restored (%s)\n", r.Component, strings.Join(r.Actions, "; "))
+			for _, w := range r.Warnings {
+				fmt.Printf("  Warning: %s\n", w)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type backupRestoreResult struct {
+	Component string   `json:"component"`
+	Actions   []string `json:"actions,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func backupFilesFor(m backupManifest, comps []string) []backupFile {
+	want := map[string]bool{}
+	for _, c := range comps {
+		want[c] = true
+	}
+	var out []backupFile
+	for _, f := range m.Files {
+		if want[f.Component] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// backupRestorePath maps an archive entry to the file it replaces on this
+// host. The path recorded in the manifest is never used: it is not
+// authenticated and may follow PSAS_* overrides of another host. Panel
+// exports have no file and map to "".
+func backupRestorePath(f backupFile) (string, error) {
+	if f.Component == backupComponentHidd && (f.Name == backupHiddifyPanel || f.Name == backupHiddifyUsers) {
+		return "", nil
+	}
+	for _, src := range backupSources(f.Component) {
+		if src.Name == f.Name {
+			return src.Path, nil
+		}
+	}
+	return "", fmt.Errorf("unknown archive entry %q for component %s", f.Name, f.Component)
+}
+
+// validateBackupEntry checks an entry before anything is written, so a
+// damaged archive never leaves the server half-restored.
+func validateBackupEntry(f backupFile, data []byte) error {
+	if _, err := backupRestorePath(f); err != nil {
+		return err
+	}
+	base := filepath.Base(f.Name)
+	switch {
+	case base == "socks-users.json":
+		var users []socksUser
+		if err := json.Unmarshal(data, &users); err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := validateSocksLogin(normalizeSocksLogin(u.Name)); err != nil {
+				return err
+			}
+			if u.SystemUser != "" {
+				if err := validateSocksLogin(strings.TrimSpace(u.SystemUser)); err != nil {
+					return fmt.Errorf("socks user %s: system_user: %w", u.Name, err)
+				}
+			}
+			if strings.TrimSpace(u.Password) == "" {
+				return fmt.Errorf("socks user %s has empty password", u.Name)
+			}
+		}
+	case base == "mtproxy.json":
+		var cfg mtproxyConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return err
+		}
+		if _, err := normalizeMTProxySecret(cfg.Secret); err != nil {
+			return err
+		}
+	case strings.HasSuffix(base, ".json"):
+		if len(bytes.TrimSpace(data)) > 0 && !json.Valid(data) {
+			return errors.New("invalid JSON")
+		}
+	case base == "danted.conf":
+		if !strings.Contains(string(data), "internal:") {
+			return errors.New("no internal: directive")
+		}
+	case strings.HasSuffix(base, ".toml"):
+		if len(bytes.TrimSpace(data)) == 0 {
+			return errors.New("empty file")
+		}
+	}
+	return nil
+}
+
+func restoreBackupComponents(comps []string, files []backupFile, contents map[string][]byte, restart bool) []backupRestoreResult {
+	var results []backupRestoreResult
+	for _, comp := range comps {
+		r := backupRestoreResult{Component: comp}
+		for _, f := range files {
+			if f.Component != comp {
+				continue
+			}
+			path, err := backupRestorePath(f)
+			if err != nil {
+				r.Error = err.Error()
+				break
+			}
+			if path == "" {
+				continue
+			}
+			mode := os.FileMode(f.Mode).Perm()
+			if mode == 0 {
+				mode = 0o600
+			}
+			data := contents[f.Name]
+			if err := withStateLock(path, func() error { return writeFileAtomic(path, data, mode) }); err != nil {
+				r.Error = err.Error()
+				break
+			}
+			r.Actions = append(r.Actions, "wrote "+path)
+		}
+		if r.Error == "" {
+			if err := reapplyBackupComponent(comp, contents, restart, &r); err != nil {
+				r.Error = err.Error()
+			}
+		}
+		var restoreErr error
+		if r.Error != "" {
+			restoreErr = errors.New(r.Error)
+		}
+		auditRecord("backup.restore", comp, nil, restoreErr)
+		results = append(results, r)
+	}
+	return results
+}
+
+func reapplyBackupComponent(comp string, contents map[string][]byte, restart bool, r *backupRestoreResult) error {
+	switch comp {
+	case backupComponentSocks:
+		sc := newSocksClient()
+		users, err := sc.usersList()
+		if err != nil {
+			return err
+		}
+		ensured := 0
+		for _, u := range users {
+			login := socksSystemUser(u)
+			if err := sc.checkSocksLinuxAccount(login); err != nil {
+				r.Warnings = append(r.Warnings, "skipped: "+err.Error())
+				continue
+			}
+			if err := sc.ensureLinuxUser(login, u.Password); err != nil {
+				r.Warnings = append(r.Warnings, err.Error())
+				continue
+			}
+			ensured++
+		}
+		r.Actions = append(r.Actions, fmt.Sprintf("ensured %d Linux user(s)", ensured))
+		if restart && sc.installed() {
+			if err := sc.restartService(); err != nil {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("restart %s: %v", sc.service, err))
+			} else {
+				r.Actions = append(r.Actions, "restarted "+sc.service)
+			}
+		}
+	case backupComponentTrust:
+		tt := newTrustClient()
+		if restart && tt.installed() {
+			if err := tt.restartService(); err != nil {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("restart %s: %v", tt.service, err))
+			} else {
+				r.Actions = append(r.Actions, "restarted "+tt.service)
+			}
+		}
+	case backupComponentMTP:
+		mp := newMTProxyClient()
+		if restart && mp.installed() {
+			if err := mp.restartService(); err != nil {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("restart %s: %v", mp.service, err))
+			} else {
+				r.Actions = append(r.Actions, "restarted "+mp.service)
+			}
+		}
+	case backupComponentHidd:
+		return restoreHiddifyPanel(contents, restart, r)
+	}
+	return nil
+}
+
+// restoreHiddifyPanel imports the panel export; if the panel cannot import
+// it (or the archive has no export) users are upserted through the API.
+func restoreHiddifyPanel(contents map[string][]byte, restart bool, r *backupRestoreResult) error {
+	c := mustClient(false)
+	imported := false
+	if panel, ok := contents[backupHiddifyPanel]; ok {
+		tmp, err := os.CreateTemp("", "psas-panel-*.json")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, werr := tmp.Write(panel)
+		if cerr := tmp.Close(); werr == nil {
+			werr = cerr
+		}
+		if werr != nil {
+			return werr
+		}
+		if _, err := c.runPanelScript(hiddifyPanelImportPy, tmp.Name()); err != nil {
+			r.Warnings = append(r.Warnings, "panel import failed, falling back to API users: "+err.Error())
+		} else {
+			imported = true
+			r.Actions = append(r.Actions, "imported panel database")
+		}
+	}
+	if !imported {
+		raw, ok := contents[backupHiddifyUsers]
+		if !ok {
+			return errors.New("archive has neither a usable panel export nor a users list")
+		}
+		if err := c.ensureState(); err != nil {
+			return err
+		}
+		created, updated, warnings, err := upsertHiddifyUsers(c, raw)
+		r.Warnings = append(r.Warnings, warnings...)
+		if err != nil {
+			return err
+		}
+		r.Actions = append(r.Actions, fmt.Sprintf("users via API: %d created, %d updated", created, updated))
+	}
+	if restart {
+		if err := c.ensureState(); err != nil {
+			r.Warnings = append(r.Warnings, "apply skipped: "+err.Error())
+		} else if err := applyWithClient(c); err != nil {
+			r.Warnings = append(r.Warnings, "apply: "+err.Error())
+		} else {
+			r.Actions = append(r.Actions, "applied Hiddify config")
+		}
+	}
+	return nil
+}
+
+// hiddifyReadOnlyUserFields are returned by the admin API but rejected or
+// meaningless on create/update.
+var hiddifyReadOnlyUserFields = []string{"id", "added_by_uuid", "last_online", "remaining_days"}
+
+// upsertHiddifyUsers recreates raw admin API users, keeping their UUIDs.
+// Per-user failures are returned as warnings.
+func upsertHiddifyUsers(c *client, raw []byte) (int, int, []string, error) {
+	var users []map[string]any
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return 0, 0, nil, fmt.Errorf("parse users: %w", err)
+	}
+	existing, err := c.usersList()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	have := map[string]apiUser{}
+	for _, u := range existing {
+		have[strings.ToLower(u.UUID)] = u
+	}
+	var warnings []string
+	created, updated := 0, 0
+	for _, u := range users {
+		id, _ := u["uuid"].(string)
+		id = strings.ToLower(strings.TrimSpace(id))
+		if validateUUID(id) != nil {
+			warnings = append(warnings, fmt.Sprintf("skip user without valid uuid: %v", u["name"]))
+			continue
+		}
+		for _, k := range hiddifyReadOnlyUserFields {
+			delete(u, k)
+		}
+		u["uuid"] = id
+		if current, ok := have[id]; ok {
+			if _, err := c.userPatch(current, u); err != nil {
+				warnings = append(warnings, fmt.Sprintf("update %s: %v", id, shortText(err.Error(), 160)))
+				continue
+			}
+			updated++
+			continue
+		}
+		if _, err := c.userAdd(u); err != nil {
+			warnings = append(warnings, fmt.Sprintf("create %s: %v", id, shortText(err.Error(), 160)))
+			continue
+		}
+		created++
+	}
+	return created, updated, warnings, nil
+}
@@ -0,0 +1,955 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultBotConfigPath = "/etc/psas/bot.json"
+	defaultBotUnitPath   = "/etc/systemd/system/psas-bot.service"
+	botPollTimeout       = 50
+	botMaxListedUsers    = 50
+)
+
+// botConfig is /etc/psas/bot.json. Bindings map a Telegram user ID to the
+// Hiddify UUID of the end user allowed to fetch links from that account.
+// Self-binding with /start UUID is opt-in: whoever learns a UUID could
+// otherwise bind to it and keep pulling that user's links.
+type botConfig struct {
+	Token    string            `json:"token"`
+	APIBase  string            `json:"api_base,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Admins   []int64           `json:"admins,omitempty"`
+	SelfBind bool              `json:"self_bind,omitempty"`
+	Bindings map[string]string `json:"bindings,omitempty"`
+}
+
+type tgUpdate struct {
+	UpdateID int64      `json:"update_id"`
+	Message  *tgMessage `json:"message"`
+}
+
+type tgMessage struct {
+	MessageID int64   `json:"message_id"`
+	From      *tgUser `json:"from"`
+	Chat      tgChat  `json:"chat"`
+	Text      string  `json:"text"`
+}
+
+type tgUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type tgChat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+// tgBot is a minimal Telegram Bot API client.
+type tgBot struct {
+	base  string
+	token string
+	hc    *http.Client
+}
+
+type botServer struct {
+	api *tgBot
+	c   *client
+	mp  *mtproxyClient
+}
+
+func botConfigPath() string {
+	return envOr("PSAS_BOT", defaultBotConfigPath)
+}
+
+func loadBotConfig() (botConfig, error) {
+	cfg := botConfig{Bindings: map[string]string{}}
+	p := botConfigPath()
+	raw, err := loadStateFile(p)
+	if err != nil {
+		return cfg, err
+	}
+	if strings.TrimSpace(string(raw)) == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", p, err)
+	}
+	if cfg.Bindings == nil {
+		cfg.Bindings = map[string]string{}
+	}
+	return cfg, nil
+}
+
+func writeBotConfig(cfg botConfig) error {
+	payload, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := botConfigPath()
+	return saveStateFile(p, append(payload, '\n'), 0o600)
+}
+
+func (cfg botConfig) isAdmin(id int64) bool {
+	for _, a := range cfg.Admins {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
+func newTGBot(base, token string) *tgBot {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	if base == "" {
+		base = defaultTelegramAPIBase
+	}
+	return &tgBot{
+		base:  base,
+		token: strings.TrimSpace(token),
+		hc:    &http.Client{Timeout: (botPollTimeout + 15) * time.Second},
+	}
+}
+
+func (b *tgBot) do(req *http.Request, out any) error {
+	resp, err := b.hc.Do(req)
+	if err != nil {
+		// The URL contains the bot token; keep it out of logs.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return fmt.Errorf("%s: %w", uerr.Op, uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+	var env struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("telegram: HTTP %d: invalid response", resp.StatusCode)
+	}
+	if !env.OK {
+		return fmt.Errorf("telegram: %s", valueOrDash(env.Description))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(env.Result, out)
+}
+
+func (b *tgBot) call(ctx context.Context, method string, payload any, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.base+"/bot"+b.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return b.do(req, out)
+}
+
+func (b *tgBot) getUpdates(ctx context.Context, offset int64) ([]tgUpdate, error) {
+	var updates []tgUpdate
+	err := b.call(ctx, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         botPollTimeout,
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+func (b *tgBot) sendMessage(chatID int64, text string) error {
+	return b.call(context.Background(), "sendMessage", map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+func (b *tgBot) sendPhoto(chatID int64, png []byte, caption string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	if caption != "" {
+		_ = mw.WriteField("caption", caption)
+	}
+	fw, err := mw.CreateFormFile("photo", "qr.png")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(png); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.base+"/bot"+b.token+"/sendPhoto", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return b.do(req, nil)
+}
+
+func runBot(args []string) {
+	if len(args) < 1 {
+		fatalf("bot requires subcommand: run|config|bind|unbind|bindings|unit|install")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "run":
+		fs := flag.NewFlagSet("bot run", flag.ExitOnError)
+		token := fs.String("token", "", "bot token (default: from bot.json or PSAS_BOT_TOKEN)")
+		apiBase := fs.String("api-base", "", "Telegram Bot API base URL (default: from bot.json or "+defaultTelegramAPIBase+")")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("bot run takes only flags")
+		}
+		cfg, err := loadBotConfig()
+		must(err)
+		tok := firstNonEmpty(*token, os.Getenv("PSAS_BOT_TOKEN"), cfg.Token)
+		if tok == "" {
+			fatalf("bot token is not configured (psasctl bot config --token TOKEN)")
+		}
+		base := firstNonEmpty(*apiBase, cfg.APIBase)
+		s := &botServer{api: newTGBot(base, tok), c: mustClient(true), mp: newMTProxyClient()}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		s.run(ctx)
+	case "config":
+		fs := flag.NewFlagSet("bot config", flag.ExitOnError)
+		token := fs.String("token", "", "bot token from @BotFather")
+		apiBase := fs.String("api-base", "", "Telegram Bot API base URL")
+		host := fs.String("host", "", "domain for generated links (default: panel main domain)")
+		var admins stringListFlag
+		fs.Var(&admins, "admin", "Telegram user ID allowed to run admin commands (repeatable)")
+		clearAdmins := fs.Bool("clear-admins", false, "remove all admins before adding --admin values")
+		selfBind := fs.String("self-bind", "", "allow end users to bind themselves with /start UUID: on|off (default off)")
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("bot config takes only flags")
+		}
+		cfg, err := loadBotConfig()
+		must(err)
+		changed := false
+		if strings.TrimSpace(*token) != "" {
+			cfg.Token = strings.TrimSpace(*token)
+			changed = true
+		}
+		if strings.TrimSpace(*apiBase) != "" {
+			cfg.APIBase = strings.TrimSpace(*apiBase)
+			changed = true
+		}
+		if strings.TrimSpace(*host) != "" {
+			cfg.Host = strings.TrimSpace(*host)
+			changed = true
+		}
+		if *clearAdmins {
+			cfg.Admins = nil
+			changed = true
+		}
+		for _, raw := range admins {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				fatalf("invalid --admin (Telegram user ID expected): %s", raw)
+			}
+			if !cfg.isAdmin(id) {
+				cfg.Admins = append(cfg.Admins, id)
+			}
+			changed = true
+		}
+		if strings.TrimSpace(*selfBind) != "" {
+			v, err := parseBoolLike(*selfBind)
+			must(err)
+			cfg.SelfBind = v
+			changed = true
+		}
+		if changed {
+			must(requireRoot("bot config"))
+			must(writeBotConfig(cfg))
+		}
+		view := cfg
+		if view.Token != "" {
+			view.Token = maskSecret(view.Token)
+		}
+		if *jsonOut {
+			printJSON(view)
+			return
+		}
+		fmt.Printf("Config    : %s\n", botConfigPath())
+		fmt.Printf("Token     : %s\n", valueOrDash(view.Token))
+		fmt.Printf("API base  : %s\n", valueOrDash(view.APIBase))
+		fmt.Printf("Host      : %s\n", valueOrDash(view.Host))
+		fmt.Printf("Admins    : %s\n", valueOrDash(joinInt64s(view.Admins)))
+		fmt.Printf("Self-bind : %t\n", view.SelfBind)
+		fmt.Printf("Bindings  : %d\n", len(view.Bindings))
+	case "bind":
+		if len(subArgs) != 2 {
+			fatalf("bot bind requires <TELEGRAM_ID> <USER_ID>")
+		}
+		must(requireRoot("bot bind"))
+		tgID, err := strconv.ParseInt(strings.TrimSpace(subArgs[0]), 10, 64)
+		if err != nil {
+			fatalf("invalid Telegram user ID: %s", subArgs[0])
+		}
+		c := mustClient(true)
+		u, err := c.resolveUser(subArgs[1])
+		must(err)
+		cfg, err := loadBotConfig()
+		must(err)
+		cfg.Bindings[strconv.FormatInt(tgID, 10)] = u.UUID
+		must(writeBotConfig(cfg))
+		fmt.Printf("Telegram %d bound to %s (%s)\n", tgID, u.Name, u.UUID)
+	case "unbind":
+		if len(subArgs) != 1 {
+			fatalf("bot unbind requires <TELEGRAM_ID>")
+		}
+		must(requireRoot("bot unbind"))
+		cfg, err := loadBotConfig()
+		must(err)
+		key := strings.TrimSpace(subArgs[0])
+		if _, ok := cfg.Bindings[key]; !ok {
+			fatalf("no binding for Telegram ID: %s", key)
+		}
+		delete(cfg.Bindings, key)
+		must(writeBotConfig(cfg))
+		fmt.Printf("Telegram %s unbound\n", key)
+	case "bindings":
+		fs := flag.NewFlagSet("bot bindings", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		cfg, err := loadBotConfig()
+		must(err)
+		if *jsonOut {
+			printJSON(cfg.Bindings)
+			return
+		}
+		ids := make([]string, 0, len(cfg.Bindings))
+		for id := range cfg.Bindings {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TELEGRAM_ID\tUUID")
+		for _, id := range ids {
+			fmt.Fprintf(tw, "%s\t%s\n", id, cfg.Bindings[id])
+		}
+		_ = tw.Flush()
+	case "unit":
+		fmt.Print(renderPSASUnit("PSAS Telegram bot", []string{"bot", "run"}))
+	case "install":
+		fs := flag.NewFlagSet("bot install", flag.ExitOnError)
+		unitPath := fs.String("unit-path", defaultBotUnitPath, "systemd unit file path")
+		noStart := fs.Bool("no-start", false, "write the unit but do not enable/start it")
+		must(fs.Parse(subArgs))
+		must(requireRoot("bot install"))
+		must(installPSASUnit(*unitPath, renderPSASUnit("PSAS Telegram bot", []string{"bot", "run"}), !*noStart))
+	default:
+		fatalf("unknown bot subcommand: %s", sub)
+	}
+}
+
+func (s *botServer) run(ctx context.Context) {
+	fmt.Fprintln(os.Stderr, "psasctl bot: polling Telegram updates")
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := s.api.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "psasctl bot: getUpdates: %v\n", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, upd := range updates {
+			if upd.UpdateID >= offset {
+				offset = upd.UpdateID + 1
+			}
+			if upd.Message == nil || upd.Message.From == nil {
+				continue
+			}
+			s.handle(upd.Message)
+		}
+	}
+}
+
+// handle answers one message. Only private chats are served so links and
+// credentials never end up in group chats.
+func (s *botServer) handle(m *tgMessage) {
+	if m.Chat.Type != "private" {
+		return
+	}
+	cmd, args := parseBotCommand(m.Text)
+	if cmd == "" {
+		return
+	}
+	cfg, err := loadBotConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "psasctl bot: %v\n", err)
+		s.reply(m, uiText("Temporary error, try again later."))
+		return
+	}
+	admin := cfg.isAdmin(m.From.ID)
+	setAuditContext(auditContext{Via: "bot", Actor: "tg:" + strconv.FormatInt(m.From.ID, 10), Command: "/" + cmd})
+	if admin {
+		if handled := s.handleAdmin(m, cfg, cmd, args); handled {
+			return
+		}
+	}
+	s.handleUser(m, cfg, cmd, args, admin)
+}
+
+func (s *botServer) handleUser(m *tgMessage, cfg botConfig, cmd string, args []string, admin bool) {
+	key := strconv.FormatInt(m.From.ID, 10)
+	switch cmd {
+	case "start", "bind":
+		if len(args) == 0 {
+			if _, ok := cfg.Bindings[key]; ok {
+				s.reply(m, botUserHelp(admin))
+				return
+			}
+			s.reply(m, botLinkHint(cfg, admin))
+			return
+		}
+		if !cfg.SelfBind && !admin {
+			s.reply(m, uiText("Self-service linking is disabled. Ask the administrator to link your account."))
+			return
+		}
+		id := strings.ToLower(strings.TrimSpace(args[0]))
+		if validateUUID(id) != nil {
+			s.reply(m, uiText("Invalid UUID."))
+			return
+		}
+		u, err := s.c.userShow(id)
+		if err != nil {
+			s.reply(m, uiText("User not found."))
+			return
+		}
+		cfg.Bindings[key] = u.UUID
+		if err := writeBotConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "psasctl bot: %v\n", err)
+			s.reply(m, uiText("Temporary error, try again later."))
+			return
+		}
+		s.reply(m, uiTextf("Account linked: %s", u.Name)+"\n\n"+botUserHelp(admin))
+		return
+	case "help":
+		s.reply(m, botUserHelp(admin))
+		return
+	case "unbind":
+		if _, ok := cfg.Bindings[key]; !ok {
+			s.reply(m, uiText("Your account is not linked yet.")+" "+botLinkHint(cfg, admin))
+			return
+		}
+		delete(cfg.Bindings, key)
+		if err := writeBotConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "psasctl bot: %v\n", err)
+			s.reply(m, uiText("Temporary error, try again later."))
+			return
+		}
+		s.reply(m, uiText("Account unlinked."))
+		return
+	case "mtproxy":
+		// The MTProxy link is shared by everybody; still only for linked users.
+	case "link", "links", "qr", "usage", "status":
+	default:
+		s.reply(m, uiText("Unknown command. Send /help."))
+		return
+	}
+
+	id, ok := cfg.Bindings[key]
+	if !ok {
+		s.reply(m, uiText("Your account is not linked yet.")+" "+botLinkHint(cfg, admin))
+		return
+	}
+	u, err := s.c.userShow(id)
+	if err != nil {
+		s.reply(m, uiText("User not found."))
+		return
+	}
+	switch cmd {
+	case "link":
+		s.reply(m, s.links(cfg, u.UUID).Auto)
+	case "links":
+		s.reply(m, renderBotLinks(s.links(cfg, u.UUID)))
+	case "qr":
+		s.replyQR(m, s.links(cfg, u.UUID).Auto)
+	case "usage", "status":
+		s.reply(m, renderBotUsage(u))
+	case "mtproxy":
+		if !s.mp.installed() {
+			s.reply(m, uiText("MTProxy is not installed."))
+			return
+		}
+		info, err := s.mp.connectionInfo("", 0, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "psasctl bot: mtproxy: %v\n", err)
+			s.reply(m, uiText("Temporary error, try again later."))
+			return
+		}
+		s.reply(m, info.ShareURL)
+	}
+}
+
+// botLinkHint tells an unlinked user how to get linked.
+func botLinkHint(cfg botConfig, admin bool) string {
+	if !cfg.SelfBind && !admin {
+		return uiText("Self-service linking is disabled. Ask the administrator to link your account.")
+	}
+	return uiText("Send /start <UUID> to link your account. The UUID is the last part of your subscription link.")
+}
+
+// handleAdmin runs admin-only commands and reports whether cmd was one.
+func (s *botServer) handleAdmin(m *tgMessage, cfg botConfig, cmd string, args []string) bool {
+	switch cmd {
+	case "users":
+		users, err := s.c.usersList()
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		query := strings.Join(args, " ")
+		users = filterUsers(users, query, false)
+		_ = sortUsers(users, "name", false)
+		var b strings.Builder
+		fmt.Fprintf(&b, "Users: %d\n", len(users))
+		for i, u := range users {
+			if i == botMaxListedUsers {
+				fmt.Fprintf(&b, "... and %d more\n", len(users)-botMaxListedUsers)
+				break
+			}
+			state := "on"
+			if !u.Enable {
+				state = "off"
+			}
+			fmt.Fprintf(&b, "%s [%s] %.1f/%s GB, %s d\n", u.Name, state, u.CurrentUsageGB, botLimitGB(u), formatRemainingDays(u))
+		}
+		s.reply(m, b.String())
+	case "show":
+		if len(args) != 1 {
+			s.reply(m, "Usage: /show USER_ID")
+			return true
+		}
+		u, err := s.c.resolveUser(args[0])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		s.reply(m, renderBotUserDetails(u)+"\n\n"+renderBotLinks(s.links(cfg, u.UUID)))
+	case "qr":
+		if len(args) == 0 {
+			return false
+		}
+		u, err := s.c.resolveUser(args[0])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		s.replyQR(m, s.links(cfg, u.UUID).Auto)
+	case "add":
+		if len(args) < 1 {
+			s.reply(m, "Usage: /add NAME [days=30] [gb=100] [mode=no_reset] [plan=NAME]")
+			return true
+		}
+		payload, trueUnlimited, err := botUserAddPayload(args[0], args[1:])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		if trueUnlimited {
+			if err := s.c.ensureTrueUnlimitedSupport(); err != nil {
+				s.replyErr(m, err)
+				return true
+			}
+		}
+		u, err := s.c.userAdd(payload)
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		notify(notifyEvent{Type: notifyUserCreated, Source: "bot", User: u.Name, UUID: u.UUID, Details: map[string]any{"admin": m.From.ID}})
+		s.reply(m, "User added.\n\n"+renderBotUserDetails(u)+"\n\n"+renderBotLinks(s.links(cfg, u.UUID)))
+	case "edit":
+		if len(args) < 2 {
+			s.reply(m, "Usage: /edit USER_ID [days=N] [gb=N] [add_days=N] [add_gb=N] [mode=MODE] [name=NAME] [enable|disable|reset]")
+			return true
+		}
+		u, err := s.c.resolveUser(args[0])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		// Relative changes must be computed from fresh values.
+		if u, err = s.c.userShow(u.UUID); err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		payload, err := botUserEditPayload(u, args[1:])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		updated, err := s.c.userPatch(u, payload)
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		changes := userEditChanges(u, updated)
+		notify(notifyEvent{Type: notifyUserUpdated, Source: "bot", User: updated.Name, UUID: updated.UUID, Details: map[string]any{"changes": changes, "admin": m.From.ID}})
+		var b strings.Builder
+		b.WriteString("User updated.\n")
+		for _, ch := range changes {
+			fmt.Fprintf(&b, "%s: %v -> %v\n", ch.Field, ch.Before, ch.After)
+		}
+		b.WriteString("\n" + renderBotUserDetails(updated))
+		s.reply(m, b.String())
+	case "bind":
+		if len(args) != 2 {
+			return false
+		}
+		tgID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			s.reply(m, "Usage: /bind TELEGRAM_ID USER_ID")
+			return true
+		}
+		u, err := s.c.resolveUser(args[1])
+		if err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		cfg.Bindings[strconv.FormatInt(tgID, 10)] = u.UUID
+		if err := writeBotConfig(cfg); err != nil {
+			s.replyErr(m, err)
+			return true
+		}
+		s.reply(m, fmt.Sprintf("Telegram %d bound to %s (%s)", tgID, u.Name, u.UUID))
+	case "start", "help":
+		if len(args) != 0 {
+			return false
+		}
+		s.reply(m, botAdminHelp()+"\n\n"+botUserHelp(true))
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *botServer) links(cfg botConfig, uuid string) linkSet {
+	host := strings.TrimSpace(cfg.Host)
+	if host == "" {
+		host = s.c.mainDomain()
+	}
+	return buildLinks(s.c.clientPath(), uuid, host)
+}
+
+func (s *botServer) reply(m *tgMessage, text string) {
+	if err := s.api.sendMessage(m.Chat.ID, text); err != nil {
+		fmt.Fprintf(os.Stderr, "psasctl bot: sendMessage: %v\n", err)
+	}
+}
+
+func (s *botServer) replyErr(m *tgMessage, err error) {
+	s.reply(m, "Error: "+err.Error())
+}
+
+func (s *botServer) replyQR(m *tgMessage, text string) {
+	png, err := qrPNG(text, bundleQRScale)
+	if err != nil {
+		s.replyErr(m, err)
+		return
+	}
+	if err := s.api.sendPhoto(m.Chat.ID, png, text); err != nil {
+		fmt.Fprintf(os.Stderr, "psasctl bot: sendPhoto: %v\n", err)
+	}
+}
+
+// parseBotCommand splits "/cmd@BotName a b" into ("cmd", ["a", "b"]).
+func parseBotCommand(text string) (string, []string) {
+	fields := strings.Fields(strings.TrimSpace(text))
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil
+	}
+	cmd := strings.TrimPrefix(fields[0], "/")
+	if i := strings.Index(cmd, "@"); i >= 0 {
+		cmd = cmd[:i]
+	}
+	return strings.ToLower(cmd), fields[1:]
+}
+
+// parseBotOptions parses key=value arguments; bare words map to "true".
+func parseBotOptions(args []string) map[string]string {
+	out := map[string]string{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			v = "true"
+		}
+		out[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(k), "-", "_"))] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+// botUserAddPayload mirrors `users add`: plan values first, explicit
+// options override them. "unlimited" is accepted for days and gb.
+func botUserAddPayload(name string, args []string) (map[string]any, bool, error) {
+	opts := parseBotOptions(args)
+	days := 30
+	gb := 100.0
+	mode := "no_reset"
+	trueUnlimited := false
+	if planName := opts["plan"]; planName != "" {
+		plan, err := loadUserPlan(planName)
+		if err != nil {
+			return nil, false, err
+		}
+		days = plan.packageDays()
+		gb = plan.usageLimitGB()
+		trueUnlimited = plan.needsTrueUnlimited()
+		if plan.Mode != "" {
+			mode = plan.Mode
+		}
+	}
+	for k, v := range opts {
+		switch k {
+		case "plan":
+		case "days":
+			if strings.EqualFold(v, "unlimited") {
+				days = unlimitedPackageDays
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, false, fmt.Errorf("invalid days: %s", v)
+			}
+			days = n
+		case "gb":
+			if strings.EqualFold(v, "unlimited") {
+				gb = unlimitedUsageGB
+				continue
+			}
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n <= 0 {
+				return nil, false, fmt.Errorf("invalid gb: %s", v)
+			}
+			gb = n
+		case "mode":
+			if !isValidMode(v) {
+				return nil, false, fmt.Errorf("invalid mode: %s", v)
+			}
+			mode = v
+		default:
+			return nil, false, fmt.Errorf("unknown option: %s", k)
+		}
+	}
+	return map[string]any{
+		"uuid":           newUUID(),
+		"name":           name,
+		"package_days":   days,
+		"usage_limit_GB": gb,
+		"mode":           mode,
+		"enable":         true,
+	}, trueUnlimited, nil
+}
+
+// botUserEditPayload mirrors `users edit` for the options the bot accepts.
+func botUserEditPayload(u apiUser, args []string) (map[string]any, error) {
+	opts := parseBotOptions(args)
+	payload := map[string]any{}
+	for k, v := range opts {
+		switch k {
+		case "name":
+			if v == "" || v == "true" {
+				return nil, errors.New("name requires a value")
+			}
+			payload["name"] = v
+		case "mode":
+			if !isValidMode(v) {
+				return nil, fmt.Errorf("invalid mode: %s", v)
+			}
+			payload["mode"] = v
+		case "enable":
+			payload["enable"] = true
+		case "disable":
+			payload["enable"] = false
+		case "reset":
+			for rk, rv := range userResetPayload() {
+				payload[rk] = rv
+			}
+		case "days":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid days: %s", v)
+			}
+			payload["package_days"] = n
+		case "gb":
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid gb: %s", v)
+			}
+			payload["usage_limit_GB"] = n
+		case "add_days":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid add_days: %s", v)
+			}
+			if isUnlimitedDays(u) {
+				return nil, errors.New("add_days: user already has unlimited package days")
+			}
+			payload["package_days"] = u.PackageDays + n
+		case "add_gb":
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid add_gb: %s", v)
+			}
+			if isUnlimitedUsage(u) {
+				return nil, errors.New("add_gb: user already has unlimited traffic")
+			}
+			payload["usage_limit_GB"] = u.UsageLimitGB + n
+		default:
+			return nil, fmt.Errorf("unknown option: %s", k)
+		}
+	}
+	if _, ok := opts["enable"]; ok {
+		if _, ok := opts["disable"]; ok {
+			return nil, errors.New("enable and disable cannot be used together")
+		}
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("no changes requested")
+	}
+	return payload, nil
+}
+
+func botLimitGB(u apiUser) string {
+	if isUnlimitedUsage(u) {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(u.UsageLimitGB, 'f', -1, 64)
+}
+
+func renderBotUsage(u apiUser) string {
+	var b strings.Builder
+	b.WriteString(u.Name + "\n")
+	status := uiText("enabled")
+	if !u.Enable {
+		status = uiText("disabled")
+	}
+	fmt.Fprintf(&b, "%s: %s\n", uiText("Status"), status)
+	if isUnlimitedUsage(u) {
+		fmt.Fprintf(&b, "%s: %.2f GB (%s)\n", uiText("Traffic"), u.CurrentUsageGB, uiText("unlimited"))
+	} else {
+		left := u.UsageLimitGB - u.CurrentUsageGB
+		if left < 0 {
+			left = 0
+		}
+		fmt.Fprintf(&b, "%s: %.2f / %.2f GB (%s)\n", uiText("Traffic"), u.CurrentUsageGB, u.UsageLimitGB, formatUsagePercent(u))
+		fmt.Fprintf(&b, "%s: %.2f GB\n", uiText("Traffic left"), left)
+	}
+	days := formatRemainingDays(u)
+	if isUnlimitedDays(u) {
+		days = uiText("unlimited")
+	}
+	fmt.Fprintf(&b, "%s: %s", uiText("Days left"), days)
+	return b.String()
+}
+
+func renderBotUserDetails(u apiUser) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "UUID: %s\n", u.UUID)
+	fmt.Fprintf(&b, "Name: %s\n", u.Name)
+	fmt.Fprintf(&b, "Enabled: %t\n", u.Enable)
+	fmt.Fprintf(&b, "Used GB: %.2f (%s)\n", u.CurrentUsageGB, formatUsagePercent(u))
+	fmt.Fprintf(&b, "Limit GB: %s\n", botLimitGB(u))
+	fmt.Fprintf(&b, "Days left: %s\n", formatRemainingDays(u))
+	fmt.Fprintf(&b, "Mode: %s", u.Mode)
+	return b.String()
+}
+
+func renderBotLinks(l linkSet) string {
+	return strings.Join([]string{
+		"Auto: " + l.Auto,
+		"Sub64: " + l.Sub64,
+		"Sub: " + l.Sub,
+		"Singbox: " + l.Singbox,
+		"Panel: " + l.Panel,
+	}, "\n")
+}
+
+func botUserHelp(admin bool) string {
+	lines := []string{
+		"/link — " + uiText("subscription link"),
+		"/links — " + uiText("all links"),
+		"/qr — " + uiText("QR code of the subscription link"),
+		"/usage — " + uiText("remaining traffic and days"),
+		"/mtproxy — " + uiText("Telegram MTProxy link"),
+		"/unbind — " + uiText("unlink this Telegram account"),
+	}
+	if admin {
+		lines = append([]string{"(" + uiText("own account, after /start UUID") + ")"}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func botAdminHelp() string {
+	return strings.Join([]string{
+		"Admin commands:",
+		"/users [QUERY]",
+		"/show USER_ID",
+		"/qr USER_ID",
+		"/add NAME [days=30|unlimited] [gb=100|unlimited] [mode=no_reset] [plan=NAME]",
+		"/edit USER_ID [days=N] [gb=N] [add_days=N] [add_gb=N] [mode=MODE] [name=NAME] [enable|disable|reset]",
+		"/bind TELEGRAM_ID USER_ID",
+	}, "\n")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func joinInt64s(vals []int64) string {
+	parts := make([]string, 0, len(vals))
+	for _, v := range vals {
+		parts = append(parts, strconv.FormatInt(v, 10))
+	}
+	return strings.Join(parts, ",")
+}
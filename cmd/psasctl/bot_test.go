@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testBotToken = "123:secret-token"
+	testBotUUID  = "0b7a6c4e-1f2d-4c3b-9a8e-7d6f5e4c3b2a"
+)
+
+type fakeTGCall struct {
+	Method string
+	Body   map[string]any
+}
+
+// fakeTelegram is a local stand-in for the Bot API. getUpdates answers come
+// from updates, one batch per call; an exhausted queue cancels stop.
+type fakeTelegram struct {
+	t       *testing.T
+	srv     *httptest.Server
+	mu      sync.Mutex
+	calls   []fakeTGCall
+	updates [][]tgUpdate
+	stop    context.CancelFunc
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	f := &fakeTelegram{t: t}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + testBotToken + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, `{"ok":false,"description":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	call := fakeTGCall{Method: strings.TrimPrefix(r.URL.Path, prefix), Body: map[string]any{}}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&call.Body); err != nil {
+			f.t.Errorf("%s: decode body: %v", call.Method, err)
+		}
+	} else if err := r.ParseMultipartForm(1 << 20); err == nil {
+		for k, v := range r.MultipartForm.Value {
+			call.Body[k] = v[0]
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var result any = true
+	if call.Method == "getUpdates" {
+		result = []tgUpdate{}
+		if len(f.updates) > 0 {
+			result, f.updates = f.updates[0], f.updates[1:]
+		} else if f.stop != nil {
+			f.stop()
+		}
+	}
+	f.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func (f *fakeTelegram) sent(method string) []fakeTGCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeTGCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// lastText returns the text of the last sendMessage call.
+func (f *fakeTelegram) lastText() string {
+	msgs := f.sent("sendMessage")
+	if len(msgs) == 0 {
+		f.t.Fatal("no message sent")
+	}
+	text, _ := msgs[len(msgs)-1].Body["text"].(string)
+	return text
+}
+
+// newFakePanel serves the admin API endpoints the bot uses for one user.
+func newFakePanel(t *testing.T, u apiUser) *client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := "/api-path/api/v2/admin/user/"
+		if r.Header.Get("Hiddify-API-Key") != "key" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case base:
+			_ = json.NewEncoder(w).Encode([]apiUser{u})
+		case base + u.UUID + "/":
+			_ = json.NewEncoder(w).Encode(u)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &client{
+		panelAddr: srv.URL,
+		state: state{
+			APIPath:   "/api-path",
+			APIKey:    "key",
+			Chconfigs: map[string]map[string]any{"0": {"proxy_path_client": "client-path"}},
+		},
+	}
+}
+
+func newTestBotServer(t *testing.T, cfg botConfig) (*botServer, *fakeTelegram) {
+	dir := t.TempDir()
+	t.Setenv("PSAS_BOT", filepath.Join(dir, "bot.json"))
+	t.Setenv("PSAS_AUDIT_LOG", filepath.Join(dir, "audit.jsonl"))
+	if cfg.Bindings == nil {
+		cfg.Bindings = map[string]string{}
+	}
+	if err := writeBotConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	tg := newFakeTelegram(t)
+	panel := newFakePanel(t, apiUser{UUID: testBotUUID, Name: "alice", Enable: true, UsageLimitGB: 100, PackageDays: 30})
+	return &botServer{api: newTGBot(tg.srv.URL, testBotToken), c: panel, mp: newMTProxyClient()}, tg
+}
+
+func privateMessage(from int64, text string) *tgMessage {
+	return &tgMessage{From: &tgUser{ID: from}, Chat: tgChat{ID: from, Type: "private"}, Text: text}
+}
+
+func TestTGBotGetUpdatesAndSendMessage(t *testing.T) {
+	tg := newFakeTelegram(t)
+	tg.updates = [][]tgUpdate{{
+		{UpdateID: 7, Message: privateMessage(1, "/help")},
+		{UpdateID: 8, Message: privateMessage(2, "/link")},
+	}}
+	api := newTGBot(tg.srv.URL+"/", testBotToken)
+
+	updates, err := api.getUpdates(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[1].UpdateID != 8 || updates[1].Message.Text != "/link" {
+		t.Fatalf("updates = %+v", updates)
+	}
+	req := tg.sent("getUpdates")[0].Body
+	if req["offset"] != float64(5) || req["timeout"] != float64(botPollTimeout) {
+		t.Fatalf("getUpdates request = %v", req)
+	}
+
+	if err := api.sendMessage(42, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	msg := tg.sent("sendMessage")[0].Body
+	if msg["chat_id"] != float64(42) || msg["text"] != "hello" || msg["disable_web_page_preview"] != true {
+		t.Fatalf("sendMessage request = %v", msg)
+	}
+}
+
+func TestTGBotErrors(t *testing.T) {
+	tg := newFakeTelegram(t)
+	if err := newTGBot(tg.srv.URL, "wrong").sendMessage(1, "x"); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("err = %v, want the API description", err)
+	}
+
+	tg.srv.Close()
+	err := newTGBot(tg.srv.URL, testBotToken).sendMessage(1, "x")
+	if err == nil {
+		t.Fatal("send to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), testBotToken) {
+		t.Fatalf("error leaks the bot token: %v", err)
+	}
+}
+
+func TestBotRunHandlesUpdatesAndAdvancesOffset(t *testing.T) {
+	s, tg := newTestBotServer(t, botConfig{})
+	tg.updates = [][]tgUpdate{
+		{{UpdateID: 10, Message: privateMessage(1, "/help")}},
+		{{UpdateID: 11, Message: privateMessage(1, "/nope")}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tg.stop = cancel
+	s.run(ctx)
+
+	polls := tg.sent("getUpdates")
+	if len(polls) < 3 {
+		t.Fatalf("polled %d times, want 3", len(polls))
+	}
+	if polls[1].Body["offset"] != float64(11) || polls[2].Body["offset"] != float64(12) {
+		t.Fatalf("offsets = %v, %v", polls[1].Body["offset"], polls[2].Body["offset"])
+	}
+	if got := len(tg.sent("sendMessage")); got != 2 {
+		t.Fatalf("sent %d replies, want 2", got)
+	}
+	if tg.lastText() != uiText("Unknown command. Send /help.") {
+		t.Fatalf("reply = %q", tg.lastText())
+	}
+}
+
+func TestBotSelfBindIsOffByDefault(t *testing.T) {
+	s, tg := newTestBotServer(t, botConfig{})
+	s.handle(privateMessage(100, "/start "+testBotUUID))
+
+	if want := uiText("Self-service linking is disabled. Ask the administrator to link your account."); tg.lastText() != want {
+		t.Fatalf("reply = %q, want %q", tg.lastText(), want)
+	}
+	cfg, err := loadBotConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Bindings) != 0 {
+		t.Fatalf("bound despite self-bind off: %v", cfg.Bindings)
+	}
+
+	s.handle(privateMessage(100, "/link"))
+	if !strings.Contains(tg.lastText(), uiText("Self-service linking is disabled. Ask the administrator to link your account.")) {
+		t.Fatalf("unlinked reply = %q", tg.lastText())
+	}
+}
+
+func TestBotSelfBindAndLinks(t *testing.T) {
+	s, tg := newTestBotServer(t, botConfig{SelfBind: true, Host: "vpn.example.com"})
+	s.handle(privateMessage(100, "/start "+strings.ToUpper(testBotUUID)))
+
+	cfg, err := loadBotConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bindings["100"] != testBotUUID {
+		t.Fatalf("bindings = %v", cfg.Bindings)
+	}
+	if !strings.Contains(tg.lastText(), "alice") {
+		t.Fatalf("reply = %q", tg.lastText())
+	}
+
+	s.handle(privateMessage(100, "/link"))
+	if want := "https://vpn.example.com/client-path/" + testBotUUID + "/auto/"; tg.lastText() != want {
+		t.Fatalf("link = %q, want %q", tg.lastText(), want)
+	}
+
+	s.handle(privateMessage(100, "/start 00000000-0000-0000-0000-000000000000"))
+	if tg.lastText() != uiText("User not found.") {
+		t.Fatalf("reply for unknown UUID = %q", tg.lastText())
+	}
+}
+
+func TestBotIgnoresGroupChats(t *testing.T) {
+	s, tg := newTestBotServer(t, botConfig{SelfBind: true})
+	m := privateMessage(100, "/start "+testBotUUID)
+	m.Chat.Type = "group"
+	s.handle(m)
+	if n := len(tg.sent("sendMessage")); n != 0 {
+		t.Fatalf("replied %d times in a group chat", n)
+	}
+}
+
+func TestBotAdminBind(t *testing.T) {
+	s, tg := newTestBotServer(t, botConfig{Admins: []int64{1}})
+
+	s.handle(privateMessage(2, "/bind 200 alice"))
+	cfg, _ := loadBotConfig()
+	if len(cfg.Bindings) != 0 {
+		t.Fatalf("non-admin could bind: %v", cfg.Bindings)
+	}
+
+	s.handle(privateMessage(1, "/bind 200 alice"))
+	cfg, _ = loadBotConfig()
+	if cfg.Bindings["200"] != testBotUUID {
+		t.Fatalf("bindings = %v", cfg.Bindings)
+	}
+	if !strings.Contains(tg.lastText(), "Telegram 200 bound to alice") {
+		t.Fatalf("reply = %q", tg.lastText())
+	}
+}
+
+func TestBotSendPhoto(t *testing.T) {
+	tg := newFakeTelegram(t)
+	if err := newTGBot(tg.srv.URL, testBotToken).sendPhoto(5, []byte("png"), "caption"); err != nil {
+		t.Fatal(err)
+	}
+	got := tg.sent("sendPhoto")
+	if len(got) != 1 || got[0].Body["chat_id"] != "5" || got[0].Body["caption"] != "caption" {
+		t.Fatalf("sendPhoto = %+v", got)
+	}
+}
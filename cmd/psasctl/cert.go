@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultLetsEncryptLive = "/etc/letsencrypt/live"
+	defaultHiddifySSLDir   = "/opt/hiddify-manager/ssl"
+	defaultCertWarnDays    = 21
+
+	defaultCertNotifyStatePath = "/etc/psas/cert-notify.json"
+)
+
+// certInfo describes one certificate found on disk or served on :443.
+type certInfo struct {
+	Source    string   `json:"source"`
+	Path      string   `json:"path"`
+	Subject   string   `json:"subject,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	SANs      []string `json:"sans,omitempty"`
+	NotBefore string   `json:"not_before,omitempty"`
+	NotAfter  string   `json:"not_after,omitempty"`
+	DaysLeft  int      `json:"days_left"`
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// certSyncResult is one destination file of `cert sync`.
+type certSyncResult struct {
+	Source  string `json:"source"`
+	Dest    string `json:"dest"`
+	Changed bool   `json:"changed"`
+}
+
+func letsEncryptLiveDir() string {
+	return envOr("PSAS_LE_LIVE", defaultLetsEncryptLive)
+}
+
+func hiddifySSLDir() string {
+	return envOr("PSAS_HIDDIFY_SSL", defaultHiddifySSLDir)
+}
+
+func runCert(args []string) {
+	if len(args) == 0 {
+		fatalf("cert requires subcommand: status|sync|renew")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+	switch sub {
+	case "status", "list":
+		runCertStatus(subArgs)
+	case "sync":
+		runCertSync(subArgs)
+	case "renew":
+		runCertRenew(subArgs)
+	default:
+		fatalf("unknown cert subcommand: %s", sub)
+	}
+}
+
+// certDomain returns --domain or the main panel domain.
+func certDomain(flagValue string) string {
+	if d := strings.TrimSpace(flagValue); d != "" {
+		return d
+	}
+	c := mustClient(true)
+	d, err := c.mainDomainOrErr()
+	if err != nil {
+		fatalf("%v (use --domain)", err)
+	}
+	return d
+}
+
+func runCertStatus(args []string) {
+	fs := flag.NewFlagSet("cert status", flag.ExitOnError)
+	domain := fs.String("domain", "", "main domain (default: from panel)")
+	warnDays := fs.Int("warn-days", defaultCertWarnDays, "warn when a certificate expires within this many days")
+	remote := fs.Bool("remote", true, "also check the certificate served on DOMAIN:443")
+	notifyFlag := fs.Bool("notify", false, "send cert_expiring/cert_expired notifications (once per certificate and status)")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+
+	certs := collectCerts(certDomain(*domain), *warnDays, *remote)
+	if *notifyFlag {
+		notifyCertExpiry(certs, *warnDays)
+	}
+	if *jsonOut {
+		printJSON(certs)
+	} else {
+		printCerts(certs)
+	}
+	for _, ci := range certs {
+		if ci.Status == "expired" || ci.Status == "error" {
+			os.Exit(1)
+		}
+	}
+}
+
+// collectCerts gathers the Let's Encrypt live chain, every certificate in the
+// Hiddify ssl directory, the TrustTunnel hosts.toml chains and optionally
+// the one served on :443.
+func collectCerts(domain string, warnDays int, remote bool) []certInfo {
+	var out []certInfo
+	seen := map[string]bool{}
+	addFile := func(source, path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		cert, err := readPEMCertificate(path)
+		out = append(out, newCertInfo(source, path, cert, err, warnDays))
+	}
+
+	if domain != "" {
+		le := filepath.Join(letsEncryptLiveDir(), domain, "fullchain.pem")
+		if fileExists(le) {
+			addFile("letsencrypt", le)
+		} else {
+			out = append(out, certInfo{Source: "letsencrypt", Path: le, Status: "missing"})
+		}
+	}
+	if matches, err := filepath.Glob(filepath.Join(hiddifySSLDir(), "*.crt")); err == nil {
+		sort.Strings(matches)
+		for _, p := range matches {
+			addFile("hiddify", p)
+		}
+	}
+	tt := newTrustClient()
+	if tt.installed() {
+		paths, err := trustCertPaths(tt.hostsPath())
+		if err != nil {
+			out = append(out, certInfo{Source: "trust", Path: tt.hostsPath(), Status: "error", Error: err.Error()})
+		}
+		for _, p := range paths {
+			addFile("trust", p)
+		}
+	}
+	if remote && domain != "" {
+		cert, err := fetchServedCert(domain, 5*time.Second)
+		out = append(out, newCertInfo("served", domain+":443", cert, err, warnDays))
+	}
+	return out
+}
+
+func newCertInfo(source, path string, cert *x509.Certificate, err error, warnDays int) certInfo {
+	ci := certInfo{Source: source, Path: path}
+	if err != nil {
+		ci.Status, ci.Error = "error", err.Error()
+		return ci
+	}
+	ci.Subject = cert.Subject.CommonName
+	ci.Issuer = cert.Issuer.CommonName
+	if ci.Issuer == "" && len(cert.Issuer.Organization) > 0 {
+		ci.Issuer = cert.Issuer.Organization[0]
+	}
+	ci.SANs = append(ci.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		ci.SANs = append(ci.SANs, ip.String())
+	}
+	ci.NotBefore = cert.NotBefore.UTC().Format(time.RFC3339)
+	ci.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	left := time.Until(cert.NotAfter)
+	ci.DaysLeft = int(left.Hours() / 24)
+	switch {
+	case left <= 0:
+		ci.Status = "expired"
+	case ci.DaysLeft < warnDays:
+		ci.Status = "warn"
+	default:
+		ci.Status = "ok"
+	}
+	return ci
+}
+
+// fetchServedCert returns the leaf certificate served for host:443 without
+// verifying it, so that broken chains are still reported.
+func fetchServedCert(host string, timeout time.Duration) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	return certs[0], nil
+}
+
+func printCerts(certs []certInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tSOURCE\tEXPIRES\tDAYS\tISSUER\tSANS\tPATH")
+	for _, ci := range certs {
+		expires, days := "-", "-"
+		if ci.NotAfter != "" {
+			expires = ci.NotAfter[:10]
+			days = fmt.Sprint(ci.DaysLeft)
+		}
+		sans := valueOrDash(strings.Join(ci.SANs, ","))
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", strings.ToUpper(ci.Status), ci.Source, expires, days, valueOrDash(ci.Issuer), sans, ci.Path)
+	}
+	_ = tw.Flush()
+	for _, ci := range certs {
+		if ci.Error != "" {
+			fmt.Printf("Warning: %s %s: %s\n", ci.Source, ci.Path, ci.Error)
+		}
+	}
+}
+
+func certNotifyStatePath() string {
+	return envOr("PSAS_CERT_NOTIFY_STATE", defaultCertNotifyStatePath)
+}
+
+// notifyCertExpiry sends cert_expiring/cert_expired once per certificate
+// and status: cert-notify.json remembers what was sent, keyed by source and
+// path, so the daily sync cron does not repeat the warning for the whole
+// window. A renewed certificate (new expiry) or the step from expiring to
+// expired notifies again.
+func notifyCertExpiry(certs []certInfo, warnDays int) {
+	p := certNotifyStatePath()
+	sent := map[string]string{}
+	raw, err := loadStateFile(p)
+	if err == nil && len(bytes.TrimSpace(raw)) > 0 {
+		err = json.Unmarshal(raw, &sent)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", p, err)
+		sent = map[string]string{}
+	}
+	changed := false
+	for _, ci := range certs {
+		key := ci.Source + ":" + ci.Path
+		typ := ""
+		switch ci.Status {
+		case "expired":
+			typ = notifyCertExpired
+		case "warn":
+			typ = notifyCertExpiring
+		default:
+			if _, ok := sent[key]; ok && ci.Status == "ok" {
+				delete(sent, key)
+				changed = true
+			}
+			continue
+		}
+		mark := ci.Status + " " + ci.NotAfter
+		if sent[key] == mark {
+			continue
+		}
+		notify(notifyEvent{
+			Type:    typ,
+			Source:  "cert",
+			Message: fmt.Sprintf("Certificate %s (%s) expires %s, %d days left", ci.Path, strings.Join(ci.SANs, ","), ci.NotAfter[:10], ci.DaysLeft),
+			Details: map[string]any{"path": ci.Path, "source": ci.Source, "not_after": ci.NotAfter, "days_left": ci.DaysLeft, "warn_days": warnDays},
+		})
+		sent[key] = mark
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	payload, err := json.MarshalIndent(sent, "", "  ")
+	if err == nil {
+		err = saveStateFile(p, append(payload, '\n'), 0o600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to save %s: %v\n", p, err)
+	}
+}
+
+func runCertSync(args []string) {
+	fs := flag.NewFlagSet("cert sync", flag.ExitOnError)
+	domain := fs.String("domain", "", "main domain (default: from panel)")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	noReload := fs.Bool("no-reload", false, "do not reload hiddify-haproxy/hiddify-nginx/trusttunnel")
+	notifyFlag := fs.Bool("notify", false, "notify on sync and when the certificate expires within --warn-days")
+	warnDays := fs.Int("warn-days", defaultCertWarnDays, "expiry warning threshold in days")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if !*dryRun {
+		must(requireRoot("cert sync"))
+	}
+	d := certDomain(*domain)
+	results, skipped, err := certSync(d, *dryRun)
+	must(err)
+	changed := false
+	for _, r := range results {
+		changed = changed || r.Changed
+	}
+	var reloadWarnings []string
+	if changed && !*dryRun && !*noReload {
+		reloadWarnings = certReloadServices(d)
+	}
+	if changed && !*dryRun && *notifyFlag {
+		notify(notifyEvent{Type: notifyCertSynced, Source: "cert", Message: "Certificate synced to Hiddify for " + d, Details: map[string]any{"domain": d}})
+	}
+	if *notifyFlag {
+		notifyCertExpiry(collectCerts(d, *warnDays, false), *warnDays)
+	}
+
+	if *jsonOut {
+		printJSON(map[string]any{
+			"domain":   d,
+			"dry_run":  *dryRun,
+			"skipped":  skipped,
+			"changed":  changed,
+			"files":    results,
+			"warnings": reloadWarnings,
+		})
+		return
+	}
+	if skipped != "" {
+		fmt.Println(skipped)
+		return
+	}
+	for _, r := range results {
+		state := "unchanged"
+		if r.Changed {
+			state = "updated"
+			if *dryRun {
+				state = "would update"
+			}
+		}
+		fmt.Printf("%s: %s -> %s\n", state, r.Source, r.Dest)
+	}
+	for _, w := range reloadWarnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+}
+
+// certSync copies the Let's Encrypt chain and key into the Hiddify ssl
+// directory (DOMAIN.crt, DOMAIN.crt.key) when their contents differ. A
+// missing LE certificate is not an error: skipped explains why.
+func certSync(domain string, dryRun bool) ([]certSyncResult, string, error) {
+	live := filepath.Join(letsEncryptLiveDir(), domain)
+	pairs := []certSyncResult{
+		{Source: filepath.Join(live, "fullchain.pem"), Dest: filepath.Join(hiddifySSLDir(), domain+".crt")},
+		{Source: filepath.Join(live, "privkey.pem"), Dest: filepath.Join(hiddifySSLDir(), domain+".crt.key")},
+	}
+	for _, p := range pairs {
+		if st, err := os.Stat(p.Source); err != nil || st.Size() == 0 {
+			return nil, fmt.Sprintf("Let's Encrypt cert/key not found for %s, skip sync", domain), nil
+		}
+	}
+	for i, p := range pairs {
+		src, err := os.ReadFile(p.Source)
+		if err != nil {
+			return nil, "", err
+		}
+		dst, err := os.ReadFile(p.Dest)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, "", err
+		}
+		if err == nil && bytes.Equal(src, dst) {
+			continue
+		}
+		pairs[i].Changed = true
+		if dryRun {
+			continue
+		}
+		err = writeFileAtomic(p.Dest, src, 0o600)
+		auditRecord("cert.sync", p.Dest, []auditChange{{Field: "source", New: p.Source}}, err)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return pairs, "", nil
+}
+
+// certReloadServices reloads the Hiddify proxies and restarts TrustTunnel
+// when its hosts.toml uses the Let's Encrypt chain of domain.
+func certReloadServices(domain string) []string {
+	var warnings []string
+	for _, svc := range []string{"hiddify-haproxy.service", "hiddify-nginx.service"} {
+		if active, _ := runCommandOutput("systemctl", "is-active", svc); strings.TrimSpace(active) != "active" {
+			continue
+		}
+		if err := runServiceAction("reload", svc); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	tt := newTrustClient()
+	if !tt.installed() {
+		return warnings
+	}
+	paths, _ := trustCertPaths(tt.hostsPath())
+	le := filepath.Join(letsEncryptLiveDir(), domain)
+	for _, p := range paths {
+		if strings.HasPrefix(p, le+string(filepath.Separator)) {
+			if err := tt.restartService(); err != nil {
+				warnings = append(warnings, fmt.Sprintf("restart %s: %v", tt.service, err))
+			}
+			break
+		}
+	}
+	return warnings
+}
+
+func runCertRenew(args []string) {
+	fs := flag.NewFlagSet("cert renew", flag.ExitOnError)
+	domain := fs.String("domain", "", "domain (default: main panel domain)")
+	email := fs.String("email", envOr("ACME_EMAIL", ""), "ACME account email (default: register without email)")
+	force := fs.Bool("force", false, "renew even if the certificate is not close to expiry")
+	noSync := fs.Bool("no-sync", false, "do not run cert sync afterwards")
+	must(fs.Parse(args))
+	must(requireRoot("cert renew"))
+	d := certDomain(*domain)
+	if _, err := runCommandOutput("sh", "-c", "command -v certbot"); err != nil {
+		fatalf("certbot is not installed")
+	}
+
+	// certbot --standalone needs port 80, which Hiddify's proxies hold.
+	var stopped []string
+	for _, svc := range []string{"hiddify-haproxy.service", "nginx.service"} {
+		if active, _ := runCommandOutput("systemctl", "is-active", svc); strings.TrimSpace(active) == "active" {
+			if err := runServiceAction("stop", svc); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: stop %s: %v\n", svc, err)
+				continue
+			}
+			stopped = append(stopped, svc)
+		}
+	}
+	certbotArgs := []string{"certonly", "--standalone", "-d", d, "--non-interactive", "--agree-tos"}
+	if *force {
+		certbotArgs = append(certbotArgs, "--force-renewal")
+	} else {
+		certbotArgs = append(certbotArgs, "--keep-until-expiring")
+	}
+	if strings.TrimSpace(*email) != "" {
+		certbotArgs = append(certbotArgs, "--email", strings.TrimSpace(*email))
+	} else {
+		certbotArgs = append(certbotArgs, "--register-unsafely-without-email")
+	}
+	certErr := runCommand("certbot", certbotArgs...)
+	for i := len(stopped) - 1; i >= 0; i-- {
+		if err := runServiceAction("start", stopped[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: start %s: %v\n", stopped[i], err)
+		}
+	}
+	if certErr != nil {
+		fatalf("certbot failed for %s: %v", d, certErr)
+	}
+	fmt.Printf("Let's Encrypt certificate for %s is up to date\n", d)
+	if *noSync {
+		return
+	}
+	results, skipped, err := certSync(d, false)
+	must(err)
+	if skipped != "" {
+		fmt.Println(skipped)
+		return
+	}
+	changed := false
+	for _, r := range results {
+		if r.Changed {
+			changed = true
+			fmt.Printf("updated: %s -> %s\n", r.Source, r.Dest)
+		}
+	}
+	if changed {
+		for _, w := range certReloadServices(d) {
+			fmt.Printf("Warning: %s\n", w)
+		}
+		notify(notifyEvent{Type: notifyCertSynced, Source: "cert", Message: "Certificate renewed and synced for " + d, Details: map[string]any{"domain": d}})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifyCertExpiryOncePerCertificateAndStatus(t *testing.T) {
+	hook := newFakeWebhook(t, 0)
+	setupNotify(t, notifyConfig{Webhooks: []notifyWebhookTarget{{URL: hook.srv.URL}}})
+	t.Setenv("PSAS_CERT_NOTIFY_STATE", filepath.Join(t.TempDir(), "cert-notify.json"))
+
+	ci := certInfo{Source: "letsencrypt", Path: "/etc/letsencrypt/live/a/fullchain.pem", NotAfter: "2026-11-01T00:00:00Z", DaysLeft: 10, Status: "warn"}
+	sent := func() int {
+		_, _, hits := hook.received()
+		return hits
+	}
+
+	// The daily cron runs again inside the window: one notification only.
+	for i := 0; i < 3; i++ {
+		notifyCertExpiry([]certInfo{ci}, 21)
+	}
+	if sent() != 1 {
+		t.Fatalf("sent %d notifications for one expiring certificate, want 1", sent())
+	}
+
+	ci.Status, ci.DaysLeft = "expired", -1
+	notifyCertExpiry([]certInfo{ci}, 21)
+	notifyCertExpiry([]certInfo{ci}, 21)
+	if sent() != 2 {
+		t.Fatalf("sent %d, want a second notification once it expired", sent())
+	}
+
+	// Renewed: state is cleared, and the next certificate warns again.
+	notifyCertExpiry([]certInfo{{Source: ci.Source, Path: ci.Path, NotAfter: "2027-01-30T00:00:00Z", DaysLeft: 90, Status: "ok"}}, 21)
+	ci.NotAfter, ci.DaysLeft, ci.Status = "2027-01-30T00:00:00Z", 20, "warn"
+	notifyCertExpiry([]certInfo{ci}, 21)
+	if sent() != 3 {
+		t.Fatalf("sent %d, want a notification for the renewed certificate", sent())
+	}
+}
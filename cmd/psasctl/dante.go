@@ -0,0 +1,539 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// danteConfig models danted.conf: top-level directives in file order and
+// the client/socks rule blocks. Comments are not preserved; `socks config
+// set` keeps a backup of the previous file instead.
+type danteConfig struct {
+	Directives []danteDirective `json:"directives"`
+	Rules      []danteRule      `json:"rules"`
+}
+
+type danteDirective struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// danteRule is one `client|socks pass|block { ... }` block. From/To hold the
+// `from: X to: Y` line; every other line is kept in Options.
+type danteRule struct {
+	Type    string           `json:"type"`
+	Action  string           `json:"action"`
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Options []danteDirective `json:"options,omitempty"`
+}
+
+var (
+	danteRuleHeadRe = regexp.MustCompile(`^(client|socks|route|hostid)\s+(pass|block)$`)
+	danteFromToRe   = regexp.MustCompile(`^from:\s*(.*?)\s+to:\s*(.*)$`)
+	danteInternalRe = regexp.MustCompile(`(?i)^([^\s]+)(?:\s+port\s*=\s*([0-9]{1,5}))?$`)
+	dantePortRange  = regexp.MustCompile(`^([0-9]{1,5})-([0-9]{1,5})$`)
+)
+
+func parseDanteConfig(raw []byte) (danteConfig, error) {
+	var cfg danteConfig
+	text := strings.ReplaceAll(string(raw), "\r", "")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		// Put braces on lines of their own so one-line blocks parse too.
+		line = strings.NewReplacer("{", "\n{\n", "}", "\n}\n").Replace(line)
+		for _, part := range strings.Split(line, "\n") {
+			if part = strings.TrimSpace(part); part != "" {
+				lines = append(lines, part)
+			}
+		}
+	}
+
+	var cur *danteRule
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case cur == nil && danteRuleHeadRe.MatchString(strings.Join(strings.Fields(line), " ")):
+			m := danteRuleHeadRe.FindStringSubmatch(strings.Join(strings.Fields(line), " "))
+			if i+1 >= len(lines) || lines[i+1] != "{" {
+				return cfg, fmt.Errorf("%q: expected {", line)
+			}
+			i++
+			cur = &danteRule{Type: m[1], Action: m[2]}
+		case line == "}":
+			if cur == nil {
+				return cfg, errors.New("unexpected }")
+			}
+			cfg.Rules = append(cfg.Rules, *cur)
+			cur = nil
+		case line == "{":
+			return cfg, errors.New("unexpected {")
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return cfg, fmt.Errorf("%q: expected KEY: VALUE", line)
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if cur == nil {
+				cfg.Directives = append(cfg.Directives, danteDirective{Key: key, Value: value})
+				continue
+			}
+			if key == "from" {
+				m := danteFromToRe.FindStringSubmatch(line)
+				if m == nil {
+					return cfg, fmt.Errorf("%q: expected from: X to: Y", line)
+				}
+				cur.From, cur.To = m[1], m[2]
+				continue
+			}
+			cur.Options = append(cur.Options, danteDirective{Key: key, Value: value})
+		}
+	}
+	if cur != nil {
+		return cfg, fmt.Errorf("%s %s block is not closed", cur.Type, cur.Action)
+	}
+	return cfg, nil
+}
+
+// danteDirectiveGroup groups top-level keys so render can separate them
+// with blank lines, the same layout the installer writes.
+func danteDirectiveGroup(key string) string {
+	switch {
+	case key == "logoutput" || key == "errorlog" || key == "debug":
+		return "log"
+	case strings.HasPrefix(key, "internal") || strings.HasPrefix(key, "external"):
+		return "net"
+	case strings.HasSuffix(key, "method"):
+		return "method"
+	case strings.HasPrefix(key, "user."):
+		return "user"
+	}
+	return "other"
+}
+
+func (cfg danteConfig) render() []byte {
+	var b strings.Builder
+	group := ""
+	for i, d := range cfg.Directives {
+		if g := danteDirectiveGroup(d.Key); i > 0 && g != group {
+			b.WriteByte('\n')
+		}
+		group = danteDirectiveGroup(d.Key)
+		fmt.Fprintf(&b, "%s: %s\n", d.Key, d.Value)
+	}
+	for _, r := range cfg.Rules {
+		b.WriteByte('\n')
+		fmt.Fprintf(&b, "%s %s {\n", r.Type, r.Action)
+		if r.From != "" || r.To != "" {
+			fmt.Fprintf(&b, "  from: %s to: %s\n", r.From, r.To)
+		}
+		for _, o := range r.Options {
+			fmt.Fprintf(&b, "  %s: %s\n", o.Key, o.Value)
+		}
+		b.WriteString("}\n")
+	}
+	return []byte(b.String())
+}
+
+func (cfg danteConfig) get(key string) string {
+	for _, d := range cfg.Directives {
+		if d.Key == key {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+func (cfg danteConfig) all(key string) []string {
+	var out []string
+	for _, d := range cfg.Directives {
+		if d.Key == key {
+			out = append(out, d.Value)
+		}
+	}
+	return out
+}
+
+// set replaces every occurrence of key with one directive holding value,
+// placed where the first occurrence was (or after the last key of the same
+// group).
+func (cfg *danteConfig) set(key, value string) {
+	out := make([]danteDirective, 0, len(cfg.Directives)+1)
+	placed := false
+	for _, d := range cfg.Directives {
+		if d.Key != key {
+			out = append(out, d)
+			continue
+		}
+		if !placed {
+			out = append(out, danteDirective{Key: key, Value: value})
+			placed = true
+		}
+	}
+	if !placed {
+		at := len(out)
+		for i, d := range out {
+			if danteDirectiveGroup(d.Key) == danteDirectiveGroup(key) {
+				at = i + 1
+			}
+		}
+		out = append(out[:at], append([]danteDirective{{Key: key, Value: value}}, out[at:]...)...)
+	}
+	cfg.Directives = out
+}
+
+func (r danteRule) get(key string) string {
+	for _, o := range r.Options {
+		if o.Key == key {
+			return o.Value
+		}
+	}
+	return ""
+}
+
+func (r *danteRule) set(key, value string) {
+	for i, o := range r.Options {
+		if o.Key == key {
+			r.Options[i].Value = value
+			return
+		}
+	}
+	r.Options = append(r.Options, danteDirective{Key: key, Value: value})
+}
+
+// internal returns the host and port of the first `internal:` directive.
+func (cfg danteConfig) internal() (string, int, error) {
+	v := cfg.get("internal")
+	if v == "" {
+		return "", 0, errors.New("internal listen address not found")
+	}
+	m := danteInternalRe.FindStringSubmatch(v)
+	if m == nil {
+		return "", 0, fmt.Errorf("invalid internal: %s", v)
+	}
+	host := strings.Trim(m[1], "[]")
+	port := defaultSocksPort
+	if m[2] != "" {
+		p, err := strconv.Atoi(m[2])
+		if err != nil || p < 1 || p > 65535 {
+			return "", 0, fmt.Errorf("invalid SOCKS port: %s", m[2])
+		}
+		port = p
+	}
+	return host, port, nil
+}
+
+// setPort changes the port of every `internal:` directive.
+func (cfg *danteConfig) setPort(port int) error {
+	found := false
+	for i, d := range cfg.Directives {
+		if d.Key != "internal" {
+			continue
+		}
+		m := danteInternalRe.FindStringSubmatch(d.Value)
+		if m == nil {
+			return fmt.Errorf("invalid internal: %s", d.Value)
+		}
+		cfg.Directives[i].Value = fmt.Sprintf("%s port = %d", m[1], port)
+		found = true
+	}
+	if !found {
+		cfg.set("internal", fmt.Sprintf("0.0.0.0 port = %d", port))
+	}
+	return nil
+}
+
+// udpRange returns the udp.portrange of the first socks pass rule.
+func (cfg danteConfig) udpRange() string {
+	for _, r := range cfg.Rules {
+		if r.Type == "socks" && r.Action == "pass" {
+			if v := r.get("udp.portrange"); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// setUDPRange sets udp.portrange on every socks pass rule.
+func (cfg *danteConfig) setUDPRange(v string) error {
+	found := false
+	for i := range cfg.Rules {
+		if cfg.Rules[i].Type == "socks" && cfg.Rules[i].Action == "pass" {
+			cfg.Rules[i].set("udp.portrange", v)
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("no socks pass rule to set udp.portrange on")
+	}
+	return nil
+}
+
+func validateDantePortRange(v string) error {
+	m := dantePortRange.FindStringSubmatch(v)
+	if m == nil {
+		return fmt.Errorf("invalid UDP port range %q (expected START-END, e.g. 20000-50000)", v)
+	}
+	from, _ := strconv.Atoi(m[1])
+	to, _ := strconv.Atoi(m[2])
+	if from < 1 || to > 65535 || from >= to {
+		return fmt.Errorf("invalid UDP port range %q (expected START-END, e.g. 20000-50000)", v)
+	}
+	return nil
+}
+
+func dantedBinary() (string, error) {
+	if p, err := exec.LookPath("danted"); err == nil {
+		return p, nil
+	}
+	for _, p := range []string{"/usr/sbin/danted", "/usr/bin/danted"} {
+		if fileExists(p) {
+			return p, nil
+		}
+	}
+	return "", errors.New("danted not found")
+}
+
+// validateDanteConfig runs `danted -V` on a rendered config written next to
+// the live file.
+func validateDanteConfig(livePath string, data []byte) error {
+	bin, err := dantedBinary()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(livePath), ".danted-check-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if out, err := runCommandOutput(bin, "-V", "-f", f.Name()); err != nil {
+		return fmt.Errorf("danted -V rejected the config: %v: %s", err, out)
+	}
+	return nil
+}
+
+func (s *socksClient) loadDanteConfig() (danteConfig, error) {
+	raw, ver, err := readStateFile(s.config)
+	if err != nil {
+		return danteConfig{}, err
+	}
+	s.versions.remember(s.config, ver)
+	cfg, err := parseDanteConfig(raw)
+	if err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", s.config, err)
+	}
+	return cfg, nil
+}
+
+// writeDanteConfig validates cfg with danted, saves the current file as
+// danted.conf.bak-TIMESTAMP and replaces it. It returns the backup path.
+func (s *socksClient) writeDanteConfig(cfg danteConfig) (backup string, err error) {
+	before := map[string]any{}
+	after := danteConfigSummary(cfg)
+	defer func() {
+		auditRecord("socks.config.write", s.config, auditMapChanges(before, after), err)
+	}()
+	data := cfg.render()
+	if err := validateDanteConfig(s.config, data); err != nil {
+		return "", err
+	}
+	ver, err := commitStateFile(s.config, 0o640, s.versions.lookup(s.config), func(current []byte) ([]byte, error) {
+		if old, perr := parseDanteConfig(current); perr == nil {
+			before = danteConfigSummary(old)
+		}
+		if len(current) > 0 {
+			backup = fmt.Sprintf("%s.bak-%s", s.config, time.Now().Format("20060102-150405"))
+			if err := replaceFile(backup, current, 0o640, false); err != nil {
+				return nil, fmt.Errorf("backup %s: %w", s.config, err)
+			}
+		}
+		return data, nil
+	})
+	if err != nil {
+		return backup, err
+	}
+	s.versions.remember(s.config, ver)
+	return backup, nil
+}
+
+// danteConfigSummary lists the settings `socks config` manages.
+func danteConfigSummary(cfg danteConfig) map[string]any {
+	out := map[string]any{
+		"internal":          cfg.all("internal"),
+		"external":          cfg.all("external"),
+		"external_protocol": cfg.get("external.protocol"),
+		"clientmethod":      cfg.get("clientmethod"),
+		"socksmethod":       cfg.get("socksmethod"),
+		"logoutput":         cfg.get("logoutput"),
+		"udp_portrange":     cfg.udpRange(),
+		"rules":             len(cfg.Rules),
+	}
+	if _, port, err := cfg.internal(); err == nil {
+		out["port"] = port
+	}
+	return out
+}
+
+func runSocksConfig(sc *socksClient, args []string) {
+	if len(args) < 1 {
+		fatalf("socks config requires subcommand: show|set")
+	}
+	if sc.builtin() {
+		fatalf("socks config manages %s; the built-in server is configured with `psasctl socks-server install`", defaultSocksConfig)
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "show":
+		fs := flag.NewFlagSet("socks config show", flag.ExitOnError)
+		raw := fs.Bool("raw", false, "print the normalized danted.conf")
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks config show takes only flags")
+		}
+		cfg, err := sc.loadDanteConfig()
+		must(err)
+		if *jsonOut {
+			printJSON(map[string]any{"path": sc.config, "summary": danteConfigSummary(cfg), "config": cfg})
+			return
+		}
+		if *raw {
+			fmt.Print(string(cfg.render()))
+			return
+		}
+		printDanteConfig(sc.config, cfg)
+	case "set":
+		fs := flag.NewFlagSet("socks config set", flag.ExitOnError)
+		port := fs.Int("port", 0, "listen port (internal: ... port = N)")
+		udpRange := fs.String("udp-range", "", "UDP relay port range START-END (udp.portrange)")
+		externalIface := fs.String("external-iface", "", "outgoing interface or IP (external:)")
+		logOutput := fs.String("log", "", "logoutput target (syslog, stderr or a file path)")
+		dryRun := fs.Bool("dry-run", false, "print the new danted.conf without writing it")
+		noRestart := fs.Bool("no-restart", false, "do not restart danted after writing")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks config set takes only flags")
+		}
+		if *port == 0 && *udpRange == "" && *externalIface == "" && *logOutput == "" {
+			fatalf("socks config set: no changes requested")
+		}
+		if !*dryRun {
+			must(requireRoot("socks config set"))
+		}
+
+		cfg, err := sc.loadDanteConfig()
+		must(err)
+		if *port != 0 {
+			if *port < 1 || *port > 65535 {
+				fatalf("invalid --port: %d", *port)
+			}
+			must(cfg.setPort(*port))
+		}
+		if v := strings.TrimSpace(*udpRange); v != "" {
+			must(validateDantePortRange(v))
+			must(cfg.setUDPRange(v))
+		}
+		if v := strings.TrimSpace(*externalIface); v != "" {
+			if _, err := net.InterfaceByName(v); err != nil && net.ParseIP(v) == nil {
+				fatalf("unknown network interface: %s", v)
+			}
+			cfg.set("external", v)
+		}
+		if v := strings.TrimSpace(*logOutput); v != "" {
+			cfg.set("logoutput", v)
+		}
+
+		if *dryRun {
+			fmt.Print(string(cfg.render()))
+			return
+		}
+		backup, err := sc.writeDanteConfig(cfg)
+		must(err)
+		fmt.Printf("Config written: %s\n", sc.config)
+		if backup != "" {
+			fmt.Printf("Backup: %s\n", backup)
+		}
+		if *noRestart {
+			return
+		}
+		if err := sc.restartService(); err != nil {
+			if backup == "" {
+				fatalf("restart %s: %v", sc.service, err)
+			}
+			// Put the previous file back so danted comes up with a config it
+			// accepted before.
+			if rerr := restoreDanteBackup(sc, backup); rerr != nil {
+				fatalf("restart %s: %v; restoring %s failed: %v", sc.service, err, backup, rerr)
+			}
+			if rerr := sc.restartService(); rerr != nil {
+				fatalf("restart %s: %v (previous config restored from %s, but restarting with it failed too: %v)", sc.service, err, backup, rerr)
+			}
+			fatalf("restart %s: %v (previous config restored from %s and %s restarted)", sc.service, err, backup, sc.service)
+		}
+		fmt.Printf("SOCKS service restarted: %s\n", sc.service)
+	default:
+		fatalf("unknown socks config subcommand: %s", sub)
+	}
+}
+
+// restoreDanteBackup puts backup back unless danted.conf changed since sc
+// last wrote it, and remembers the restored version for later writes.
+func restoreDanteBackup(sc *socksClient, backup string) error {
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+	ver, err := commitStateFile(sc.config, 0o640, sc.versions.lookup(sc.config), func([]byte) ([]byte, error) {
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	sc.versions.remember(sc.config, ver)
+	auditRecord("socks.config.restore", sc.config, []auditChange{{Field: "source", New: backup}}, nil)
+	return nil
+}
+
+func printDanteConfig(path string, cfg danteConfig) {
+	host, port, err := cfg.internal()
+	fmt.Printf("Config: %s\n", path)
+	if err == nil {
+		fmt.Printf("Listen: %s port %d\n", host, port)
+	}
+	fmt.Printf("External: %s\n", valueOrDash(strings.Join(cfg.all("external"), ", ")))
+	fmt.Printf("External protocol: %s\n", valueOrDash(cfg.get("external.protocol")))
+	fmt.Printf("Client method: %s\n", valueOrDash(cfg.get("clientmethod")))
+	fmt.Printf("SOCKS method: %s\n", valueOrDash(cfg.get("socksmethod")))
+	fmt.Printf("UDP port range: %s\n", valueOrDash(cfg.udpRange()))
+	fmt.Printf("Log output: %s\n", valueOrDash(cfg.get("logoutput")))
+	fmt.Printf("Rules:\n")
+	for _, r := range cfg.Rules {
+		line := fmt.Sprintf("  %s %s from %s to %s", r.Type, r.Action, r.From, r.To)
+		for _, o := range r.Options {
+			line += fmt.Sprintf("; %s: %s", o.Key, o.Value)
+		}
+		fmt.Println(line)
+	}
+}
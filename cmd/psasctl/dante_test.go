@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installerDanteConfig returns the danted.conf the installer writes, taken
+// from the heredoc in install/psas-install.sh so the tests follow it.
+func installerDanteConfig(t *testing.T, port, udpRange string) string {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("..", "..", "install", "psas-install.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, ok := strings.Cut(string(raw), "cat >/etc/danted.conf <<EOF\n")
+	if !ok {
+		t.Fatal("danted.conf heredoc not found in psas-install.sh")
+	}
+	body, _, ok = strings.Cut(body, "\nEOF\n")
+	if !ok {
+		t.Fatal("danted.conf heredoc is not terminated")
+	}
+	return strings.NewReplacer(
+		"${SOCKS_PORT}", port,
+		"${SOCKS_IFACE}", "eth0",
+		"${SOCKS_UDP_PORTRANGE}", udpRange,
+	).Replace(body) + "\n"
+}
+
+func mustParseDante(t *testing.T, raw string) danteConfig {
+	t.Helper()
+	cfg, err := parseDanteConfig([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse: %v\n%s", err, raw)
+	}
+	return cfg
+}
+
+func TestDanteConfigInstallerRoundTrip(t *testing.T) {
+	raw := installerDanteConfig(t, "1080", "20000-50000")
+	cfg := mustParseDante(t, raw)
+	if got := string(cfg.render()); got != raw {
+		t.Fatalf("render changed the installer config:\n%s\nwant:\n%s", got, raw)
+	}
+	host, port, err := cfg.internal()
+	if err != nil || host != "0.0.0.0" || port != 1080 {
+		t.Fatalf("internal() = %q, %d, %v", host, port, err)
+	}
+	if got := cfg.udpRange(); got != "20000-50000" {
+		t.Fatalf("udpRange() = %q", got)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[0].Type != "client" || cfg.Rules[1].get("command") != "connect udpassociate bind" {
+		t.Fatalf("rules = %+v", cfg.Rules)
+	}
+}
+
+func TestDanteSetPort(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		port int
+		want []string
+	}{
+		{"installer", installerDanteConfig(t, "1080", "20000-50000"), 1443, []string{"0.0.0.0 port = 1443"}},
+		{"no spaces", "internal: eth0 port=1080\n", 2000, []string{"eth0 port = 2000"}},
+		{"no port", "internal: 10.0.0.1\n", 2000, []string{"10.0.0.1 port = 2000"}},
+		{"every internal", "internal: 0.0.0.0 port = 1080\ninternal: :: port = 1080\n", 3000, []string{"0.0.0.0 port = 3000", ":: port = 3000"}},
+		{"missing", "logoutput: syslog\nexternal: eth0\n", 4000, []string{"0.0.0.0 port = 4000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mustParseDante(t, tt.raw)
+			if err := cfg.setPort(tt.port); err != nil {
+				t.Fatal(err)
+			}
+			back := mustParseDante(t, string(cfg.render()))
+			if got := back.all("internal"); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("internal = %q, want %q", got, tt.want)
+			}
+			if _, port, err := back.internal(); err != nil || port != tt.port {
+				t.Fatalf("internal() port = %d, %v; want %d", port, err, tt.port)
+			}
+		})
+	}
+
+	// Only the port changes; the rest of the installer config is kept.
+	cfg := mustParseDante(t, installerDanteConfig(t, "1080", "20000-50000"))
+	if err := cfg.setPort(1443); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(cfg.render()), installerDanteConfig(t, "1443", "20000-50000"); got != want {
+		t.Fatalf("render after setPort:\n%s\nwant:\n%s", got, want)
+	}
+
+	cfg = mustParseDante(t, "internal: 0.0.0.0 port = abc\n")
+	if err := cfg.setPort(1080); err == nil {
+		t.Fatal("setPort accepted an unparsable internal: directive")
+	}
+}
+
+func TestDanteSetUDPRange(t *testing.T) {
+	cfg := mustParseDante(t, installerDanteConfig(t, "1080", "20000-50000"))
+	if err := cfg.setUDPRange("40000-40100"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(cfg.render()), installerDanteConfig(t, "1080", "40000-40100"); got != want {
+		t.Fatalf("render after setUDPRange:\n%s\nwant:\n%s", got, want)
+	}
+	if cfg.Rules[0].get("udp.portrange") != "" {
+		t.Fatalf("client rule got udp.portrange: %+v", cfg.Rules[0])
+	}
+
+	// Every socks pass rule gets the range, including ones without it.
+	cfg = mustParseDante(t, "socks pass { from: 0.0.0.0/0 to: 10.0.0.0/8 }\nsocks block { from: 0.0.0.0/0 to: 0.0.0.0/0 }\nsocks pass {\nfrom: 0.0.0.0/0 to: 0.0.0.0/0\nudp.portrange: 1-2\n}\n")
+	if err := cfg.setUDPRange("3000-4000"); err != nil {
+		t.Fatal(err)
+	}
+	back := mustParseDante(t, string(cfg.render()))
+	for i, want := range []string{"3000-4000", "", "3000-4000"} {
+		if got := back.Rules[i].get("udp.portrange"); got != want {
+			t.Fatalf("rule %d udp.portrange = %q, want %q", i, got, want)
+		}
+	}
+
+	cfg = mustParseDante(t, "client pass {\nfrom: 0.0.0.0/0 to: 0.0.0.0/0\n}\n")
+	if err := cfg.setUDPRange("3000-4000"); err == nil {
+		t.Fatal("setUDPRange succeeded without a socks pass rule")
+	}
+}
+
+func TestValidateDantePortRange(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"20000-50000", true},
+		{"1-65535", true},
+		{"1024-1025", true},
+		{"0-100", false},
+		{"100-100", false},
+		{"5000-4000", false},
+		{"1-65536", false},
+		{"20000", false},
+		{"20000-", false},
+		{" 20000-50000", false},
+		{"20000 - 50000", false},
+		{"a-b", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := validateDantePortRange(tt.in); (err == nil) != tt.ok {
+			t.Errorf("validateDantePortRange(%q) = %v, want ok=%v", tt.in, err, tt.ok)
+		}
+	}
+}
+
+func TestRestoreDanteBackupRemembersVersion(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PSAS_AUDIT_LOG", filepath.Join(dir, "audit.jsonl"))
+	sc := &socksClient{backend: socksBackendDante, config: filepath.Join(dir, "danted.conf")}
+	next := installerDanteConfig(t, "1443", "20000-50000")
+	prev := installerDanteConfig(t, "1080", "20000-50000")
+	backup := sc.config + ".bak-20260101-000000"
+	if err := os.WriteFile(sc.config, []byte(next), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backup, []byte(prev), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.loadDanteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreDanteBackup(sc, backup); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(sc.config); string(raw) != prev {
+		t.Fatalf("restored config:\n%s", raw)
+	}
+	if ver := sc.versions.lookup(sc.config); ver == nil || *ver != stateVersionOf([]byte(prev), true) {
+		t.Fatalf("remembered version %+v is not the restored file", ver)
+	}
+
+	// A change made by someone else after the restore is not overwritten.
+	if err := os.WriteFile(sc.config, []byte(next), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreDanteBackup(sc, backup); !errors.Is(err, errStateConflict) {
+		t.Fatalf("restore over a concurrent change = %v, want errStateConflict", err)
+	}
+	if raw, _ := os.ReadFile(sc.config); string(raw) != next {
+		t.Fatalf("concurrent change was overwritten:\n%s", raw)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+	doctorSkip = "skip"
+
+	// doctorCertWarnDays is how close to expiry a certificate becomes a warning.
+	doctorCertWarnDays = 14
+)
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type doctorReport struct {
+	Checks  []doctorCheck  `json:"checks"`
+	Summary map[string]int `json:"summary"`
+}
+
+func (r *doctorReport) add(name, status, format string, args ...any) {
+	detail := strings.Join(strings.Fields(fmt.Sprintf(format, args...)), " ")
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Detail: detail})
+}
+
+// doctorPort is a port a service is expected to listen on and to have open
+// in UFW.
+type doctorPort struct {
+	Service string
+	Port    int
+	Proto   string
+}
+
+type doctor struct {
+	timeout time.Duration
+	c       *client
+	tt      *trustClient
+	sc      *socksClient
+	mp      *mtproxyClient
+	report  doctorReport
+	ports   []doctorPort
+}
+
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "output JSON")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for each network probe")
+	strict := fs.Bool("strict", false, "exit nonzero on warnings too")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("doctor takes only flags")
+	}
+
+	d := &doctor{
+		timeout: *timeout,
+		c:       mustClient(false),
+		tt:      newTrustClient(),
+		sc:      newSocksClient(),
+		mp:      newMTProxyClient(),
+	}
+	d.checkPanel()
+	d.checkSocks()
+	d.checkTrust()
+	d.checkMTProxy()
+	d.checkFirewall()
+
+	rep := d.report
+	rep.Summary = map[string]int{doctorPass: 0, doctorWarn: 0, doctorFail: 0, doctorSkip: 0}
+	for _, ch := range rep.Checks {
+		rep.Summary[ch.Status]++
+	}
+	if *jsonOut {
+		printJSON(rep)
+	} else {
+		printDoctorReport(rep)
+	}
+	if rep.Summary[doctorFail] > 0 || (*strict && rep.Summary[doctorWarn] > 0) {
+		os.Exit(1)
+	}
+}
+
+func printDoctorReport(rep doctorReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+	for _, ch := range rep.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(ch.Status), ch.Name, ch.Detail)
+	}
+	_ = tw.Flush()
+	fmt.Printf("\n%d pass, %d warn, %d fail, %d skip\n", rep.Summary[doctorPass], rep.Summary[doctorWarn], rep.Summary[doctorFail], rep.Summary[doctorSkip])
+}
+
+func (d *doctor) checkService(name, service string, active func() (bool, error)) {
+	up, err := active()
+	switch {
+	case err != nil:
+		d.report.add(name+" service", doctorFail, "%v", err)
+	case !up:
+		d.report.add(name+" service", doctorFail, "%s is not active", service)
+	default:
+		d.report.add(name+" service", doctorPass, "%s is active", service)
+	}
+}
+
+func (d *doctor) checkPanel() {
+	if err := d.c.loadState(); err != nil {
+		d.report.add("panel state", doctorFail, "%v", err)
+		return
+	}
+	d.report.add("panel state", doctorPass, "api_path and api_key loaded")
+
+	start := time.Now()
+	users, err := d.c.usersList()
+	if err != nil {
+		d.report.add("panel API auth", doctorFail, "%v", err)
+	} else {
+		d.report.add("panel API auth", doctorPass, "%d users, %s", len(users), time.Since(start).Round(time.Millisecond))
+	}
+
+	host, err := d.c.mainDomainOrErr()
+	if err != nil {
+		d.report.add("main domain TLS", doctorWarn, "%v", err)
+		return
+	}
+	d.ports = append(d.ports, doctorPort{"hiddify", 443, "tcp"})
+	d.checkRemoteTLS("main domain TLS", host)
+}
+
+// checkRemoteTLS connects to host:443 with SNI and full verification.
+func (d *doctor) checkRemoteTLS(name, host string) {
+	dialer := &net.Dialer{Timeout: d.timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host})
+	if err != nil {
+		d.report.add(name, doctorFail, "%s: %v", host, err)
+		return
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		d.report.add(name, doctorFail, "%s: no peer certificate", host)
+		return
+	}
+	d.reportCertExpiry(name, host, certs[0])
+}
+
+func (d *doctor) reportCertExpiry(name, subject string, cert *x509.Certificate) {
+	left := time.Until(cert.NotAfter)
+	days := int(left.Hours() / 24)
+	expires := cert.NotAfter.UTC().Format("2006-01-02")
+	switch {
+	case left <= 0:
+		d.report.add(name, doctorFail, "%s: certificate expired on %s", subject, expires)
+	case days < doctorCertWarnDays:
+		d.report.add(name, doctorWarn, "%s: certificate expires in %d days (%s)", subject, days, expires)
+	default:
+		d.report.add(name, doctorPass, "%s: valid until %s (%d days)", subject, expires, days)
+	}
+}
+
+func (d *doctor) checkSocks() {
+	if !d.sc.installed() {
+		d.report.add("socks", doctorSkip, "not installed")
+		return
+	}
+	d.checkService("socks", d.sc.service, d.sc.serviceIsActive)
+	addr, err := d.sc.listenAddress()
+	if err != nil {
+		d.report.add("socks listener", doctorFail, "%v", err)
+		return
+	}
+	host, port, err := doctorSplitPort(addr)
+	if err != nil {
+		d.report.add("socks listener", doctorFail, "%v", err)
+		return
+	}
+	d.ports = append(d.ports, doctorPort{"socks", port, "tcp"}, doctorPort{"socks", port, "udp"})
+	d.checkListener("socks listener", host, port, "tcp")
+
+	users, err := d.sc.usersList()
+	if err != nil {
+		d.report.add("socks handshake", doctorFail, "%v", err)
+		return
+	}
+	if len(users) == 0 {
+		d.report.add("socks handshake", doctorSkip, "no stored SOCKS users")
+		return
+	}
+	u := users[0]
+	target := net.JoinHostPort(doctorProbeHost(host), strconv.Itoa(port))
+	if err := socks5Handshake(target, u.Name, u.Password, d.timeout); err != nil {
+		d.report.add("socks handshake", doctorFail, "%s as %s: %v", target, u.Name, err)
+		return
+	}
+	d.report.add("socks handshake", doctorPass, "%s: username/password auth as %s accepted", target, u.Name)
+}
+
+// socks5Handshake performs the RFC 1928 greeting and RFC 1929
+// username/password authentication; it does not open a tunnel.
+func socks5Handshake(addr, user, pass string, timeout time.Duration) error {
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("username or password longer than 255 bytes")
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x02}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("not a SOCKS5 server (version %d)", reply[0])
+	}
+	if reply[1] != 0x02 {
+		return fmt.Errorf("server refused username/password auth (method 0x%02x)", reply[1])
+	}
+	msg := []byte{0x01, byte(len(user))}
+	msg = append(msg, user...)
+	msg = append(msg, byte(len(pass)))
+	msg = append(msg, pass...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("authentication failed (status 0x%02x)", reply[1])
+	}
+	return nil
+}
+
+func (d *doctor) checkTrust() {
+	if !d.tt.installed() {
+		d.report.add("trust", doctorSkip, "not installed")
+		return
+	}
+	d.checkService("trust", d.tt.service, d.tt.serviceIsActive)
+	addr, err := d.tt.listenAddress()
+	if err != nil {
+		d.report.add("trust listener", doctorFail, "%v", err)
+	} else if host, port, err := doctorSplitPort(addr); err != nil {
+		d.report.add("trust listener", doctorFail, "%v", err)
+	} else {
+		d.ports = append(d.ports, doctorPort{"trust", port, "tcp"}, doctorPort{"trust", port, "udp"})
+		d.checkListener("trust listener", host, port, "tcp")
+		d.checkListener("trust listener", host, port, "udp")
+	}
+
+	paths, err := trustCertPaths(d.tt.hostsPath())
+	if err != nil {
+		d.report.add("trust certificate", doctorFail, "%v", err)
+		return
+	}
+	if len(paths) == 0 {
+		d.report.add("trust certificate", doctorWarn, "no cert_chain_path in %s", d.tt.hostsPath())
+		return
+	}
+	hostname, _ := d.tt.hostname()
+	for _, p := range paths {
+		cert, err := readPEMCertificate(p)
+		if err != nil {
+			d.report.add("trust certificate", doctorFail, "%s: %v", p, err)
+			continue
+		}
+		if hostname != "" {
+			if err := cert.VerifyHostname(hostname); err != nil {
+				d.report.add("trust certificate", doctorWarn, "%s: %v", p, err)
+				continue
+			}
+		}
+		d.reportCertExpiry("trust certificate", p, cert)
+	}
+}
+
+// trustCertPaths returns every cert_chain_path from TrustTunnel hosts.toml.
+func trustCertPaths(hostsPath string) ([]string, error) {
+	raw, err := os.ReadFile(hostsPath)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range strings.Split(strings.ReplaceAll(string(raw), "\r", ""), "\n") {
+		v, ok, err := parseTOMLStringAssignment(stripTOMLComment(line), "cert_chain_path")
+		if err != nil {
+			return nil, err
+		}
+		if ok && strings.TrimSpace(v) != "" {
+			out = append(out, strings.TrimSpace(v))
+		}
+	}
+	return out, nil
+}
+
+// readPEMCertificate returns the first (leaf) certificate of a PEM chain.
+func readPEMCertificate(path string) (*x509.Certificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, errors.New("no PEM certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+func (d *doctor) checkMTProxy() {
+	if !d.mp.installed() {
+		d.report.add("mtproxy", doctorSkip, "not installed")
+		return
+	}
+	d.checkService("mtproxy", d.mp.service, d.mp.serviceIsActive)
+	cfg, err := d.mp.loadConfig()
+	if err != nil {
+		d.report.add("mtproxy listener", doctorFail, "%v", err)
+		return
+	}
+	if cfg.Port <= 0 {
+		d.report.add("mtproxy listener", doctorFail, "no port in %s", d.mp.config)
+		return
+	}
+	d.ports = append(d.ports, doctorPort{"mtproxy", cfg.Port, "tcp"})
+	d.checkListener("mtproxy listener", "", cfg.Port, "tcp")
+}
+
+// checkListener looks the port up in /proc/net; for TCP it also dials the
+// configured listen host (loopback for wildcard or empty hosts) to make
+// sure the socket accepts connections.
+func (d *doctor) checkListener(name, host string, port int, proto string) {
+	label := fmt.Sprintf("%s %d/%s", name, port, proto)
+	bound, err := procNetListening(port, proto)
+	if err != nil {
+		d.report.add(label, doctorWarn, "cannot read /proc/net: %v", err)
+		return
+	}
+	if !bound {
+		d.report.add(label, doctorFail, "nothing listens on %d/%s", port, proto)
+		return
+	}
+	if proto == "tcp" {
+		target := net.JoinHostPort(doctorProbeHost(host), strconv.Itoa(port))
+		conn, err := net.DialTimeout("tcp", target, d.timeout)
+		if err != nil {
+			d.report.add(label, doctorWarn, "listening, but connect to %s failed: %v", target, err)
+			return
+		}
+		conn.Close()
+		d.report.add(label, doctorPass, "listening and accepting connections")
+		return
+	}
+	d.report.add(label, doctorPass, "socket bound")
+}
+
+// procNetListening reports whether a TCP socket listens (state 0A) or a UDP
+// socket is bound on port, IPv4 or IPv6.
+func procNetListening(port int, proto string) (bool, error) {
+	want := fmt.Sprintf("%04X", port)
+	var lastErr error
+	read := 0
+	for _, f := range []string{"/proc/net/" + proto, "/proc/net/" + proto + "6"} {
+		file, err := os.Open(f)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		read++
+		sc := bufio.NewScanner(file)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 4 || !strings.Contains(fields[1], ":") {
+				continue
+			}
+			local := fields[1]
+			if local[strings.LastIndex(local, ":")+1:] != want {
+				continue
+			}
+			if proto == "udp" || fields[3] == "0A" {
+				file.Close()
+				return true, nil
+			}
+		}
+		file.Close()
+	}
+	if read == 0 {
+		return false, lastErr
+	}
+	return false, nil
+}
+
+var ufwRuleRe = regexp.MustCompile(`^(\d+)(?::(\d+))?(?:/(tcp|udp))?(?:\s+\(v6\))?\s+ALLOW`)
+
+func (d *doctor) checkFirewall() {
+	if _, err := exec.LookPath("ufw"); err != nil {
+		d.report.add("ufw", doctorSkip, "ufw is not installed")
+		return
+	}
+	out, err := runCommandOutput("ufw", "status")
+	if err != nil {
+		d.report.add("ufw", doctorWarn, "ufw status: %v (%s)", err, shortText(out, 120))
+		return
+	}
+	if !strings.Contains(out, "Status: active") {
+		d.report.add("ufw", doctorWarn, "ufw is inactive")
+		return
+	}
+	d.report.add("ufw", doctorPass, "active")
+	rules := parseUFWAllowRules(out)
+	for _, p := range d.ports {
+		label := fmt.Sprintf("ufw %s %d/%s", p.Service, p.Port, p.Proto)
+		if ufwAllows(rules, p.Port, p.Proto) {
+			d.report.add(label, doctorPass, "allowed")
+		} else {
+			d.report.add(label, doctorWarn, "no ALLOW rule (ufw allow %d/%s)", p.Port, p.Proto)
+		}
+	}
+}
+
+type ufwRule struct {
+	From, To int
+	Proto    string
+}
+
+func parseUFWAllowRules(status string) []ufwRule {
+	var rules []ufwRule
+	for _, line := range strings.Split(status, "\n") {
+		m := ufwRuleRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		from, _ := strconv.Atoi(m[1])
+		to := from
+		if m[2] != "" {
+			to, _ = strconv.Atoi(m[2])
+		}
+		rules = append(rules, ufwRule{From: from, To: to, Proto: m[3]})
+	}
+	return rules
+}
+
+func ufwAllows(rules []ufwRule, port int, proto string) bool {
+	for _, r := range rules {
+		if port >= r.From && port <= r.To && (r.Proto == "" || r.Proto == proto) {
+			return true
+		}
+	}
+	return false
+}
+
+func doctorSplitPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %s", addr)
+	}
+	return host, port, nil
+}
+
+// doctorProbeHost maps wildcard listen hosts to loopback.
+func doctorProbeHost(host string) string {
+	switch host {
+	case "", "0.0.0.0", "*":
+		return "127.0.0.1"
+	case "::", "[::]":
+		return "::1"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return host
+	}
+	// danted also accepts interface names (internal: eth0).
+	if ifc, err := net.InterfaceByName(host); err == nil {
+		if addrs, err := ifc.Addrs(); err == nil {
+			for _, a := range addrs {
+				if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() != nil {
+					return ipn.IP.String()
+				}
+			}
+		}
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDoctorCheckListenerDialsConfiguredHost(t *testing.T) {
+	// danted with internal: 127.0.0.2 does not accept on 127.0.0.1.
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("cannot listen on 127.0.0.2: %v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	d := &doctor{timeout: time.Second}
+	d.checkListener("socks listener", "127.0.0.2", port, "tcp")
+	if got := d.report.Checks[0]; got.Status != doctorPass {
+		t.Fatalf("check = %+v, want pass", got)
+	}
+}
+
+func TestDoctorProbeHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"":          "127.0.0.1",
+		"0.0.0.0":   "127.0.0.1",
+		"::":        "::1",
+		"10.0.0.5":  "10.0.0.5",
+		"127.0.0.2": "127.0.0.2",
+		"no-such0":  "127.0.0.1",
+	} {
+		if got := doctorProbeHost(host); got != want {
+			t.Errorf("doctorProbeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
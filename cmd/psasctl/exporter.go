@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultExporterListen   = "127.0.0.1:9787"
+	defaultExporterUnitPath = "/etc/systemd/system/psas-exporter.service"
+	exporterBytesPerGB      = 1 << 30
+)
+
+// promWriter renders the Prometheus text exposition format (version 0.0.4).
+// Samples are grouped by family so collectors may emit in any order.
+type promWriter struct {
+	order    []string
+	families map[string]*promFamily
+}
+
+type promFamily struct {
+	typ, help string
+	samples   bytes.Buffer
+}
+
+func newPromWriter() *promWriter {
+	return &promWriter{families: map[string]*promFamily{}}
+}
+
+// gauge writes one sample; labels are alternating name/value pairs.
+func (p *promWriter) gauge(name, help string, value float64, labels ...string) {
+	p.sample("gauge", name, help, value, labels...)
+}
+
+// counter writes one sample of a monotonic counter; name should end in
+// _total.
+func (p *promWriter) counter(name, help string, value float64, labels ...string) {
+	p.sample("counter", name, help, value, labels...)
+}
+
+func (p *promWriter) sample(typ, name, help string, value float64, labels ...string) {
+	f, ok := p.families[name]
+	if !ok {
+		f = &promFamily{typ: typ, help: help}
+		p.families[name] = f
+		p.order = append(p.order, name)
+	}
+	b := &f.samples
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", labels[i], promEscape(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(promFloat(value))
+	b.WriteByte('\n')
+}
+
+func (p *promWriter) bytes() []byte {
+	var out bytes.Buffer
+	for _, name := range p.order {
+		f := p.families[name]
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		out.Write(f.samples.Bytes())
+	}
+	return out.Bytes()
+}
+
+func promEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(v)
+}
+
+func promFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func promBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type exporter struct {
+	userMetrics bool
+	// mu serializes scrapes; each one shells out to systemctl and the panel.
+	mu sync.Mutex
+	c  *client
+	tt *trustClient
+	sc *socksClient
+	mp *mtproxyClient
+}
+
+func runExporter(args []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub := strings.ToLower(strings.TrimSpace(args[0]))
+		subArgs := args[1:]
+		switch sub {
+		case "dump", "once":
+			fs := flag.NewFlagSet("exporter dump", flag.ExitOnError)
+			noUsers := fs.Bool("no-user-metrics", false, "skip per-user gauges")
+			out := fs.String("out", "", "write to file atomically (node_exporter textfile collector) instead of stdout")
+			must(fs.Parse(subArgs))
+			e := newExporter(!*noUsers)
+			metrics := e.collect()
+			if strings.TrimSpace(*out) == "" {
+				_, err := os.Stdout.Write(metrics)
+				must(err)
+				return
+			}
+			tmp := *out + ".tmp"
+			must(os.WriteFile(tmp, metrics, 0o644))
+			must(os.Rename(tmp, *out))
+		case "unit", "install":
+			fs := flag.NewFlagSet("exporter "+sub, flag.ExitOnError)
+			listen := fs.String("listen", defaultExporterListen, "listen address: HOST:PORT or unix:/path.sock")
+			allowRemote := fs.Bool("allow-remote", false, "allow listening on non-loopback addresses")
+			noUsers := fs.Bool("no-user-metrics", false, "skip per-user gauges")
+			unitPath := fs.String("unit-path", defaultExporterUnitPath, "systemd unit file path")
+			noStart := fs.Bool("no-start", false, "write the unit but do not enable/start it")
+			must(fs.Parse(subArgs))
+			runArgs := []string{"exporter", "--listen", *listen}
+			if *allowRemote {
+				runArgs = append(runArgs, "--allow-remote")
+			}
+			if *noUsers {
+				runArgs = append(runArgs, "--no-user-metrics")
+			}
+			unit := renderPSASUnit("PSAS Prometheus exporter", runArgs)
+			if sub == "unit" {
+				fmt.Print(unit)
+				return
+			}
+			must(requireRoot("exporter install"))
+			must(installPSASUnit(*unitPath, unit, !*noStart))
+		default:
+			fatalf("unknown exporter subcommand: %s", sub)
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	listen := fs.String("listen", defaultExporterListen, "listen address: HOST:PORT or unix:/path.sock")
+	allowRemote := fs.Bool("allow-remote", false, "allow listening on non-loopback addresses")
+	noUsers := fs.Bool("no-user-metrics", false, "skip per-user gauges (large installations)")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("exporter takes only flags")
+	}
+
+	e := newExporter(!*noUsers)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(e.collect())
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, `<html><body><a href="/metrics">metrics</a></body></html>`+"\n")
+	})
+	ln, where, err := apiListen(*listen, *allowRemote)
+	must(err)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(os.Stderr, "psasctl exporter: listening on %s/metrics\n", where)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("%v", err)
+	}
+}
+
+func newExporter(userMetrics bool) *exporter {
+	return &exporter{
+		userMetrics: userMetrics,
+		c:           mustClient(false),
+		tt:          newTrustClient(),
+		sc:          newSocksClient(),
+		mp:          newMTProxyClient(),
+	}
+}
+
+// collect runs one scrape. A failing collector reports
+// psas_collector_success 0 instead of failing the whole scrape.
+func (e *exporter) collect() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	start := time.Now()
+	p := newPromWriter()
+	collectors := []struct {
+		name string
+		fn   func(*promWriter) error
+	}{
+		{"services", e.collectServices},
+		{"hiddify", e.collectHiddify},
+		{"socks", e.collectSocks},
+		{"trust", e.collectTrust},
+		{"accounts", e.collectAccounts},
+	}
+	results := map[string]bool{}
+	for _, col := range collectors {
+		err := col.fn(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "psasctl exporter: %s: %v\n", col.name, err)
+		}
+		results[col.name] = err == nil
+	}
+	for _, col := range collectors {
+		p.gauge("psas_collector_success", "Whether the collector succeeded during the last scrape.", promBool(results[col.name]), "collector", col.name)
+	}
+	p.gauge("psas_scrape_duration_seconds", "Duration of the last scrape.", time.Since(start).Seconds())
+	return p.bytes()
+}
+
+func (e *exporter) collectServices(p *promWriter) error {
+	services := []struct {
+		backend   string
+		service   string
+		installed func() bool
+		active    func() (bool, error)
+	}{
+		{"socks", e.sc.service, e.sc.installed, e.sc.serviceIsActive},
+		{"trust", e.tt.service, e.tt.installed, e.tt.serviceIsActive},
+		{"mtproxy", e.mp.service, e.mp.installed, e.mp.serviceIsActive},
+	}
+	var errs []error
+	for _, s := range services {
+		installed := s.installed()
+		p.gauge("psas_service_installed", "Whether the backend is installed.", promBool(installed), "backend", s.backend, "service", s.service)
+		if !installed {
+			continue
+		}
+		up, err := s.active()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		p.gauge("psas_service_up", "Whether the systemd service is active.", promBool(up), "backend", s.backend, "service", s.service)
+	}
+	return errors.Join(errs...)
+}
+
+func (e *exporter) collectHiddify(p *promWriter) error {
+	up, latency := 0.0, 0.0
+	defer func() {
+		p.gauge("psas_hiddify_panel_up", "Whether the Hiddify panel API answered.", up)
+		p.gauge("psas_hiddify_panel_latency_seconds", "Latency of the Hiddify users API call.", latency)
+	}()
+	if err := e.c.ensureState(); err != nil {
+		return err
+	}
+	start := time.Now()
+	users, err := e.c.usersList()
+	latency = time.Since(start).Seconds()
+	if err != nil {
+		// The API key may have been rotated: reload the state next time.
+		e.c.state = state{}
+		return err
+	}
+	up = 1
+
+	now := time.Now()
+	var enabled, expired, overQuota float64
+	for _, u := range users {
+		if u.Enable {
+			enabled++
+		}
+		if !isUnlimitedDays(u) && u.RemainingDays <= 0 {
+			expired++
+		}
+		if !isUnlimitedUsage(u) && u.UsageLimitGB > 0 && userUsagePercent(u) >= 100 {
+			overQuota++
+		}
+	}
+	p.gauge("psas_users", "Number of users per backend.", float64(len(users)), "backend", "hiddify")
+	const stateHelp = "Number of Hiddify users by state (a user can be in several states)."
+	p.gauge("psas_hiddify_users", stateHelp, enabled, "state", "enabled")
+	p.gauge("psas_hiddify_users", stateHelp, float64(len(users))-enabled, "state", "disabled")
+	p.gauge("psas_hiddify_users", stateHelp, expired, "state", "expired")
+	p.gauge("psas_hiddify_users", stateHelp, overQuota, "state", "over_quota")
+	if !e.userMetrics {
+		return nil
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].UUID < users[j].UUID })
+	for _, u := range users {
+		labels := []string{"uuid", u.UUID, "name", u.Name}
+		p.gauge("psas_hiddify_user_enabled", "Whether the Hiddify user is enabled.", promBool(u.Enable), labels...)
+		p.gauge("psas_hiddify_user_usage_bytes", "Traffic used in the current package.", u.CurrentUsageGB*exporterBytesPerGB, labels...)
+		limit := u.UsageLimitGB * exporterBytesPerGB
+		if isUnlimitedUsage(u) {
+			limit = math.Inf(1)
+		}
+		p.gauge("psas_hiddify_user_limit_bytes", "Traffic limit of the current package (+Inf = unlimited).", limit, labels...)
+		days := float64(userRemainingDays(u, now))
+		if isUnlimitedDays(u) {
+			days = math.Inf(1)
+		}
+		p.gauge("psas_hiddify_user_remaining_days", "Days left in the current package (+Inf = unlimited).", days, labels...)
+		if t, ok := parseHiddifyTime(u.LastOnline); ok {
+			p.gauge("psas_hiddify_user_last_online_timestamp_seconds", "Last time the user was online.", float64(t.Unix()), labels...)
+		}
+	}
+	return nil
+}
+
+func (e *exporter) collectSocks(p *promWriter) error {
+	if !e.sc.installed() {
+		return nil
+	}
+	users, err := e.sc.usersList()
+	if err != nil {
+		return err
+	}
+	p.gauge("psas_users", "Number of users per backend.", float64(len(users)), "backend", "socks")
+	if !e.userMetrics || !e.sc.builtin() {
+		return nil
+	}
+
+	// Only the built-in server keeps per-user counters.
+	st, err := loadSocksStats(socksStatsPath())
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		cur := st.Users[u.Name]
+		p.counter("psas_socks_user_traffic_bytes_total", "Traffic through the built-in SOCKS server (up = client to destination).", float64(cur.BytesUp), "name", u.Name, "direction", "up")
+		p.counter("psas_socks_user_traffic_bytes_total", "Traffic through the built-in SOCKS server (up = client to destination).", float64(cur.BytesDown), "name", u.Name, "direction", "down")
+		p.gauge("psas_socks_user_active_connections", "Open connections of the SOCKS user.", float64(cur.Active), "name", u.Name)
+	}
+	return nil
+}
+
+func (e *exporter) collectTrust(p *promWriter) error {
+	if !e.tt.installed() {
+		return nil
+	}
+	users, err := e.tt.usersList()
+	if err != nil {
+		return err
+	}
+	p.gauge("psas_users", "Number of users per backend.", float64(len(users)), "backend", "trust")
+	return nil
+}
+
+func (e *exporter) collectAccounts(p *promWriter) error {
+	accounts, err := loadAccounts()
+	if err != nil {
+		return err
+	}
+	p.gauge("psas_accounts", "Number of PSAS accounts (psasctl accounts).", float64(len(accounts)))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExporterSocksTrafficCounter(t *testing.T) {
+	dir := t.TempDir()
+	sc := &socksClient{
+		backend:      socksBackendBuiltin,
+		serverConfig: filepath.Join(dir, "socks-server.json"),
+		users:        filepath.Join(dir, "socks-users.json"),
+	}
+	t.Setenv("PSAS_SOCKS_STATS", filepath.Join(dir, "socks-stats.json"))
+	files := map[string]string{
+		sc.serverConfig:  `{"listen":"0.0.0.0:1080"}`,
+		sc.users:         `[{"name":"alice","password":"pw"}]`,
+		socksStatsPath(): `{"users":{"alice":{"bytes_up":10,"bytes_down":2048,"active":1}}}`,
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newPromWriter()
+	if err := (&exporter{userMetrics: true, sc: sc}).collectSocks(p); err != nil {
+		t.Fatal(err)
+	}
+	out := string(p.bytes())
+	for _, want := range []string{
+		"# TYPE psas_socks_user_traffic_bytes_total counter\n",
+		`psas_socks_user_traffic_bytes_total{name="alice",direction="up"} 10` + "\n",
+		`psas_socks_user_traffic_bytes_total{name="alice",direction="down"} 2048` + "\n",
+		"# TYPE psas_socks_user_active_connections gauge\n",
+		`psas_users{backend="socks"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "psas_socks_user_traffic_bytes{") {
+		t.Errorf("old gauge name still exported:\n%s", out)
+	}
+}
@@ -65,12 +65,16 @@ type domain struct {
 }
 
 type apiUser struct {
-	UUID         string  `json:"uuid"`
-	Name         string  `json:"name"`
-	Enable       bool    `json:"enable"`
-	UsageLimitGB float64 `json:"usage_limit_GB"`
-	PackageDays  int     `json:"package_days"`
-	Mode         string  `json:"mode"`
+	UUID           string  `json:"uuid"`
+	Name           string  `json:"name"`
+	Enable         bool    `json:"enable"`
+	UsageLimitGB   float64 `json:"usage_limit_GB"`
+	CurrentUsageGB float64 `json:"current_usage_GB"`
+	PackageDays    int     `json:"package_days"`
+	StartDate      string  `json:"start_date,omitempty"`
+	LastOnline     string  `json:"last_online,omitempty"`
+	RemainingDays  int     `json:"remaining_days"`
+	Mode           string  `json:"mode"`
 }
 
 type linkSet struct {
@@ -94,6 +98,7 @@ type trustClient struct {
 	dir               string
 	service           string
 	lastExportAddress string
+	versions          stateVersions
 }
 
 type trustUser struct {
@@ -112,9 +117,10 @@ type trustStatus struct {
 }
 
 type mtproxyClient struct {
-	dir     string
-	service string
-	config  string
+	dir      string
+	service  string
+	config   string
+	versions stateVersions
 }
 
 type mtproxyConfig struct {
@@ -146,18 +152,24 @@ type mtproxyConnInfo struct {
 }
 
 type socksClient struct {
-	service string
-	config  string
-	users   string
+	backend      string
+	service      string
+	config       string
+	serverConfig string
+	users        string
+	versions     stateVersions
 }
 
 type socksUser struct {
-	Name       string `json:"name"`
-	Password   string `json:"password"`
-	SystemUser string `json:"system_user,omitempty"`
+	Name       string   `json:"name"`
+	Password   string   `json:"password"`
+	SystemUser string   `json:"system_user,omitempty"`
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
 }
 
 type socksStatus struct {
+	Backend       string `json:"backend"`
 	Installed     bool   `json:"installed"`
 	Service       string `json:"service"`
 	ServiceActive bool   `json:"service_active"`
@@ -210,7 +222,6 @@ var uuidRe = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[
 var mtproxySecretRe = regexp.MustCompile(`^[A-Fa-f0-9]{32}$`)
 var trustUserRe = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
 var socksUserRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,30}$`)
-var dantedInternalRe = regexp.MustCompile(`(?i)^internal:\s*([^\s]+)(?:\s+port\s*=\s*([0-9]{1,5}))?\s*$`)
 var ansiRe = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]`)
 var errUISelectionCanceled = errors.New("selection canceled")
 var errUIExitRequested = errors.New("exit requested")
@@ -310,6 +321,9 @@ var uiTextRU = map[string]string{
 	"Directory":                                                         "Каталог",
 	"Config":                                                            "Конфиг",
 	"Listen":                                                            "Слушает",
+	"Backend":                                                           "Бэкенд",
+	"Allowed destinations":                                              "Разрешённые адреса",
+	"Denied destinations":                                               "Запрещённые адреса",
 	"Hostname":                                                          "Хостнейм",
 	"Users":                                                             "Пользователи",
 	"Main domain":                                                       "Основной домен",
@@ -417,6 +431,55 @@ var uiTextRU = map[string]string{
 	"MTProxy secret (HEX32)":                            "Секрет MTProxy (HEX32)",
 	"Server host/ip (empty = from config)":              "Сервер host/ip (пусто = из конфига)",
 	"Port (empty = from config)":                        "Порт (пусто = из конфига)",
+	"Select plan":                                       "Выберите тариф",
+	"No plan":                                           "Без тарифа",
+	"Enter limits manually":                             "Задать лимиты вручную",
+	"Plan %s: %s":                                       "Тариф %s: %s",
+	"Override plan limits?":                             "Изменить лимиты тарифа?",
+	"Account":                                           "Аккаунт",
+	"Created":                                           "Создан",
+	"Access bundle for %s":                              "Доступы для %s",
+	"Traffic limit":                                     "Лимит трафика",
+	"Days left":                                         "Осталось дней",
+	"unlimited":                                         "безлимит",
+	"1. Hiddify (main VPN)":                             "1. Hiddify (основной VPN)",
+	"Install the Hiddify app, then open the link below or scan qr-hiddify-auto.png.": "Установите приложение Hiddify, затем откройте ссылку ниже или отсканируйте qr-hiddify-auto.png.",
+	"Import link":                    "Ссылка для импорта",
+	"Subscription for other clients": "Подписка для других клиентов",
+	"Sing-box profile":               "Профиль sing-box",
+	"Personal page":                  "Личная страница",
+	"Use in a browser or app proxy settings; see socks5.txt or scan qr-socks5.png.": "Укажите в настройках прокси браузера или приложения; см. socks5.txt или отсканируйте qr-socks5.png.",
+	"Import trusttunnel.toml into the TrustTunnel client.":                          "Импортируйте trusttunnel.toml в клиент TrustTunnel.",
+	"Open the link on a device with Telegram installed or scan qr-mtproxy.png.":     "Откройте ссылку на устройстве с Telegram или отсканируйте qr-mtproxy.png.",
+	"Keep this bundle private: it contains personal links and passwords.":           "Не передавайте эти файлы третьим лицам: в них личные ссылки и пароли.",
+	"Show QR code?":                    "Показать QR-код?",
+	"Select link for QR code":          "Выберите ссылку для QR-кода",
+	"Account linked: %s":               "Аккаунт привязан: %s",
+	"Account unlinked.":                "Аккаунт отвязан.",
+	"Invalid UUID.":                    "Некорректный UUID.",
+	"MTProxy is not installed.":        "MTProxy не установлен.",
+	"QR code of the subscription link": "QR-код ссылки подписки",
+	"Self-service linking is disabled. Ask the administrator to link your account.":                 "Самостоятельная привязка отключена. Попросите администратора привязать ваш аккаунт.",
+	"Send /start <UUID> to link your account. The UUID is the last part of your subscription link.": "Отправьте /start <UUID>, чтобы привязать аккаунт. UUID — последняя часть вашей ссылки подписки.",
+	"Telegram MTProxy link":             "ссылка на MTProxy для Telegram",
+	"Temporary error, try again later.": "Временная ошибка, попробуйте позже.",
+	"Traffic":                           "Трафик",
+	"Traffic left":                      "Осталось трафика",
+	"Unknown command. Send /help.":      "Неизвестная команда. Отправьте /help.",
+	"User not found.":                   "Пользователь не найден.",
+	"Your account is not linked yet.":   "Аккаунт ещё не привязан.",
+	"all links":                         "все ссылки",
+	"disabled":                          "отключён",
+	"enabled":                           "включён",
+	"own account, after /start UUID":    "свой аккаунт, после /start UUID",
+	"remaining traffic and days":        "остаток трафика и дней",
+	"subscription link":                 "ссылка подписки",
+	"unlink this Telegram account":      "отвязать этот Telegram-аккаунт",
+	// Subscription page (psasctl sub).
+	"Download client config": "Скачать конфиг клиента",
+	"Endpoint":               "Адрес",
+	"Subscription b64":       "Подписка b64",
+	"User is disabled":       "Пользователь отключён",
 }
 
 func main() {
@@ -453,6 +516,34 @@ func main() {
 		runMTProxy(args)
 	case "socks", "socks5":
 		runSocks(args)
+	case "socks-server":
+		runSocksServer(args)
+	case "plans", "plan":
+		runPlans(args)
+	case "accounts", "account", "acc":
+		runAccounts(args)
+	case "agent":
+		runAgent(args)
+	case "notify", "notifications":
+		runNotify(args)
+	case "bot", "telegram":
+		runBot(args)
+	case "serve", "api":
+		runServe(args)
+	case "sub", "subscription":
+		runSub(args)
+	case "exporter", "metrics":
+		runExporter(args)
+	case "doctor", "check":
+		runDoctor(args)
+	case "cert", "certs":
+		runCert(args)
+	case "backup", "backups":
+		runBackup(args)
+	case "migrate", "migration":
+		runMigrate(args)
+	case "audit":
+		runAudit(args)
 	case "lang", "language":
 		runLang(args)
 	case "help", "-h", "--help":
@@ -467,15 +558,66 @@ func usage() {
 
 Usage:
   psasctl status [--json]
+  psasctl doctor [--json] [--strict] [--timeout 5s]
+  psasctl cert status [--domain D] [--warn-days 21] [--remote=false] [--notify] [--json]
+  psasctl cert sync [--domain D] [--dry-run] [--no-reload] [--notify] [--warn-days 21] [--json]
+  psasctl cert renew [--domain D] [--email E] [--force] [--no-sync]
+  psasctl backup create [--dir DIR] [--only psas,socks,trust,mtproxy,hiddify] [--skip LIST] [--passphrase-file F | --age-recipient R] [--keep 14] [--json]
+  psasctl backup list [--dir DIR] [--json]
+  psasctl backup restore [--only LIST] [--passphrase-file F | --age-identity F] [--dry-run] [--no-restart] [--no-safety-backup] [--yes] [--json] <FILE>
+  psasctl migrate export [--out FILE|-] [--only hiddify,socks,trust,mtproxy,accounts] [--passphrase-file F]
+  psasctl migrate import [--only LIST] [--domain D] [--host H] [--ip IP] [--passphrase-file F] [--dry-run] [--no-apply] [--yes] [--json] <FILE>
+  psasctl audit show [--since 24h|7d|DATE] [--user U] [--action PREFIX] [--target T] [--via cli|api|web|bot|agent] [--errors] [--limit 50] [--json]
   psasctl admin-url
   psasctl ui
-  psasctl users list [--name QUERY] [--enabled] [--json]
+  psasctl users list [--name QUERY] [--enabled] [--expiring-within 7d] [--over-usage 80%] [--sort name|usage|remaining|last-online] [--desc] [--json]
   psasctl users find [--enabled] [--json] <QUERY>
-  psasctl users add --name NAME [--subscription-name TITLE] [--days 30] [--gb 100] [--unlimited] [--unlimited-days] [--unlimited-gb] [--true-unlimited] [--true-unlimited-days] [--true-unlimited-gb] [--mode no_reset] [--host DOMAIN] [--uuid UUID] [--json]
-  psasctl users edit [--name NAME] [--subscription-name TITLE] [--days N] [--gb N] [--unlimited] [--unlimited-days] [--unlimited-gb] [--true-unlimited] [--true-unlimited-days] [--true-unlimited-gb] [--mode MODE] [--enable|--disable] [--host DOMAIN] [--json] <USER_ID>
-  psasctl users show [--host DOMAIN] [--json] <USER_ID>
-  psasctl users links [--host DOMAIN] [--json] <USER_ID>
+  psasctl users add --name NAME [--subscription-name TITLE] [--days 30] [--gb 100] [--unlimited] [--unlimited-days] [--unlimited-gb] [--true-unlimited] [--true-unlimited-days] [--true-unlimited-gb] [--mode no_reset] [--plan NAME] [--host DOMAIN] [--uuid UUID] [--json]
+  psasctl users edit [--name NAME] [--subscription-name TITLE] [--days N] [--gb N] [--unlimited] [--unlimited-days] [--unlimited-gb] [--true-unlimited] [--true-unlimited-days] [--true-unlimited-gb] [--mode MODE] [--enable|--disable] [--add-days N] [--add-gb N] [--reset-usage] [--host DOMAIN] [--json] <USER_ID>
+  psasctl users show [--host DOMAIN] [--qr] [--qr-out FILE.png|FILE.svg] [--qr-link auto|sub64|sub|singbox|panel] [--json] <USER_ID>
+  psasctl users links [--host DOMAIN] [--qr] [--qr-out FILE.png|FILE.svg] [--qr-link auto|sub64|sub|singbox|panel] [--json] <USER_ID>
   psasctl users del <USER_ID>
+  psasctl users bulk <enable|disable|extend|reset|delete> [--all] [--name GLOB] [--regex RE] [--enabled|--disabled] [--mode MODE] [--expiring-within 7d] [--over-usage 80%] [--days N] [--gb N] [--concurrency 4] [--dry-run] [--yes] [--json]
+  psasctl users export [--format json|csv] [--out FILE] [--host DOMAIN] [--name QUERY] [--enabled]
+  psasctl users import [--format json|csv] [--dry-run] [--upsert] [--json] <FILE|->
+  psasctl users bundle [--out DIR|FILE.zip] [--zip] [--host DOMAIN] [--socks LOGIN] [--socks-server HOST] [--trust USERNAME] [--trust-address IP:PORT] [--no-mtproxy] [--lang us|ru] [--json] <USER_ID>
+  psasctl plans list [--json]
+  psasctl plans add --name NAME [--description TEXT] [--days 30] [--gb 100] [--unlimited] [--unlimited-days] [--unlimited-gb] [--true-unlimited] [--mode no_reset] [--replace] [--json]
+  psasctl plans del <NAME>
+  psasctl accounts list [--json]
+  psasctl accounts add --name NAME [--services hiddify,socks,trust,mtproxy] [--plan NAME] [--days 30] [--gb 100] [--mode no_reset] [--uuid UUID] [--socks-login LOGIN] [--trust-username NAME] [--password PASS] [--json]
+  psasctl accounts show [--host DOMAIN] [--json] <ACCOUNT>
+  psasctl accounts del [--json] <ACCOUNT>
+  psasctl agent run [--interval 5m] [--expiry-days 3d] [--usage-threshold 90%] [--auto-disable] [--hook CMD]... [--once] [--dry-run] [--json]
+  psasctl agent status [--json]
+  psasctl agent unit [agent run flags]
+  psasctl agent install [--unit-path /etc/systemd/system/psas-agent.service] [--no-start] [agent run flags]
+  psasctl notify status [--json]
+  psasctl notify test [--message TEXT]
+  psasctl notify outbox [--clear] [--json]
+  psasctl notify flush
+  psasctl bot config [--token TOKEN] [--admin TELEGRAM_ID]... [--clear-admins] [--host DOMAIN] [--api-base URL] [--self-bind on|off] [--json]
+  psasctl bot run [--token TOKEN] [--api-base URL]
+  psasctl bot bind <TELEGRAM_ID> <USER_ID>
+  psasctl bot unbind <TELEGRAM_ID>
+  psasctl bot bindings [--json]
+  psasctl bot unit
+  psasctl bot install [--unit-path /etc/systemd/system/psas-bot.service] [--no-start]
+  psasctl serve [--listen 127.0.0.1:8787|unix:/run/psas/api.sock] [--allow-remote] [--no-ui]
+  psasctl serve token [--rotate]
+  psasctl serve passwd [--user admin] [--password PASS]
+  psasctl serve openapi
+  psasctl serve unit [--listen ADDR] [--allow-remote] [--no-ui]
+  psasctl serve install [--listen ADDR] [--allow-remote] [--no-ui] [--unit-path /etc/systemd/system/psas-api.service] [--no-start]
+  psasctl sub serve [--listen 127.0.0.1:8788] [--path /psas-sub/] [--host DOMAIN]
+  psasctl sub url [--base URL] [--rotate] [--qr] [--qr-out FILE] [--json] <ACCOUNT>
+  psasctl sub revoke <ACCOUNT>
+  psasctl sub unit [--listen ADDR] [--path /psas-sub/] [--host DOMAIN]
+  psasctl sub install [--listen ADDR] [--path /psas-sub/] [--host DOMAIN] [--unit-path /etc/systemd/system/psas-sub.service] [--no-start]
+  psasctl exporter [--listen 127.0.0.1:9787] [--allow-remote] [--no-user-metrics]
+  psasctl exporter dump [--no-user-metrics] [--out /var/lib/node_exporter/textfile/psas.prom]
+  psasctl exporter unit [--listen ADDR] [--allow-remote] [--no-user-metrics]
+  psasctl exporter install [--listen ADDR] [--allow-remote] [--no-user-metrics] [--unit-path /etc/systemd/system/psas-exporter.service] [--no-start]
   psasctl protocols list [--json]
   psasctl list protocols [--json]
   psasctl protocols set <PROTOCOL> <on|off|true|false|1|0>
@@ -494,7 +636,7 @@ Usage:
   psasctl trust service <status|start|stop|restart>
   psasctl trust ui
   psasctl mtproxy status [--json]
-  psasctl mtproxy config [--server HOST] [--port N] [--secret HEX32] [--json]
+  psasctl mtproxy config [--server HOST] [--port N] [--secret HEX32] [--qr] [--qr-out FILE.png|FILE.svg] [--json]
   psasctl mtproxy secret show [--json]
   psasctl mtproxy secret set <HEX32> [--json]
   psasctl mtproxy secret regen [--json]
@@ -505,14 +647,27 @@ Usage:
   psasctl socks users add --name LOGIN [--password PASS] [--server HOST] [--port N] [--show-config] [--json]
   psasctl socks users edit [--name LOGIN] [--password PASS] [--json] <USER_ID>
   psasctl socks users show [--server HOST] [--port N] [--show-config] [--json] <USER_ID>
-  psasctl socks users config [--server HOST] [--port N] [--out FILE] [--json] <USER_ID>
+  psasctl socks users config [--server HOST] [--port N] [--out FILE] [--qr] [--qr-out FILE.png|FILE.svg] [--json] <USER_ID>
   psasctl socks users del <USER_ID>
+  psasctl socks users sync [--dry-run] [--prune] [--remove-orphans [--yes]] [--json]
+  psasctl socks users acl [--allow IP|CIDR]... [--deny IP|CIDR]... [--clear] [--json] <USER_ID>
+  psasctl socks stats [--json]
+  psasctl socks config show [--raw] [--json]
+  psasctl socks config set [--port N] [--udp-range START-END] [--external-iface IFACE] [--log OUTPUT] [--dry-run] [--no-restart]
+  psasctl socks acl show [--json]
+  psasctl socks acl set --block-private on|off
+  psasctl socks acl apply
   psasctl socks service <status|start|stop|restart>
   psasctl socks ui
+  psasctl socks-server run [--config FILE] [--listen ADDR]
+  psasctl socks-server unit
+  psasctl socks-server install [--listen 0.0.0.0:1080] [--max-conns N] [--max-conns-per-user N] [--allow CIDR]... [--deny CIDR]... [--allow-private] [--unit-path /etc/systemd/system/psas-socks.service] [--no-start]
+  psasctl socks-server uninstall [--unit-path FILE]
   psasctl lang [show]
   psasctl lang set <us|ru>
 
 USER_ID can be UUID or user name (exact/substring match).
+ACCOUNT can be account name, Hiddify UUID, SOCKS login or TrustTunnel username.
 
 Environment overrides:
   PSAS_PANEL_CFG   (default /opt/hiddify-manager/hiddify-panel/app.cfg)
@@ -524,10 +679,30 @@ Environment overrides:
   PSAS_MTPROXY_SERVICE (default mtproxy)
   PSAS_MTPROXY_CONF    (default /etc/psas/mtproxy.json)
   PSAS_MTPROXY_HOST    (override default host for mtproxy config output)
-  PSAS_SOCKS_SERVICE (default danted)
+  PSAS_SOCKS_BACKEND (dante|builtin; default builtin when socks-server.json exists)
+  PSAS_SOCKS_SERVICE (default danted, psas-socks for builtin)
   PSAS_SOCKS_CONF    (default /etc/danted.conf)
   PSAS_SOCKS_USERS   (default /etc/psas/socks-users.json)
   PSAS_SOCKS_HOST    (override default server host in config output)
+  PSAS_SOCKS_SERVER_CONF (default /etc/psas/socks-server.json)
+  PSAS_SOCKS_STATS   (default /var/lib/psas/socks-stats.json)
+  PSAS_SOCKS_ACL     (default /etc/psas/socks-acl.json)
+  PSAS_PLANS         (default /etc/psas/plans.json)
+  PSAS_ACCOUNTS      (default /etc/psas/accounts.json)
+  PSAS_AGENT_STATE   (default /etc/psas/agent-state.json)
+  PSAS_NOTIFY        (default /etc/psas/notify.json)
+  PSAS_NOTIFY_OUTBOX (default /etc/psas/notify-outbox.jsonl)
+  PSAS_BOT           (default /etc/psas/bot.json)
+  PSAS_BOT_TOKEN     (Telegram bot token, overrides bot.json)
+  PSAS_API_TOKEN     (REST API token, overrides the token file)
+  PSAS_API_TOKEN_FILE (default /etc/psas/api-token)
+  PSAS_WEB           (default /etc/psas/web.json)
+  PSAS_LE_LIVE       (default /etc/letsencrypt/live)
+  PSAS_HIDDIFY_SSL   (default /opt/hiddify-manager/ssl)
+  PSAS_AUDIT_LOG     (default /var/log/psas/audit.jsonl)
+  PSAS_BACKUP_DIR    (default /var/backups/psas)
+  PSAS_BACKUP_PASSPHRASE (encrypt/decrypt backups with this passphrase)
+  PSAS_SUB_BASE      (public base URL of subscription pages, e.g. https://example.com/psas-sub/)
   PSAS_UI_LANG       (force UI language: us|ru)
   PSAS_UI_LANG_FILE  (path to language settings file)
 `)
@@ -546,31 +721,8 @@ func runStatus(args []string) {
 	mainDomain := c.mainDomain()
 	cfg := c.currentConfig()
 
-	out := map[string]any{
-		"panel_loaded":      panelErr == nil,
-		"main_domain":        mainDomain,
-		"admin_url":          c.adminURL(mainDomain),
-		"client_path":        cfg["proxy_path_client"],
-		"reality_enabled":    cfg["reality_enable"],
-		"hysteria2_enabled":  cfg["hysteria_enable"],
-		"hysteria_base_port": cfg["hysteria_port"],
-		"reality_sni":        cfg["reality_server_names"],
-		"users":              len(c.state.Users),
-	}
-	if panelErr != nil {
-		out["panel_error"] = panelErr.Error()
-	}
-	if tt, err := newTrustClient().status(); err == nil {
-		out["trusttunnel"] = tt
-	}
-	if mtp, err := newMTProxyClient().status(); err == nil {
-		out["mtproxy"] = mtp
-	}
-	if sc, err := newSocksClient().status(); err == nil {
-		out["socks5"] = sc
-	}
 	if *jsonOut {
-		printJSON(out)
+		printJSON(statusSummary(c, panelErr))
 		return
 	}
 
@@ -612,6 +764,37 @@ func runStatus(args []string) {
 	}
 }
 
+// statusSummary is the `status --json` document; panelErr is the result of
+// the preceding c.loadState().
+func statusSummary(c *client, panelErr error) map[string]any {
+	mainDomain := c.mainDomain()
+	cfg := c.currentConfig()
+	out := map[string]any{
+		"panel_loaded":       panelErr == nil,
+		"main_domain":        mainDomain,
+		"admin_url":          c.adminURL(mainDomain),
+		"client_path":        cfg["proxy_path_client"],
+		"reality_enabled":    cfg["reality_enable"],
+		"hysteria2_enabled":  cfg["hysteria_enable"],
+		"hysteria_base_port": cfg["hysteria_port"],
+		"reality_sni":        cfg["reality_server_names"],
+		"users":              len(c.state.Users),
+	}
+	if panelErr != nil {
+		out["panel_error"] = panelErr.Error()
+	}
+	if tt, err := newTrustClient().status(); err == nil {
+		out["trusttunnel"] = tt
+	}
+	if mtp, err := newMTProxyClient().status(); err == nil {
+		out["mtproxy"] = mtp
+	}
+	if sc, err := newSocksClient().status(); err == nil {
+		out["socks5"] = sc
+	}
+	return out
+}
+
 func runAdminURL(args []string) {
 	if len(args) != 0 {
 		fatalf("admin-url takes no args")
@@ -638,7 +821,7 @@ func runListAlias(args []string) {
 
 func runUsers(args []string) {
 	if len(args) < 1 {
-		fatalf("users requires subcommand: list|find|add|edit|show|links|del")
+		fatalf("users requires subcommand: list|find|add|edit|show|links|del|bulk|export|import|bundle")
 	}
 	c := mustClient(true)
 
@@ -651,6 +834,10 @@ func runUsers(args []string) {
 		jsonOut := fs.Bool("json", false, "output JSON")
 		enabledOnly := fs.Bool("enabled", false, "show only enabled users")
 		nameFilter := fs.String("name", "", "name contains (case-insensitive)")
+		expiringWithin := fs.String("expiring-within", "", "only users whose package ends within N days, e.g. 7d or 48h (includes already expired)")
+		overUsage := fs.String("over-usage", "", "only users that used at least this share of their limit, e.g. 80%")
+		sortBy := fs.String("sort", "name", "sort by: name|usage|remaining|last-online")
+		desc := fs.Bool("desc", false, "reverse sort order")
 		must(fs.Parse(subArgs))
 		if len(fs.Args()) != 0 {
 			fatalf("users list takes no positional args")
@@ -658,6 +845,17 @@ func runUsers(args []string) {
 		users, err := c.usersList()
 		must(err)
 		users = filterUsers(users, *nameFilter, *enabledOnly)
+		if strings.TrimSpace(*expiringWithin) != "" {
+			days, err := parseDaysValue(*expiringWithin)
+			must(err)
+			users = filterUsersExpiringWithin(users, days)
+		}
+		if strings.TrimSpace(*overUsage) != "" {
+			pct, err := parsePercentValue(*overUsage)
+			must(err)
+			users = filterUsersOverUsage(users, pct)
+		}
+		must(sortUsers(users, *sortBy, *desc))
 		if *jsonOut {
 			printJSON(users)
 			return
@@ -683,6 +881,8 @@ func runUsers(args []string) {
 	case "show":
 		fs := flag.NewFlagSet("show", flag.ExitOnError)
 		host := fs.String("host", "", "domain for generated links")
+		qr := bindQRFlags(fs)
+		qrLink := fs.String("qr-link", "auto", "link encoded in the QR code: auto|sub64|sub|singbox|panel")
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
 		rest := fs.Args()
@@ -696,18 +896,32 @@ func runUsers(args []string) {
 			h = c.mainDomainRequired()
 		}
 		links := buildLinks(c.clientPath(), u.UUID, h)
+		qrText, err := linkSetValue(links, *qrLink)
+		must(err)
 		if *jsonOut {
-			printJSON(map[string]any{
+			out := map[string]any{
 				"user":  u,
 				"links": links,
-			})
+			}
+			if qr.requested() {
+				qrPath, err := qr.emit("", qrText, true)
+				must(err)
+				out["qr_out"] = qrPath
+			}
+			printJSON(out)
 			return
 		}
 		printUser(u)
 		printLinksFromSet(links)
+		if qr.requested() {
+			_, err := qr.emit("QR ("+*qrLink+")", qrText, false)
+			must(err)
+		}
 	case "links":
 		fs := flag.NewFlagSet("links", flag.ExitOnError)
 		host := fs.String("host", "", "domain for generated links")
+		qr := bindQRFlags(fs)
+		qrLink := fs.String("qr-link", "auto", "link encoded in the QR code: auto|sub64|sub|singbox|panel")
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
 		rest := fs.Args()
@@ -721,14 +935,26 @@ func runUsers(args []string) {
 			h = c.mainDomainRequired()
 		}
 		links := buildLinks(c.clientPath(), u.UUID, h)
+		qrText, err := linkSetValue(links, *qrLink)
+		must(err)
 		if *jsonOut {
-			printJSON(map[string]any{
+			out := map[string]any{
 				"user":  u,
 				"links": links,
-			})
+			}
+			if qr.requested() {
+				qrPath, err := qr.emit("", qrText, true)
+				must(err)
+				out["qr_out"] = qrPath
+			}
+			printJSON(out)
 			return
 		}
 		printLinksFromSet(links)
+		if qr.requested() {
+			_, err := qr.emit("QR ("+*qrLink+")", qrText, false)
+			must(err)
+		}
 	case "add":
 		fs := flag.NewFlagSet("add", flag.ExitOnError)
 		name := fs.String("name", "", "user name")
@@ -744,6 +970,7 @@ func runUsers(args []string) {
 		mode := fs.String("mode", "no_reset", "user mode: no_reset|daily|weekly|monthly")
 		host := fs.String("host", "", "domain for generated links")
 		uuid := fs.String("uuid", "", "custom UUID (optional)")
+		planName := fs.String("plan", "", "plan template from plans.json (explicit flags override it)")
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
 		if len(fs.Args()) != 0 {
@@ -754,9 +981,12 @@ func runUsers(args []string) {
 		if nameValue == "" {
 			fatalf("--name is required")
 		}
-		if !isValidMode(*mode) {
-			fatalf("invalid --mode: %s", *mode)
-		}
+		explicit := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		daysFromFlags := explicit["days"] || *unlimited || *unlimitedDays || *trueUnlimited || *trueUnlimitedDays
+		gbFromFlags := explicit["gb"] || *unlimited || *unlimitedGB || *trueUnlimited || *trueUnlimitedGB
+
+		modeValue := *mode
 		daysValue := *days
 		gbValue := *gb
 		useTrueUnlimited := *trueUnlimited || *trueUnlimitedDays || *trueUnlimitedGB
@@ -766,6 +996,24 @@ func runUsers(args []string) {
 		if *unlimited || *unlimitedGB || *trueUnlimited || *trueUnlimitedGB {
 			gbValue = unlimitedUsageGB
 		}
+		if strings.TrimSpace(*planName) != "" {
+			plan, err := loadUserPlan(*planName)
+			must(err)
+			if !daysFromFlags {
+				daysValue = plan.packageDays()
+				useTrueUnlimited = useTrueUnlimited || (plan.TrueUnlimited && plan.UnlimitedDays)
+			}
+			if !gbFromFlags {
+				gbValue = plan.usageLimitGB()
+				useTrueUnlimited = useTrueUnlimited || (plan.TrueUnlimited && plan.UnlimitedGB)
+			}
+			if !explicit["mode"] && plan.Mode != "" {
+				modeValue = plan.Mode
+			}
+		}
+		if !isValidMode(modeValue) {
+			fatalf("invalid --mode: %s", modeValue)
+		}
 		if daysValue < 1 {
 			fatalf("--days must be >= 1 (or use --unlimited/--unlimited-days/--true-unlimited-days)")
 		}
@@ -786,11 +1034,22 @@ func runUsers(args []string) {
 			"name":           nameValue,
 			"package_days":   daysValue,
 			"usage_limit_GB": gbValue,
-			"mode":           *mode,
+			"mode":           modeValue,
 			"enable":         true,
 		}
 		u, err := c.userAdd(payload)
 		must(err)
+		notify(notifyEvent{
+			Type:   notifyUserCreated,
+			Source: "hiddify",
+			User:   u.Name,
+			UUID:   u.UUID,
+			Details: map[string]any{
+				"package_days":   u.PackageDays,
+				"usage_limit_GB": u.UsageLimitGB,
+				"mode":           u.Mode,
+			},
+		})
 		h := *host
 		if h == "" {
 			h = c.mainDomainRequired()
@@ -819,6 +1078,9 @@ func runUsers(args []string) {
 		mode := fs.String("mode", "", "new user mode: no_reset|daily|weekly|monthly")
 		enableUser := fs.Bool("enable", false, "enable user")
 		disableUser := fs.Bool("disable", false, "disable user")
+		addDays := fs.Int("add-days", 0, "add N days to current package days")
+		addGB := fs.Float64("add-gb", 0, "add N GB to current usage limit")
+		resetUsage := fs.Bool("reset-usage", false, "reset consumed traffic and package start date")
 		host := fs.String("host", "", "domain for generated links")
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
@@ -829,6 +1091,11 @@ func runUsers(args []string) {
 
 		u, err := c.resolveUser(rest[0])
 		must(err)
+		if *addDays != 0 || *addGB != 0 || *resetUsage {
+			// Relative changes must be computed from fresh values, not from a cached list.
+			u, err = c.userShow(u.UUID)
+			must(err)
+		}
 
 		payload := map[string]any{}
 		changed := false
@@ -869,6 +1136,16 @@ func runUsers(args []string) {
 			hasDays = true
 			daysValue = unlimitedPackageDays
 		}
+		if *addDays != 0 {
+			if hasDays {
+				fatalf("--add-days cannot be combined with --days/--unlimited*/--true-unlimited*")
+			}
+			if isUnlimitedDays(u) {
+				fatalf("--add-days: user already has unlimited package days")
+			}
+			hasDays = true
+			daysValue = u.PackageDays + *addDays
+		}
 		if hasDays {
 			if daysValue < 1 {
 				fatalf("--days must be >= 1 (or use --unlimited/--unlimited-days/--true-unlimited-days)")
@@ -883,6 +1160,16 @@ func runUsers(args []string) {
 			hasGB = true
 			gbValue = unlimitedUsageGB
 		}
+		if *addGB != 0 {
+			if hasGB {
+				fatalf("--add-gb cannot be combined with --gb/--unlimited*/--true-unlimited*")
+			}
+			if isUnlimitedUsage(u) {
+				fatalf("--add-gb: user already has unlimited traffic")
+			}
+			hasGB = true
+			gbValue = u.UsageLimitGB + *addGB
+		}
 		if hasGB {
 			if gbValue <= 0 {
 				fatalf("--gb must be > 0 (or use --unlimited/--unlimited-gb/--true-unlimited-gb)")
@@ -891,6 +1178,13 @@ func runUsers(args []string) {
 			changed = true
 		}
 
+		if *resetUsage {
+			for k, v := range userResetPayload() {
+				payload[k] = v
+			}
+			changed = true
+		}
+
 		if !changed {
 			fatalf("users edit: no changes requested; pass at least one edit flag")
 		}
@@ -898,8 +1192,16 @@ func runUsers(args []string) {
 			must(c.ensureTrueUnlimitedSupport())
 		}
 
-		updated, err := c.userPatch(u.UUID, payload)
+		updated, err := c.userPatch(u, payload)
 		must(err)
+		changes := userEditChanges(u, updated)
+		notify(notifyEvent{
+			Type:    notifyUserUpdated,
+			Source:  "hiddify",
+			User:    updated.Name,
+			UUID:    updated.UUID,
+			Details: map[string]any{"changes": changes},
+		})
 
 		h := strings.TrimSpace(*host)
 		if h == "" {
@@ -908,11 +1210,14 @@ func runUsers(args []string) {
 		links := buildLinks(c.clientPath(), updated.UUID, h)
 		if *jsonOut {
 			printJSON(map[string]any{
-				"user":  updated,
-				"links": links,
+				"user_before": u,
+				"user":        updated,
+				"changes":     changes,
+				"links":       links,
 			})
 			return
 		}
+		printUserEditChanges(changes)
 		printUser(updated)
 		printLinksFromSet(links)
 	case "del", "delete", "rm":
@@ -921,8 +1226,17 @@ func runUsers(args []string) {
 		}
 		u, err := c.resolveUser(subArgs[0])
 		must(err)
-		must(c.userDelete(u.UUID))
+		must(c.userDelete(u))
+		notify(notifyEvent{Type: notifyUserDeleted, Source: "hiddify", User: u.Name, UUID: u.UUID})
 		fmt.Printf("Deleted: %s (%s)\n", u.UUID, u.Name)
+	case "bulk":
+		runUsersBulk(c, subArgs)
+	case "export":
+		runUsersExport(c, subArgs)
+	case "import":
+		runUsersImport(c, subArgs)
+	case "bundle":
+		runUsersBundle(c, subArgs)
 	default:
 		fatalf("unknown users subcommand: %s", sub)
 	}
@@ -1060,6 +1374,7 @@ func runMTProxy(args []string) {
 		server := fs.String("server", "", "server host/ip for generated links")
 		port := fs.Int("port", 0, "server port for generated links")
 		secret := fs.String("secret", "", "secret override (HEX32)")
+		qr := bindQRFlags(fs)
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
 		if len(fs.Args()) != 0 {
@@ -1068,10 +1383,20 @@ func runMTProxy(args []string) {
 		cfg, err := mp.connectionInfo(strings.TrimSpace(*server), *port, strings.TrimSpace(*secret))
 		must(err)
 		if *jsonOut {
+			if qr.requested() {
+				qrPath, err := qr.emit("", cfg.ShareURL, true)
+				must(err)
+				printJSON(map[string]any{"config": cfg, "qr_out": qrPath})
+				return
+			}
 			printJSON(cfg)
 			return
 		}
 		printMTProxyConnInfo(cfg)
+		if qr.requested() {
+			_, err := qr.emit("QR (Share URL)", cfg.ShareURL, false)
+			must(err)
+		}
 	case "secret":
 		runMTProxySecret(mp, subArgs)
 	case "service", "svc":
@@ -1127,6 +1452,12 @@ func runMTProxySecret(mp *mtproxyClient, args []string) {
 		cfg.Secret = secret
 		must(mp.writeConfig(cfg))
 		restartWarn := mtproxyRestartWarning(mp.service, mp.restartService())
+		notify(notifyEvent{
+			Type:    notifySecretChanged,
+			Source:  "mtproxy",
+			Message: "MTProxy secret updated",
+			Details: map[string]any{"secret_masked": maskSecret(cfg.Secret)},
+		})
 
 		resp := map[string]any{
 			"secret":        cfg.Secret,
@@ -1158,6 +1489,12 @@ func runMTProxySecret(mp *mtproxyClient, args []string) {
 		cfg.Secret = newHexToken(16)
 		must(mp.writeConfig(cfg))
 		restartWarn := mtproxyRestartWarning(mp.service, mp.restartService())
+		notify(notifyEvent{
+			Type:    notifySecretChanged,
+			Source:  "mtproxy",
+			Message: "MTProxy secret regenerated",
+			Details: map[string]any{"secret_masked": maskSecret(cfg.Secret)},
+		})
 
 		resp := map[string]any{
 			"secret":        cfg.Secret,
@@ -1189,7 +1526,7 @@ func runMTProxyService(mp *mtproxyClient, args []string) {
 	case "status":
 		must(runCommand("systemctl", "--no-pager", "--full", "status", mp.service))
 	case "start", "stop", "restart":
-		must(runCommand("systemctl", action, mp.service))
+		must(runServiceAction(action, mp.service))
 		fmt.Printf("MTProxy service %s: %s\n", action, mp.service)
 	default:
 		fatalf("unknown mtproxy service action: %s (expected status|start|stop|restart)", action)
@@ -1218,7 +1555,7 @@ func runMTProxyUI(args []string) {
 
 func runSocks(args []string) {
 	if len(args) < 1 {
-		fatalf("socks requires subcommand: status|users|service|ui")
+		fatalf("socks requires subcommand: status|users|stats|config|acl|service|ui")
 	}
 
 	sc := newSocksClient()
@@ -1242,6 +1579,12 @@ func runSocks(args []string) {
 		printSocksStatus(st)
 	case "users", "user", "u":
 		runSocksUsers(sc, subArgs)
+	case "stats":
+		runSocksStats(sc, subArgs)
+	case "config", "conf":
+		runSocksConfig(sc, subArgs)
+	case "acl":
+		runSocksACL(sc, subArgs)
 	case "service", "svc":
 		runSocksService(sc, subArgs)
 	case "ui", "menu", "interactive":
@@ -1253,7 +1596,7 @@ func runSocks(args []string) {
 
 func runSocksUsers(sc *socksClient, args []string) {
 	if len(args) < 1 {
-		fatalf("socks users requires subcommand: list|add|edit|show|config|del")
+		fatalf("socks users requires subcommand: list|add|edit|show|config|acl|del|sync")
 	}
 
 	sub := strings.ToLower(strings.TrimSpace(args[0]))
@@ -1298,7 +1641,7 @@ func runSocksUsers(sc *socksClient, args []string) {
 		if hasSocksUserExact(users, login) {
 			fatalf("socks user already exists: %s", login)
 		}
-		if osSocksUserExists(login) {
+		if sc.linuxUserExists(login) {
 			fatalf("linux user already exists: %s", login)
 		}
 
@@ -1307,9 +1650,8 @@ func runSocksUsers(sc *socksClient, args []string) {
 			pass = newSecureToken(24)
 		}
 
-		must(sc.ensureLinuxUser(login, pass))
-		users = append(users, socksUser{Name: login, Password: pass, SystemUser: login})
-		must(sc.writeUsers(users))
+		must(sc.addUser(users, socksUser{Name: login, Password: pass, SystemUser: login}))
+		notify(notifyEvent{Type: notifyUserCreated, Source: "socks", User: login})
 
 		resp := map[string]any{
 			"user": map[string]any{
@@ -1358,7 +1700,6 @@ func runSocksUsers(sc *socksClient, args []string) {
 		target := current
 		newName := normalizeSocksLogin(*name)
 		newPass := strings.TrimSpace(*password)
-		oldSystemUser := socksSystemUser(current)
 
 		if newName == "" && newPass == "" {
 			fatalf("socks users edit: no changes requested")
@@ -1375,20 +1716,22 @@ func runSocksUsers(sc *socksClient, args []string) {
 					fatalf("socks user already exists: %s", newName)
 				}
 			}
-			if osSocksUserExists(newName) {
+			if sc.linuxUserExists(newName) {
 				fatalf("linux user already exists: %s", newName)
 			}
-			must(runCommand("usermod", "-l", newName, oldSystemUser))
 			target.Name = newName
 			target.SystemUser = newName
 		}
 		if newPass != "" {
-			must(sc.setLinuxUserPassword(socksSystemUser(target), newPass))
 			target.Password = newPass
 		}
-
-		users[idx] = target
-		must(sc.writeUsers(users))
+		must(sc.editUser(users, idx, target))
+		notify(notifyEvent{
+			Type:    notifyUserUpdated,
+			Source:  "socks",
+			User:    target.Name,
+			Details: map[string]any{"old_name": current.Name, "password_changed": newPass != ""},
+		})
 
 		if *jsonOut {
 			printJSON(map[string]any{
@@ -1442,6 +1785,7 @@ func runSocksUsers(sc *socksClient, args []string) {
 		server := fs.String("server", "", "server host/ip for generated config")
 		port := fs.Int("port", 0, "server port for generated config")
 		outPath := fs.String("out", "", "write socks config to file")
+		qr := bindQRFlags(fs)
 		jsonOut := fs.Bool("json", false, "output JSON")
 		must(fs.Parse(subArgs))
 		rest := fs.Args()
@@ -1461,17 +1805,27 @@ func runSocksUsers(sc *socksClient, args []string) {
 		}
 
 		if *jsonOut {
-			printJSON(map[string]any{
+			out := map[string]any{
 				"user":   u,
 				"config": cfg,
 				"out":    strings.TrimSpace(*outPath),
-			})
+			}
+			if qr.requested() {
+				qrPath, err := qr.emit("", cfg.URI, true)
+				must(err)
+				out["qr_out"] = qrPath
+			}
+			printJSON(out)
 			return
 		}
 		printSocksConnInfo(cfg)
 		if p := strings.TrimSpace(*outPath); p != "" {
 			fmt.Printf("Saved to: %s\n", p)
 		}
+		if qr.requested() {
+			_, err := qr.emit("QR (URI)", cfg.URI, false)
+			must(err)
+		}
 	case "del", "delete", "rm":
 		if len(subArgs) != 1 {
 			fatalf("socks users del requires USER_ID")
@@ -1482,19 +1836,13 @@ func runSocksUsers(sc *socksClient, args []string) {
 		must(err)
 		u, idx, err := resolveSocksUser(users, subArgs[0])
 		must(err)
-		next := make([]socksUser, 0, len(users)-1)
-		next = append(next, users[:idx]...)
-		next = append(next, users[idx+1:]...)
-		must(sc.writeUsers(next))
-
-		warn := ""
-		if err := sc.deleteLinuxUser(socksSystemUser(u)); err != nil {
-			warn = err.Error()
-		}
+		must(sc.deleteUser(users, idx))
+		notify(notifyEvent{Type: notifyUserDeleted, Source: "socks", User: u.Name})
 		fmt.Printf("SOCKS user deleted: %s\n", u.Name)
-		if warn != "" {
-			fmt.Printf("Warning: %s\n", warn)
-		}
+	case "sync":
+		runSocksUsersSync(sc, subArgs)
+	case "acl":
+		runSocksUsersACL(sc, subArgs)
 	default:
 		fatalf("unknown socks users subcommand: %s", sub)
 	}
@@ -1509,7 +1857,7 @@ func runSocksService(sc *socksClient, args []string) {
 	case "status":
 		must(runCommand("systemctl", "--no-pager", "--full", "status", sc.service))
 	case "start", "stop", "restart":
-		must(runCommand("systemctl", action, sc.service))
+		must(runServiceAction(action, sc.service))
 		fmt.Printf("SOCKS service %s: %s\n", action, sc.service)
 	default:
 		fatalf("unknown socks service action: %s (expected status|start|stop|restart)", action)
@@ -1615,6 +1963,7 @@ func runTrustUsers(tt *trustClient, args []string) {
 		users = append(users, trustUser{Username: username, Password: pass})
 		must(tt.writeUsers(users))
 		restartWarn := trustRestartWarning(tt.service, tt.restartService())
+		notify(notifyEvent{Type: notifyUserCreated, Source: "trust", User: username})
 
 		resp := map[string]any{
 			"user": map[string]any{
@@ -1692,6 +2041,12 @@ func runTrustUsers(tt *trustClient, args []string) {
 
 		must(tt.writeUsers(users))
 		restartWarn := trustRestartWarning(tt.service, tt.restartService())
+		notify(notifyEvent{
+			Type:    notifyUserUpdated,
+			Source:  "trust",
+			User:    users[idx].Username,
+			Details: map[string]any{"old_name": current.Username, "password_changed": newPassword != ""},
+		})
 
 		out := map[string]any{
 			"before": current,
@@ -1801,6 +2156,7 @@ func runTrustUsers(tt *trustClient, args []string) {
 		next = append(next, users[idx+1:]...)
 		must(tt.writeUsers(next))
 		restartWarn := trustRestartWarning(tt.service, tt.restartService())
+		notify(notifyEvent{Type: notifyUserDeleted, Source: "trust", User: u.Username})
 
 		fmt.Printf("TrustTunnel user deleted: %s\n", u.Username)
 		if restartWarn != "" {
@@ -1820,7 +2176,7 @@ func runTrustService(tt *trustClient, args []string) {
 	case "status":
 		must(runCommand("systemctl", "--no-pager", "--full", "status", tt.service))
 	case "start", "stop", "restart":
-		must(runCommand("systemctl", action, tt.service))
+		must(runServiceAction(action, tt.service))
 		fmt.Printf("TrustTunnel service %s: %s\n", action, tt.service)
 	default:
 		fatalf("unknown trust service action: %s (expected status|start|stop|restart)", action)
@@ -1883,6 +2239,7 @@ func printTrustUser(u trustUser) {
 }
 
 func printSocksStatus(st socksStatus) {
+	fmt.Printf("%s: %s\n", uiText("Backend"), st.Backend)
 	fmt.Printf("%s: %t\n", uiText("SOCKS installed"), st.Installed)
 	fmt.Printf("%s: %s (active=%t)\n", uiText("Service"), st.Service, st.ServiceActive)
 	fmt.Printf("%s: %s\n", uiText("Config"), st.ConfigPath)
@@ -1907,6 +2264,10 @@ func printSocksUser(u socksUser) {
 	fmt.Println("==========")
 	fmt.Printf("%s: %s\n", uiText("Login"), u.Name)
 	fmt.Printf("%s: %s\n", uiText("Password"), u.Password)
+	if len(u.Allow) > 0 || len(u.Deny) > 0 {
+		fmt.Printf("%s: %s\n", uiText("Allowed destinations"), socksACLText(u.Allow))
+		fmt.Printf("%s: %s\n", uiText("Denied destinations"), socksACLText(u.Deny))
+	}
 }
 
 func renderSocksConnInfo(cfg socksConnInfo) string {
@@ -2084,15 +2445,18 @@ func applyWithClient(c *client) error {
 	}
 	if fileExists("/usr/local/bin/hiddify-apply-safe") {
 		if err := runCommand("/usr/local/bin/hiddify-apply-safe", mainDomain); err != nil {
+			notify(notifyEvent{Type: notifyApplyFailed, Source: "hiddify", Message: "Hiddify apply failed: " + err.Error()})
 			return err
 		}
 		fmt.Println("Applied with hiddify-apply-safe")
 	} else {
 		if err := runCommand("/opt/hiddify-manager/common/commander.py", "apply"); err != nil {
+			notify(notifyEvent{Type: notifyApplyFailed, Source: "hiddify", Message: "Hiddify apply failed: " + err.Error()})
 			return err
 		}
 		fmt.Println("Applied with /opt/hiddify-manager/common/commander.py apply")
 	}
+	notify(notifyEvent{Type: notifyConfigApplied, Source: "hiddify", Message: "Hiddify config applied", Details: map[string]any{"domain": mainDomain}})
 
 	// Best-effort: Hiddify apply may stop MTProxy via /opt/hiddify-manager/other/telegram/disable.sh
 	// even when MTProxy is managed separately by PSAS.
@@ -2100,7 +2464,7 @@ func applyWithClient(c *client) error {
 	if fileExists(mp.config) {
 		active, err := mp.serviceIsActive()
 		if err == nil && !active {
-			if err := runCommand("systemctl", "start", mp.service); err != nil {
+			if err := runServiceAction("start", mp.service); err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: unable to start MTProxy service (%s): %v\n", mp.service, err)
 			} else {
 				fmt.Printf("MTProxy service started: %s\n", mp.service)
@@ -2617,11 +2981,11 @@ func uiRunFlagWizard(c *client, in *bufio.Reader) error {
 	options := []uiOption{
 		{Value: "status", Title: "status", Hint: "Supports --json"},
 		{Value: "admin-url", Title: "admin-url", Hint: "Print admin panel URL"},
-		{Value: "users-list", Title: "users list", Hint: "Supports --name, --enabled, --json"},
+		{Value: "users-list", Title: "users list", Hint: "Supports --name, --enabled, --expiring-within, --over-usage, --sort, --json"},
 		{Value: "users-find", Title: "users find", Hint: "Supports --enabled, --json + QUERY"},
 		{Value: "users-show", Title: "users show", Hint: "Supports --host, --json + USER_ID"},
 		{Value: "users-links", Title: "users links", Hint: "Supports --host, --json + USER_ID"},
-		{Value: "users-add", Title: "users add", Hint: "Supports --name, --days, --gb, --unlimited*, --true-unlimited*, --mode, --host, --uuid, --json"},
+		{Value: "users-add", Title: "users add", Hint: "Supports --name, --plan, --days, --gb, --unlimited*, --true-unlimited*, --mode, --host, --uuid, --json"},
 		{Value: "users-del", Title: "users del", Hint: "Delete by USER_ID"},
 		{Value: "config-get", Title: "config get", Hint: "Get config by key"},
 		{Value: "config-set", Title: "config set", Hint: "Set config key/value"},
@@ -2696,6 +3060,23 @@ func uiBuildWizardArgs(c *client, choice string, in *bufio.Reader) ([]string, er
 		if err != nil {
 			return nil, err
 		}
+		expiring, err := promptLine(in, "Expiring within, e.g. 7d (--expiring-within, optional)", "")
+		if err != nil {
+			return nil, err
+		}
+		overUsage, err := promptLine(in, "Used at least, e.g. 80% (--over-usage, optional)", "")
+		if err != nil {
+			return nil, err
+		}
+		sortBy, err := uiSelectOptionValue("Sort users by (--sort)", []uiOption{
+			{Value: "name", Title: "name", Hint: "Alphabetical order"},
+			{Value: "usage", Title: "usage", Hint: "Share of traffic limit used"},
+			{Value: "remaining", Title: "remaining", Hint: "Days left in package"},
+			{Value: "last-online", Title: "last-online", Hint: "Last connection time"},
+		}, 0, in)
+		if err != nil {
+			return nil, err
+		}
 		jsonOut, err := promptYesNo(in, "Use --json output?", false)
 		if err != nil {
 			return nil, err
@@ -2707,6 +3088,15 @@ func uiBuildWizardArgs(c *client, choice string, in *bufio.Reader) ([]string, er
 		if enabledOnly {
 			args = append(args, "--enabled")
 		}
+		if v := strings.TrimSpace(expiring); v != "" {
+			args = append(args, "--expiring-within", v)
+		}
+		if v := strings.TrimSpace(overUsage); v != "" {
+			args = append(args, "--over-usage", v)
+		}
+		if sortBy != "name" {
+			args = append(args, "--sort", sortBy)
+		}
 		if jsonOut {
 			args = append(args, "--json")
 		}
@@ -2788,47 +3178,56 @@ func uiBuildWizardArgs(c *client, choice string, in *bufio.Reader) ([]string, er
 		if err != nil {
 			return nil, err
 		}
-		trueUnlimitedAll, err := promptYesNo(in, "True unlimited traffic + time? (--true-unlimited)", false)
+		plan, err := promptLine(in, "Plan (--plan, optional; empty = set limits manually)", "")
 		if err != nil {
 			return nil, err
 		}
+		plan = strings.TrimSpace(plan)
+		trueUnlimitedAll := false
 		unlimitedAll := false
-		if !trueUnlimitedAll {
-			unlimitedAll, err = promptYesNo(in, "Unlimited traffic + time? (--unlimited)", false)
-			if err != nil {
-				return nil, err
-			}
-		}
 		useUnlimitedDays := false
 		useUnlimitedGB := false
 		days := 30
 		gb := 100.0
-		if !unlimitedAll && !trueUnlimitedAll {
-			useUnlimitedDays, err = promptYesNo(in, fmt.Sprintf("Unlimited package time? (--unlimited-days = %d days)", unlimitedPackageDays), false)
+		mode := ""
+		if plan == "" {
+			trueUnlimitedAll, err = promptYesNo(in, "True unlimited traffic + time? (--true-unlimited)", false)
 			if err != nil {
 				return nil, err
 			}
-			if !useUnlimitedDays {
-				days, err = promptPositiveIntValue(in, "Package days (--days)", 30)
+			if !trueUnlimitedAll {
+				unlimitedAll, err = promptYesNo(in, "Unlimited traffic + time? (--unlimited)", false)
 				if err != nil {
 					return nil, err
 				}
 			}
+			if !unlimitedAll && !trueUnlimitedAll {
+				useUnlimitedDays, err = promptYesNo(in, fmt.Sprintf("Unlimited package time? (--unlimited-days = %d days)", unlimitedPackageDays), false)
+				if err != nil {
+					return nil, err
+				}
+				if !useUnlimitedDays {
+					days, err = promptPositiveIntValue(in, "Package days (--days)", 30)
+					if err != nil {
+						return nil, err
+					}
+				}
 
-			useUnlimitedGB, err = promptYesNo(in, fmt.Sprintf("Unlimited traffic? (--unlimited-gb = %.0f GB)", unlimitedUsageGB), false)
-			if err != nil {
-				return nil, err
-			}
-			if !useUnlimitedGB {
-				gb, err = promptPositiveFloatValue(in, "Usage limit GB (--gb)", 100)
+				useUnlimitedGB, err = promptYesNo(in, fmt.Sprintf("Unlimited traffic? (--unlimited-gb = %.0f GB)", unlimitedUsageGB), false)
 				if err != nil {
 					return nil, err
 				}
+				if !useUnlimitedGB {
+					gb, err = promptPositiveFloatValue(in, "Usage limit GB (--gb)", 100)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			mode, err = uiSelectMode(in)
+			if err != nil {
+				return nil, err
 			}
-		}
-		mode, err := uiSelectMode(in)
-		if err != nil {
-			return nil, err
 		}
 		host, err := promptLine(in, "Host for links (--host, optional)", "")
 		if err != nil {
@@ -2845,22 +3244,26 @@ func uiBuildWizardArgs(c *client, choice string, in *bufio.Reader) ([]string, er
 		args := []string{
 			"users", "add",
 			"--name", name,
-			"--mode", mode,
 		}
-		if trueUnlimitedAll {
-			args = append(args, "--true-unlimited")
-		} else if unlimitedAll {
-			args = append(args, "--unlimited")
+		if plan != "" {
+			args = append(args, "--plan", plan)
 		} else {
-			if useUnlimitedDays {
-				args = append(args, "--unlimited-days")
-			} else {
-				args = append(args, "--days", strconv.Itoa(days))
-			}
-			if useUnlimitedGB {
-				args = append(args, "--unlimited-gb")
+			args = append(args, "--mode", mode)
+			if trueUnlimitedAll {
+				args = append(args, "--true-unlimited")
+			} else if unlimitedAll {
+				args = append(args, "--unlimited")
 			} else {
-				args = append(args, "--gb", strconv.FormatFloat(gb, 'f', -1, 64))
+				if useUnlimitedDays {
+					args = append(args, "--unlimited-days")
+				} else {
+					args = append(args, "--days", strconv.Itoa(days))
+				}
+				if useUnlimitedGB {
+					args = append(args, "--unlimited-gb")
+				} else {
+					args = append(args, "--gb", strconv.FormatFloat(gb, 'f', -1, 64))
+				}
 			}
 		}
 		if strings.TrimSpace(host) != "" {
@@ -4189,7 +4592,11 @@ func uiShowUser(c *client, in *bufio.Reader) error {
 	printUser(u)
 	fmt.Println()
 	printLinksFromSet(links)
-	return nil
+	return uiOfferQR(in, []uiOption{
+		{Value: links.Auto, Title: "Hiddify (auto)"},
+		{Value: links.Sub64, Title: "Subscription b64"},
+		{Value: links.Singbox, Title: "Sing-box"},
+	})
 }
 
 func uiAddUser(c *client, in *bufio.Reader) error {
@@ -4202,62 +4609,35 @@ func uiAddUser(c *client, in *bufio.Reader) error {
 		return err
 	}
 
-	trueUnlimitedAll, err := promptYesNo(in, "True unlimited traffic + time? (patches Hiddify once)", false)
+	days, gb, mode := 30, 100.0, "no_reset"
+	needsTrueUnlimitedPatch := false
+	plan, hasPlan, err := uiSelectPlan(in)
 	if err != nil {
 		return err
 	}
-	needsTrueUnlimitedPatch := false
-	unlimitedAll := false
-	days := 30
-	gb := 100.0
-	if trueUnlimitedAll {
-		days = unlimitedPackageDays
-		gb = unlimitedUsageGB
-		needsTrueUnlimitedPatch = true
-	} else {
-		unlimitedAll, err = promptYesNo(in, "Unlimited traffic + time?", false)
+	overridePlan := false
+	if hasPlan {
+		days, gb, needsTrueUnlimitedPatch = plan.packageDays(), plan.usageLimitGB(), plan.needsTrueUnlimited()
+		if plan.Mode != "" {
+			mode = plan.Mode
+		}
+		fmt.Println(uiTextf("Plan %s: %s", plan.Name, plan.summary()))
+		overridePlan, err = promptYesNo(in, "Override plan limits?", false)
 		if err != nil {
 			return err
 		}
 	}
-	if trueUnlimitedAll {
-		// already set above
-	} else if unlimitedAll {
-		days = unlimitedPackageDays
-		gb = unlimitedUsageGB
-	} else {
-		useUnlimitedDays, derr := promptYesNo(in, fmt.Sprintf("Unlimited package time? (%d days)", unlimitedPackageDays), false)
-		if derr != nil {
-			return derr
-		}
-		if useUnlimitedDays {
-			days = unlimitedPackageDays
-		} else {
-			days, derr = promptPositiveIntValue(in, "Package days", 30)
-			if derr != nil {
-				return derr
-			}
-		}
-
-		useUnlimitedGB, gerr := promptYesNo(in, fmt.Sprintf("Unlimited traffic? (%.0f GB)", unlimitedUsageGB), false)
-		if gerr != nil {
-			return gerr
+	if !hasPlan || overridePlan {
+		days, gb, needsTrueUnlimitedPatch, err = uiPromptUserLimits(in)
+		if err != nil {
+			return err
 		}
-		if useUnlimitedGB {
-			gb = unlimitedUsageGB
-		} else {
-			gb, gerr = promptPositiveFloatValue(in, "Usage limit (GB)", 100)
-			if gerr != nil {
-				return gerr
-			}
+		mode, err = uiSelectMode(in)
+		if err != nil {
+			return err
 		}
 	}
 
-	mode, err := uiSelectMode(in)
-	if err != nil {
-		return err
-	}
-
 	id, err := promptUUIDOrAuto(in, "Custom UUID (empty = auto)")
 	if err != nil {
 		return err
@@ -4298,6 +4678,62 @@ func uiAddUser(c *client, in *bufio.Reader) error {
 	return nil
 }
 
+// uiPromptUserLimits asks for package time and traffic limits; the bool result
+// reports whether the true-unlimited Hiddify patch is required.
+func uiPromptUserLimits(in *bufio.Reader) (int, float64, bool, error) {
+	trueUnlimitedAll, err := promptYesNo(in, "True unlimited traffic + time? (patches Hiddify once)", false)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	needsTrueUnlimitedPatch := false
+	unlimitedAll := false
+	days := 30
+	gb := 100.0
+	if trueUnlimitedAll {
+		days = unlimitedPackageDays
+		gb = unlimitedUsageGB
+		needsTrueUnlimitedPatch = true
+	} else {
+		unlimitedAll, err = promptYesNo(in, "Unlimited traffic + time?", false)
+		if err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if trueUnlimitedAll {
+		// already set above
+	} else if unlimitedAll {
+		days = unlimitedPackageDays
+		gb = unlimitedUsageGB
+	} else {
+		useUnlimitedDays, derr := promptYesNo(in, fmt.Sprintf("Unlimited package time? (%d days)", unlimitedPackageDays), false)
+		if derr != nil {
+			return 0, 0, false, derr
+		}
+		if useUnlimitedDays {
+			days = unlimitedPackageDays
+		} else {
+			days, derr = promptPositiveIntValue(in, "Package days", 30)
+			if derr != nil {
+				return 0, 0, false, derr
+			}
+		}
+
+		useUnlimitedGB, gerr := promptYesNo(in, fmt.Sprintf("Unlimited traffic? (%.0f GB)", unlimitedUsageGB), false)
+		if gerr != nil {
+			return 0, 0, false, gerr
+		}
+		if useUnlimitedGB {
+			gb = unlimitedUsageGB
+		} else {
+			gb, gerr = promptPositiveFloatValue(in, "Usage limit (GB)", 100)
+			if gerr != nil {
+				return 0, 0, false, gerr
+			}
+		}
+	}
+	return days, gb, needsTrueUnlimitedPatch, nil
+}
+
 func uiEditUser(c *client, in *bufio.Reader) error {
 	if err := c.loadState(); err != nil {
 		return err
@@ -4349,6 +4785,7 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 				daysAction, derr := uiSelectOptionValue("Package days", []uiOption{
 					{Value: "keep", Title: fmt.Sprintf("Keep (%d days)", u.PackageDays), Hint: "Do not change package days"},
 					{Value: "set", Title: "Set custom days", Hint: "Enter a specific positive number of days"},
+					{Value: "add", Title: "Add days", Hint: "Extend current package by N days"},
 					{Value: "unlimited", Title: fmt.Sprintf("Practical unlimited (%d days)", unlimitedPackageDays), Hint: "Set to large value"},
 					{Value: "true-unlimited", Title: "True unlimited days", Hint: "Set unlimited logic in patched Hiddify"},
 				}, 0, in)
@@ -4363,6 +4800,13 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 					}
 					payload["package_days"] = days
 					changed = true
+				case "add":
+					n, perr := promptPositiveIntValue(in, "Days to add", 30)
+					if perr != nil {
+						return perr
+					}
+					payload["package_days"] = u.PackageDays + n
+					changed = true
 				case "unlimited":
 					payload["package_days"] = unlimitedPackageDays
 					changed = true
@@ -4375,6 +4819,7 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 				gbAction, gerr := uiSelectOptionValue("Traffic limit", []uiOption{
 					{Value: "keep", Title: fmt.Sprintf("Keep (%.2f GB)", u.UsageLimitGB), Hint: "Do not change usage limit"},
 					{Value: "set", Title: "Set custom GB", Hint: "Enter a specific positive traffic limit"},
+					{Value: "add", Title: "Add GB", Hint: "Increase current traffic limit by N GB"},
 					{Value: "unlimited", Title: fmt.Sprintf("Practical unlimited (%.0f GB)", unlimitedUsageGB), Hint: "Set to large value"},
 					{Value: "true-unlimited", Title: "True unlimited traffic", Hint: "Set unlimited logic in patched Hiddify"},
 				}, 0, in)
@@ -4393,6 +4838,13 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 					}
 					payload["usage_limit_GB"] = gb
 					changed = true
+				case "add":
+					n, perr := promptPositiveFloatValue(in, "GB to add", 100)
+					if perr != nil {
+						return perr
+					}
+					payload["usage_limit_GB"] = u.UsageLimitGB + n
+					changed = true
 				case "unlimited":
 					payload["usage_limit_GB"] = unlimitedUsageGB
 					changed = true
@@ -4405,6 +4857,17 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 		}
 	}
 
+	resetUsage, rerr := promptYesNo(in, fmt.Sprintf("Reset used traffic and start date? (used %.2f GB)", u.CurrentUsageGB), false)
+	if rerr != nil {
+		return rerr
+	}
+	if resetUsage {
+		for k, v := range userResetPayload() {
+			payload[k] = v
+		}
+		changed = true
+	}
+
 	modeChoice, merr := uiSelectOptionValue("User mode", []uiOption{
 		{Value: "keep", Title: fmt.Sprintf("Keep (%s)", u.Mode), Hint: "Do not change user mode"},
 		{Value: "no_reset", Title: "no_reset", Hint: "No periodic reset"},
@@ -4452,7 +4915,7 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 		}
 	}
 
-	updated, err := c.userPatch(u.UUID, payload)
+	updated, err := c.userPatch(u, payload)
 	if err != nil {
 		return err
 	}
@@ -4471,6 +4934,7 @@ func uiEditUser(c *client, in *bufio.Reader) error {
 
 	links := buildLinks(c.clientPath(), updated.UUID, host)
 	fmt.Println("\nUser updated successfully!")
+	printUserEditChanges(userEditChanges(u, updated))
 	printUser(updated)
 	fmt.Println()
 	printLinksFromSet(links)
@@ -4616,7 +5080,7 @@ func uiDeleteUser(c *client, in *bufio.Reader) error {
 		fmt.Println(uiText("Canceled."))
 		return nil
 	}
-	if err := c.userDelete(u.UUID); err != nil {
+	if err := c.userDelete(u); err != nil {
 		return err
 	}
 	fmt.Printf("\nDeleted: %s (%s)\n", u.UUID, u.Name)
@@ -4711,7 +5175,7 @@ func uiMTProxyShowConfig(mp *mtproxyClient, in *bufio.Reader) error {
 	}
 	fmt.Println()
 	printMTProxyConnInfo(cfg)
-	return nil
+	return uiOfferQR(in, []uiOption{{Value: cfg.ShareURL, Title: "Share URL"}})
 }
 
 func uiMTProxySetSecret(mp *mtproxyClient, in *bufio.Reader) error {
@@ -4780,7 +5244,7 @@ func uiMTProxyService(mp *mtproxyClient, in *bufio.Reader) error {
 	case "status":
 		return runCommand("systemctl", "--no-pager", "--full", "status", mp.service)
 	case "start", "stop", "restart":
-		if err := runCommand("systemctl", action, mp.service); err != nil {
+		if err := runServiceAction(action, mp.service); err != nil {
 			return err
 		}
 		fmt.Printf("MTProxy service %s: %s\n", action, mp.service)
@@ -4891,7 +5355,7 @@ func uiSocksAddUser(sc *socksClient, in *bufio.Reader) error {
 	if hasSocksUserExact(users, login) {
 		return fmt.Errorf(uiTextf("socks user already exists: %s", login))
 	}
-	if osSocksUserExists(login) {
+	if sc.linuxUserExists(login) {
 		return fmt.Errorf(uiTextf("linux user already exists: %s", login))
 	}
 
@@ -4904,12 +5368,8 @@ func uiSocksAddUser(sc *socksClient, in *bufio.Reader) error {
 		password = newSecureToken(24)
 	}
 
-	if err := sc.ensureLinuxUser(login, password); err != nil {
-		return err
-	}
 	u := socksUser{Name: login, Password: password, SystemUser: login}
-	users = append(users, u)
-	if err := sc.writeUsers(users); err != nil {
+	if err := sc.addUser(users, u); err != nil {
 		return err
 	}
 
@@ -4949,7 +5409,7 @@ func uiSocksPrintConn(sc *socksClient, in *bufio.Reader, u socksUser) error {
 	}
 	fmt.Println()
 	printSocksConnInfo(cfg)
-	return nil
+	return uiOfferQR(in, []uiOption{{Value: cfg.URI, Title: "URI"}})
 }
 
 func uiSocksEditUser(sc *socksClient, in *bufio.Reader) error {
@@ -4968,6 +5428,8 @@ func uiSocksEditUser(sc *socksClient, in *bufio.Reader) error {
 	if idx < 0 {
 		return fmt.Errorf(uiTextf("selected user not found: %s", current.Name))
 	}
+	current = users[idx]
+	target := current
 
 	newName, err := promptLine(in, uiTextf("New login (empty = keep: %s)", current.Name), "")
 	if err != nil {
@@ -4986,14 +5448,11 @@ func uiSocksEditUser(sc *socksClient, in *bufio.Reader) error {
 				return fmt.Errorf(uiTextf("socks user already exists: %s", newName))
 			}
 		}
-		if osSocksUserExists(newName) {
+		if sc.linuxUserExists(newName) {
 			return fmt.Errorf(uiTextf("linux user already exists: %s", newName))
 		}
-		if err := runCommand("usermod", "-l", newName, socksSystemUser(current)); err != nil {
-			return err
-		}
-		users[idx].Name = newName
-		users[idx].SystemUser = newName
+		target.Name = newName
+		target.SystemUser = newName
 	}
 
 	newPassword, err := promptLine(in, "New password (empty = keep current)", "")
@@ -5002,20 +5461,17 @@ func uiSocksEditUser(sc *socksClient, in *bufio.Reader) error {
 	}
 	newPassword = strings.TrimSpace(newPassword)
 	if newPassword != "" {
-		if err := sc.setLinuxUserPassword(socksSystemUser(users[idx]), newPassword); err != nil {
-			return err
-		}
-		users[idx].Password = newPassword
+		target.Password = newPassword
 	}
 
-	if users[idx] == current {
+	if target.Name == current.Name && target.Password == current.Password {
 		fmt.Println("\n" + uiText("No changes requested."))
 		return nil
 	}
-	if err := sc.writeUsers(users); err != nil {
+	if err := sc.editUser(users, idx, target); err != nil {
 		return err
 	}
-	fmt.Printf("\n%s\n", uiTextf("SOCKS user updated: %s -> %s", current.Name, users[idx].Name))
+	fmt.Printf("\n%s\n", uiTextf("SOCKS user updated: %s -> %s", current.Name, target.Name))
 	if newPassword != "" {
 		fmt.Printf("%s\n", uiTextf("New password: %s", newPassword))
 	}
@@ -5062,16 +5518,10 @@ func uiSocksDeleteUser(sc *socksClient, in *bufio.Reader) error {
 		fmt.Println(uiText("Canceled."))
 		return nil
 	}
-	next := make([]socksUser, 0, len(users)-1)
-	next = append(next, users[:idx]...)
-	next = append(next, users[idx+1:]...)
-	if err := sc.writeUsers(next); err != nil {
+	if err := sc.deleteUser(users, idx); err != nil {
 		return err
 	}
 	fmt.Printf("%s\n", uiTextf("Deleted SOCKS user: %s", u.Name))
-	if err := sc.deleteLinuxUser(socksSystemUser(u)); err != nil {
-		fmt.Printf("%s\n", uiTextf("Warning: %s", err.Error()))
-	}
 	return nil
 }
 
@@ -5093,7 +5543,7 @@ func uiSocksService(sc *socksClient, in *bufio.Reader) error {
 	case "status":
 		return runCommand("systemctl", "--no-pager", "--full", "status", sc.service)
 	case "start", "stop", "restart":
-		if err := runCommand("systemctl", action, sc.service); err != nil {
+		if err := runServiceAction(action, sc.service); err != nil {
 			return err
 		}
 		fmt.Printf("%s\n", uiTextf("SOCKS service %s: %s", action, sc.service))
@@ -5386,7 +5836,7 @@ func uiTrustService(tt *trustClient, in *bufio.Reader) error {
 	case "status":
 		return runCommand("systemctl", "--no-pager", "--full", "status", tt.service)
 	case "start", "stop", "restart":
-		if err := runCommand("systemctl", action, tt.service); err != nil {
+		if err := runServiceAction(action, tt.service); err != nil {
 			return err
 		}
 		fmt.Printf("%s\n", uiTextf("TrustTunnel service %s: %s", action, tt.service))
@@ -5499,7 +5949,7 @@ func (m *mtproxyClient) serviceIsActive() (bool, error) {
 }
 
 func (m *mtproxyClient) restartService() error {
-	return runCommand("systemctl", "restart", m.service)
+	return runServiceAction("restart", m.service)
 }
 
 func (m *mtproxyClient) loadConfig() (mtproxyConfig, error) {
@@ -5508,32 +5958,31 @@ func (m *mtproxyClient) loadConfig() (mtproxyConfig, error) {
 		Port:         defaultMTProxyPort,
 		InternalPort: defaultMTProxyInternalPort,
 	}
-	if fileExists(m.config) {
-		raw, err := os.ReadFile(m.config)
-		if err != nil {
-			return cfg, err
+	raw, ver, err := readStateFile(m.config)
+	if err != nil {
+		return cfg, err
+	}
+	m.versions.remember(m.config, ver)
+	if strings.TrimSpace(string(raw)) != "" {
+		var parsed mtproxyConfig
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", m.config, err)
 		}
-		if strings.TrimSpace(string(raw)) != "" {
-			var parsed mtproxyConfig
-			if err := json.Unmarshal(raw, &parsed); err != nil {
-				return cfg, fmt.Errorf("parse %s: %w", m.config, err)
-			}
-			if strings.TrimSpace(parsed.Server) != "" {
-				cfg.Server = strings.TrimSpace(parsed.Server)
-			}
-			if parsed.Port > 0 {
-				cfg.Port = parsed.Port
-			}
-			if parsed.InternalPort > 0 {
-				cfg.InternalPort = parsed.InternalPort
-			}
-			if strings.TrimSpace(parsed.Secret) != "" {
-				secret, err := normalizeMTProxySecret(parsed.Secret)
-				if err != nil {
-					return cfg, err
-				}
-				cfg.Secret = secret
+		if strings.TrimSpace(parsed.Server) != "" {
+			cfg.Server = strings.TrimSpace(parsed.Server)
+		}
+		if parsed.Port > 0 {
+			cfg.Port = parsed.Port
+		}
+		if parsed.InternalPort > 0 {
+			cfg.InternalPort = parsed.InternalPort
+		}
+		if strings.TrimSpace(parsed.Secret) != "" {
+			secret, err := normalizeMTProxySecret(parsed.Secret)
+			if err != nil {
+				return cfg, err
 			}
+			cfg.Secret = secret
 		}
 	}
 	if cfg.Port < 1 || cfg.Port > 65535 {
@@ -5545,7 +5994,13 @@ func (m *mtproxyClient) loadConfig() (mtproxyConfig, error) {
 	return cfg, nil
 }
 
-func (m *mtproxyClient) writeConfig(cfg mtproxyConfig) error {
+// writeConfig replaces mtproxy.json. It fails with errStateConflict if the
+// file changed since this client last loaded it.
+func (m *mtproxyClient) writeConfig(cfg mtproxyConfig) (err error) {
+	var before map[string]any
+	defer func() {
+		auditRecord("mtproxy.config.write", m.config, auditMapChanges(before, auditStructMap(cfg)), err)
+	}()
 	cfg.Server = strings.TrimSpace(cfg.Server)
 	if cfg.Server == "" {
 		if envHost := strings.TrimSpace(os.Getenv("PSAS_MTPROXY_HOST")); envHost != "" {
@@ -5574,10 +6029,18 @@ func (m *mtproxyClient) writeConfig(cfg mtproxyConfig) error {
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(m.config), 0o755); err != nil {
+	ver, err := commitStateFile(m.config, 0o600, m.versions.lookup(m.config), func(current []byte) ([]byte, error) {
+		var old mtproxyConfig
+		if len(current) > 0 && json.Unmarshal(current, &old) == nil {
+			before = auditStructMap(old)
+		}
+		return append(payload, '\n'), nil
+	})
+	if err != nil {
 		return err
 	}
-	return os.WriteFile(m.config, append(payload, '\n'), 0o600)
+	m.versions.remember(m.config, ver)
+	return nil
 }
 
 func (m *mtproxyClient) connectionInfo(server string, port int, secret string) (mtproxyConnInfo, error) {
@@ -5712,7 +6175,7 @@ func (t *trustClient) serviceIsActive() (bool, error) {
 }
 
 func (t *trustClient) restartService() error {
-	return runCommand("systemctl", "restart", t.service)
+	return runServiceAction("restart", t.service)
 }
 
 func (t *trustClient) listenAddress() (string, error) {
@@ -5786,10 +6249,14 @@ func (t *trustClient) usersList() ([]trustUser, error) {
 	if err != nil {
 		return nil, err
 	}
-	raw, err := os.ReadFile(credPath)
+	raw, ver, err := readStateFile(credPath)
 	if err != nil {
 		return nil, err
 	}
+	if !ver.Exists {
+		return nil, fmt.Errorf("open %s: %w", credPath, os.ErrNotExist)
+	}
+	t.versions.remember(credPath, ver)
 	users, err := parseTrustCredentials(string(raw))
 	if err != nil {
 		return nil, fmt.Errorf("parse %s: %w", credPath, err)
@@ -5797,21 +6264,38 @@ func (t *trustClient) usersList() ([]trustUser, error) {
 	return users, nil
 }
 
-func (t *trustClient) writeUsers(users []trustUser) error {
+// writeUsers replaces credentials.toml. It fails with errStateConflict if
+// the file changed since this client last read it.
+func (t *trustClient) writeUsers(users []trustUser) (err error) {
 	credPath, err := t.credentialsPath()
 	if err != nil {
 		return err
 	}
-	mode := os.FileMode(0o600)
-	if info, err := os.Stat(credPath); err == nil {
-		mode = info.Mode()
-	}
-
+	before := map[string]string{}
+	defer func() {
+		after := map[string]string{}
+		for _, u := range users {
+			after[u.Username] = u.Password
+		}
+		auditRecord("trust.users.write", credPath, auditCredentialChanges(before, after), err)
+	}()
 	payload, err := renderTrustCredentials(users)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(credPath, []byte(payload), mode)
+	ver, err := commitStateFile(credPath, 0o600, t.versions.lookup(credPath), func(current []byte) ([]byte, error) {
+		if old, perr := parseTrustCredentials(string(current)); perr == nil {
+			for _, u := range old {
+				before[u.Username] = u.Password
+			}
+		}
+		return []byte(payload), nil
+	})
+	if err != nil {
+		return err
+	}
+	t.versions.remember(credPath, ver)
+	return nil
 }
 
 func (t *trustClient) exportClientConfig(username, address string) (string, error) {
@@ -5883,19 +6367,51 @@ func (t *trustClient) normalizeExportAddress(raw string) (string, error) {
 	return host + ":" + port, nil
 }
 
+// newSocksClient picks the backend from PSAS_SOCKS_BACKEND, or the built-in
+// server when `socks-server install` has written its config.
 func newSocksClient() *socksClient {
+	serverConfig := socksServerConfigPath()
+	backend := socksBackendDante
+	if fileExists(serverConfig) {
+		backend = socksBackendBuiltin
+	}
+	if strings.EqualFold(envOr("PSAS_SOCKS_BACKEND", backend), socksBackendBuiltin) {
+		backend = socksBackendBuiltin
+	} else {
+		backend = socksBackendDante
+	}
+	service := defaultSocksService
+	if backend == socksBackendBuiltin {
+		service = defaultSocksServerService
+	}
 	return &socksClient{
-		service: envOr("PSAS_SOCKS_SERVICE", defaultSocksService),
-		config:  envOr("PSAS_SOCKS_CONF", defaultSocksConfig),
-		users:   envOr("PSAS_SOCKS_USERS", defaultSocksUsers),
+		backend:      backend,
+		service:      envOr("PSAS_SOCKS_SERVICE", service),
+		config:       envOr("PSAS_SOCKS_CONF", defaultSocksConfig),
+		serverConfig: serverConfig,
+		users:        envOr("PSAS_SOCKS_USERS", defaultSocksUsers),
 	}
 }
 
+// builtin reports whether users are served by `psasctl socks-server`, which
+// needs no Linux accounts.
+func (s *socksClient) builtin() bool {
+	return s.backend == socksBackendBuiltin
+}
+
+func (s *socksClient) configPath() string {
+	if s.builtin() {
+		return s.serverConfig
+	}
+	return s.config
+}
+
 func (s *socksClient) status() (socksStatus, error) {
 	st := socksStatus{
+		Backend:    s.backend,
 		Installed:  s.installed(),
 		Service:    s.service,
-		ConfigPath: s.config,
+		ConfigPath: s.configPath(),
 	}
 	active, err := s.serviceIsActive()
 	if err == nil {
@@ -5914,6 +6430,9 @@ func (s *socksClient) status() (socksStatus, error) {
 }
 
 func (s *socksClient) installed() bool {
+	if s.builtin() {
+		return fileExists(s.serverConfig)
+	}
 	if _, err := exec.LookPath("danted"); err == nil {
 		return true
 	}
@@ -5936,61 +6455,54 @@ func (s *socksClient) serviceIsActive() (bool, error) {
 }
 
 func (s *socksClient) restartService() error {
-	return runCommand("systemctl", "restart", s.service)
+	return runServiceAction("restart", s.service)
 }
 
 func (s *socksClient) listenAddress() (string, error) {
+	if s.builtin() {
+		cfg, err := loadSocksServerConfig(s.serverConfig)
+		if err != nil {
+			return "", err
+		}
+		return cfg.Listen, nil
+	}
 	raw, err := os.ReadFile(s.config)
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(strings.ReplaceAll(string(raw), "\r", ""), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if i := strings.Index(line, "#"); i >= 0 {
-			line = strings.TrimSpace(line[:i])
-		}
-		if line == "" {
-			continue
-		}
-		m := dantedInternalRe.FindStringSubmatch(line)
-		if len(m) == 0 {
-			continue
-		}
-		host := strings.Trim(strings.TrimSpace(m[1]), "[]")
-		if host == "" {
-			host = "0.0.0.0"
-		}
-		port := strings.TrimSpace(m[2])
-		if port == "" {
-			port = strconv.Itoa(defaultSocksPort)
-		}
-		p, err := strconv.Atoi(port)
-		if err != nil || p < 1 || p > 65535 {
-			return "", fmt.Errorf("invalid SOCKS port in %s: %s", s.config, port)
-		}
-		if strings.Contains(host, ":") {
-			return net.JoinHostPort(host, port), nil
-		}
-		return host + ":" + port, nil
+	cfg, err := parseDanteConfig(raw)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", s.config, err)
+	}
+	host, port, err := cfg.internal()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.config, err)
 	}
-	return "", fmt.Errorf("internal listen address not found in %s", s.config)
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	if strings.Contains(host, ":") {
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	return host + ":" + strconv.Itoa(port), nil
 }
 
 func (s *socksClient) usersList() ([]socksUser, error) {
-	if !fileExists(s.users) {
-		return []socksUser{}, nil
-	}
-	raw, err := os.ReadFile(s.users)
+	raw, ver, err := readStateFile(s.users)
 	if err != nil {
 		return nil, err
 	}
+	s.versions.remember(s.users, ver)
+	return parseSocksUsers(raw, s.users)
+}
+
+func parseSocksUsers(raw []byte, path string) ([]socksUser, error) {
 	if strings.TrimSpace(string(raw)) == "" {
 		return []socksUser{}, nil
 	}
 	var users []socksUser
 	if err := json.Unmarshal(raw, &users); err != nil {
-		return nil, fmt.Errorf("parse %s: %w", s.users, err)
+		return nil, fmt.Errorf("parse %s: %w", path, err)
 	}
 	out := make([]socksUser, 0, len(users))
 	for _, u := range users {
@@ -6006,6 +6518,8 @@ func (s *socksClient) usersList() ([]socksUser, error) {
 			Name:       name,
 			Password:   strings.TrimSpace(u.Password),
 			SystemUser: strings.TrimSpace(systemUser),
+			Allow:      u.Allow,
+			Deny:       u.Deny,
 		})
 	}
 	sort.Slice(out, func(i, j int) bool {
@@ -6014,7 +6528,19 @@ func (s *socksClient) usersList() ([]socksUser, error) {
 	return out, nil
 }
 
-func (s *socksClient) writeUsers(users []socksUser) error {
+// writeUsers replaces the users file. It fails with errStateConflict if the
+// file changed since this client last read it.
+func (s *socksClient) writeUsers(users []socksUser) (err error) {
+	before, beforeACL := map[string]string{}, map[string]string{}
+	defer func() {
+		after, afterACL := map[string]string{}, map[string]string{}
+		for _, u := range users {
+			after[u.Name] = u.Password
+			afterACL[u.Name] = socksACLAuditValue(u)
+		}
+		changes := append(auditCredentialChanges(before, after), socksACLAuditChanges(beforeACL, afterACL)...)
+		auditRecord("socks.users.write", s.users, changes, err)
+	}()
 	for i := range users {
 		users[i].Name = normalizeSocksLogin(users[i].Name)
 		if users[i].SystemUser == "" {
@@ -6025,13 +6551,26 @@ func (s *socksClient) writeUsers(users []socksUser) error {
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(s.users), 0o755); err != nil {
+	ver, err := commitStateFile(s.users, 0o600, s.versions.lookup(s.users), func(current []byte) ([]byte, error) {
+		if old, perr := parseSocksUsers(current, s.users); perr == nil {
+			for _, u := range old {
+				before[u.Name] = u.Password
+				beforeACL[u.Name] = socksACLAuditValue(u)
+			}
+		}
+		return append(payload, '\n'), nil
+	})
+	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.users, append(payload, '\n'), 0o600)
+	s.versions.remember(s.users, ver)
+	return nil
 }
 
 func (s *socksClient) ensureLinuxUser(login, password string) error {
+	if s.builtin() {
+		return nil
+	}
 	login = normalizeSocksLogin(login)
 	if err := validateSocksLogin(login); err != nil {
 		return err
@@ -6044,7 +6583,7 @@ func (s *socksClient) ensureLinuxUser(login, password string) error {
 		if !fileExists(shell) {
 			shell = "/bin/false"
 		}
-		if err := runCommand("useradd", "-M", "-N", "-s", shell, login); err != nil {
+		if err := runCommand("useradd", "-M", "-N", "-s", shell, "-c", socksAccountComment, login); err != nil {
 			return fmt.Errorf("useradd %s: %w", login, err)
 		}
 	}
@@ -6055,6 +6594,9 @@ func (s *socksClient) ensureLinuxUser(login, password string) error {
 }
 
 func (s *socksClient) setLinuxUserPassword(login, password string) error {
+	if s.builtin() {
+		return nil
+	}
 	login = strings.TrimSpace(login)
 	if login == "" {
 		return errors.New("empty login")
@@ -6071,7 +6613,7 @@ func (s *socksClient) setLinuxUserPassword(login, password string) error {
 
 func (s *socksClient) deleteLinuxUser(login string) error {
 	login = strings.TrimSpace(login)
-	if login == "" {
+	if login == "" || s.builtin() {
 		return nil
 	}
 	if !osSocksUserExists(login) {
@@ -6507,6 +7049,15 @@ func (c *client) loadState() error {
 	return nil
 }
 
+// ensureState loads the panel state only once; long-running modes (sub,
+// exporter) call it per request instead of spawning the panel every time.
+func (c *client) ensureState() error {
+	if c.state.APIKey != "" {
+		return nil
+	}
+	return c.loadState()
+}
+
 func (c *client) runPanel(args ...string) ([]byte, error) {
 	cmdArgs := append([]string{"-m", "hiddifypanel"}, args...)
 	cmd := exec.Command(c.panelPy, cmdArgs...)
@@ -6577,6 +7128,9 @@ func (c *client) usersList() ([]apiUser, error) {
 	if err := json.Unmarshal(b, &users); err != nil {
 		return nil, err
 	}
+	for i := range users {
+		users[i].RemainingDays = userRemainingDays(users[i], time.Now())
+	}
 	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
 	return users, nil
 }
@@ -6590,10 +7144,14 @@ func (c *client) userShow(uuid string) (apiUser, error) {
 	if err := json.Unmarshal(b, &u); err != nil {
 		return apiUser{}, err
 	}
+	u.RemainingDays = userRemainingDays(u, time.Now())
 	return u, nil
 }
 
-func (c *client) userAdd(payload map[string]any) (apiUser, error) {
+func (c *client) userAdd(payload map[string]any) (_ apiUser, err error) {
+	defer func() {
+		auditRecord("hiddify.user.add", auditHiddifyTarget(payload["name"], payload["uuid"]), auditMapChanges(nil, payload), err)
+	}()
 	b, err := c.api(http.MethodPost, "user/", payload)
 	if err != nil {
 		return apiUser{}, err
@@ -6602,27 +7160,79 @@ func (c *client) userAdd(payload map[string]any) (apiUser, error) {
 	if err := json.Unmarshal(b, &u); err != nil {
 		return apiUser{}, err
 	}
+	u.RemainingDays = userRemainingDays(u, time.Now())
 	return u, nil
 }
 
-func (c *client) userPatch(uuid string, payload map[string]any) (apiUser, error) {
-	b, err := c.api(http.MethodPatch, "user/"+uuid+"/", payload)
+// userPatch applies payload to the panel user u. u is the user as the
+// caller last read it; it is the "before" side of the audit entry, so no
+// extra request is made. A u with only UUID set records new values only.
+func (c *client) userPatch(u apiUser, payload map[string]any) (_ apiUser, err error) {
+	before := auditPanelUser(u)
+	defer func() {
+		auditRecord("hiddify.user.edit", auditHiddifyTarget(firstNonNil(before["name"], payload["name"]), u.UUID), auditMapChanges(before, payload), err)
+	}()
+	b, err := c.api(http.MethodPatch, "user/"+u.UUID+"/", payload)
 	if err != nil {
 		return apiUser{}, err
 	}
-	var u apiUser
-	if err := json.Unmarshal(b, &u); err != nil {
+	var updated apiUser
+	if err := json.Unmarshal(b, &updated); err != nil {
 		return apiUser{}, err
 	}
-	return u, nil
+	updated.RemainingDays = userRemainingDays(updated, time.Now())
+	return updated, nil
 }
 
-func (c *client) userDelete(uuid string) error {
-	_, err := c.api(http.MethodDelete, "user/"+uuid+"/", nil)
+// userDelete deletes the panel user u (see userPatch for what u is).
+func (c *client) userDelete(u apiUser) error {
+	_, err := c.api(http.MethodDelete, "user/"+u.UUID+"/", nil)
+	auditRecord("hiddify.user.delete", auditHiddifyTarget(u.Name, u.UUID), nil, err)
 	return err
 }
 
+// auditPanelUser is the audit view of a user the caller already holds, or
+// nil when only its UUID is known.
+func auditPanelUser(u apiUser) map[string]any {
+	if u.Name == "" {
+		return nil
+	}
+	return auditStructMap(u)
+}
+
+func auditHiddifyTarget(name, uuid any) string {
+	n, _ := name.(string)
+	id, _ := uuid.(string)
+	switch {
+	case n != "" && id != "":
+		return n + " (" + id + ")"
+	case id != "":
+		return id
+	default:
+		return n
+	}
+}
+
+func firstNonNil(values ...any) any {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
 func (c *client) resolveUser(id string) (apiUser, error) {
+	return c.findUser(id, true)
+}
+
+// resolveUserExact is resolveUser without partial name matches, for callers
+// where a near miss must not pick another user (HTTP API edits and deletes).
+func (c *client) resolveUserExact(id string) (apiUser, error) {
+	return c.findUser(id, false)
+}
+
+func (c *client) findUser(id string, partialNames bool) (apiUser, error) {
 	key := strings.TrimSpace(id)
 	if key == "" {
 		return apiUser{}, errors.New("empty USER_ID")
@@ -6655,6 +7265,9 @@ func (c *client) resolveUser(id string) (apiUser, error) {
 	if len(exact) > 1 {
 		return apiUser{}, fmt.Errorf("multiple users have name %q: %s", key, formatUserRefs(exact))
 	}
+	if !partialNames {
+		return apiUser{}, fmt.Errorf("user not found by exact name/UUID: %s", key)
+	}
 
 	var partial []apiUser
 	lkey := strings.ToLower(key)
@@ -6673,7 +7286,9 @@ func (c *client) resolveUser(id string) (apiUser, error) {
 }
 
 func (c *client) setConfig(key, value string) error {
+	old := c.currentConfig()[key]
 	_, err := c.runPanel("set-setting", "-k", key, "-v", value)
+	auditRecord("hiddify.config.set", key, []auditChange{{Field: key, Old: auditValue(key, old), New: auditValue(key, value)}}, err)
 	return err
 }
 
@@ -6767,6 +7382,14 @@ func (c *client) ensureTrueUnlimitedSupport() error {
 	if !changedUsers && !changedHiddify {
 		return nil
 	}
+	var patched []auditChange
+	if changedUsers {
+		patched = append(patched, auditChange{Field: "file", New: userModelPath})
+	}
+	if changedHiddify {
+		patched = append(patched, auditChange{Field: "file", New: hiddifyPath})
+	}
+	auditRecord("hiddify.true_unlimited.patch", panelPkgDir, patched, nil)
 
 	fmt.Println("Enabled true unlimited support in Hiddify.")
 	if err := restartHiddifyServices(); err != nil {
@@ -6922,9 +7545,11 @@ func (c *client) adminURL(host string) string {
 
 func printUsers(users []apiUser) {
 	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
-	fmt.Fprintln(tw, "UUID\tNAME\tENABLED\tLIMIT_GB\tDAYS\tMODE")
+	fmt.Fprintln(tw, "UUID\tNAME\tENABLED\tUSED_GB\tLIMIT_GB\tUSED%\tDAYS\tLEFT\tMODE\tLAST_ONLINE")
 	for _, u := range users {
-		fmt.Fprintf(tw, "%s\t%s\t%t\t%.2f\t%d\t%s\n", u.UUID, u.Name, u.Enable, u.UsageLimitGB, u.PackageDays, u.Mode)
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%.2f\t%.2f\t%s\t%d\t%s\t%s\t%s\n",
+			u.UUID, u.Name, u.Enable, u.CurrentUsageGB, u.UsageLimitGB, formatUsagePercent(u),
+			u.PackageDays, formatRemainingDays(u), u.Mode, formatLastOnline(u.LastOnline))
 	}
 	_ = tw.Flush()
 }
@@ -6954,11 +7579,229 @@ func printUser(u apiUser) {
 	fmt.Printf("UUID      : %s\n", u.UUID)
 	fmt.Printf("Name      : %s\n", u.Name)
 	fmt.Printf("Enabled   : %t\n", u.Enable)
+	fmt.Printf("Used GB   : %.2f (%s)\n", u.CurrentUsageGB, formatUsagePercent(u))
 	fmt.Printf("Limit GB  : %.2f\n", u.UsageLimitGB)
 	fmt.Printf("Days      : %d\n", u.PackageDays)
+	fmt.Printf("Started   : %s\n", valueOrDash(u.StartDate))
+	fmt.Printf("Days left : %s\n", formatRemainingDays(u))
+	fmt.Printf("Online    : %s\n", formatLastOnline(u.LastOnline))
 	fmt.Printf("Mode      : %s\n", u.Mode)
 }
 
+// userRemainingDays mirrors Hiddify's User.remaining_days: package days count
+// from start_date (the first connection), and a package that has not started yet
+// still has all of its days left.
+func userRemainingDays(u apiUser, now time.Time) int {
+	if u.PackageDays >= unlimitedPackageDays {
+		return unlimitedPackageDays
+	}
+	start, ok := parseHiddifyTime(u.StartDate)
+	if !ok {
+		return u.PackageDays
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	return u.PackageDays - int(today.Sub(start).Hours()/24)
+}
+
+func userUsagePercent(u apiUser) float64 {
+	if u.UsageLimitGB <= 0 {
+		return 0
+	}
+	return u.CurrentUsageGB / u.UsageLimitGB * 100
+}
+
+func isUnlimitedUsage(u apiUser) bool {
+	return u.UsageLimitGB >= unlimitedUsageGB
+}
+
+func isUnlimitedDays(u apiUser) bool {
+	return u.PackageDays >= unlimitedPackageDays
+}
+
+func formatUsagePercent(u apiUser) string {
+	if isUnlimitedUsage(u) {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.0f%%", userUsagePercent(u))
+}
+
+func formatRemainingDays(u apiUser) string {
+	if isUnlimitedDays(u) {
+		return "unlimited"
+	}
+	return strconv.Itoa(u.RemainingDays)
+}
+
+func formatLastOnline(raw string) string {
+	t, ok := parseHiddifyTime(raw)
+	if !ok {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func valueOrDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return strings.TrimSpace(s)
+}
+
+func parseHiddifyTime(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999",
+		"2006-01-02 15:04:05.999999",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		time.RFC1123,
+		time.RFC1123Z,
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			// Hiddify 1970 timestamps mean "never".
+			if t.Year() <= 1970 {
+				return time.Time{}, false
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseDaysValue accepts "7", "7d", "2w" or "48h" and returns whole days
+// (hours are rounded up).
+func parseDaysValue(raw string) (int, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	if s == "" {
+		return 0, errors.New("empty days value")
+	}
+	mult := 1
+	hours := false
+	switch {
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		s = strings.TrimSuffix(s, "w")
+		mult = 7
+	case strings.HasSuffix(s, "h"):
+		s = strings.TrimSuffix(s, "h")
+		hours = true
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid days value %q (expected e.g. 7d, 2w, 48h)", raw)
+	}
+	if hours {
+		return (n + 23) / 24, nil
+	}
+	return n * mult, nil
+}
+
+// parsePercentValue accepts "80%", "80" or "0.8".
+func parsePercentValue(raw string) (float64, error) {
+	s := strings.TrimSpace(raw)
+	hasSign := strings.HasSuffix(s, "%")
+	s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid percent value %q (expected e.g. 80%%)", raw)
+	}
+	if !hasSign && v > 0 && v <= 1 {
+		v *= 100
+	}
+	return v, nil
+}
+
+func filterUsersExpiringWithin(users []apiUser, days int) []apiUser {
+	out := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		if isUnlimitedDays(u) {
+			continue
+		}
+		if u.RemainingDays <= days {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func filterUsersOverUsage(users []apiUser, pct float64) []apiUser {
+	out := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		if isUnlimitedUsage(u) {
+			continue
+		}
+		if userUsagePercent(u) >= pct {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func sortUsers(users []apiUser, by string, desc bool) error {
+	var less func(a, b apiUser) bool
+	switch strings.ToLower(strings.TrimSpace(by)) {
+	case "", "name":
+		less = func(a, b apiUser) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "usage", "used":
+		less = func(a, b apiUser) bool { return userUsagePercent(a) < userUsagePercent(b) }
+	case "remaining", "left", "expiry":
+		less = func(a, b apiUser) bool { return a.RemainingDays < b.RemainingDays }
+	case "last-online", "online":
+		less = func(a, b apiUser) bool {
+			ta, _ := parseHiddifyTime(a.LastOnline)
+			tb, _ := parseHiddifyTime(b.LastOnline)
+			return ta.Before(tb)
+		}
+	default:
+		return fmt.Errorf("invalid --sort: %s (expected name|usage|remaining|last-online)", by)
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if desc {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+	return nil
+}
+
+// userEditChanges lists the user fields that differ between two snapshots.
+func userEditChanges(before, after apiUser) []userFieldChange {
+	var out []userFieldChange
+	add := func(field string, b, a any) {
+		if b != a {
+			out = append(out, userFieldChange{Field: field, Before: b, After: a})
+		}
+	}
+	add("name", before.Name, after.Name)
+	add("enable", before.Enable, after.Enable)
+	add("mode", before.Mode, after.Mode)
+	add("package_days", before.PackageDays, after.PackageDays)
+	add("remaining_days", before.RemainingDays, after.RemainingDays)
+	add("usage_limit_GB", before.UsageLimitGB, after.UsageLimitGB)
+	add("current_usage_GB", before.CurrentUsageGB, after.CurrentUsageGB)
+	add("start_date", before.StartDate, after.StartDate)
+	return out
+}
+
+func printUserEditChanges(changes []userFieldChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Changes")
+	fmt.Println("=======")
+	for _, ch := range changes {
+		fmt.Printf("%-17s: %v -> %v\n", ch.Field, ch.Before, ch.After)
+	}
+}
+
 func buildLinks(clientPath, uuid, host string) linkSet {
 	base := fmt.Sprintf("https://%s/%s/%s", strings.TrimSpace(host), strings.Trim(clientPath, "/"), strings.TrimSpace(uuid))
 	return linkSet{
@@ -7364,7 +8207,7 @@ func setUILang(lang string, persist bool) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, append(payload, '\n'), 0o600)
+	return writeStateFile(path, append(payload, '\n'), 0o600)
 }
 
 func uiText(s string) string {
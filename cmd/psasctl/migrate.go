@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	migrateFormat        = "psas-migrate"
+	migrateFormatVersion = 1
+)
+
+var migrateComponents = []string{"hiddify", "socks", "trust", "mtproxy", "accounts"}
+
+// migrateBundle carries everything needed to serve existing customers from
+// another VPS. Unlike a backup it holds logical data (users, secrets,
+// toggles), not files, so it can be imported into a freshly installed server.
+type migrateBundle struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	CreatedAt string          `json:"created_at"`
+	Source    migrateHosts    `json:"source"`
+	Hiddify   *migrateHiddify `json:"hiddify,omitempty"`
+	Socks     *migrateSocks   `json:"socks,omitempty"`
+	Trust     *migrateTrust   `json:"trust,omitempty"`
+	MTProxy   *mtproxyConfig  `json:"mtproxy,omitempty"`
+	Accounts  []psasAccount   `json:"accounts,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+}
+
+// migrateHosts are the public names clients of the source server use.
+type migrateHosts struct {
+	Hostname      string `json:"hostname,omitempty"`
+	MainDomain    string `json:"main_domain,omitempty"`
+	PublicIP      string `json:"public_ip,omitempty"`
+	SocksHost     string `json:"socks_host,omitempty"`
+	MTProxyServer string `json:"mtproxy_server,omitempty"`
+	TrustHostname string `json:"trust_hostname,omitempty"`
+}
+
+type migrateHiddify struct {
+	ClientPath string          `json:"client_path,omitempty"`
+	Protocols  []protocolState `json:"protocols,omitempty"`
+	Users      json.RawMessage `json:"users"`
+}
+
+type migrateSocks struct {
+	Users []socksUser `json:"users"`
+}
+
+type migrateTrust struct {
+	Users []trustUser `json:"users"`
+}
+
+// migrateStep is one line of the import report.
+type migrateStep struct {
+	Component string   `json:"component"`
+	Actions   []string `json:"actions,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fatalf("migrate requires subcommand: export|import")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+	switch sub {
+	case "export":
+		runMigrateExport(subArgs)
+	case "import":
+		runMigrateImport(subArgs)
+	default:
+		fatalf("unknown migrate subcommand: %s", sub)
+	}
+}
+
+func migrateSelect(only string) (map[string]bool, error) {
+	out := map[string]bool{}
+	if strings.TrimSpace(only) == "" {
+		for _, c := range migrateComponents {
+			out[c] = true
+		}
+		return out, nil
+	}
+	for _, part := range strings.Split(only, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		known := false
+		for _, c := range migrateComponents {
+			known = known || c == part
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown migrate component %q (expected %s)", part, strings.Join(migrateComponents, ","))
+		}
+		out[part] = true
+	}
+	return out, nil
+}
+
+func runMigrateExport(args []string) {
+	fs := flag.NewFlagSet("migrate export", flag.ExitOnError)
+	out := fs.String("out", "", "output file (default: psas-migrate-YYYYMMDD-HHMMSS.json[.enc], - for stdout)")
+	only := fs.String("only", "", "comma-separated components: "+strings.Join(migrateComponents, ","))
+	passFile := fs.String("passphrase-file", "", "encrypt with the passphrase from this file (or set PSAS_BACKUP_PASSPHRASE)")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("migrate export takes only flags")
+	}
+	must(requireRoot("migrate export"))
+	want, err := migrateSelect(*only)
+	must(err)
+	passphrase, err := backupPassphrase(*passFile)
+	must(err)
+
+	bundle, err := buildMigrateBundle(want)
+	must(err)
+	payload, err := json.MarshalIndent(bundle, "", "  ")
+	must(err)
+	payload = append(payload, '\n')
+	ext := ".json"
+	if passphrase != "" {
+		payload, err = backupEncrypt(payload, passphrase)
+		must(err)
+		ext += backupPassphraseExt
+	}
+	if *out == "-" {
+		_, err := os.Stdout.Write(payload)
+		must(err)
+		return
+	}
+	path := *out
+	if path == "" {
+		path = "psas-migrate-" + time.Now().UTC().Format("20060102-150405") + ext
+	}
+	must(writeFileAtomic(path, payload, 0o600))
+	fmt.Printf("Migration bundle written: %s\n", path)
+	if bundle.Hiddify != nil {
+		var users []json.RawMessage
+		_ = json.Unmarshal(bundle.Hiddify.Users, &users)
+		fmt.Printf("Hiddify users: %d\n", len(users))
+	}
+	if bundle.Socks != nil {
+		fmt.Printf("SOCKS users: %d\n", len(bundle.Socks.Users))
+	}
+	if bundle.Trust != nil {
+		fmt.Printf("TrustTunnel users: %d\n", len(bundle.Trust.Users))
+	}
+	if bundle.MTProxy != nil {
+		fmt.Println("MTProxy secret: included")
+	}
+	if len(bundle.Accounts) > 0 {
+		fmt.Printf("Accounts: %d\n", len(bundle.Accounts))
+	}
+	for _, w := range bundle.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	fmt.Println("The bundle contains passwords and secrets; copy it over SSH and delete it afterwards.")
+}
+
+func buildMigrateBundle(want map[string]bool) (migrateBundle, error) {
+	host, _ := os.Hostname()
+	b := migrateBundle{
+		Format:    migrateFormat,
+		Version:   migrateFormatVersion,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Source: migrateHosts{
+			Hostname:  host,
+			SocksHost: strings.TrimSpace(os.Getenv("PSAS_SOCKS_HOST")),
+		},
+	}
+	if ip, err := detectPublicIPv4(); err == nil {
+		b.Source.PublicIP = ip
+	}
+	warn := func(comp string, err error) {
+		b.Warnings = append(b.Warnings, comp+": "+err.Error())
+	}
+
+	if want["hiddify"] {
+		c := mustClient(false)
+		if !fileExists(c.panelCfg) {
+			warn("hiddify", errors.New("panel is not installed, skipped"))
+		} else if err := c.ensureState(); err != nil {
+			return b, err
+		} else {
+			raw, err := c.api(http.MethodGet, "user/", nil)
+			if err != nil {
+				return b, err
+			}
+			b.Source.MainDomain = c.mainDomain()
+			b.Hiddify = &migrateHiddify{
+				ClientPath: c.clientPath(),
+				Protocols:  protocolStates(c.currentConfig()),
+				Users:      json.RawMessage(raw),
+			}
+		}
+	}
+	if want["socks"] {
+		sc := newSocksClient()
+		users, err := sc.usersList()
+		if err != nil {
+			return b, err
+		}
+		if len(users) > 0 || sc.installed() {
+			b.Socks = &migrateSocks{Users: users}
+		}
+	}
+	if want["trust"] {
+		tt := newTrustClient()
+		if tt.installed() {
+			users, err := tt.usersList()
+			if err != nil {
+				return b, err
+			}
+			b.Trust = &migrateTrust{Users: users}
+			if h, err := tt.hostname(); err == nil {
+				b.Source.TrustHostname = h
+			}
+		}
+	}
+	if want["mtproxy"] {
+		mp := newMTProxyClient()
+		if fileExists(mp.config) {
+			cfg, err := mp.loadConfig()
+			if err != nil {
+				return b, err
+			}
+			if cfg.Secret != "" {
+				b.MTProxy = &cfg
+				b.Source.MTProxyServer = cfg.Server
+			}
+		}
+	}
+	if want["accounts"] {
+		accounts, err := loadAccounts()
+		if err != nil {
+			return b, err
+		}
+		b.Accounts = accounts
+	}
+	return b, nil
+}
+
+func readMigrateBundle(path, passFile string) (migrateBundle, error) {
+	var b migrateBundle
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if bytes.HasPrefix(raw, []byte(backupEncMagic)) {
+		passphrase, err := backupPassphrase(passFile)
+		if err != nil {
+			return b, err
+		}
+		if raw, err = backupDecrypt(raw, passphrase); err != nil {
+			return b, err
+		}
+	}
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return b, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if b.Format != migrateFormat {
+		return b, fmt.Errorf("%s is not a psasctl migration bundle", path)
+	}
+	if b.Version > migrateFormatVersion {
+		return b, fmt.Errorf("bundle version %d is newer than supported %d", b.Version, migrateFormatVersion)
+	}
+	if b.Socks != nil {
+		for _, u := range b.Socks.Users {
+			if err := validateSocksLogin(u.Name); err != nil {
+				return b, err
+			}
+		}
+	}
+	if b.Trust != nil {
+		for _, u := range b.Trust.Users {
+			if err := validateTrustUsername(u.Username); err != nil {
+				return b, err
+			}
+		}
+	}
+	if b.MTProxy != nil {
+		if _, err := normalizeMTProxySecret(b.MTProxy.Secret); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// migrateHostMap maps every public name of the source server to the
+// matching name of this one: the main domain to --domain (or this panel's
+// main domain), IP addresses to --ip (or the detected public IP), and the
+// remaining hostnames to --host (default: the new domain).
+func migrateHostMap(src migrateHosts, newDomain, newHost, newIP string) map[string]string {
+	out := map[string]string{}
+	add := func(old string) {
+		old = strings.TrimSpace(old)
+		if old == "" {
+			return
+		}
+		switch {
+		case old == src.MainDomain && newDomain != "":
+			out[old] = newDomain
+		case isIPv4(old) && newIP != "":
+			out[old] = newIP
+		case !isIPv4(old) && newHost != "":
+			out[old] = newHost
+		}
+	}
+	add(src.MainDomain)
+	add(src.PublicIP)
+	add(src.SocksHost)
+	add(src.MTProxyServer)
+	add(src.TrustHostname)
+	return out
+}
+
+func rewriteHost(hostMap map[string]string, old string) string {
+	if v, ok := hostMap[strings.TrimSpace(old)]; ok {
+		return v
+	}
+	return old
+}
+
+func runMigrateImport(args []string) {
+	fs := flag.NewFlagSet("migrate import", flag.ExitOnError)
+	only := fs.String("only", "", "comma-separated components: "+strings.Join(migrateComponents, ","))
+	domain := fs.String("domain", "", "main domain of this server (default: from panel)")
+	host := fs.String("host", "", "public hostname for SOCKS/MTProxy/TrustTunnel (default: --domain)")
+	ip := fs.String("ip", "", "public IPv4 of this server (default: auto-detect)")
+	passFile := fs.String("passphrase-file", "", "passphrase file for encrypted bundles (or set PSAS_BACKUP_PASSPHRASE)")
+	dryRun := fs.Bool("dry-run", false, "print the import plan without changing anything")
+	noApply := fs.Bool("no-apply", false, "do not apply Hiddify config or restart services")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 1 {
+		fatalf("usage: psasctl migrate import [flags] <FILE>")
+	}
+	want, err := migrateSelect(*only)
+	must(err)
+	bundle, err := readMigrateBundle(fs.Args()[0], *passFile)
+	must(err)
+
+	var c *client
+	newDomain := strings.TrimSpace(*domain)
+	if want["hiddify"] && bundle.Hiddify != nil {
+		c = mustClient(true)
+		if newDomain == "" {
+			newDomain = c.mainDomain()
+		}
+	}
+	if newDomain == "" {
+		newDomain = bundle.Source.MainDomain
+	}
+	newHost := firstNonEmpty(strings.TrimSpace(*host), newDomain)
+	newIP := strings.TrimSpace(*ip)
+	if newIP == "" {
+		newIP, _ = detectPublicIPv4()
+	}
+	hostMap := migrateHostMap(bundle.Source, newDomain, newHost, newIP)
+
+	if !*jsonOut || *dryRun {
+		printMigratePlan(bundle, want, hostMap)
+	}
+	if *dryRun {
+		if *jsonOut {
+			printJSON(map[string]any{"dry_run": true, "source": bundle.Source, "host_map": hostMap})
+		}
+		return
+	}
+	must(requireRoot("migrate import"))
+	if !*yes {
+		if !isInteractiveTerminal() {
+			fatalf("refusing to import without confirmation; pass --yes")
+		}
+		ok, err := promptYesNo(bufio.NewReader(os.Stdin), "Import into this server?", false)
+		must(err)
+		if !ok {
+			fmt.Println(uiText("Canceled."))
+			return
+		}
+	}
+
+	var steps []migrateStep
+	if want["hiddify"] && bundle.Hiddify != nil {
+		steps = append(steps, migrateImportHiddify(c, bundle, newDomain, !*noApply))
+	}
+	if want["socks"] && bundle.Socks != nil {
+		steps = append(steps, migrateImportSocks(bundle.Socks.Users, !*noApply))
+	}
+	if want["trust"] && bundle.Trust != nil {
+		steps = append(steps, migrateImportTrust(bundle.Trust.Users, !*noApply))
+	}
+	if want["mtproxy"] && bundle.MTProxy != nil {
+		steps = append(steps, migrateImportMTProxy(*bundle.MTProxy, hostMap, !*noApply))
+	}
+	if want["accounts"] && len(bundle.Accounts) > 0 {
+		steps = append(steps, migrateImportAccounts(bundle.Accounts))
+	}
+	if socksHost := rewriteHost(hostMap, bundle.Source.SocksHost); bundle.Source.SocksHost != "" && socksHost != bundle.Source.SocksHost {
+		for i := range steps {
+			if steps[i].Component == "socks" {
+				steps[i].Warnings = append(steps[i].Warnings, "set PSAS_SOCKS_HOST="+socksHost+" for psasctl on this server")
+			}
+		}
+	}
+
+	failed := false
+	for _, s := range steps {
+		failed = failed || s.Error != ""
+	}
+	if *jsonOut {
+		printJSON(map[string]any{"source": bundle.Source, "host_map": hostMap, "steps": steps})
+	} else {
+		fmt.Println()
+		for _, s := range steps {
+			if s.Error != "" {
+				fmt.Printf("%s: FAILED: %s\n", s.Component, s.Error)
+			} else {
+				fmt.Printf("%s: %s\n", s.Component, strings.Join(s.Actions, "; "))
+			}
+			for _, w := range s.Warnings {
+				fmt.Printf("  Warning: %s\n", w)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printMigratePlan(b migrateBundle, want map[string]bool, hostMap map[string]string) {
+	fmt.Printf("Bundle created %s on %s\n", b.CreatedAt, valueOrDash(b.Source.Hostname))
+	if want["hiddify"] && b.Hiddify != nil {
+		var users []json.RawMessage
+		_ = json.Unmarshal(b.Hiddify.Users, &users)
+		enabled := 0
+		for _, p := range b.Hiddify.Protocols {
+			if p.Enabled {
+				enabled++
+			}
+		}
+		fmt.Printf("  hiddify: %d user(s) with their UUIDs, %d/%d protocols enabled, client path %s\n", len(users), enabled, len(b.Hiddify.Protocols), valueOrDash(maskSecret(b.Hiddify.ClientPath)))
+	}
+	if want["socks"] && b.Socks != nil {
+		fmt.Printf("  socks: %d user(s)\n", len(b.Socks.Users))
+	}
+	if want["trust"] && b.Trust != nil {
+		fmt.Printf("  trust: %d user(s)\n", len(b.Trust.Users))
+	}
+	if want["mtproxy"] && b.MTProxy != nil {
+		fmt.Printf("  mtproxy: secret %s, port %d\n", maskSecret(b.MTProxy.Secret), b.MTProxy.Port)
+	}
+	if want["accounts"] && len(b.Accounts) > 0 {
+		fmt.Printf("  accounts: %d\n", len(b.Accounts))
+	}
+	if len(hostMap) > 0 {
+		olds := make([]string, 0, len(hostMap))
+		for k := range hostMap {
+			olds = append(olds, k)
+		}
+		sort.Strings(olds)
+		fmt.Println("Host rewrites:")
+		for _, k := range olds {
+			fmt.Printf("  %s -> %s\n", k, hostMap[k])
+		}
+	}
+}
+
+func migrateImportHiddify(c *client, b migrateBundle, newDomain string, apply bool) migrateStep {
+	step := migrateStep{Component: "hiddify"}
+	h := b.Hiddify
+	if b.Source.MainDomain != "" && newDomain != "" && !strings.EqualFold(b.Source.MainDomain, newDomain) {
+		step.Warnings = append(step.Warnings, fmt.Sprintf("main domain changes %s -> %s: old links work only after %s points here and is added to the panel", b.Source.MainDomain, newDomain, b.Source.MainDomain))
+	}
+	if h.ClientPath != "" && h.ClientPath != c.clientPath() {
+		if err := c.setConfig("proxy_path_client", h.ClientPath); err != nil {
+			step.Error = "set proxy_path_client: " + err.Error()
+			return step
+		}
+		step.Actions = append(step.Actions, "client path restored")
+	}
+	cfg := c.currentConfig()
+	toggled := 0
+	for _, p := range h.Protocols {
+		if _, err := resolveProtocolSetting(p.Key); err != nil {
+			step.Warnings = append(step.Warnings, "unknown protocol "+p.Key)
+			continue
+		}
+		if anyToBool(cfg[p.Key]) == p.Enabled {
+			continue
+		}
+		if err := c.setConfig(p.Key, strconv.FormatBool(p.Enabled)); err != nil {
+			step.Warnings = append(step.Warnings, fmt.Sprintf("set %s: %v", p.Key, err))
+			continue
+		}
+		toggled++
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("%d protocol toggle(s) changed", toggled))
+	created, updated, warnings, err := upsertHiddifyUsers(c, h.Users)
+	step.Warnings = append(step.Warnings, warnings...)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("users: %d created, %d updated", created, updated))
+	if apply {
+		if err := applyWithClient(c); err != nil {
+			step.Warnings = append(step.Warnings, "apply: "+err.Error())
+		} else {
+			step.Actions = append(step.Actions, "config applied")
+		}
+	}
+	return step
+}
+
+func migrateImportSocks(users []socksUser, restart bool) migrateStep {
+	step := migrateStep{Component: "socks"}
+	sc := newSocksClient()
+	current, err := sc.usersList()
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	byName := map[string]int{}
+	known := map[string]bool{}
+	for i, u := range current {
+		byName[u.Name] = i
+		known[socksSystemUser(u)] = true
+	}
+	added, updated := 0, 0
+	for _, u := range users {
+		u.Name = normalizeSocksLogin(u.Name)
+		u.SystemUser = strings.TrimSpace(u.SystemUser)
+		login := socksSystemUser(u)
+		if err := validateSocksLogin(u.Name); err != nil {
+			step.Warnings = append(step.Warnings, "skipped: "+err.Error())
+			continue
+		}
+		if err := validateSocksLogin(login); err != nil {
+			step.Warnings = append(step.Warnings, fmt.Sprintf("skipped %s: system_user: %v", u.Name, err))
+			continue
+		}
+		// Never take over an account this server does not manage as SOCKS:
+		// ensureLinuxUser would reset its password.
+		if !known[login] && sc.linuxUserExists(login) {
+			step.Warnings = append(step.Warnings, fmt.Sprintf("skipped %s: linux user %s already exists and is not a SOCKS user here", u.Name, login))
+			continue
+		}
+		if err := sc.ensureLinuxUser(login, u.Password); err != nil {
+			step.Warnings = append(step.Warnings, err.Error())
+			continue
+		}
+		if i, ok := byName[u.Name]; ok {
+			current[i] = u
+			updated++
+			continue
+		}
+		byName[u.Name] = len(current)
+		known[login] = true
+		current = append(current, u)
+		added++
+	}
+	if err := sc.writeUsers(current); err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("users: %d added, %d updated", added, updated))
+	if restart && sc.installed() {
+		if err := sc.restartService(); err != nil {
+			step.Warnings = append(step.Warnings, fmt.Sprintf("restart %s: %v", sc.service, err))
+		}
+	}
+	return step
+}
+
+func migrateImportTrust(users []trustUser, restart bool) migrateStep {
+	step := migrateStep{Component: "trust"}
+	tt := newTrustClient()
+	current, err := tt.usersList()
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	byName := map[string]int{}
+	for i, u := range current {
+		byName[u.Username] = i
+	}
+	added, updated := 0, 0
+	for _, u := range users {
+		if i, ok := byName[u.Username]; ok {
+			current[i] = u
+			updated++
+			continue
+		}
+		byName[u.Username] = len(current)
+		current = append(current, u)
+		added++
+	}
+	if err := tt.writeUsers(current); err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("users: %d added, %d updated", added, updated))
+	if restart {
+		if w := trustRestartWarning(tt.service, tt.restartService()); w != "" {
+			step.Warnings = append(step.Warnings, w)
+		}
+	}
+	return step
+}
+
+func migrateImportMTProxy(src mtproxyConfig, hostMap map[string]string, restart bool) migrateStep {
+	step := migrateStep{Component: "mtproxy"}
+	mp := newMTProxyClient()
+	cfg, err := mp.loadConfig()
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	secret, _ := normalizeMTProxySecret(src.Secret)
+	cfg.Secret = secret
+	if src.Port > 0 {
+		cfg.Port = src.Port
+	}
+	if server := rewriteHost(hostMap, src.Server); server != "" {
+		cfg.Server = server
+	}
+	if err := mp.writeConfig(cfg); err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("secret restored, server %s:%d", valueOrDash(cfg.Server), cfg.Port))
+	if restart && mp.installed() {
+		if w := mtproxyRestartWarning(mp.service, mp.restartService()); w != "" {
+			step.Warnings = append(step.Warnings, w)
+		}
+	}
+	return step
+}
+
+func migrateImportAccounts(accounts []psasAccount) migrateStep {
+	step := migrateStep{Component: "accounts"}
+	current, err := loadAccounts()
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	byName := map[string]int{}
+	for i, a := range current {
+		byName[a.Name] = i
+	}
+	added, updated := 0, 0
+	for _, a := range accounts {
+		if i, ok := byName[a.Name]; ok {
+			current[i] = a
+			updated++
+			continue
+		}
+		byName[a.Name] = len(current)
+		current = append(current, a)
+		added++
+	}
+	if err := writeAccounts(current); err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Actions = append(step.Actions, fmt.Sprintf("%d added, %d updated (subscription tokens kept)", added, updated))
+	return step
+}
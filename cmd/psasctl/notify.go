@@ -0,0 +1,733 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultNotifyConfigPath = "/etc/psas/notify.json"
+	defaultNotifyOutboxPath = "/etc/psas/notify-outbox.jsonl"
+	defaultNotifyRetries    = 3
+	defaultNotifyTimeout    = 10 * time.Second
+	defaultTelegramAPIBase  = "https://api.telegram.org"
+)
+
+// Notification event types emitted by CLI commands. The agent forwards its
+// own event types (expiring, expired, quota_warning, quota_exceeded,
+// disabled, service_down, service_up) unchanged.
+const (
+	notifyUserCreated   = "user_created"
+	notifyUserUpdated   = "user_updated"
+	notifyUserDeleted   = "user_deleted"
+	notifySecretChanged = "secret_changed"
+	notifyConfigApplied = "config_applied"
+	notifyApplyFailed   = "apply_failed"
+	notifyCertExpiring  = "cert_expiring"
+	notifyCertExpired   = "cert_expired"
+	notifyCertSynced    = "cert_synced"
+	notifyTest          = "test"
+)
+
+type notifyEvent struct {
+	Type    string         `json:"type"`
+	Source  string         `json:"source"`
+	User    string         `json:"user,omitempty"`
+	UUID    string         `json:"uuid,omitempty"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+	Host    string         `json:"host"`
+	Time    string         `json:"time"`
+}
+
+// notifyConfig is /etc/psas/notify.json. Every target may restrict the
+// event types it receives; an empty list (or "*") means all events.
+type notifyConfig struct {
+	Retries  int                    `json:"retries,omitempty"`
+	Timeout  string                 `json:"timeout,omitempty"`
+	Webhooks []notifyWebhookTarget  `json:"webhooks,omitempty"`
+	Telegram []notifyTelegramTarget `json:"telegram,omitempty"`
+	SMTP     []notifySMTPTarget     `json:"smtp,omitempty"`
+}
+
+type notifyWebhookTarget struct {
+	URL     string            `json:"url"`
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Events  []string          `json:"events,omitempty"`
+}
+
+type notifyTelegramTarget struct {
+	BotToken string   `json:"bot_token"`
+	ChatID   string   `json:"chat_id"`
+	APIBase  string   `json:"api_base,omitempty"`
+	Events   []string `json:"events,omitempty"`
+}
+
+type notifySMTPTarget struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	TLS      bool     `json:"tls,omitempty"`
+	Events   []string `json:"events,omitempty"`
+}
+
+// notifyTarget is one resolved delivery destination.
+type notifyTarget struct {
+	ID     string
+	Events []string
+	send   func(ev notifyEvent) error
+}
+
+type notifyOutboxEntry struct {
+	Target    string      `json:"target"`
+	Event     notifyEvent `json:"event"`
+	Attempts  int         `json:"attempts"`
+	LastError string      `json:"last_error"`
+	QueuedAt  string      `json:"queued_at"`
+}
+
+func notifyConfigPath() string {
+	return envOr("PSAS_NOTIFY", defaultNotifyConfigPath)
+}
+
+func notifyOutboxPath() string {
+	return envOr("PSAS_NOTIFY_OUTBOX", defaultNotifyOutboxPath)
+}
+
+// loadNotifyConfig returns nil without error when notifications are not
+// configured.
+func loadNotifyConfig() (*notifyConfig, error) {
+	p := notifyConfigPath()
+	if !fileExists(p) {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(raw)) == "" {
+		return nil, nil
+	}
+	var cfg notifyConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *notifyConfig) retries() int {
+	if cfg.Retries > 0 {
+		return cfg.Retries
+	}
+	return defaultNotifyRetries
+}
+
+func (cfg *notifyConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(cfg.Timeout)); err == nil && d > 0 {
+		return d
+	}
+	return defaultNotifyTimeout
+}
+
+func (cfg *notifyConfig) targets() []notifyTarget {
+	hc := &http.Client{Timeout: cfg.timeout()}
+	var out []notifyTarget
+	for _, w := range cfg.Webhooks {
+		w := w
+		out = append(out, notifyTarget{
+			ID:     "webhook:" + w.URL,
+			Events: w.Events,
+			send:   func(ev notifyEvent) error { return sendNotifyWebhook(hc, w, ev) },
+		})
+	}
+	for _, t := range cfg.Telegram {
+		t := t
+		out = append(out, notifyTarget{
+			ID:     "telegram:" + t.ChatID,
+			Events: t.Events,
+			send:   func(ev notifyEvent) error { return sendNotifyTelegram(hc, t, ev) },
+		})
+	}
+	for _, s := range cfg.SMTP {
+		s := s
+		out = append(out, notifyTarget{
+			ID:     "smtp:" + s.Host + "/" + strings.Join(s.To, ","),
+			Events: s.Events,
+			send:   func(ev notifyEvent) error { return sendNotifySMTP(s, ev, cfg.timeout()) },
+		})
+	}
+	return out
+}
+
+func (t notifyTarget) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		e = strings.TrimSpace(e)
+		if e == "*" || strings.EqualFold(e, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify delivers ev to every configured target. It never fails the caller:
+// undeliverable events are written to the outbox and retried by
+// `notify flush` or the agent.
+func notify(ev notifyEvent) {
+	cfg, err := loadNotifyConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: notifications disabled: %v\n", err)
+		return
+	}
+	if cfg == nil {
+		return
+	}
+	ev = completeNotifyEvent(ev)
+	for _, t := range cfg.targets() {
+		if !t.wants(ev.Type) {
+			continue
+		}
+		attempts, err := deliverNotify(t, ev, cfg.retries())
+		if err == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: notification %s to %s failed: %v\n", ev.Type, notifyRedactTarget(t.ID), err)
+		entry := notifyOutboxEntry{
+			Target:    t.ID,
+			Event:     ev,
+			Attempts:  attempts,
+			LastError: err.Error(),
+			QueuedAt:  time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := appendNotifyOutbox(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: unable to write notify outbox: %v\n", err)
+		}
+	}
+}
+
+// queueNotify is notify for loops over many users (bulk actions, imports)
+// and for long-running services (API server, agent): it only writes ev to
+// the outbox for each target, so an unreachable target cannot stall the
+// caller. Callers deliver the batch with flushQueuedNotifications (or
+// flushNotifyOutbox) once the loop or request is done.
+func queueNotify(ev notifyEvent) {
+	cfg, err := loadNotifyConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: notifications disabled: %v\n", err)
+		return
+	}
+	if cfg == nil {
+		return
+	}
+	ev = completeNotifyEvent(ev)
+	for _, t := range cfg.targets() {
+		if !t.wants(ev.Type) {
+			continue
+		}
+		entry := notifyOutboxEntry{Target: t.ID, Event: ev, QueuedAt: time.Now().UTC().Format(time.RFC3339)}
+		if err := appendNotifyOutbox(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: unable to write notify outbox: %v\n", err)
+		}
+	}
+}
+
+// flushQueuedNotifications makes one delivery pass over the outbox after a
+// batch of queueNotify calls; what fails stays queued for the agent or
+// `notify flush`.
+func flushQueuedNotifications() {
+	_, failed, _, err := flushNotifyOutbox()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: notify flush: %v\n", err)
+	} else if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d notification(s) not delivered, queued in %s\n", failed, notifyOutboxPath())
+	}
+}
+
+func completeNotifyEvent(ev notifyEvent) notifyEvent {
+	if ev.Time == "" {
+		ev.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	if ev.Host == "" {
+		ev.Host, _ = os.Hostname()
+	}
+	if ev.Message == "" {
+		ev.Message = ev.Type
+		if ev.User != "" {
+			ev.Message += ": " + ev.User
+		}
+	}
+	return ev
+}
+
+// deliverNotify tries t up to retries times with exponential backoff and
+// returns the number of attempts made.
+func deliverNotify(t notifyTarget, ev notifyEvent, retries int) (int, error) {
+	var err error
+	delay := 500 * time.Millisecond
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = t.send(ev); err == nil {
+			return attempt, nil
+		}
+		if attempt < retries {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return retries, err
+}
+
+func sendNotifyWebhook(hc *http.Client, w notifyWebhookTarget, ev notifyEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "psasctl")
+	req.Header.Set("X-PSAS-Event", ev.Type)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.Secret != "" {
+		req.Header.Set("X-PSAS-Signature", notifySignature(w.Secret, body))
+	}
+	return doNotifyRequest(hc, req)
+}
+
+// notifySignature is the value of X-PSAS-Signature: HMAC-SHA256 of the raw
+// request body keyed with the webhook secret.
+func notifySignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendNotifyTelegram(hc *http.Client, t notifyTelegramTarget, ev notifyEvent) error {
+	if strings.TrimSpace(t.BotToken) == "" || strings.TrimSpace(t.ChatID) == "" {
+		return errors.New("telegram target requires bot_token and chat_id")
+	}
+	base := strings.TrimRight(strings.TrimSpace(t.APIBase), "/")
+	if base == "" {
+		base = defaultTelegramAPIBase
+	}
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  t.ChatID,
+		"text":                     notifyText(ev),
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/bot"+t.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotifyRequest(hc, req)
+}
+
+func doNotifyRequest(hc *http.Client, req *http.Request) error {
+	resp, err := hc.Do(req)
+	if err != nil {
+		// Do not leak bot tokens embedded in the URL into logs and the outbox.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return fmt.Errorf("%s: %w", uerr.Op, uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func sendNotifySMTP(s notifySMTPTarget, ev notifyEvent, timeout time.Duration) error {
+	if strings.TrimSpace(s.Host) == "" || strings.TrimSpace(s.From) == "" || len(s.To) == 0 {
+		return errors.New("smtp target requires host, from and to")
+	}
+	port := s.Port
+	if port == 0 {
+		port = 587
+		if s.TLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if s.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if !s.TLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(strings.TrimSpace(to)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	subject := "[PSAS] " + ev.Type
+	if ev.User != "" {
+		subject += ": " + ev.User
+	}
+	var msg strings.Builder
+	msg.WriteString("From: " + s.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(s.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(notifyText(ev), "\n", "\r\n") + "\r\n")
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// notifyText renders ev for human channels (Telegram, e-mail).
+func notifyText(ev notifyEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[PSAS %s] %s\n", ev.Host, ev.Message)
+	fmt.Fprintf(&b, "event: %s (%s)\n", ev.Type, ev.Source)
+	if ev.User != "" {
+		fmt.Fprintf(&b, "user: %s\n", ev.User)
+	}
+	if ev.UUID != "" {
+		fmt.Fprintf(&b, "uuid: %s\n", ev.UUID)
+	}
+	if len(ev.Details) > 0 {
+		if raw, err := json.Marshal(ev.Details); err == nil {
+			fmt.Fprintf(&b, "details: %s\n", raw)
+		}
+	}
+	fmt.Fprintf(&b, "time: %s", ev.Time)
+	return b.String()
+}
+
+func loadNotifyOutbox() ([]notifyOutboxEntry, error) {
+	p := notifyOutboxPath()
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var out []notifyOutboxEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var e notifyOutboxEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p, err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+// The outbox is appended to by every process that emits events and rewritten
+// by flushers. Appends and rewrites hold the state lock of the file. A flush
+// also holds PATH.flush for its whole run, so two flushers never send the
+// same entries, while appends only wait for the short rewrite and never for
+// deliveries.
+
+func appendNotifyOutbox(e notifyOutboxEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p := notifyOutboxPath()
+	return withStateLock(p, func() error {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// writeNotifyOutbox replaces the outbox; the caller holds its state lock.
+func writeNotifyOutbox(entries []notifyOutboxEntry) error {
+	p := notifyOutboxPath()
+	if len(entries) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return replaceFile(p, buf.Bytes(), 0o600, true)
+}
+
+// clearNotifyOutbox drops every queued entry.
+func clearNotifyOutbox() error {
+	p := notifyOutboxPath()
+	return withStateLock(p+".flush", func() error {
+		return withStateLock(p, func() error { return writeNotifyOutbox(nil) })
+	})
+}
+
+// flushNotifyOutbox retries queued events and keeps the ones that still
+// fail. Each target is tried until its first failure in this pass; its
+// remaining entries wait for the next flush, so a dead target costs one
+// timeout rather than one per entry. Entries for targets removed from
+// notify.json are dropped.
+func flushNotifyOutbox() (sent, failed, dropped int, err error) {
+	p := notifyOutboxPath()
+	err = withStateLock(p+".flush", func() error {
+		var entries []notifyOutboxEntry
+		if err := withStateLock(p, func() (err error) {
+			entries, err = loadNotifyOutbox()
+			return err
+		}); err != nil || len(entries) == 0 {
+			return err
+		}
+		cfg, err := loadNotifyConfig()
+		if err != nil {
+			return err
+		}
+		byID := map[string]notifyTarget{}
+		if cfg != nil {
+			for _, t := range cfg.targets() {
+				byID[t.ID] = t
+			}
+		}
+		down := map[string]bool{}
+		var keep []notifyOutboxEntry
+		for _, e := range entries {
+			t, ok := byID[e.Target]
+			if !ok {
+				dropped++
+				continue
+			}
+			if down[e.Target] {
+				keep = append(keep, e)
+				failed++
+				continue
+			}
+			if err := t.send(e.Event); err != nil {
+				e.Attempts++
+				e.LastError = err.Error()
+				keep = append(keep, e)
+				down[e.Target] = true
+				failed++
+				continue
+			}
+			sent++
+		}
+		return withStateLock(p, func() error {
+			// Only appends can have happened since the load, so everything
+			// past the entries handled here is new and is kept as is.
+			current, err := loadNotifyOutbox()
+			if err != nil {
+				return err
+			}
+			if len(current) > len(entries) {
+				keep = append(keep, current[len(entries):]...)
+			}
+			return writeNotifyOutbox(keep)
+		})
+	})
+	return sent, failed, dropped, err
+}
+
+func runNotify(args []string) {
+	if len(args) < 1 {
+		fatalf("notify requires subcommand: status|test|outbox|flush")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "status":
+		fs := flag.NewFlagSet("notify status", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		cfg, err := loadNotifyConfig()
+		must(err)
+		entries, err := loadNotifyOutbox()
+		must(err)
+		var ids []string
+		if cfg != nil {
+			for _, t := range cfg.targets() {
+				ids = append(ids, t.ID)
+			}
+		}
+		if *jsonOut {
+			printJSON(map[string]any{
+				"config":  notifyConfigPath(),
+				"enabled": cfg != nil,
+				"targets": notifyRedactTargets(ids),
+				"outbox":  len(entries),
+			})
+			return
+		}
+		fmt.Printf("Config : %s\n", notifyConfigPath())
+		if cfg == nil {
+			fmt.Println("Notifications are not configured.")
+		}
+		for _, id := range notifyRedactTargets(ids) {
+			fmt.Printf("Target : %s\n", id)
+		}
+		fmt.Printf("Outbox : %d queued (%s)\n", len(entries), notifyOutboxPath())
+	case "test":
+		fs := flag.NewFlagSet("notify test", flag.ExitOnError)
+		message := fs.String("message", "PSAS test notification", "message text")
+		must(fs.Parse(subArgs))
+		cfg, err := loadNotifyConfig()
+		must(err)
+		if cfg == nil {
+			fatalf("notifications are not configured: %s", notifyConfigPath())
+		}
+		ev := completeNotifyEvent(notifyEvent{Type: notifyTest, Source: "psasctl", Message: *message})
+		failed := 0
+		for _, t := range cfg.targets() {
+			if err := t.send(ev); err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %v\n", notifyRedactTarget(t.ID), err)
+				continue
+			}
+			fmt.Printf("OK   %s\n", notifyRedactTarget(t.ID))
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	case "outbox":
+		fs := flag.NewFlagSet("notify outbox", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		clear := fs.Bool("clear", false, "drop all queued notifications")
+		must(fs.Parse(subArgs))
+		if *clear {
+			must(requireRoot("notify outbox --clear"))
+			must(clearNotifyOutbox())
+			fmt.Println("Outbox cleared.")
+			return
+		}
+		entries, err := loadNotifyOutbox()
+		must(err)
+		if *jsonOut {
+			printJSON(entries)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "QUEUED\tTARGET\tEVENT\tUSER\tATTEMPTS\tLAST_ERROR")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", e.QueuedAt, notifyRedactTarget(e.Target), e.Event.Type, valueOrDash(e.Event.User), e.Attempts, e.LastError)
+		}
+		_ = tw.Flush()
+	case "flush", "retry":
+		fs := flag.NewFlagSet("notify flush", flag.ExitOnError)
+		must(fs.Parse(subArgs))
+		sent, failed, dropped, err := flushNotifyOutbox()
+		must(err)
+		fmt.Printf("Sent: %d, still queued: %d, dropped: %d\n", sent, failed, dropped)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		fatalf("unknown notify subcommand: %s", sub)
+	}
+}
+
+// notifyRedactTarget hides credentials that may be part of a webhook URL.
+func notifyRedactTarget(id string) string {
+	const prefix = "webhook:"
+	if !strings.HasPrefix(id, prefix) {
+		return id
+	}
+	raw := strings.TrimPrefix(id, prefix)
+	if i := strings.Index(raw, "?"); i >= 0 {
+		raw = raw[:i] + "?..."
+	}
+	return prefix + raw
+}
+
+func notifyRedactTargets(ids []string) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, notifyRedactTarget(id))
+	}
+	return out
+}
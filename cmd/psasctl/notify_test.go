@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebhook records POSTed events and fails the first failures requests.
+type fakeWebhook struct {
+	srv      *httptest.Server
+	mu       sync.Mutex
+	failures int
+	hits     int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newFakeWebhook(t *testing.T, failures int) *fakeWebhook {
+	f := &fakeWebhook{failures: failures}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.hits++
+		if f.hits <= f.failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		f.bodies = append(f.bodies, body)
+		f.headers = append(f.headers, r.Header.Clone())
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeWebhook) received() ([][]byte, []http.Header, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies, f.headers, f.hits
+}
+
+// setupNotify points notify.json and the outbox at a temp directory.
+func setupNotify(t *testing.T, cfg notifyConfig) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notify.json")
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PSAS_NOTIFY", path)
+	t.Setenv("PSAS_NOTIFY_OUTBOX", filepath.Join(dir, "outbox.jsonl"))
+}
+
+func loadTestOutbox(t *testing.T) []notifyOutboxEntry {
+	t.Helper()
+	entries, err := loadNotifyOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestNotifyWebhookSignature(t *testing.T) {
+	hook := newFakeWebhook(t, 0)
+	setupNotify(t, notifyConfig{Webhooks: []notifyWebhookTarget{{
+		URL:     hook.srv.URL,
+		Secret:  "s3cret",
+		Headers: map[string]string{"X-Extra": "1"},
+	}}})
+
+	notify(notifyEvent{Type: notifyUserCreated, Source: "test", User: "alice"})
+
+	bodies, headers, _ := hook.received()
+	if len(bodies) != 1 {
+		t.Fatalf("webhook got %d events, want 1", len(bodies))
+	}
+	if got, want := headers[0].Get("X-PSAS-Signature"), notifySignature("s3cret", bodies[0]); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(headers[0].Get("X-PSAS-Signature"), "sha256=") {
+		t.Fatalf("signature = %q, want sha256= prefix", headers[0].Get("X-PSAS-Signature"))
+	}
+	if headers[0].Get("X-PSAS-Event") != notifyUserCreated || headers[0].Get("X-Extra") != "1" {
+		t.Fatalf("headers = %v", headers[0])
+	}
+	var ev notifyEvent
+	if err := json.Unmarshal(bodies[0], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.User != "alice" || ev.Host == "" || ev.Time == "" || ev.Message != "user_created: alice" {
+		t.Fatalf("event = %+v", ev)
+	}
+}
+
+func TestNotifySignatureKnownValue(t *testing.T) {
+	// echo -n '{"type":"test"}' | openssl dgst -sha256 -hmac key
+	const want = "sha256=333dc789972821241d1c6dfc067c3af4270ea975317f38c3760be69ba4c55de7"
+	if got := notifySignature("key", []byte(`{"type":"test"}`)); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestNotifyRetriesThenSucceeds(t *testing.T) {
+	hook := newFakeWebhook(t, 1)
+	setupNotify(t, notifyConfig{Retries: 2, Webhooks: []notifyWebhookTarget{{URL: hook.srv.URL}}})
+
+	notify(notifyEvent{Type: notifyUserDeleted, Source: "test", User: "bob"})
+
+	bodies, _, hits := hook.received()
+	if hits != 2 || len(bodies) != 1 {
+		t.Fatalf("hits = %d, delivered = %d; want 2, 1", hits, len(bodies))
+	}
+	if entries := loadTestOutbox(t); len(entries) != 0 {
+		t.Fatalf("outbox = %+v, want empty", entries)
+	}
+}
+
+func TestNotifyQueuesAfterRetriesAndFlushDrains(t *testing.T) {
+	hook := newFakeWebhook(t, 2)
+	setupNotify(t, notifyConfig{Retries: 2, Webhooks: []notifyWebhookTarget{{URL: hook.srv.URL}}})
+
+	notify(notifyEvent{Type: notifyUserCreated, Source: "test", User: "carol"})
+
+	entries := loadTestOutbox(t)
+	if len(entries) != 1 {
+		t.Fatalf("outbox has %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Target != "webhook:"+hook.srv.URL || e.Attempts != 2 || !strings.Contains(e.LastError, "HTTP 503") || e.Event.User != "carol" {
+		t.Fatalf("outbox entry = %+v", e)
+	}
+
+	sent, failed, dropped, err := flushNotifyOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 || failed != 0 || dropped != 0 {
+		t.Fatalf("flush = %d sent, %d failed, %d dropped; want 1, 0, 0", sent, failed, dropped)
+	}
+	if entries := loadTestOutbox(t); len(entries) != 0 {
+		t.Fatalf("outbox after flush = %+v", entries)
+	}
+	if _, err := os.Stat(notifyOutboxPath()); !os.IsNotExist(err) {
+		t.Fatalf("empty outbox file left behind: %v", err)
+	}
+	bodies, _, _ := hook.received()
+	var ev notifyEvent
+	if len(bodies) != 1 || json.Unmarshal(bodies[0], &ev) != nil || ev.User != "carol" {
+		t.Fatalf("delivered = %q", bodies)
+	}
+}
+
+func TestNotifyFlushKeepsFailuresAndDropsRemovedTargets(t *testing.T) {
+	down := newFakeWebhook(t, 100)
+	setupNotify(t, notifyConfig{Webhooks: []notifyWebhookTarget{{URL: down.srv.URL}}})
+	for _, user := range []string{"a", "b", "c"} {
+		queueNotify(notifyEvent{Type: notifyUserCreated, Source: "test", User: user})
+	}
+	if err := appendNotifyOutbox(notifyOutboxEntry{Target: "webhook:http://removed.invalid", Event: notifyEvent{Type: notifyTest}}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, failed, dropped, err := flushNotifyOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 0 || failed != 3 || dropped != 1 {
+		t.Fatalf("flush = %d sent, %d failed, %d dropped; want 0, 3, 1", sent, failed, dropped)
+	}
+	// A dead target is tried once per pass, not once per entry.
+	if _, _, hits := down.received(); hits != 1 {
+		t.Fatalf("dead target hit %d times, want 1", hits)
+	}
+	entries := loadTestOutbox(t)
+	if len(entries) != 3 || entries[0].Attempts != 1 || entries[1].Attempts != 0 || entries[2].Event.User != "c" {
+		t.Fatalf("outbox = %+v", entries)
+	}
+}
+
+func TestNotifyTelegramTarget(t *testing.T) {
+	tg := newFakeTelegram(t)
+	setupNotify(t, notifyConfig{Telegram: []notifyTelegramTarget{{
+		BotToken: testBotToken,
+		ChatID:   "-10042",
+		APIBase:  tg.srv.URL + "/",
+		Events:   []string{notifyUserCreated},
+	}}})
+
+	notify(notifyEvent{Type: notifyUserDeleted, Source: "test", User: "skipped"})
+	notify(notifyEvent{Type: notifyUserCreated, Source: "test", User: "dave", UUID: "u-1"})
+
+	msgs := tg.sent("sendMessage")
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1 (events filter)", len(msgs))
+	}
+	text, _ := msgs[0].Body["text"].(string)
+	if msgs[0].Body["chat_id"] != "-10042" || !strings.Contains(text, "user: dave") || !strings.Contains(text, "uuid: u-1") {
+		t.Fatalf("sendMessage = %v", msgs[0].Body)
+	}
+
+	// A rejected token is queued without leaking it into the outbox.
+	setupNotify(t, notifyConfig{Retries: 1, Telegram: []notifyTelegramTarget{{BotToken: "wrong", ChatID: "1", APIBase: tg.srv.URL}}})
+	notify(notifyEvent{Type: notifyTest, Source: "test"})
+	entries := loadTestOutbox(t)
+	if len(entries) != 1 || entries[0].Target != "telegram:1" || !strings.Contains(entries[0].LastError, "HTTP 401") {
+		t.Fatalf("outbox = %+v", entries)
+	}
+	if strings.Contains(entries[0].LastError, "wrong") {
+		t.Fatalf("outbox leaks the bot token: %q", entries[0].LastError)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const defaultPlansPath = "/etc/psas/plans.json"
+
+var planNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// userPlan is a named users add template. Unlimited flags win over Days/GB;
+// TrueUnlimited makes the unlimited dimensions use the patched Hiddify logic.
+type userPlan struct {
+	Name          string  `json:"name"`
+	Description   string  `json:"description,omitempty"`
+	Days          int     `json:"days,omitempty"`
+	GB            float64 `json:"gb,omitempty"`
+	Mode          string  `json:"mode,omitempty"`
+	UnlimitedDays bool    `json:"unlimited_days,omitempty"`
+	UnlimitedGB   bool    `json:"unlimited_gb,omitempty"`
+	TrueUnlimited bool    `json:"true_unlimited,omitempty"`
+}
+
+func plansPath() string {
+	return envOr("PSAS_PLANS", defaultPlansPath)
+}
+
+func loadUserPlans() ([]userPlan, error) {
+	p := plansPath()
+	raw, err := loadStateFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(raw)) == "" {
+		return []userPlan{}, nil
+	}
+	var plans []userPlan
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	return plans, nil
+}
+
+func writeUserPlans(plans []userPlan) error {
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	payload, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := plansPath()
+	return saveStateFile(p, append(payload, '\n'), 0o600)
+}
+
+func findUserPlan(plans []userPlan, name string) (userPlan, int, error) {
+	key := strings.TrimSpace(name)
+	for i, p := range plans {
+		if strings.EqualFold(p.Name, key) {
+			return p, i, nil
+		}
+	}
+	return userPlan{}, -1, fmt.Errorf("plan not found: %s", key)
+}
+
+func loadUserPlan(name string) (userPlan, error) {
+	plans, err := loadUserPlans()
+	if err != nil {
+		return userPlan{}, err
+	}
+	p, _, err := findUserPlan(plans, name)
+	return p, err
+}
+
+func (p userPlan) validate() error {
+	if !planNameRe.MatchString(p.Name) {
+		return fmt.Errorf("invalid plan name %q (allowed: A-Z a-z 0-9 . _ -)", p.Name)
+	}
+	if p.Mode != "" && !isValidMode(p.Mode) {
+		return fmt.Errorf("invalid mode: %s", p.Mode)
+	}
+	if !p.UnlimitedDays && p.Days < 1 {
+		return errors.New("plan days must be >= 1 (or use --unlimited-days)")
+	}
+	if !p.UnlimitedGB && p.GB <= 0 {
+		return errors.New("plan gb must be > 0 (or use --unlimited-gb)")
+	}
+	return nil
+}
+
+func (p userPlan) packageDays() int {
+	if p.UnlimitedDays {
+		return unlimitedPackageDays
+	}
+	return p.Days
+}
+
+func (p userPlan) usageLimitGB() float64 {
+	if p.UnlimitedGB {
+		return unlimitedUsageGB
+	}
+	return p.GB
+}
+
+func (p userPlan) needsTrueUnlimited() bool {
+	return p.TrueUnlimited && (p.UnlimitedDays || p.UnlimitedGB)
+}
+
+func (p userPlan) summary() string {
+	days := fmt.Sprintf("%dd", p.Days)
+	if p.UnlimitedDays {
+		days = "unlimited days"
+	}
+	gb := fmt.Sprintf("%g GB", p.GB)
+	if p.UnlimitedGB {
+		gb = "unlimited GB"
+	}
+	mode := p.Mode
+	if mode == "" {
+		mode = "no_reset"
+	}
+	out := days + ", " + gb + ", " + mode
+	if p.needsTrueUnlimited() {
+		out += ", true-unlimited"
+	}
+	return out
+}
+
+func runPlans(args []string) {
+	if len(args) < 1 {
+		fatalf("plans requires subcommand: list|add|del")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "list", "ls":
+		fs := flag.NewFlagSet("plans list", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("plans list takes no positional args")
+		}
+		plans, err := loadUserPlans()
+		must(err)
+		if *jsonOut {
+			printJSON(plans)
+			return
+		}
+		printUserPlans(plans)
+	case "add", "set":
+		fs := flag.NewFlagSet("plans add", flag.ExitOnError)
+		name := fs.String("name", "", "plan name")
+		description := fs.String("description", "", "plan description")
+		days := fs.Int("days", 30, "package days")
+		gb := fs.Float64("gb", 100, "usage limit in GB")
+		mode := fs.String("mode", "no_reset", "user mode: no_reset|daily|weekly|monthly")
+		unlimited := fs.Bool("unlimited", false, "practically unlimited traffic and time")
+		unlimitedDays := fs.Bool("unlimited-days", false, fmt.Sprintf("package days = %d", unlimitedPackageDays))
+		unlimitedGB := fs.Bool("unlimited-gb", false, fmt.Sprintf("usage limit = %.0f GB", unlimitedUsageGB))
+		trueUnlimited := fs.Bool("true-unlimited", false, "truly unlimited traffic and time (auto-patches Hiddify on first use)")
+		replace := fs.Bool("replace", false, "overwrite existing plan with the same name")
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("plans add takes only flags")
+		}
+		must(requireRoot("plans add"))
+
+		p := userPlan{
+			Name:          strings.TrimSpace(*name),
+			Description:   strings.TrimSpace(*description),
+			Days:          *days,
+			GB:            *gb,
+			Mode:          strings.TrimSpace(*mode),
+			UnlimitedDays: *unlimited || *unlimitedDays || *trueUnlimited,
+			UnlimitedGB:   *unlimited || *unlimitedGB || *trueUnlimited,
+			TrueUnlimited: *trueUnlimited,
+		}
+		if p.UnlimitedDays {
+			p.Days = 0
+		}
+		if p.UnlimitedGB {
+			p.GB = 0
+		}
+		must(p.validate())
+
+		plans, err := loadUserPlans()
+		must(err)
+		if _, idx, err := findUserPlan(plans, p.Name); err == nil {
+			if !*replace {
+				fatalf("plan already exists: %s (use --replace)", p.Name)
+			}
+			plans[idx] = p
+		} else {
+			plans = append(plans, p)
+		}
+		must(writeUserPlans(plans))
+		if *jsonOut {
+			printJSON(p)
+			return
+		}
+		fmt.Printf("Plan saved: %s (%s)\n", p.Name, p.summary())
+	case "del", "delete", "rm":
+		if len(subArgs) != 1 {
+			fatalf("plans del requires NAME")
+		}
+		must(requireRoot("plans del"))
+		plans, err := loadUserPlans()
+		must(err)
+		p, idx, err := findUserPlan(plans, subArgs[0])
+		must(err)
+		plans = append(plans[:idx], plans[idx+1:]...)
+		must(writeUserPlans(plans))
+		fmt.Printf("Plan deleted: %s\n", p.Name)
+	default:
+		fatalf("unknown plans subcommand: %s", sub)
+	}
+}
+
+func printUserPlans(plans []userPlan) {
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDAYS\tGB\tMODE\tTRUE_UNLIMITED\tDESCRIPTION")
+	for _, p := range plans {
+		days := fmt.Sprintf("%d", p.Days)
+		if p.UnlimitedDays {
+			days = "unlimited"
+		}
+		gb := fmt.Sprintf("%g", p.GB)
+		if p.UnlimitedGB {
+			gb = "unlimited"
+		}
+		mode := p.Mode
+		if mode == "" {
+			mode = "no_reset"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\n", p.Name, days, gb, mode, p.needsTrueUnlimited(), p.Description)
+	}
+	_ = tw.Flush()
+}
+
+func uiSelectPlan(in *bufio.Reader) (userPlan, bool, error) {
+	plans, err := loadUserPlans()
+	if err != nil || len(plans) == 0 {
+		return userPlan{}, false, err
+	}
+	options := []uiOption{{Value: "", Title: uiText("No plan"), Hint: uiText("Enter limits manually")}}
+	for _, p := range plans {
+		hint := p.summary()
+		if p.Description != "" {
+			hint = p.Description + " (" + hint + ")"
+		}
+		options = append(options, uiOption{Value: p.Name, Title: p.Name, Hint: hint})
+	}
+	choice, err := uiSelectOptionValue(uiText("Select plan"), options, 0, in)
+	if err != nil {
+		return userPlan{}, false, err
+	}
+	if choice == "" {
+		return userPlan{}, false, nil
+	}
+	p, _, err := findUserPlan(plans, choice)
+	if err != nil {
+		return userPlan{}, false, err
+	}
+	return p, true, nil
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"strings"
+)
+
+// Minimal QR code encoder (ISO/IEC 18004, byte mode only). Links and proxy URIs
+// are short ASCII strings, so byte mode with automatic version selection is
+// all we need; keeping it in-tree avoids a third-party dependency.
+
+type qrECLevel int
+
+const (
+	qrECLow qrECLevel = iota
+	qrECMedium
+	qrECQuartile
+	qrECHigh
+)
+
+// qrFormatBits are the 2-bit level indicators used in the format information.
+var qrFormatBits = [4]int{1, 0, 3, 2}
+
+// Indexed by level then version (index 0 unused).
+var qrECCCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrNumECCBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrCode is an encoded symbol; Modules[y][x] is true for dark modules.
+type qrCode struct {
+	Version  int
+	Size     int
+	Modules  [][]bool
+	function [][]bool
+}
+
+// qrEncode encodes text with the smallest version that fits at the given level.
+func qrEncode(text string, level qrECLevel) (*qrCode, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v <= 40; v++ {
+		ccBits := 8
+		if v >= 10 {
+			ccBits = 16
+		}
+		if len(data) >= 1<<ccBits {
+			continue
+		}
+		if 4+ccBits+8*len(data) <= qrNumDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("data too long for a QR code")
+	}
+
+	var bb qrBitBuffer
+	bb.append(0x4, 4)
+	if version >= 10 {
+		bb.append(len(data), 16)
+	} else {
+		bb.append(len(data), 8)
+	}
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := qrNumDataCodewords(version, level) * 8
+	terminator := capacity - len(bb)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+	codewords := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+
+	q := &qrCode{Version: version, Size: version*4 + 17}
+	q.Modules = make([][]bool, q.Size)
+	q.function = make([][]bool, q.Size)
+	for i := range q.Modules {
+		q.Modules[i] = make([]bool, q.Size)
+		q.function[i] = make([]bool, q.Size)
+	}
+	q.drawFunctionPatterns(level)
+	q.drawCodewords(q.addECCAndInterleave(codewords, level))
+
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(level, mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			bestMask, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(bestMask)
+	q.drawFormatBits(level, bestMask)
+	q.function = nil
+	return q, nil
+}
+
+type qrBitBuffer []bool
+
+func (bb *qrBitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, (val>>uint(i))&1 != 0)
+	}
+}
+
+func qrNumRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func qrNumDataCodewords(version int, level qrECLevel) int {
+	return qrNumRawDataModules(version)/8 - qrECCCodewordsPerBlock[level][version]*qrNumECCBlocks[level][version]
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.Modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFunctionPatterns(level qrECLevel) {
+	for i := 0; i < q.Size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(q.Size-4, 3)
+	q.drawFinder(3, q.Size-4)
+
+	pos := qrAlignmentPositions(q.Version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(pos[i]+dx, pos[j]+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve format areas now; real bits are drawn once the mask is chosen.
+	q.drawFormatBits(level, 0)
+	if q.Version >= 7 {
+		rem := q.Version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := q.Version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := q.Size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (q *qrCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+				continue
+			}
+			dist := qrMax(qrAbs(dx), qrAbs(dy))
+			q.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *qrCode) drawFormatBits(level qrECLevel, mask int) {
+	data := qrFormatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(i))
+	}
+	q.setFunction(8, q.Size-8, true)
+}
+
+func (q *qrCode) addECCAndInterleave(data []byte, level qrECLevel) []byte {
+	numBlocks := qrNumECCBlocks[level][q.Version]
+	eccLen := qrECCCodewordsPerBlock[level][q.Version]
+	rawCodewords := qrNumRawDataModules(q.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := qrRSDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		n := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			n++
+		}
+		dat := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := qrRSRemainder(dat, divisor)
+		if i < numShortBlocks {
+			dat = append(dat, 0)
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.Modules[y][x] = (data[i>>3]>>(7-uint(i&7)))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.Modules[y][x] = !q.Modules[y][x]
+			}
+		}
+	}
+}
+
+// qrFinderLike are the 1:1:3:1:1 patterns with a light margin on one side.
+var qrFinderLike = [2][11]bool{
+	{false, false, false, false, true, false, true, true, true, false, true},
+	{true, false, true, true, true, false, true, false, false, false, false},
+}
+
+// penalty scores a masked symbol with the four rules from the specification.
+func (q *qrCode) penalty() int {
+	n := q.Size
+	at := func(x, y int, rows bool) bool {
+		if rows {
+			return q.Modules[y][x]
+		}
+		return q.Modules[x][y]
+	}
+	result := 0
+	for _, rows := range []bool{true, false} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x < n; x++ {
+				if at(x, y, rows) == at(x-1, y, rows) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+			if run >= 5 {
+				result += 3 + run - 5
+			}
+			for x := 0; x+len(qrFinderLike[0]) <= n; x++ {
+				for _, pattern := range qrFinderLike {
+					match := true
+					for k, want := range pattern {
+						if at(x+k, y, rows) != want {
+							match = false
+							break
+						}
+					}
+					if match {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.Modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.Modules[y][x]
+				if c == q.Modules[y][x+1] && c == q.Modules[y+1][x] && c == q.Modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+	total := n * n
+	k := (qrAbs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+	return result
+}
+
+func qrRSDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			result[j] = qrGFMul(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrGFMul(root, 0x02)
+	}
+	return result
+}
+
+func qrRSRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= qrGFMul(d, factor)
+		}
+	}
+	return result
+}
+
+func qrGFMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func qrAbs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// qrQuietZone is the standard 4-module white border around the symbol.
+const qrQuietZone = 4
+
+// image renders the symbol with `scale` pixels per module and a quiet zone.
+func (q *qrCode) image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	dim := (q.Size + 2*qrQuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, dim, dim))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if !q.Modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+qrQuietZone)*scale+dx, (y+qrQuietZone)*scale+dy, color.Gray{Y: 0})
+				}
+			}
+		}
+	}
+	return img
+}
+
+// qrPNG encodes text as a PNG image.
+func qrPNG(text string, scale int) ([]byte, error) {
+	q, err := qrEncode(text, qrECMedium)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, q.image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qrTerminalQuietZone is narrower than the standard border to save screen space;
+// phone scanners handle it fine on a terminal background.
+const qrTerminalQuietZone = 2
+
+// terminal renders the symbol with upper-half blocks, two modules per text row,
+// forcing black-on-white colours so it scans on dark terminals too.
+func (q *qrCode) terminal() string {
+	dark := func(x, y int) bool {
+		x -= qrTerminalQuietZone
+		y -= qrTerminalQuietZone
+		return x >= 0 && y >= 0 && x < q.Size && y < q.Size && q.Modules[y][x]
+	}
+	dim := q.Size + 2*qrTerminalQuietZone
+	var b strings.Builder
+	for y := 0; y < dim; y += 2 {
+		for x := 0; x < dim; x++ {
+			fg, bg := 97, 107
+			if dark(x, y) {
+				fg = 30
+			}
+			if dark(x, y+1) {
+				bg = 40
+			}
+			fmt.Fprintf(&b, "\x1b[%d;%dm\u2580", fg, bg)
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// svg renders the symbol as a standalone SVG document.
+func (q *qrCode) svg(scale int) string {
+	if scale < 1 {
+		scale = 1
+	}
+	dim := q.Size + 2*qrQuietZone
+	var path strings.Builder
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.Modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", dim*scale, dim*scale, dim, dim)
+	b.WriteString(`<rect width="100%" height="100%" fill="#FFFFFF"/>` + "\n")
+	fmt.Fprintf(&b, `<path d="%s" fill="#000000"/>`+"\n", path.String())
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// writeQRFile writes text as a QR image; the format follows the extension
+// (.png or .svg).
+func writeQRFile(path, text string) error {
+	q, err := qrEncode(text, qrECMedium)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, q.image(bundleQRScale)); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	case ".svg":
+		data = []byte(q.svg(bundleQRScale))
+	default:
+		return fmt.Errorf("unsupported QR file extension %q (expected .png or .svg)", filepath.Ext(path))
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func printQRTerminal(title, text string) error {
+	q, err := qrEncode(text, qrECMedium)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println(title)
+	fmt.Print(q.terminal())
+	return nil
+}
+
+// qrFlags are the shared --qr/--qr-out flags of commands that print links.
+type qrFlags struct {
+	show *bool
+	out  *string
+}
+
+func bindQRFlags(fs *flag.FlagSet) qrFlags {
+	return qrFlags{
+		show: fs.Bool("qr", false, "print a QR code in the terminal"),
+		out:  fs.String("qr-out", "", "write the QR code to a .png or .svg file"),
+	}
+}
+
+func (f qrFlags) requested() bool {
+	return *f.show || strings.TrimSpace(*f.out) != ""
+}
+
+// emit writes --qr-out and, unless JSON output was requested, prints the
+// terminal QR. It returns the written file path, if any.
+func (f qrFlags) emit(title, text string, jsonOut bool) (string, error) {
+	out := strings.TrimSpace(*f.out)
+	if out != "" {
+		if err := writeQRFile(out, text); err != nil {
+			return "", err
+		}
+	}
+	if *f.show && !jsonOut {
+		if err := printQRTerminal(title, text); err != nil {
+			return out, err
+		}
+	}
+	if out != "" && !jsonOut {
+		fmt.Printf("QR saved to: %s\n", out)
+	}
+	return out, nil
+}
+
+// linkSetValue picks one of the generated links by name for QR output.
+func linkSetValue(l linkSet, name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return l.Auto, nil
+	case "sub64":
+		return l.Sub64, nil
+	case "sub":
+		return l.Sub, nil
+	case "singbox", "sing-box":
+		return l.Singbox, nil
+	case "panel":
+		return l.Panel, nil
+	default:
+		return "", fmt.Errorf("unknown link %q (expected auto|sub64|sub|singbox|panel)", name)
+	}
+}
+
+// uiOfferQR asks whether to show a QR code and, when several links are
+// available, which one.
+func uiOfferQR(in *bufio.Reader, options []uiOption) error {
+	if len(options) == 0 {
+		return nil
+	}
+	show, err := promptYesNo(in, "Show QR code?", false)
+	if err != nil || !show {
+		return err
+	}
+	idx := 0
+	if len(options) > 1 {
+		choice, err := uiSelectOptionValue(uiText("Select link for QR code"), options, 0, in)
+		if err != nil {
+			return err
+		}
+		for i, o := range options {
+			if o.Value == choice {
+				idx = i
+			}
+		}
+	}
+	return printQRTerminal(uiText(options[idx].Title), options[idx].Value)
+}
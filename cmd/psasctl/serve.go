@@ -0,0 +1,1247 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultAPIListen    = "127.0.0.1:8787"
+	defaultAPITokenPath = "/etc/psas/api-token"
+	defaultAPIUnitPath  = "/etc/systemd/system/psas-api.service"
+	apiVersion          = "v1"
+	apiPrefix           = "/api/" + apiVersion
+)
+
+// apiError carries the HTTP status for an error returned by a handler.
+type apiError struct {
+	Status int
+	Err    error
+}
+
+func (e *apiError) Error() string { return e.Err.Error() }
+
+func apiBadRequest(err error) error { return &apiError{Status: http.StatusBadRequest, Err: err} }
+func apiNotFound(err error) error   { return &apiError{Status: http.StatusNotFound, Err: err} }
+func apiConflict(err error) error   { return &apiError{Status: http.StatusConflict, Err: err} }
+
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiRoute is the single source for both the mux and the OpenAPI document.
+// Body and Response hold zero values of the request/response types.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Tag      string
+	Summary  string
+	Query    []apiParam
+	Body     any
+	Response any
+	Public   bool
+	Status   int
+	Handle   func(s *apiServer, r *http.Request) (any, error)
+}
+
+type apiServer struct {
+	token string
+	// mu serializes handlers: the panel state and the TrustTunnel/SOCKS
+	// users files are read-modify-write.
+	mu sync.Mutex
+	c  *client
+	tt *trustClient
+	sc *socksClient
+	mp *mtproxyClient
+	// web is nil when the dashboard is disabled (--no-ui).
+	web *webUI
+	// notifyKick wakes the notification flusher. Handlers only queue
+	// events, so a slow target never runs under mu.
+	notifyKick chan struct{}
+}
+
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type apiOK struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type apiUserResponse struct {
+	User  apiUser `json:"user"`
+	Links linkSet `json:"links"`
+}
+
+type apiUserEditResponse struct {
+	UserBefore apiUser           `json:"user_before"`
+	User       apiUser           `json:"user"`
+	Changes    []userFieldChange `json:"changes"`
+	Links      linkSet           `json:"links"`
+}
+
+type apiUserCreateRequest struct {
+	Name          string   `json:"name"`
+	Days          *int     `json:"days,omitempty"`
+	GB            *float64 `json:"gb,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	Plan          string   `json:"plan,omitempty"`
+	UUID          string   `json:"uuid,omitempty"`
+	UnlimitedDays bool     `json:"unlimited_days,omitempty"`
+	UnlimitedGB   bool     `json:"unlimited_gb,omitempty"`
+	TrueUnlimited bool     `json:"true_unlimited,omitempty"`
+}
+
+type apiUserPatchRequest struct {
+	Name       *string  `json:"name,omitempty"`
+	Days       *int     `json:"days,omitempty"`
+	GB         *float64 `json:"gb,omitempty"`
+	Mode       *string  `json:"mode,omitempty"`
+	Enable     *bool    `json:"enable,omitempty"`
+	AddDays    int      `json:"add_days,omitempty"`
+	AddGB      float64  `json:"add_gb,omitempty"`
+	ResetUsage bool     `json:"reset_usage,omitempty"`
+}
+
+type apiProtocolRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type apiConfigValue struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+type apiConfigSetRequest struct {
+	Value string `json:"value"`
+}
+
+type apiCredentialsRequest struct {
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type apiTrustUserResponse struct {
+	User           trustUser `json:"user"`
+	RestartWarning string    `json:"restart_warning,omitempty"`
+}
+
+type apiTrustConfigResponse struct {
+	User    trustUser `json:"user"`
+	Address string    `json:"address"`
+	Config  string    `json:"config"`
+}
+
+type apiSocksUserResponse struct {
+	User socksUser `json:"user"`
+}
+
+type apiMTProxySecretRequest struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+type apiMTProxySecretResponse struct {
+	SecretMasked   string `json:"secret_masked"`
+	RestartWarning string `json:"restart_warning,omitempty"`
+}
+
+var apiPathParamRe = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+func apiTokenPath() string {
+	return envOr("PSAS_API_TOKEN_FILE", defaultAPITokenPath)
+}
+
+// loadAPIToken returns PSAS_API_TOKEN or the token file, creating the file
+// with a fresh token when create is set and nothing is configured yet.
+func loadAPIToken(create bool) (string, error) {
+	if v := strings.TrimSpace(os.Getenv("PSAS_API_TOKEN")); v != "" {
+		return v, nil
+	}
+	p := apiTokenPath()
+	raw, err := os.ReadFile(p)
+	if err == nil && strings.TrimSpace(string(raw)) != "" {
+		return strings.TrimSpace(string(raw)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if !create {
+		return "", fmt.Errorf("API token is not configured: %s", p)
+	}
+	return writeAPIToken()
+}
+
+func writeAPIToken() (string, error) {
+	token := newHexToken(32)
+	p := apiTokenPath()
+	if err := writeStateFile(p, []byte(token+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func runServe(args []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub := strings.ToLower(strings.TrimSpace(args[0]))
+		subArgs := args[1:]
+		switch sub {
+		case "token":
+			fs := flag.NewFlagSet("serve token", flag.ExitOnError)
+			rotate := fs.Bool("rotate", false, "generate a new token")
+			must(fs.Parse(subArgs))
+			if *rotate {
+				must(requireRoot("serve token --rotate"))
+				token, err := writeAPIToken()
+				must(err)
+				fmt.Println(token)
+				return
+			}
+			token, err := loadAPIToken(true)
+			must(err)
+			fmt.Println(token)
+		case "openapi":
+			printJSON(apiOpenAPIDocument())
+		case "passwd":
+			fs := flag.NewFlagSet("serve passwd", flag.ExitOnError)
+			user := fs.String("user", "admin", "web admin login")
+			password := fs.String("password", "", "web admin password (empty = generated)")
+			must(fs.Parse(subArgs))
+			must(requireRoot("serve passwd"))
+			login := strings.TrimSpace(*user)
+			if login == "" {
+				fatalf("--user is required")
+			}
+			pass := *password
+			generated := pass == ""
+			if generated {
+				pass = newSecureToken(20)
+			}
+			must(writeWebAuth(webAuth{Username: login, PasswordHash: hashWebPassword(pass), UpdatedAt: time.Now().UTC().Format(time.RFC3339)}))
+			fmt.Printf("Web admin login: %s\n", login)
+			if generated {
+				fmt.Printf("Password: %s\n", pass)
+			}
+			fmt.Printf("Saved to %s (restart psas-api to drop existing sessions)\n", webAuthPath())
+		case "unit", "install":
+			fs := flag.NewFlagSet("serve "+sub, flag.ExitOnError)
+			listen := fs.String("listen", defaultAPIListen, "listen address: HOST:PORT or unix:/path.sock")
+			allowRemote := fs.Bool("allow-remote", false, "allow listening on non-loopback addresses")
+			noUI := fs.Bool("no-ui", false, "do not serve the web dashboard")
+			unitPath := fs.String("unit-path", defaultAPIUnitPath, "systemd unit file path")
+			noStart := fs.Bool("no-start", false, "write the unit but do not enable/start it")
+			must(fs.Parse(subArgs))
+			runArgs := []string{"serve", "--listen", *listen}
+			if *allowRemote {
+				runArgs = append(runArgs, "--allow-remote")
+			}
+			if *noUI {
+				runArgs = append(runArgs, "--no-ui")
+			}
+			unit := renderPSASUnit("PSAS REST API", runArgs)
+			if sub == "unit" {
+				fmt.Print(unit)
+				return
+			}
+			must(requireRoot("serve install"))
+			_, err := loadAPIToken(true)
+			must(err)
+			must(installPSASUnit(*unitPath, unit, !*noStart))
+			fmt.Printf("API token: %s\n", apiTokenPath())
+		default:
+			fatalf("unknown serve subcommand: %s", sub)
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", defaultAPIListen, "listen address: HOST:PORT or unix:/path.sock")
+	allowRemote := fs.Bool("allow-remote", false, "allow listening on non-loopback addresses")
+	noUI := fs.Bool("no-ui", false, "do not serve the web dashboard")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("serve takes only flags")
+	}
+	token, err := loadAPIToken(true)
+	must(err)
+
+	s := &apiServer{
+		token:      token,
+		c:          mustClient(true),
+		tt:         newTrustClient(),
+		sc:         newSocksClient(),
+		mp:         newMTProxyClient(),
+		notifyKick: make(chan struct{}, 1),
+	}
+	if !*noUI {
+		s.web = newWebUI()
+	}
+	ln, where, err := apiListen(*listen, *allowRemote)
+	must(err)
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go s.flushNotifyLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(os.Stderr, "psasctl serve: listening on %s (API %s, token: %s)\n", where, apiPrefix, apiTokenPath())
+	if s.web != nil {
+		if _, err := loadWebAuth(); err != nil {
+			fmt.Fprintf(os.Stderr, "psasctl serve: web dashboard at /ui/ is locked: %v\n", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "psasctl serve: web dashboard at /ui/")
+		}
+	}
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("%v", err)
+	}
+}
+
+func apiListen(addr string, allowRemote bool) (net.Listener, string, error) {
+	addr = strings.TrimSpace(addr)
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, "", err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, "", err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := os.Chmod(path, 0o660); err != nil {
+			ln.Close()
+			return nil, "", err
+		}
+		return ln, "unix:" + path, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid --listen %q: %w", addr, err)
+	}
+	if !allowRemote {
+		ip := net.ParseIP(host)
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, "", fmt.Errorf("refusing to listen on non-loopback address %s (use --allow-remote)", addr)
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	return ln, "http://" + ln.Addr().String(), nil
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range apiRoutes() {
+		rt := rt
+		mux.HandleFunc(rt.Method+" "+rt.Path, func(w http.ResponseWriter, r *http.Request) {
+			s.serveRoute(rt, w, r)
+		})
+	}
+	if s.web != nil {
+		s.mountWebUI(mux)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		apiWriteJSON(w, http.StatusNotFound, apiErrorResponse{Error: "not found"})
+	})
+	return mux
+}
+
+func (s *apiServer) serveRoute(rt apiRoute, w http.ResponseWriter, r *http.Request) {
+	if !rt.Public && !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="psasctl"`)
+		apiWriteJSON(w, http.StatusUnauthorized, apiErrorResponse{Error: "unauthorized"})
+		return
+	}
+	s.mu.Lock()
+	setAuditContext(s.auditContext(r))
+	out, err := rt.Handle(s, r)
+	s.mu.Unlock()
+	if r.Method != http.MethodGet {
+		s.kickNotify()
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var aerr *apiError
+		if errors.As(err, &aerr) {
+			status = aerr.Status
+		}
+		apiWriteJSON(w, status, apiErrorResponse{Error: err.Error()})
+		return
+	}
+	status := rt.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	apiWriteJSON(w, status, out)
+}
+
+// kickNotify asks the flusher to deliver queued notifications; a pending
+// kick already covers new events.
+func (s *apiServer) kickNotify() {
+	if s.notifyKick == nil {
+		return
+	}
+	select {
+	case s.notifyKick <- struct{}{}:
+	default:
+	}
+}
+
+// flushNotifyLoop delivers what handlers queued, one outbox pass per kick.
+func (s *apiServer) flushNotifyLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notifyKick:
+			flushQueuedNotifications()
+		}
+	}
+}
+
+func (s *apiServer) authorized(r *http.Request) bool {
+	return s.tokenAuthorized(r) || (s.web != nil && s.web.authorized(r))
+}
+
+func (s *apiServer) tokenAuthorized(r *http.Request) bool {
+	got := strings.TrimSpace(r.Header.Get("X-PSAS-Token"))
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = strings.TrimSpace(v)
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1
+}
+
+// auditContext attributes audit entries to the API token or the web user.
+func (s *apiServer) auditContext(r *http.Request) auditContext {
+	ctx := auditContext{Via: "api", Actor: "token", Command: r.Method + " " + r.URL.Path}
+	if !s.tokenAuthorized(r) {
+		ctx.Actor = ""
+		if s.web != nil {
+			if sess := s.web.session(r); sess != nil {
+				ctx.Via, ctx.Actor = "web", sess.User
+			}
+		}
+	}
+	return ctx
+}
+
+func apiWriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func apiDecode(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return apiBadRequest(fmt.Errorf("invalid JSON body: %w", err))
+	}
+	return nil
+}
+
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{Method: "GET", Path: "/healthz", Tag: "meta", Summary: "Liveness probe", Response: apiOK{}, Public: true,
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return apiOK{OK: true}, nil }},
+		{Method: "GET", Path: apiPrefix + "/openapi.json", Tag: "meta", Summary: "OpenAPI document", Response: map[string]any{}, Public: true,
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return apiOpenAPIDocument(), nil }},
+		{Method: "GET", Path: apiPrefix + "/status", Tag: "status", Summary: "Panel and proxy services status (same as status --json)", Response: map[string]any{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return statusSummary(s.c, s.c.loadState()), nil }},
+
+		{Method: "GET", Path: apiPrefix + "/users", Tag: "users", Summary: "List Hiddify users", Response: []apiUser{},
+			Query:  []apiParam{{"name", "name contains (case-insensitive)"}, {"enabled", "true to show only enabled users"}},
+			Handle: (*apiServer).listUsers},
+		{Method: "POST", Path: apiPrefix + "/users", Tag: "users", Summary: "Create Hiddify user", Body: apiUserCreateRequest{}, Response: apiUserResponse{}, Status: http.StatusCreated,
+			Handle: (*apiServer).createUser},
+		{Method: "GET", Path: apiPrefix + "/users/{id}", Tag: "users", Summary: "Show Hiddify user with links (id: UUID or name)", Response: apiUserResponse{},
+			Handle: (*apiServer).showUser},
+		{Method: "PATCH", Path: apiPrefix + "/users/{id}", Tag: "users", Summary: "Edit Hiddify user (id: UUID or exact name)", Body: apiUserPatchRequest{}, Response: apiUserEditResponse{},
+			Handle: (*apiServer).patchUser},
+		{Method: "DELETE", Path: apiPrefix + "/users/{id}", Tag: "users", Summary: "Delete Hiddify user (id: UUID or exact name)", Response: apiOK{},
+			Handle: (*apiServer).deleteUser},
+
+		{Method: "GET", Path: apiPrefix + "/protocols", Tag: "protocols", Summary: "List protocol toggles", Response: []protocolState{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				if err := s.c.loadState(); err != nil {
+					return nil, err
+				}
+				return protocolStates(s.c.currentConfig()), nil
+			}},
+		{Method: "PUT", Path: apiPrefix + "/protocols/{name}", Tag: "protocols", Summary: "Enable or disable protocol (run apply afterwards)", Body: apiProtocolRequest{}, Response: apiOK{},
+			Handle: (*apiServer).setProtocol},
+		{Method: "GET", Path: apiPrefix + "/config/{key}", Tag: "config", Summary: "Read panel setting", Response: apiConfigValue{},
+			Handle: (*apiServer).getConfig},
+		{Method: "PUT", Path: apiPrefix + "/config/{key}", Tag: "config", Summary: "Write panel setting", Body: apiConfigSetRequest{}, Response: apiConfigValue{},
+			Handle: (*apiServer).setConfig},
+		{Method: "POST", Path: apiPrefix + "/apply", Tag: "config", Summary: "Apply Hiddify configuration", Response: apiOK{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				if err := applyWithClient(s.c); err != nil {
+					return nil, err
+				}
+				return apiOK{OK: true, Message: "applied"}, nil
+			}},
+
+		{Method: "POST", Path: apiPrefix + "/services/{name}/{action}", Tag: "services", Summary: "Start, stop or restart trust, socks or mtproxy service", Response: apiOK{},
+			Handle: (*apiServer).serviceAction},
+
+		{Method: "GET", Path: apiPrefix + "/trust/status", Tag: "trust", Summary: "TrustTunnel status", Response: trustStatus{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return s.tt.status() }},
+		{Method: "GET", Path: apiPrefix + "/trust/users", Tag: "trust", Summary: "List TrustTunnel users", Response: []trustUser{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return s.tt.usersList() }},
+		{Method: "POST", Path: apiPrefix + "/trust/users", Tag: "trust", Summary: "Create TrustTunnel user (empty password = generated)", Body: apiCredentialsRequest{}, Response: apiTrustUserResponse{}, Status: http.StatusCreated,
+			Handle: (*apiServer).createTrustUser},
+		{Method: "GET", Path: apiPrefix + "/trust/users/{id}", Tag: "trust", Summary: "Show TrustTunnel user", Response: trustUser{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				u, _, err := s.trustUser(r.PathValue("id"))
+				return u, err
+			}},
+		{Method: "GET", Path: apiPrefix + "/trust/users/{id}/config", Tag: "trust", Summary: "Export TrustTunnel client config", Response: apiTrustConfigResponse{},
+			Query:  []apiParam{{"address", "endpoint address ip[:port]"}},
+			Handle: (*apiServer).trustUserConfig},
+		{Method: "PATCH", Path: apiPrefix + "/trust/users/{id}", Tag: "trust", Summary: "Rename TrustTunnel user or change password", Body: apiCredentialsRequest{}, Response: apiTrustUserResponse{},
+			Handle: (*apiServer).patchTrustUser},
+		{Method: "DELETE", Path: apiPrefix + "/trust/users/{id}", Tag: "trust", Summary: "Delete TrustTunnel user", Response: apiTrustUserResponse{},
+			Handle: (*apiServer).deleteTrustUser},
+
+		{Method: "GET", Path: apiPrefix + "/socks/status", Tag: "socks", Summary: "SOCKS5 status", Response: socksStatus{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return s.sc.status() }},
+		{Method: "GET", Path: apiPrefix + "/socks/users", Tag: "socks", Summary: "List SOCKS5 users", Response: []socksUser{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return s.sc.usersList() }},
+		{Method: "POST", Path: apiPrefix + "/socks/users", Tag: "socks", Summary: "Create SOCKS5 user (empty password = generated)", Body: apiCredentialsRequest{}, Response: apiSocksUserResponse{}, Status: http.StatusCreated,
+			Handle: (*apiServer).createSocksUser},
+		{Method: "GET", Path: apiPrefix + "/socks/users/{id}", Tag: "socks", Summary: "Show SOCKS5 user", Response: socksUser{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				u, _, err := s.socksUser(r.PathValue("id"))
+				return u, err
+			}},
+		{Method: "GET", Path: apiPrefix + "/socks/users/{id}/config", Tag: "socks", Summary: "SOCKS5 connection config", Response: socksConnInfo{},
+			Query: []apiParam{{"server", "server host/ip"}, {"port", "server port"}},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				u, _, err := s.socksUser(r.PathValue("id"))
+				if err != nil {
+					return nil, err
+				}
+				port, _ := strconv.Atoi(r.URL.Query().Get("port"))
+				return s.sc.connectionConfig(u, strings.TrimSpace(r.URL.Query().Get("server")), port)
+			}},
+		{Method: "PATCH", Path: apiPrefix + "/socks/users/{id}", Tag: "socks", Summary: "Rename SOCKS5 user or change password", Body: apiCredentialsRequest{}, Response: apiSocksUserResponse{},
+			Handle: (*apiServer).patchSocksUser},
+		{Method: "DELETE", Path: apiPrefix + "/socks/users/{id}", Tag: "socks", Summary: "Delete SOCKS5 user", Response: apiSocksUserResponse{},
+			Handle: (*apiServer).deleteSocksUser},
+
+		{Method: "GET", Path: apiPrefix + "/mtproxy/status", Tag: "mtproxy", Summary: "MTProxy status", Response: mtproxyStatus{},
+			Handle: func(s *apiServer, r *http.Request) (any, error) { return s.mp.status() }},
+		{Method: "GET", Path: apiPrefix + "/mtproxy/config", Tag: "mtproxy", Summary: "MTProxy connection links", Response: mtproxyConnInfo{},
+			Query: []apiParam{{"server", "server host/ip"}, {"port", "server port"}},
+			Handle: func(s *apiServer, r *http.Request) (any, error) {
+				port, _ := strconv.Atoi(r.URL.Query().Get("port"))
+				return s.mp.connectionInfo(r.URL.Query().Get("server"), port, "")
+			}},
+		{Method: "PUT", Path: apiPrefix + "/mtproxy/secret", Tag: "mtproxy", Summary: "Set MTProxy secret (empty = regenerate)", Body: apiMTProxySecretRequest{}, Response: apiMTProxySecretResponse{},
+			Handle: (*apiServer).setMTProxySecret},
+	}
+}
+
+func (s *apiServer) links(uuid string) (linkSet, error) {
+	host, err := s.c.mainDomainOrErr()
+	if err != nil {
+		return linkSet{}, err
+	}
+	return buildLinks(s.c.clientPath(), uuid, host), nil
+}
+
+// resolveUser looks up /users/{id}. Reads accept a unique partial name like
+// the CLI; routes that change or delete the user (exact) need the UUID or
+// the exact name.
+func (s *apiServer) resolveUser(id string, exact bool) (apiUser, error) {
+	find := s.c.resolveUser
+	if exact {
+		find = s.c.resolveUserExact
+	}
+	u, err := find(id)
+	if err != nil {
+		return apiUser{}, apiNotFound(err)
+	}
+	return u, nil
+}
+
+func (s *apiServer) listUsers(r *http.Request) (any, error) {
+	users, err := s.c.usersList()
+	if err != nil {
+		return nil, err
+	}
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	return filterUsers(users, r.URL.Query().Get("name"), enabled), nil
+}
+
+func (s *apiServer) showUser(r *http.Request) (any, error) {
+	u, err := s.resolveUser(r.PathValue("id"), false)
+	if err != nil {
+		return nil, err
+	}
+	links, err := s.links(u.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return apiUserResponse{User: u, Links: links}, nil
+}
+
+func (s *apiServer) createUser(r *http.Request) (any, error) {
+	var req apiUserCreateRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, apiBadRequest(errors.New("name is required"))
+	}
+	days, gb, mode := 30, 100.0, "no_reset"
+	useTrueUnlimited := req.TrueUnlimited
+	if strings.TrimSpace(req.Plan) != "" {
+		plan, err := loadUserPlan(req.Plan)
+		if err != nil {
+			return nil, apiBadRequest(err)
+		}
+		days, gb = plan.packageDays(), plan.usageLimitGB()
+		useTrueUnlimited = useTrueUnlimited || plan.needsTrueUnlimited()
+		if plan.Mode != "" {
+			mode = plan.Mode
+		}
+	}
+	if req.Days != nil {
+		days = *req.Days
+	}
+	if req.GB != nil {
+		gb = *req.GB
+	}
+	if req.Mode != "" {
+		mode = req.Mode
+	}
+	if req.UnlimitedDays || req.TrueUnlimited {
+		days = unlimitedPackageDays
+	}
+	if req.UnlimitedGB || req.TrueUnlimited {
+		gb = unlimitedUsageGB
+	}
+	if !isValidMode(mode) {
+		return nil, apiBadRequest(fmt.Errorf("invalid mode: %s", mode))
+	}
+	if days < 1 {
+		return nil, apiBadRequest(errors.New("days must be >= 1"))
+	}
+	if gb <= 0 {
+		return nil, apiBadRequest(errors.New("gb must be > 0"))
+	}
+	id := strings.ToLower(strings.TrimSpace(req.UUID))
+	if id == "" {
+		id = newUUID()
+	} else if err := validateUUID(id); err != nil {
+		return nil, apiBadRequest(err)
+	}
+	if useTrueUnlimited {
+		if err := s.c.ensureTrueUnlimitedSupport(); err != nil {
+			return nil, err
+		}
+	}
+	u, err := s.c.userAdd(map[string]any{
+		"uuid":           id,
+		"name":           name,
+		"package_days":   days,
+		"usage_limit_GB": gb,
+		"mode":           mode,
+		"enable":         true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	queueNotify(notifyEvent{Type: notifyUserCreated, Source: "api", User: u.Name, UUID: u.UUID})
+	links, err := s.links(u.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return apiUserResponse{User: u, Links: links}, nil
+}
+
+func (s *apiServer) patchUser(r *http.Request) (any, error) {
+	var req apiUserPatchRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	u, err := s.resolveUser(r.PathValue("id"), true)
+	if err != nil {
+		return nil, err
+	}
+	if u, err = s.c.userShow(u.UUID); err != nil {
+		return nil, err
+	}
+	payload := map[string]any{}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, apiBadRequest(errors.New("name must not be empty"))
+		}
+		payload["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Mode != nil {
+		if !isValidMode(*req.Mode) {
+			return nil, apiBadRequest(fmt.Errorf("invalid mode: %s", *req.Mode))
+		}
+		payload["mode"] = *req.Mode
+	}
+	if req.Enable != nil {
+		payload["enable"] = *req.Enable
+	}
+	if req.Days != nil && req.AddDays != 0 {
+		return nil, apiBadRequest(errors.New("days and add_days cannot be combined"))
+	}
+	if req.GB != nil && req.AddGB != 0 {
+		return nil, apiBadRequest(errors.New("gb and add_gb cannot be combined"))
+	}
+	if req.Days != nil {
+		if *req.Days < 1 {
+			return nil, apiBadRequest(errors.New("days must be >= 1"))
+		}
+		payload["package_days"] = *req.Days
+	}
+	if req.AddDays != 0 {
+		if isUnlimitedDays(u) {
+			return nil, apiBadRequest(errors.New("add_days: user already has unlimited package days"))
+		}
+		payload["package_days"] = u.PackageDays + req.AddDays
+	}
+	if req.GB != nil {
+		if *req.GB <= 0 {
+			return nil, apiBadRequest(errors.New("gb must be > 0"))
+		}
+		payload["usage_limit_GB"] = *req.GB
+	}
+	if req.AddGB != 0 {
+		if isUnlimitedUsage(u) {
+			return nil, apiBadRequest(errors.New("add_gb: user already has unlimited traffic"))
+		}
+		payload["usage_limit_GB"] = u.UsageLimitGB + req.AddGB
+	}
+	if req.ResetUsage {
+		for k, v := range userResetPayload() {
+			payload[k] = v
+		}
+	}
+	if len(payload) == 0 {
+		return nil, apiBadRequest(errors.New("no changes requested"))
+	}
+	updated, err := s.c.userPatch(u, payload)
+	if err != nil {
+		return nil, err
+	}
+	changes := userEditChanges(u, updated)
+	queueNotify(notifyEvent{Type: notifyUserUpdated, Source: "api", User: updated.Name, UUID: updated.UUID, Details: map[string]any{"changes": changes}})
+	links, err := s.links(updated.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return apiUserEditResponse{UserBefore: u, User: updated, Changes: changes, Links: links}, nil
+}
+
+func (s *apiServer) deleteUser(r *http.Request) (any, error) {
+	u, err := s.resolveUser(r.PathValue("id"), true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.c.userDelete(u); err != nil {
+		return nil, err
+	}
+	queueNotify(notifyEvent{Type: notifyUserDeleted, Source: "api", User: u.Name, UUID: u.UUID})
+	return apiOK{OK: true, Message: "deleted " + u.UUID}, nil
+}
+
+func (s *apiServer) setProtocol(r *http.Request) (any, error) {
+	var req apiProtocolRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	p, err := resolveProtocolSetting(r.PathValue("name"))
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	if err := s.c.setConfig(p.Key, strconv.FormatBool(req.Enabled)); err != nil {
+		return nil, err
+	}
+	return apiOK{OK: true, Message: fmt.Sprintf("protocol %s (%s) set to %t", p.Name, p.Key, req.Enabled)}, nil
+}
+
+func (s *apiServer) getConfig(r *http.Request) (any, error) {
+	if err := s.c.loadState(); err != nil {
+		return nil, err
+	}
+	key := r.PathValue("key")
+	v, ok := s.c.currentConfig()[key]
+	if !ok {
+		return nil, apiNotFound(fmt.Errorf("key not found: %s", key))
+	}
+	return apiConfigValue{Key: key, Value: v}, nil
+}
+
+func (s *apiServer) setConfig(r *http.Request) (any, error) {
+	var req apiConfigSetRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	key := r.PathValue("key")
+	if err := s.c.setConfig(key, req.Value); err != nil {
+		return nil, err
+	}
+	return apiConfigValue{Key: key, Value: req.Value}, nil
+}
+
+func (s *apiServer) trustUser(id string) (trustUser, int, error) {
+	users, err := s.tt.usersList()
+	if err != nil {
+		return trustUser{}, -1, err
+	}
+	u, idx, err := resolveTrustUser(users, id)
+	if err != nil {
+		return trustUser{}, -1, apiNotFound(err)
+	}
+	return u, idx, nil
+}
+
+// exactTrustUser and exactSocksUser resolve {id} for routes that change or
+// delete a user: only the exact name counts, never a partial match.
+func exactTrustUser(users []trustUser, id string) (trustUser, int, error) {
+	idx := findTrustUserIndex(users, id)
+	if idx < 0 {
+		return trustUser{}, -1, fmt.Errorf("trust user not found: %s", strings.TrimSpace(id))
+	}
+	return users[idx], idx, nil
+}
+
+func exactSocksUser(users []socksUser, id string) (socksUser, int, error) {
+	idx := findSocksUserIndex(users, id)
+	if idx < 0 {
+		return socksUser{}, -1, fmt.Errorf("socks user not found: %s", normalizeSocksLogin(id))
+	}
+	return users[idx], idx, nil
+}
+
+func (s *apiServer) createTrustUser(r *http.Request) (any, error) {
+	var req apiCredentialsRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	username := strings.TrimSpace(req.Name)
+	if err := validateTrustUsername(username); err != nil {
+		return nil, apiBadRequest(err)
+	}
+	users, err := s.tt.usersList()
+	if err != nil {
+		return nil, err
+	}
+	if hasTrustUserExact(users, username) {
+		return nil, apiConflict(fmt.Errorf("trust user already exists: %s", username))
+	}
+	pass := strings.TrimSpace(req.Password)
+	if pass == "" {
+		pass = newSecureToken(24)
+	}
+	u := trustUser{Username: username, Password: pass}
+	if err := s.tt.writeUsers(append(users, u)); err != nil {
+		return nil, err
+	}
+	warn := trustRestartWarning(s.tt.service, s.tt.restartService())
+	queueNotify(notifyEvent{Type: notifyUserCreated, Source: "api", User: username, Details: map[string]any{"service": "trust"}})
+	return apiTrustUserResponse{User: u, RestartWarning: warn}, nil
+}
+
+func (s *apiServer) trustUserConfig(r *http.Request) (any, error) {
+	u, _, err := s.trustUser(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := s.tt.exportClientConfig(u.Username, strings.TrimSpace(r.URL.Query().Get("address")))
+	if err != nil {
+		return nil, err
+	}
+	return apiTrustConfigResponse{User: u, Address: s.tt.lastExportAddress, Config: cfg}, nil
+}
+
+func (s *apiServer) patchTrustUser(r *http.Request) (any, error) {
+	var req apiCredentialsRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	users, err := s.tt.usersList()
+	if err != nil {
+		return nil, err
+	}
+	current, idx, err := exactTrustUser(users, r.PathValue("id"))
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	newName := strings.TrimSpace(req.Name)
+	newPass := strings.TrimSpace(req.Password)
+	if newName == "" && newPass == "" {
+		return nil, apiBadRequest(errors.New("no changes requested"))
+	}
+	if newName != "" {
+		if err := validateTrustUsername(newName); err != nil {
+			return nil, apiBadRequest(err)
+		}
+		for i, u := range users {
+			if i != idx && strings.EqualFold(strings.TrimSpace(u.Username), newName) {
+				return nil, apiConflict(fmt.Errorf("trust user already exists: %s", newName))
+			}
+		}
+		users[idx].Username = newName
+	}
+	if newPass != "" {
+		users[idx].Password = newPass
+	}
+	if err := s.tt.writeUsers(users); err != nil {
+		return nil, err
+	}
+	warn := trustRestartWarning(s.tt.service, s.tt.restartService())
+	queueNotify(notifyEvent{Type: notifyUserUpdated, Source: "api", User: users[idx].Username, Details: map[string]any{"service": "trust", "old_name": current.Username, "password_changed": newPass != ""}})
+	return apiTrustUserResponse{User: users[idx], RestartWarning: warn}, nil
+}
+
+func (s *apiServer) deleteTrustUser(r *http.Request) (any, error) {
+	users, err := s.tt.usersList()
+	if err != nil {
+		return nil, err
+	}
+	u, idx, err := exactTrustUser(users, r.PathValue("id"))
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	next := append(append([]trustUser{}, users[:idx]...), users[idx+1:]...)
+	if err := s.tt.writeUsers(next); err != nil {
+		return nil, err
+	}
+	warn := trustRestartWarning(s.tt.service, s.tt.restartService())
+	queueNotify(notifyEvent{Type: notifyUserDeleted, Source: "api", User: u.Username, Details: map[string]any{"service": "trust"}})
+	return apiTrustUserResponse{User: u, RestartWarning: warn}, nil
+}
+
+func (s *apiServer) socksUser(id string) (socksUser, int, error) {
+	users, err := s.sc.usersList()
+	if err != nil {
+		return socksUser{}, -1, err
+	}
+	u, idx, err := resolveSocksUser(users, id)
+	if err != nil {
+		return socksUser{}, -1, apiNotFound(err)
+	}
+	return u, idx, nil
+}
+
+func (s *apiServer) createSocksUser(r *http.Request) (any, error) {
+	var req apiCredentialsRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	login := normalizeSocksLogin(req.Name)
+	if err := validateSocksLogin(login); err != nil {
+		return nil, apiBadRequest(err)
+	}
+	users, err := s.sc.usersList()
+	if err != nil {
+		return nil, err
+	}
+	if hasSocksUserExact(users, login) {
+		return nil, apiConflict(fmt.Errorf("socks user already exists: %s", login))
+	}
+	if s.sc.linuxUserExists(login) {
+		return nil, apiConflict(fmt.Errorf("linux user already exists: %s", login))
+	}
+	pass := strings.TrimSpace(req.Password)
+	if pass == "" {
+		pass = newSecureToken(24)
+	}
+	u := socksUser{Name: login, Password: pass, SystemUser: login}
+	if err := s.sc.addUser(users, u); err != nil {
+		return nil, err
+	}
+	queueNotify(notifyEvent{Type: notifyUserCreated, Source: "api", User: login, Details: map[string]any{"service": "socks"}})
+	return apiSocksUserResponse{User: u}, nil
+}
+
+func (s *apiServer) patchSocksUser(r *http.Request) (any, error) {
+	var req apiCredentialsRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	users, err := s.sc.usersList()
+	if err != nil {
+		return nil, err
+	}
+	current, idx, err := exactSocksUser(users, r.PathValue("id"))
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	target := current
+	newName := normalizeSocksLogin(req.Name)
+	newPass := strings.TrimSpace(req.Password)
+	if newName == "" && newPass == "" {
+		return nil, apiBadRequest(errors.New("no changes requested"))
+	}
+	if newName != "" && newName != current.Name {
+		if err := validateSocksLogin(newName); err != nil {
+			return nil, apiBadRequest(err)
+		}
+		for i, u := range users {
+			if i != idx && strings.EqualFold(strings.TrimSpace(u.Name), newName) {
+				return nil, apiConflict(fmt.Errorf("socks user already exists: %s", newName))
+			}
+		}
+		if s.sc.linuxUserExists(newName) {
+			return nil, apiConflict(fmt.Errorf("linux user already exists: %s", newName))
+		}
+		target.Name = newName
+		target.SystemUser = newName
+	}
+	if newPass != "" {
+		target.Password = newPass
+	}
+	if err := s.sc.editUser(users, idx, target); err != nil {
+		return nil, err
+	}
+	queueNotify(notifyEvent{Type: notifyUserUpdated, Source: "api", User: target.Name, Details: map[string]any{"service": "socks", "old_name": current.Name, "password_changed": newPass != ""}})
+	return apiSocksUserResponse{User: target}, nil
+}
+
+func (s *apiServer) deleteSocksUser(r *http.Request) (any, error) {
+	users, err := s.sc.usersList()
+	if err != nil {
+		return nil, err
+	}
+	u, idx, err := exactSocksUser(users, r.PathValue("id"))
+	if err != nil {
+		return nil, apiNotFound(err)
+	}
+	if err := s.sc.deleteUser(users, idx); err != nil {
+		return nil, err
+	}
+	queueNotify(notifyEvent{Type: notifyUserDeleted, Source: "api", User: u.Name, Details: map[string]any{"service": "socks"}})
+	return apiSocksUserResponse{User: u}, nil
+}
+
+func (s *apiServer) setMTProxySecret(r *http.Request) (any, error) {
+	var req apiMTProxySecretRequest
+	if err := apiDecode(r, &req); err != nil {
+		return nil, err
+	}
+	secret := newHexToken(16)
+	if strings.TrimSpace(req.Secret) != "" {
+		v, err := normalizeMTProxySecret(req.Secret)
+		if err != nil {
+			return nil, apiBadRequest(err)
+		}
+		secret = v
+	}
+	cfg, err := s.mp.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Secret = secret
+	if err := s.mp.writeConfig(cfg); err != nil {
+		return nil, err
+	}
+	warn := mtproxyRestartWarning(s.mp.service, s.mp.restartService())
+	queueNotify(notifyEvent{Type: notifySecretChanged, Source: "api", Message: "MTProxy secret updated", Details: map[string]any{"secret_masked": maskSecret(secret)}})
+	return apiMTProxySecretResponse{SecretMasked: maskSecret(secret), RestartWarning: warn}, nil
+}
+
+func (s *apiServer) serviceAction(r *http.Request) (any, error) {
+	action := r.PathValue("action")
+	switch action {
+	case "start", "stop", "restart":
+	default:
+		return nil, apiBadRequest(fmt.Errorf("unknown action: %s (expected start|stop|restart)", action))
+	}
+	var service string
+	switch strings.ToLower(r.PathValue("name")) {
+	case "trust", "trusttunnel":
+		service = s.tt.service
+	case "socks", "socks5":
+		service = s.sc.service
+	case "mtproxy":
+		service = s.mp.service
+	default:
+		return nil, apiNotFound(fmt.Errorf("unknown service: %s", r.PathValue("name")))
+	}
+	if err := runServiceAction(action, service); err != nil {
+		return nil, err
+	}
+	return apiOK{OK: true, Message: fmt.Sprintf("%s: %s", action, service)}, nil
+}
+
+// apiOpenAPIDocument builds an OpenAPI 3.0 document from apiRoutes.
+func apiOpenAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, rt := range apiRoutes() {
+		op := map[string]any{
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+			"operationId": apiOperationID(rt),
+		}
+		var params []map[string]any
+		for _, m := range apiPathParamRe.FindAllStringSubmatch(rt.Path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]any{"type": "string"}})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": apiSchema(reflect.TypeOf(rt.Body), schemas)}},
+			}
+		}
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		errResp := map[string]any{"description": "error", "content": map[string]any{"application/json": map[string]any{"schema": apiSchema(reflect.TypeOf(apiErrorResponse{}), schemas)}}}
+		op["responses"] = map[string]any{
+			strconv.Itoa(status): map[string]any{
+				"description": http.StatusText(status),
+				"content":     map[string]any{"application/json": map[string]any{"schema": apiSchema(reflect.TypeOf(rt.Response), schemas)}},
+			},
+			"default": errResp,
+		}
+		if rt.Public {
+			op["security"] = []any{}
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]any{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "psasctl API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []string{}}},
+	}
+}
+
+func apiOperationID(rt apiRoute) string {
+	parts := []string{strings.ToLower(rt.Method)}
+	for _, p := range strings.Split(strings.TrimPrefix(rt.Path, apiPrefix), "/") {
+		p = strings.Trim(p, "{}")
+		if p == "" {
+			continue
+		}
+		p = strings.ReplaceAll(strings.ReplaceAll(p, ".", "_"), "-", "_")
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "_")
+}
+
+// apiSchema converts a Go type to a JSON schema; named structs go to
+// components/schemas and are referenced.
+func apiSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": apiSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": apiSchema(t.Elem(), schemas)}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		name := t.Name()
+		if name != "" {
+			if _, ok := schemas[name]; !ok {
+				schemas[name] = map[string]any{} // placeholder for recursive types
+				schemas[name] = apiStructSchema(t, schemas)
+			}
+			return map[string]any{"$ref": "#/components/schemas/" + name}
+		}
+		return apiStructSchema(t, schemas)
+	}
+	return map[string]any{}
+}
+
+func apiStructSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = apiSchema(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIMutatingUserRoutesNeedExactID(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PSAS_AUDIT_LOG", filepath.Join(dir, "audit.jsonl"))
+	t.Setenv("PSAS_NOTIFY", filepath.Join(dir, "notify.json"))
+	s := &apiServer{token: "t", c: newFakePanel(t, apiUser{UUID: testBotUUID, Name: "alice", Enable: true})}
+	h := s.handler()
+
+	do := func(method, id string) int {
+		req := httptest.NewRequest(method, apiPrefix+"/users/"+id, strings.NewReader(`{"enable":false}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer t")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// "ali" is a unique partial match; the CLI would accept it, the API
+	// must not edit or delete on it.
+	if code := do(http.MethodDelete, "ali"); code != http.StatusNotFound {
+		t.Fatalf("DELETE by partial name = %d, want 404", code)
+	}
+	if code := do(http.MethodPatch, "ali"); code != http.StatusNotFound {
+		t.Fatalf("PATCH by partial name = %d, want 404", code)
+	}
+	if code := do(http.MethodDelete, "Alice"); code != http.StatusOK {
+		t.Fatalf("DELETE by exact name = %d, want 200", code)
+	}
+	if code := do(http.MethodDelete, testBotUUID); code != http.StatusOK {
+		t.Fatalf("DELETE by UUID = %d, want 200", code)
+	}
+}
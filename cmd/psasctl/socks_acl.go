@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Destination ACLs for SOCKS users. Each user may carry allow/deny lists of
+// IPs/CIDRs; an allow entry wins over the user's deny list and over the
+// global block of internal networks. The built-in server evaluates them at
+// connect time, for Dante they are rendered into danted.conf as
+// `socks pass|block` rules selected by `user:`.
+
+const defaultSocksACLPath = "/etc/psas/socks-acl.json"
+
+func socksACLPath() string {
+	return envOr("PSAS_SOCKS_ACL", defaultSocksACLPath)
+}
+
+// socksACLSettings holds the global Dante ACL defaults. The built-in server
+// keeps the same switch as allow_private in socks-server.json.
+type socksACLSettings struct {
+	BlockPrivate bool `json:"block_private"`
+}
+
+func loadSocksACLSettings() (socksACLSettings, error) {
+	st, _, err := readSocksACLSettings()
+	return st, err
+}
+
+// readSocksACLSettings also returns the file version for
+// commitSocksACLSettings.
+func readSocksACLSettings() (socksACLSettings, stateVersion, error) {
+	st := socksACLSettings{BlockPrivate: true}
+	raw, ver, err := readStateFile(socksACLPath())
+	if err != nil || raw == nil {
+		return st, ver, err
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return st, ver, fmt.Errorf("parse %s: %w", socksACLPath(), err)
+	}
+	return st, ver, nil
+}
+
+// commitSocksACLSettings writes st unless the file changed since expect was
+// read (errStateConflict) and returns the version written.
+func commitSocksACLSettings(st socksACLSettings, expect stateVersion) (stateVersion, error) {
+	payload, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return stateVersion{}, err
+	}
+	return commitStateFile(socksACLPath(), 0o600, &expect, func([]byte) ([]byte, error) {
+		return append(payload, '\n'), nil
+	})
+}
+
+// normalizeSocksDestinations validates IP/CIDR entries and returns them in
+// canonical prefix form without duplicates.
+func normalizeSocksDestinations(items []string) ([]string, error) {
+	prefixes, err := parseSocksPrefixes(items)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range prefixes {
+		if s := p.String(); !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// danteInternalNets are blocked for every user while block_private is on.
+var danteInternalNets = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+}
+
+var danteInternalNets6 = []string{"::1/128", "fc00::/7", "fe80::/10"}
+
+// danteManagedRule reports rules psasctl renders from the ACLs: per-user
+// rules (they carry `user:`) and the global blocks of internal networks.
+func danteManagedRule(r danteRule) bool {
+	if r.Type != "socks" {
+		return false
+	}
+	if r.get("user") != "" {
+		return true
+	}
+	return r.Action == "block" && r.From == "0.0.0.0/0" &&
+		(slices.Contains(danteInternalNets, r.To) || slices.Contains(danteInternalNets6, r.To))
+}
+
+// withACL returns cfg with the managed rules replaced by ones rendered from
+// users and st. They are placed before the first remaining socks rule,
+// since Dante uses the first rule that matches.
+func (cfg danteConfig) withACL(users []socksUser, st socksACLSettings) danteConfig {
+	var kept []danteRule
+	for _, r := range cfg.Rules {
+		if !danteManagedRule(r) {
+			kept = append(kept, r)
+		}
+	}
+	// Per-user pass rules inherit command, UDP range and logging from the
+	// catch-all socks pass rule the installer writes.
+	passOpts := []danteDirective{{Key: "command", Value: "connect udpassociate bind"}}
+	logValue := "error connect disconnect"
+	for _, r := range kept {
+		if r.Type == "socks" && r.Action == "pass" {
+			passOpts = nil
+			for _, o := range r.Options {
+				if o.Key == "command" || o.Key == "udp.portrange" {
+					passOpts = append(passOpts, o)
+				}
+			}
+			if v := r.get("log"); v != "" {
+				logValue = v
+			}
+			break
+		}
+	}
+
+	var managed []danteRule
+	for _, u := range users {
+		who := []danteDirective{{Key: "socksmethod", Value: "username"}, {Key: "user", Value: socksSystemUser(u)}}
+		for _, to := range u.Allow {
+			opts := append(append(slices.Clone(who), passOpts...), danteDirective{Key: "log", Value: logValue})
+			managed = append(managed, danteRule{Type: "socks", Action: "pass", From: "0.0.0.0/0", To: to, Options: opts})
+		}
+		for _, to := range u.Deny {
+			opts := append(slices.Clone(who), danteDirective{Key: "log", Value: logValue})
+			managed = append(managed, danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: to, Options: opts})
+		}
+	}
+	if st.BlockPrivate {
+		nets := danteInternalNets
+		if !strings.EqualFold(cfg.get("external.protocol"), "ipv4") {
+			nets = append(slices.Clone(nets), danteInternalNets6...)
+		}
+		for _, to := range nets {
+			managed = append(managed, danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: to,
+				Options: []danteDirective{{Key: "log", Value: logValue}}})
+		}
+	}
+
+	at := len(kept)
+	for i, r := range kept {
+		if r.Type == "socks" {
+			at = i
+			break
+		}
+	}
+	out := cfg
+	out.Rules = append(append(slices.Clone(kept[:at]), managed...), kept[at:]...)
+	return out
+}
+
+// applyACL brings danted.conf in line with the users' ACLs, validating,
+// backing up and restarting danted only when the rendered rules change. On
+// a failed restart the previous file is put back. Unless force is set, a
+// config without ACL rules is left alone only while there is nothing to
+// render: block_private is off and no user has a list.
+func (s *socksClient) applyACL(users []socksUser, force bool) error {
+	if s.builtin() {
+		return nil
+	}
+	st, err := loadSocksACLSettings()
+	if err != nil {
+		return err
+	}
+	if !fileExists(s.config) {
+		// Dante is not installed; there is nowhere to render the rules.
+		if force || socksUsersHaveACL(users) {
+			return fmt.Errorf("%s not found; is Dante installed?", s.config)
+		}
+		return nil
+	}
+	cfg, err := s.loadDanteConfig()
+	if err != nil {
+		return err
+	}
+	if !force && !st.BlockPrivate && !socksUsersHaveACL(users) && !slices.ContainsFunc(cfg.Rules, danteManagedRule) {
+		return nil
+	}
+	next := cfg.withACL(users, st)
+	if string(next.render()) == string(cfg.render()) {
+		return nil
+	}
+	backup, err := s.writeDanteConfig(next)
+	if err != nil {
+		return err
+	}
+	if err := s.restartService(); err != nil {
+		if backup != "" {
+			if rerr := restoreDanteBackup(s, backup); rerr != nil {
+				return fmt.Errorf("%w; restoring %s failed: %v", err, backup, rerr)
+			}
+			_ = s.restartService()
+		}
+		return err
+	}
+	return nil
+}
+
+func socksUsersHaveACL(users []socksUser) bool {
+	for _, u := range users {
+		if len(u.Allow) > 0 || len(u.Deny) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func socksACLAuditValue(u socksUser) string {
+	if len(u.Allow) == 0 && len(u.Deny) == 0 {
+		return ""
+	}
+	return "allow=" + strings.Join(u.Allow, ",") + " deny=" + strings.Join(u.Deny, ",")
+}
+
+// socksACLAuditChanges diffs name->ACL summaries built by socksACLAuditValue.
+func socksACLAuditChanges(before, after map[string]string) []auditChange {
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var out []auditChange
+	for _, name := range names {
+		if before[name] != after[name] {
+			out = append(out, auditChange{Field: "user:" + name + ".acl", Old: before[name], New: after[name]})
+		}
+	}
+	return out
+}
+
+func socksACLText(list []string) string {
+	return valueOrDash(strings.Join(list, ", "))
+}
+
+func runSocksUsersACL(sc *socksClient, args []string) {
+	fs := flag.NewFlagSet("socks users acl", flag.ExitOnError)
+	var allow, deny stringListFlag
+	fs.Var(&allow, "allow", "allowed destination IP/CIDR (repeatable, replaces the list)")
+	fs.Var(&deny, "deny", "denied destination IP/CIDR (repeatable, replaces the list)")
+	clearACL := fs.Bool("clear", false, "remove all destination rules of the user")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	rest := fs.Args()
+	if len(rest) != 1 {
+		fatalf("socks users acl requires USER_ID")
+	}
+
+	users, err := sc.usersList()
+	must(err)
+	current, idx, err := resolveSocksUser(users, rest[0])
+	must(err)
+
+	target := current
+	changed := false
+	if *clearACL {
+		target.Allow, target.Deny = nil, nil
+		changed = true
+	}
+	fs.Visit(func(f *flag.Flag) {
+		var err error
+		switch f.Name {
+		case "allow":
+			target.Allow, err = normalizeSocksDestinations(allow)
+			changed = true
+		case "deny":
+			target.Deny, err = normalizeSocksDestinations(deny)
+			changed = true
+		}
+		if err != nil {
+			fatalf("%v", err)
+		}
+	})
+	if changed {
+		must(requireRoot("socks users acl"))
+		must(sc.editUser(users, idx, target))
+	}
+
+	if *jsonOut {
+		printJSON(map[string]any{"user": target.Name, "allow": target.Allow, "deny": target.Deny})
+		return
+	}
+	if changed {
+		fmt.Printf("SOCKS user ACL updated: %s\n", target.Name)
+	}
+	fmt.Printf("Allow: %s\n", socksACLText(target.Allow))
+	fmt.Printf("Deny: %s\n", socksACLText(target.Deny))
+}
+
+func runSocksACL(sc *socksClient, args []string) {
+	if len(args) < 1 {
+		fatalf("socks acl requires subcommand: show|set|apply")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "show":
+		fs := flag.NewFlagSet("socks acl show", flag.ExitOnError)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks acl show takes only flags")
+		}
+		blockPrivate, err := sc.blockPrivate()
+		must(err)
+		users, err := sc.usersList()
+		must(err)
+		applied, err := sc.aclApplied(users)
+		must(err)
+		var withACL []socksUser
+		for _, u := range users {
+			if len(u.Allow) > 0 || len(u.Deny) > 0 {
+				withACL = append(withACL, u)
+			}
+		}
+		if *jsonOut {
+			out := []map[string]any{}
+			for _, u := range withACL {
+				out = append(out, map[string]any{"user": u.Name, "allow": u.Allow, "deny": u.Deny})
+			}
+			printJSON(map[string]any{"backend": sc.backend, "block_private": blockPrivate, "applied": applied, "users": out})
+			return
+		}
+		fmt.Printf("Backend: %s\n", sc.backend)
+		fmt.Printf("Block internal networks: %t\n", blockPrivate)
+		if !applied {
+			fmt.Printf("Not applied: %s does not have these rules yet; run `psasctl socks acl apply`\n", sc.config)
+		}
+		if len(withACL) == 0 {
+			fmt.Println("No per-user rules.")
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tALLOW\tDENY")
+		for _, u := range withACL {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", u.Name, socksACLText(u.Allow), socksACLText(u.Deny))
+		}
+		must(tw.Flush())
+	case "set":
+		fs := flag.NewFlagSet("socks acl set", flag.ExitOnError)
+		blockPrivate := fs.String("block-private", "", "block loopback/RFC1918/link-local/CGNAT destinations for all users (on|off)")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks acl set takes only flags")
+		}
+		if strings.TrimSpace(*blockPrivate) == "" {
+			fatalf("socks acl set: no changes requested")
+		}
+		on, err := parseBoolLike(*blockPrivate)
+		must(err)
+		must(requireRoot("socks acl set"))
+		must(sc.setBlockPrivate(on))
+		fmt.Printf("Block internal networks: %t\n", on)
+	case "apply":
+		fs := flag.NewFlagSet("socks acl apply", flag.ExitOnError)
+		must(fs.Parse(subArgs))
+		must(requireRoot("socks acl apply"))
+		users, err := sc.usersList()
+		must(err)
+		must(sc.applyACL(users, true))
+		fmt.Println("SOCKS ACL applied.")
+	default:
+		fatalf("unknown socks acl subcommand: %s", sub)
+	}
+}
+
+// aclApplied reports whether danted.conf already holds the rules applyACL
+// would render, so `socks acl show` reflects what Dante actually enforces.
+// The built-in server reads the lists itself and is always in effect.
+func (s *socksClient) aclApplied(users []socksUser) (bool, error) {
+	if s.builtin() {
+		return true, nil
+	}
+	st, err := loadSocksACLSettings()
+	if err != nil {
+		return false, err
+	}
+	raw, err := os.ReadFile(s.config)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	cfg, err := parseDanteConfig(raw)
+	if err != nil {
+		return false, err
+	}
+	return string(cfg.withACL(users, st).render()) == string(cfg.render()), nil
+}
+
+func (s *socksClient) blockPrivate() (bool, error) {
+	if s.builtin() {
+		cfg, err := loadSocksServerConfig(s.serverConfig)
+		return !cfg.AllowPrivate, err
+	}
+	st, err := loadSocksACLSettings()
+	return st.BlockPrivate, err
+}
+
+func (s *socksClient) setBlockPrivate(on bool) error {
+	if s.builtin() {
+		cfg, err := loadSocksServerConfig(s.serverConfig)
+		if err != nil {
+			return err
+		}
+		cfg.AllowPrivate = !on
+		if err := writeSocksServerConfig(s.serverConfig, cfg); err != nil {
+			return err
+		}
+		return s.restartService()
+	}
+	old, ver, err := readSocksACLSettings()
+	if err != nil {
+		return err
+	}
+	st := old
+	st.BlockPrivate = on
+	written, err := commitSocksACLSettings(st, ver)
+	if err != nil {
+		return err
+	}
+	auditRecord("socks.acl.set", socksACLPath(), auditMapChanges(auditStructMap(old), auditStructMap(st)), nil)
+	users, err := s.usersList()
+	if err == nil {
+		err = s.applyACL(users, true)
+	}
+	if err != nil {
+		// Only undo our own write: a concurrent `acl set` since then wins.
+		if _, rerr := commitSocksACLSettings(old, written); rerr != nil {
+			return fmt.Errorf("%w; restoring %s failed: %v", err, socksACLPath(), rerr)
+		}
+		return err
+	}
+	return nil
+}
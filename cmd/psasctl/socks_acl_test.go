@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// danteRuleLine is a one-line form of r for comparing rule order.
+func danteRuleLine(r danteRule) string {
+	line := r.Type + " " + r.Action + " " + r.To
+	if u := r.get("user"); u != "" {
+		line += " user=" + u
+	}
+	return line
+}
+
+func danteRuleLines(cfg danteConfig) []string {
+	var out []string
+	for _, r := range cfg.Rules {
+		out = append(out, danteRuleLine(r))
+	}
+	return out
+}
+
+func danteInternalBlockLines(ipv6 bool) []string {
+	nets := danteInternalNets
+	if ipv6 {
+		nets = append(append([]string(nil), nets...), danteInternalNets6...)
+	}
+	var out []string
+	for _, n := range nets {
+		out = append(out, "socks block "+n)
+	}
+	return out
+}
+
+func joinLines(parts ...[]string) []string {
+	var out []string
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func TestDanteManagedRule(t *testing.T) {
+	tests := []struct {
+		rule danteRule
+		want bool
+	}{
+		{danteRule{Type: "socks", Action: "pass", From: "0.0.0.0/0", To: "10.0.0.0/8", Options: []danteDirective{{Key: "user", Value: "alice"}}}, true},
+		{danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: "8.8.8.8/32", Options: []danteDirective{{Key: "user", Value: "alice"}}}, true},
+		{danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: "192.168.0.0/16"}, true},
+		{danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: "fc00::/7"}, true},
+		{danteRule{Type: "socks", Action: "pass", From: "0.0.0.0/0", To: "0.0.0.0/0"}, false},
+		{danteRule{Type: "socks", Action: "pass", From: "0.0.0.0/0", To: "192.168.0.0/16"}, false},
+		{danteRule{Type: "socks", Action: "block", From: "10.0.0.0/8", To: "192.168.0.0/16"}, false},
+		{danteRule{Type: "socks", Action: "block", From: "0.0.0.0/0", To: "203.0.113.0/24"}, false},
+		{danteRule{Type: "client", Action: "block", From: "0.0.0.0/0", To: "10.0.0.0/8"}, false},
+		{danteRule{Type: "client", Action: "pass", From: "0.0.0.0/0", To: "0.0.0.0/0", Options: []danteDirective{{Key: "user", Value: "alice"}}}, false},
+	}
+	for _, tt := range tests {
+		if got := danteManagedRule(tt.rule); got != tt.want {
+			t.Errorf("danteManagedRule(%s) = %v, want %v", danteRuleLine(tt.rule), got, tt.want)
+		}
+	}
+}
+
+func TestDanteWithACL(t *testing.T) {
+	installer := installerDanteConfig(t, "1080", "20000-50000")
+	dualStack := strings.Replace(installer, "external.protocol: ipv4\n", "", 1)
+	// An admin rule without user: stays after the managed ones.
+	custom := strings.Replace(installer, "socks pass {", "socks block {\n  from: 0.0.0.0/0 to: 203.0.113.0/24\n}\n\nsocks pass {", 1)
+	users := []socksUser{
+		{Name: "alice", Allow: []string{"10.1.0.0/16", "192.168.5.0/24"}, Deny: []string{"8.8.8.8/32"}},
+		{Name: "bob", SystemUser: "bob_sys", Deny: []string{"1.1.1.1/32"}},
+		{Name: "carol"},
+	}
+	userLines := []string{
+		"socks pass 10.1.0.0/16 user=alice",
+		"socks pass 192.168.5.0/24 user=alice",
+		"socks block 8.8.8.8/32 user=alice",
+		"socks block 1.1.1.1/32 user=bob_sys",
+	}
+	client := []string{"client pass 0.0.0.0/0"}
+	catchAll := []string{"socks pass 0.0.0.0/0"}
+
+	tests := []struct {
+		name  string
+		raw   string
+		users []socksUser
+		st    socksACLSettings
+		want  []string
+	}{
+		{"no users", installer, nil, socksACLSettings{BlockPrivate: true},
+			joinLines(client, danteInternalBlockLines(false), catchAll)},
+		{"users ipv4", installer, users, socksACLSettings{BlockPrivate: true},
+			joinLines(client, userLines, danteInternalBlockLines(false), catchAll)},
+		{"users dual stack", dualStack, users, socksACLSettings{BlockPrivate: true},
+			joinLines(client, userLines, danteInternalBlockLines(true), catchAll)},
+		{"private allowed", installer, users, socksACLSettings{},
+			joinLines(client, userLines, catchAll)},
+		{"nothing to render", installer, nil, socksACLSettings{},
+			joinLines(client, catchAll)},
+		{"custom socks rule", custom, users, socksACLSettings{BlockPrivate: true},
+			joinLines(client, userLines, danteInternalBlockLines(false), []string{"socks block 203.0.113.0/24"}, catchAll)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := mustParseDante(t, tt.raw).withACL(tt.users, tt.st)
+			if got := danteRuleLines(next); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("rules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if next.Rules[0].get("log") != "error connect disconnect" {
+				t.Fatalf("client rule changed: %+v", next.Rules[0])
+			}
+
+			// Rendering, reading back and applying again changes nothing.
+			rendered := string(next.render())
+			again := mustParseDante(t, rendered).withACL(tt.users, tt.st)
+			if got := string(again.render()); got != rendered {
+				t.Fatalf("second withACL changed the config:\n%s\nwant:\n%s", got, rendered)
+			}
+		})
+	}
+}
+
+func TestDanteWithACLUserRuleOptions(t *testing.T) {
+	raw := strings.Replace(installerDanteConfig(t, "1080", "40000-40100"), "log: error connect disconnect\n}\n", "log: error\n}\n", 2)
+	users := []socksUser{{Name: "alice", Allow: []string{"10.1.0.0/16"}, Deny: []string{"8.8.8.8/32"}}}
+	next := mustParseDante(t, raw).withACL(users, socksACLSettings{BlockPrivate: true})
+
+	pass, block := next.Rules[1], next.Rules[2]
+	if pass.get("socksmethod") != "username" || pass.get("command") != "connect udpassociate bind" ||
+		pass.get("udp.portrange") != "40000-40100" || pass.get("log") != "error" || pass.From != "0.0.0.0/0" {
+		t.Fatalf("per-user pass rule = %+v", pass)
+	}
+	if block.get("socksmethod") != "username" || block.get("command") != "" || block.get("log") != "error" {
+		t.Fatalf("per-user block rule = %+v", block)
+	}
+	if internal := next.Rules[3]; internal.get("user") != "" || internal.get("log") != "error" {
+		t.Fatalf("internal block rule = %+v", internal)
+	}
+}
+
+func TestDanteWithACLReplacesStaleRules(t *testing.T) {
+	raw := installerDanteConfig(t, "1080", "20000-50000")
+	old := []socksUser{{Name: "alice", Deny: []string{"8.8.8.8/32"}}, {Name: "bob", Allow: []string{"10.0.0.0/8"}}}
+	dualStack := strings.Replace(raw, "external.protocol: ipv4\n", "", 1)
+	cfg := mustParseDante(t, dualStack).withACL(old, socksACLSettings{BlockPrivate: true})
+
+	// bob is gone, alice's list changed and the server became IPv4-only.
+	cfg.set("external.protocol", "ipv4")
+	users := []socksUser{{Name: "alice", Deny: []string{"9.9.9.9/32"}}}
+	got := danteRuleLines(mustParseDante(t, string(cfg.render())).withACL(users, socksACLSettings{BlockPrivate: true}))
+	want := joinLines([]string{"client pass 0.0.0.0/0", "socks block 9.9.9.9/32 user=alice"},
+		danteInternalBlockLines(false), []string{"socks pass 0.0.0.0/0"})
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("rules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
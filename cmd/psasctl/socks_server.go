@@ -0,0 +1,1055 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Built-in SOCKS5 server (`psasctl socks-server run`). Unlike Dante it
+// authenticates against socks-users.json directly, so SOCKS logins need no
+// Linux accounts, and it keeps per-user traffic counters.
+
+const (
+	socksBackendDante   = "dante"
+	socksBackendBuiltin = "builtin"
+
+	defaultSocksServerService  = "psas-socks"
+	defaultSocksServerConfig   = "/etc/psas/socks-server.json"
+	defaultSocksServerUnitPath = "/etc/systemd/system/psas-socks.service"
+	defaultSocksStatsPath      = "/var/lib/psas/socks-stats.json"
+
+	socksHandshakeTimeout = 30 * time.Second
+	socksDialTimeout      = 15 * time.Second
+	socksStatsInterval    = 30 * time.Second
+	socksUDPPeerLimit     = 4096
+)
+
+// SOCKS5 reply codes (RFC 1928, section 6).
+const (
+	socksRepSucceeded       = 0x00
+	socksRepGeneralFailure  = 0x01
+	socksRepNotAllowed      = 0x02
+	socksRepNetUnreachable  = 0x03
+	socksRepHostUnreachable = 0x04
+	socksRepRefused         = 0x05
+	socksRepCmdUnsupported  = 0x07
+	socksRepAddrUnsupported = 0x08
+)
+
+var errSocksDenied = errors.New("destination not allowed")
+
+func socksServerConfigPath() string {
+	return envOr("PSAS_SOCKS_SERVER_CONF", defaultSocksServerConfig)
+}
+
+func socksStatsPath() string {
+	return envOr("PSAS_SOCKS_STATS", defaultSocksStatsPath)
+}
+
+// socksServerConfig is stored in socks-server.json. Destinations in Allow
+// are always reachable; Deny and, unless AllowPrivate is set, loopback,
+// private, link-local and the host's own addresses are refused.
+type socksServerConfig struct {
+	Listen          string   `json:"listen"`
+	MaxConns        int      `json:"max_conns,omitempty"`
+	MaxConnsPerUser int      `json:"max_conns_per_user,omitempty"`
+	AllowPrivate    bool     `json:"allow_private,omitempty"`
+	Allow           []string `json:"allow,omitempty"`
+	Deny            []string `json:"deny,omitempty"`
+}
+
+func defaultSocksServerConf() socksServerConfig {
+	return socksServerConfig{Listen: "0.0.0.0:" + strconv.Itoa(defaultSocksPort)}
+}
+
+func loadSocksServerConfig(path string) (socksServerConfig, error) {
+	raw, err := loadStateFile(path)
+	if err != nil {
+		return defaultSocksServerConf(), err
+	}
+	return decodeSocksServerConfig(path, raw)
+}
+
+// decodeSocksServerConfig parses raw (nil for a missing file) over the
+// defaults.
+func decodeSocksServerConfig(path string, raw []byte) (socksServerConfig, error) {
+	cfg := defaultSocksServerConf()
+	if raw == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if strings.TrimSpace(cfg.Listen) == "" {
+		cfg.Listen = defaultSocksServerConf().Listen
+	}
+	return cfg, cfg.validate()
+}
+
+func (cfg socksServerConfig) validate() error {
+	host, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", cfg.Listen, err)
+	}
+	if host != "" {
+		if _, err := netip.ParseAddr(host); err != nil {
+			return fmt.Errorf("invalid listen address %q: host must be an IP", cfg.Listen)
+		}
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid listen port %q", port)
+	}
+	if cfg.MaxConns < 0 || cfg.MaxConnsPerUser < 0 {
+		return errors.New("connection limits must not be negative")
+	}
+	_, err = parseSocksACL(cfg.Allow, cfg.Deny, cfg.AllowPrivate)
+	return err
+}
+
+func writeSocksServerConfig(path string, cfg socksServerConfig) (err error) {
+	// Read the previous config for the audit entry without touching the
+	// version remembered at load.
+	before := map[string]any{}
+	if raw, _, rerr := readStateFile(path); rerr == nil && raw != nil {
+		if old, derr := decodeSocksServerConfig(path, raw); derr == nil {
+			before = auditStructMap(old)
+		}
+	}
+	defer func() {
+		auditRecord("socks.server.config", path, auditMapChanges(before, auditStructMap(cfg)), err)
+	}()
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	payload, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return saveStateFile(path, append(payload, '\n'), 0o600)
+}
+
+// socksACL decides which destination addresses a client may reach.
+type socksACL struct {
+	allow        []netip.Prefix
+	deny         []netip.Prefix
+	allowPrivate bool
+	local        map[netip.Addr]bool
+}
+
+func parseSocksACL(allow, deny []string, allowPrivate bool) (socksACL, error) {
+	acl := socksACL{allowPrivate: allowPrivate}
+	var err error
+	if acl.allow, err = parseSocksPrefixes(allow); err != nil {
+		return acl, err
+	}
+	if acl.deny, err = parseSocksPrefixes(deny); err != nil {
+		return acl, err
+	}
+	return acl, nil
+}
+
+// parseSocksPrefixes accepts CIDRs and bare IPs.
+func parseSocksPrefixes(items []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, raw := range items {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(raw); err == nil {
+			ip = ip.Unmap()
+			out = append(out, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination %q: expected IP or CIDR", raw)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func socksPrefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var socksCGNATPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// socksInternalAddr reports addresses a proxy user should not reach by
+// default: the host itself and networks behind it.
+func socksInternalAddr(ip netip.Addr) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() || socksCGNATPrefix.Contains(ip)
+}
+
+func (a socksACL) permits(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if socksPrefixesContain(a.allow, ip) {
+		return true
+	}
+	if socksPrefixesContain(a.deny, ip) {
+		return false
+	}
+	if !a.allowPrivate && (socksInternalAddr(ip) || a.local[ip]) {
+		return false
+	}
+	return true
+}
+
+// hostAddrs lists the addresses configured on this host's interfaces.
+func hostAddrs() map[netip.Addr]bool {
+	out := map[netip.Addr]bool{}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return out
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(n.IP); ok {
+				out[ip.Unmap()] = true
+			}
+		}
+	}
+	return out
+}
+
+// socksUserStats is the per-user entry of socks-stats.json. Byte counts are
+// seen from the client: up is client -> destination.
+type socksUserStats struct {
+	BytesUp     int64     `json:"bytes_up"`
+	BytesDown   int64     `json:"bytes_down"`
+	Connections int64     `json:"connections"`
+	Active      int       `json:"active"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+type socksStats struct {
+	Updated time.Time                 `json:"updated"`
+	Users   map[string]socksUserStats `json:"users"`
+}
+
+func loadSocksStats(path string) (socksStats, error) {
+	st := socksStats{Users: map[string]socksUserStats{}}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return st, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if st.Users == nil {
+		st.Users = map[string]socksUserStats{}
+	}
+	return st, nil
+}
+
+type socksCounter struct {
+	up, down, conns, lastSeen atomic.Int64
+}
+
+type socksCountingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c socksCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// socksServerUser is a login the server accepts, with its destination ACL.
+type socksServerUser struct {
+	password    string
+	allow, deny []netip.Prefix
+}
+
+type socksServer struct {
+	cfg       socksServerConfig
+	acl       socksACL
+	usersPath string
+	statsPath string
+	slots     chan struct{}
+
+	mu         sync.Mutex
+	users      map[string]socksServerUser
+	usersStamp string
+	active     map[string]int
+	counters   map[string]*socksCounter
+}
+
+func newSocksServer(cfg socksServerConfig, usersPath, statsPath string) (*socksServer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	acl, err := parseSocksACL(cfg.Allow, cfg.Deny, cfg.AllowPrivate)
+	if err != nil {
+		return nil, err
+	}
+	acl.local = hostAddrs()
+	s := &socksServer{
+		cfg:       cfg,
+		acl:       acl,
+		usersPath: usersPath,
+		statsPath: statsPath,
+		users:     map[string]socksServerUser{},
+		active:    map[string]int{},
+		counters:  map[string]*socksCounter{},
+	}
+	if cfg.MaxConns > 0 {
+		s.slots = make(chan struct{}, cfg.MaxConns)
+	}
+	st, err := loadSocksStats(statsPath)
+	if err != nil {
+		socksServerLogf("stats: %v (starting from zero)", err)
+	}
+	for name, u := range st.Users {
+		c := s.counter(name)
+		c.up.Store(u.BytesUp)
+		c.down.Store(u.BytesDown)
+		c.conns.Store(u.Connections)
+		c.lastSeen.Store(u.LastSeen.Unix())
+	}
+	return s, s.reloadUsers()
+}
+
+func socksServerLogf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "%s socks-server: %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// reloadUsers re-reads socks-users.json when its size or mtime changed, so
+// `socks users add|edit|del` take effect without a restart.
+func (s *socksServer) reloadUsers() error {
+	fi, err := os.Stat(s.usersPath)
+	stamp := ""
+	if err == nil {
+		stamp = fmt.Sprintf("%d/%d", fi.Size(), fi.ModTime().UnixNano())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.mu.Lock()
+	same := stamp == s.usersStamp
+	s.mu.Unlock()
+	if same {
+		return nil
+	}
+	users := map[string]socksServerUser{}
+	if stamp != "" {
+		raw, err := os.ReadFile(s.usersPath)
+		if err != nil {
+			return err
+		}
+		list, err := parseSocksUsers(raw, s.usersPath)
+		if err != nil {
+			return err
+		}
+		for _, u := range list {
+			if u.Password == "" {
+				continue
+			}
+			allow, err := parseSocksPrefixes(u.Allow)
+			if err == nil {
+				var deny []netip.Prefix
+				deny, err = parseSocksPrefixes(u.Deny)
+				users[u.Name] = socksServerUser{password: u.Password, allow: allow, deny: deny}
+			}
+			if err != nil {
+				socksServerLogf("users: %s: %v (user disabled)", u.Name, err)
+				delete(users, u.Name)
+			}
+		}
+	}
+	s.mu.Lock()
+	s.users = users
+	s.usersStamp = stamp
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *socksServer) authenticate(name, pass string) bool {
+	if err := s.reloadUsers(); err != nil {
+		socksServerLogf("users: %v (keeping previous list)", err)
+	}
+	s.mu.Lock()
+	want, ok := s.users[name]
+	s.mu.Unlock()
+	return ok && subtle.ConstantTimeCompare([]byte(want.password), []byte(pass)) == 1
+}
+
+func (s *socksServer) counter(name string) *socksCounter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		c = &socksCounter{}
+		s.counters[name] = c
+	}
+	return c
+}
+
+// acquire registers one more connection for user, honoring the per-user
+// limit.
+func (s *socksServer) acquire(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.MaxConnsPerUser > 0 && s.active[user] >= s.cfg.MaxConnsPerUser {
+		return false
+	}
+	s.active[user]++
+	return true
+}
+
+func (s *socksServer) release(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[user]--; s.active[user] <= 0 {
+		delete(s.active, user)
+	}
+}
+
+func (s *socksServer) snapshot() socksStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := socksStats{Updated: time.Now().UTC(), Users: map[string]socksUserStats{}}
+	for name, c := range s.counters {
+		u := socksUserStats{
+			BytesUp:     c.up.Load(),
+			BytesDown:   c.down.Load(),
+			Connections: c.conns.Load(),
+			Active:      s.active[name],
+		}
+		if ts := c.lastSeen.Load(); ts > 0 {
+			u.LastSeen = time.Unix(ts, 0).UTC()
+		}
+		st.Users[name] = u
+	}
+	return st
+}
+
+func (s *socksServer) flushStats() error {
+	payload, err := json.MarshalIndent(s.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(s.statsPath, append(payload, '\n'), 0o600)
+}
+
+func (s *socksServer) run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	socksServerLogf("listening on %s (users: %s)", ln.Addr(), s.usersPath)
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(socksStatsInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := s.flushStats(); err != nil {
+					socksServerLogf("stats: %v", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				<-done
+				return s.flushStats()
+			}
+			socksServerLogf("accept: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				_ = conn.Close()
+				continue
+			}
+		}
+		go func() {
+			defer func() {
+				if s.slots != nil {
+					<-s.slots
+				}
+			}()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *socksServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	r := bufio.NewReader(conn)
+
+	user, err := s.negotiate(r, conn)
+	if err != nil {
+		if user != "" {
+			socksServerLogf("%s: auth failed for %q", conn.RemoteAddr(), user)
+		}
+		return
+	}
+
+	cmd, host, port, err := readSocksRequest(r)
+	if err != nil {
+		var rep socksReplyError
+		if errors.As(err, &rep) {
+			_ = writeSocksReply(conn, rep.code, netip.AddrPort{})
+		}
+		return
+	}
+	if !s.acquire(user) {
+		socksServerLogf("%s: %s reached max_conns_per_user=%d", conn.RemoteAddr(), user, s.cfg.MaxConnsPerUser)
+		_ = writeSocksReply(conn, socksRepNotAllowed, netip.AddrPort{})
+		return
+	}
+	defer s.release(user)
+	c := s.counter(user)
+	c.conns.Add(1)
+	c.lastSeen.Store(time.Now().Unix())
+
+	switch cmd {
+	case 0x01:
+		s.connect(ctx, conn, r, user, host, port, c)
+	case 0x03:
+		s.udpAssociate(ctx, conn, user, c)
+	default:
+		_ = writeSocksReply(conn, socksRepCmdUnsupported, netip.AddrPort{})
+	}
+}
+
+// negotiate runs method selection and RFC 1929 username/password auth. It
+// returns the login even on failure so the caller can log it.
+func (s *socksServer) negotiate(r *bufio.Reader, w io.Writer) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	if head[0] != 0x05 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+	if !strings.ContainsRune(string(methods), 0x02) {
+		_, _ = w.Write([]byte{0x05, 0xff})
+		return "", errors.New("client does not offer username/password auth")
+	}
+	if _, err := w.Write([]byte{0x05, 0x02}); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	if head[0] != 0x01 {
+		return "", fmt.Errorf("unsupported auth version %d", head[0])
+	}
+	name := make([]byte, head[1])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	plen, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	pass := make([]byte, plen)
+	if _, err := io.ReadFull(r, pass); err != nil {
+		return "", err
+	}
+	user := string(name)
+	if !s.authenticate(user, string(pass)) {
+		_, _ = w.Write([]byte{0x01, 0x01})
+		return user, errors.New("invalid credentials")
+	}
+	if _, err := w.Write([]byte{0x01, 0x00}); err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+type socksReplyError struct {
+	code byte
+	err  error
+}
+
+func (e socksReplyError) Error() string { return e.err.Error() }
+
+func readSocksRequest(r *bufio.Reader) (cmd byte, host string, port uint16, err error) {
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return 0, "", 0, err
+	}
+	if head[0] != 0x05 {
+		return 0, "", 0, fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+	host, err = readSocksAddr(r, head[3])
+	if err != nil {
+		return 0, "", 0, err
+	}
+	var p [2]byte
+	if _, err = io.ReadFull(r, p[:]); err != nil {
+		return 0, "", 0, err
+	}
+	return head[1], host, binary.BigEndian.Uint16(p[:]), nil
+}
+
+func readSocksAddr(r io.Reader, atyp byte) (string, error) {
+	switch atyp {
+	case 0x01, 0x04:
+		b := make([]byte, 4)
+		if atyp == 0x04 {
+			b = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		ip, _ := netip.AddrFromSlice(b)
+		return ip.Unmap().String(), nil
+	case 0x03:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return "", socksReplyError{socksRepAddrUnsupported, fmt.Errorf("unsupported address type %d", atyp)}
+}
+
+func appendSocksAddr(b []byte, ap netip.AddrPort) []byte {
+	ip := ap.Addr().Unmap()
+	if !ip.IsValid() {
+		ip = netip.IPv4Unspecified()
+	}
+	if ip.Is4() {
+		b = append(b, 0x01)
+	} else {
+		b = append(b, 0x04)
+	}
+	b = append(b, ip.AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, ap.Port())
+}
+
+func writeSocksReply(w io.Writer, rep byte, bound netip.AddrPort) error {
+	_, err := w.Write(appendSocksAddr([]byte{0x05, rep, 0x00}, bound))
+	return err
+}
+
+func netAddrPort(a net.Addr) netip.AddrPort {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v.AddrPort()
+	case *net.UDPAddr:
+		return v.AddrPort()
+	}
+	return netip.AddrPort{}
+}
+
+// resolve returns the first address of host that the ACL permits. Names are
+// resolved here, not by the dialer, so the check applies to the address that
+// is actually used.
+func (s *socksServer) resolve(ctx context.Context, user, host string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if !s.permits(user, ip) {
+			return netip.Addr{}, errSocksDenied
+		}
+		return ip, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, socksDialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, ip := range addrs {
+		if ip = ip.Unmap(); s.permits(user, ip) {
+			return ip, nil
+		}
+	}
+	if len(addrs) > 0 {
+		return netip.Addr{}, errSocksDenied
+	}
+	return netip.Addr{}, fmt.Errorf("no addresses for %s", host)
+}
+
+// permits applies the user's own lists first: an allow match wins over
+// everything, a deny match refuses, anything else falls to the server ACL.
+func (s *socksServer) permits(user string, ip netip.Addr) bool {
+	s.mu.Lock()
+	u := s.users[user]
+	s.mu.Unlock()
+	for _, p := range u.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	for _, p := range u.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return s.acl.permits(ip)
+}
+
+func socksDialReply(err error) byte {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, errSocksDenied):
+		return socksRepNotAllowed
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socksRepRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socksRepNetUnreachable
+	case errors.As(err, &opErr) && opErr.Timeout(), errors.Is(err, syscall.EHOSTUNREACH):
+		return socksRepHostUnreachable
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return socksRepHostUnreachable
+	}
+	return socksRepGeneralFailure
+}
+
+func (s *socksServer) connect(ctx context.Context, conn net.Conn, r *bufio.Reader, user, host string, port uint16, c *socksCounter) {
+	ip, err := s.resolve(ctx, user, host)
+	if err == nil {
+		var remote net.Conn
+		d := net.Dialer{Timeout: socksDialTimeout}
+		remote, err = d.DialContext(ctx, "tcp", netip.AddrPortFrom(ip, port).String())
+		if err == nil {
+			defer remote.Close()
+			if err := writeSocksReply(conn, socksRepSucceeded, netAddrPort(remote.LocalAddr())); err != nil {
+				return
+			}
+			_ = conn.SetDeadline(time.Time{})
+			s.relay(conn, r, remote, c)
+			return
+		}
+	}
+	if errors.Is(err, errSocksDenied) {
+		socksServerLogf("%s: %s denied CONNECT %s", conn.RemoteAddr(), user, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}
+	_ = writeSocksReply(conn, socksDialReply(err), netip.AddrPort{})
+}
+
+// relay copies both directions until each side has finished, passing
+// half-closes through.
+func (s *socksServer) relay(client net.Conn, clientR io.Reader, remote net.Conn, c *socksCounter) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(socksCountingWriter{remote, &c.up}, clientR)
+		closeWrite(remote)
+	}()
+	_, _ = io.Copy(socksCountingWriter{client, &c.down}, remote)
+	closeWrite(client)
+	wg.Wait()
+	c.lastSeen.Store(time.Now().Unix())
+}
+
+func closeWrite(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+		return
+	}
+	_ = c.Close()
+}
+
+// udpAssociate relays datagrams for one client until its control connection
+// closes. Only the client's address may send requests, and only peers the
+// client has sent to get their replies forwarded.
+func (s *socksServer) udpAssociate(ctx context.Context, conn net.Conn, user string, c *socksCounter) {
+	local := netAddrPort(conn.LocalAddr())
+	clientIP := netAddrPort(conn.RemoteAddr()).Addr().Unmap()
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local.Addr(), 0)))
+	if err != nil {
+		_ = writeSocksReply(conn, socksRepGeneralFailure, netip.AddrPort{})
+		return
+	}
+	defer pc.Close()
+	if err := writeSocksReply(conn, socksRepSucceeded, netAddrPort(pc.LocalAddr())); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = pc.Close()
+	}()
+
+	var client netip.AddrPort
+	peers := map[netip.AddrPort]bool{}
+	resolved := map[string]netip.Addr{}
+	buf := make([]byte, 65535)
+	out := make([]byte, 0, 65535+22)
+	for {
+		n, from, err := pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		if from.Addr() == clientIP && (!client.IsValid() || from == client) {
+			host, port, payload, err := parseSocksUDPHeader(buf[:n])
+			if err != nil {
+				continue
+			}
+			client = from
+			ip, ok := resolved[host]
+			if !ok {
+				if ip, err = s.resolve(ctx, user, host); err != nil {
+					continue
+				}
+				if len(resolved) >= socksUDPPeerLimit {
+					clear(resolved)
+				}
+				resolved[host] = ip
+			}
+			dst := netip.AddrPortFrom(ip, port)
+			if len(peers) >= socksUDPPeerLimit {
+				clear(peers)
+			}
+			peers[dst] = true
+			if _, err := pc.WriteToUDPAddrPort(payload, dst); err == nil {
+				c.up.Add(int64(len(payload)))
+			}
+			continue
+		}
+		if !client.IsValid() || !peers[from] {
+			continue
+		}
+		out = append(appendSocksAddr(append(out[:0], 0, 0, 0), from), buf[:n]...)
+		if _, err := pc.WriteToUDPAddrPort(out, client); err == nil {
+			c.down.Add(int64(n))
+			c.lastSeen.Store(time.Now().Unix())
+		}
+	}
+}
+
+// parseSocksUDPHeader splits a client datagram (RFC 1928, section 7).
+// Fragmented datagrams are not supported and are rejected.
+func parseSocksUDPHeader(b []byte) (host string, port uint16, payload []byte, err error) {
+	if len(b) < 4 {
+		return "", 0, nil, errors.New("short datagram")
+	}
+	if b[2] != 0 {
+		return "", 0, nil, errors.New("fragmented datagram")
+	}
+	r := bytes.NewReader(b[4:])
+	host, err = readSocksAddr(r, b[3])
+	if err != nil {
+		return "", 0, nil, err
+	}
+	var p [2]byte
+	if _, err := io.ReadFull(r, p[:]); err != nil {
+		return "", 0, nil, err
+	}
+	return host, binary.BigEndian.Uint16(p[:]), b[len(b)-r.Len():], nil
+}
+
+func runSocksServer(args []string) {
+	if len(args) < 1 {
+		fatalf("socks-server requires subcommand: run|unit|install|uninstall")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+
+	switch sub {
+	case "run":
+		fs := flag.NewFlagSet("socks-server run", flag.ExitOnError)
+		configPath := fs.String("config", socksServerConfigPath(), "server config file")
+		listen := fs.String("listen", "", "override listen address from the config")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks-server run takes only flags")
+		}
+		cfg, err := loadSocksServerConfig(*configPath)
+		must(err)
+		if strings.TrimSpace(*listen) != "" {
+			cfg.Listen = strings.TrimSpace(*listen)
+		}
+		srv, err := newSocksServer(cfg, envOr("PSAS_SOCKS_USERS", defaultSocksUsers), socksStatsPath())
+		must(err)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		must(srv.run(ctx))
+	case "unit":
+		fs := flag.NewFlagSet("socks-server unit", flag.ExitOnError)
+		must(fs.Parse(subArgs))
+		fmt.Print(renderSocksServerUnit())
+	case "install":
+		fs := flag.NewFlagSet("socks-server install", flag.ExitOnError)
+		listen := fs.String("listen", "", "listen address (default 0.0.0.0:1080)")
+		maxConns := fs.Int("max-conns", 0, "max concurrent connections in total (0 = unlimited)")
+		maxPerUser := fs.Int("max-conns-per-user", 0, "max concurrent connections per user (0 = unlimited)")
+		allowPrivate := fs.Bool("allow-private", false, "allow loopback/private/link-local destinations and the host's own addresses")
+		var allow, deny stringListFlag
+		fs.Var(&allow, "allow", "always allowed destination IP/CIDR (repeatable)")
+		fs.Var(&deny, "deny", "denied destination IP/CIDR (repeatable)")
+		unitPath := fs.String("unit-path", defaultSocksServerUnitPath, "systemd unit file path")
+		noStart := fs.Bool("no-start", false, "write config and unit but do not enable/start it")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks-server install takes only flags")
+		}
+		must(requireRoot("socks-server install"))
+
+		path := socksServerConfigPath()
+		cfg, err := loadSocksServerConfig(path)
+		must(err)
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "listen":
+				cfg.Listen = strings.TrimSpace(*listen)
+			case "max-conns":
+				cfg.MaxConns = *maxConns
+			case "max-conns-per-user":
+				cfg.MaxConnsPerUser = *maxPerUser
+			case "allow-private":
+				cfg.AllowPrivate = *allowPrivate
+			case "allow":
+				cfg.Allow = allow
+			case "deny":
+				cfg.Deny = deny
+			}
+		})
+		must(writeSocksServerConfig(path, cfg))
+		fmt.Printf("Config written: %s\n", path)
+		if out, _ := runCommandOutput("systemctl", "is-active", defaultSocksService); strings.TrimSpace(out) == "active" {
+			fmt.Printf("Warning: %s is still active; stop it (systemctl disable --now %s) if it uses the same port\n", defaultSocksService, defaultSocksService)
+		}
+		must(installPSASUnit(*unitPath, renderSocksServerUnit(), !*noStart))
+	case "uninstall":
+		fs := flag.NewFlagSet("socks-server uninstall", flag.ExitOnError)
+		unitPath := fs.String("unit-path", defaultSocksServerUnitPath, "systemd unit file path")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 0 {
+			fatalf("socks-server uninstall takes only flags")
+		}
+		must(requireRoot("socks-server uninstall"))
+		name := strings.TrimSuffix(filepath.Base(*unitPath), ".service")
+		if fileExists(*unitPath) {
+			_ = runCommand("systemctl", "disable", name)
+			if err := runServiceAction("stop", name); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+			must(os.Remove(*unitPath))
+			_ = runCommand("systemctl", "daemon-reload")
+			fmt.Printf("Unit removed: %s\n", *unitPath)
+		}
+		path := socksServerConfigPath()
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatalf("%v", err)
+		}
+		auditRecord("socks.server.uninstall", path, nil, nil)
+		fmt.Println("SOCKS backend switched back to Dante.")
+		fmt.Println("Run `psasctl socks users sync` to recreate Linux accounts for users added meanwhile.")
+	default:
+		fatalf("unknown socks-server subcommand: %s", sub)
+	}
+}
+
+func renderSocksServerUnit() string {
+	return renderPSASUnit("PSAS built-in SOCKS5 server", []string{"socks-server", "run"})
+}
+
+func runSocksStats(sc *socksClient, args []string) {
+	fs := flag.NewFlagSet("socks stats", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("socks stats takes only flags")
+	}
+	if !sc.builtin() {
+		fatalf("socks stats needs the built-in SOCKS server (psasctl socks-server install); Dante keeps no per-user counters")
+	}
+	st, err := loadSocksStats(socksStatsPath())
+	must(err)
+	if *jsonOut {
+		printJSON(st)
+		return
+	}
+	names := make([]string, 0, len(st.Users))
+	for name := range st.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tUP\tDOWN\tCONNECTIONS\tACTIVE\tLAST SEEN")
+	for _, name := range names {
+		u := st.Users[name]
+		last := ""
+		if !u.LastSeen.IsZero() {
+			last = u.LastSeen.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", name, formatByteSize(u.BytesUp), formatByteSize(u.BytesDown), u.Connections, u.Active, valueOrDash(last))
+	}
+	must(tw.Flush())
+	if !st.Updated.IsZero() {
+		fmt.Printf("Updated: %s\n", st.Updated.Local().Format(time.RFC3339))
+	}
+}
+
+func formatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
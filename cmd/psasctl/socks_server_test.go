@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSocksServer starts the built-in server on a loopback port with
+// users written to a temp socks-users.json. It returns the listen address.
+func newTestSocksServer(t *testing.T, cfg socksServerConfig, users []socksUser) (*socksServer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	usersPath := filepath.Join(dir, "socks-users.json")
+	raw, err := json.Marshal(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(usersPath, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = "127.0.0.1:1080"
+	}
+	s, err := newSocksServer(cfg, usersPath, filepath.Join(dir, "socks-stats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handle(ctx, conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		ln.Close()
+		wg.Wait()
+	})
+	return s, ln.Addr().String()
+}
+
+// dialTestSocks connects and authenticates as user.
+func dialTestSocks(t *testing.T, addr, user, pass string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	auth := append([]byte{0x05, 0x01, 0x02, 0x01, byte(len(user))}, user...)
+	auth = append(append(auth, byte(len(pass))), pass...)
+	if _, err := conn.Write(auth); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte{0x05, 0x02, 0x01, 0x00}) {
+		t.Fatalf("handshake reply = % x", reply)
+	}
+	return conn
+}
+
+// socksTestRequest sends a request for an IP destination and returns the
+// reply code and bound address.
+func socksTestRequest(t *testing.T, conn net.Conn, cmd byte, dst netip.AddrPort) (byte, netip.AddrPort) {
+	t.Helper()
+	req := appendSocksAddr([]byte{0x05, cmd, 0x00}, dst)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	return readTestSocksReply(t, conn)
+}
+
+func readTestSocksReply(t *testing.T, conn net.Conn) (byte, netip.AddrPort) {
+	t.Helper()
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	host, err := readSocksAddr(conn, head[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p [2]byte
+	if _, err := io.ReadFull(conn, p[:]); err != nil {
+		t.Fatal(err)
+	}
+	ip, _ := netip.ParseAddr(host)
+	return head[1], netip.AddrPortFrom(ip, binary.BigEndian.Uint16(p[:]))
+}
+
+func TestSocksServerNegotiate(t *testing.T) {
+	s, _ := newTestSocksServer(t, socksServerConfig{}, []socksUser{{Name: "alice", Password: "pw"}, {Name: "nopass"}})
+
+	auth := func(user, pass string) []byte {
+		b := append([]byte{0x01, byte(len(user))}, user...)
+		return append(append(b, byte(len(pass))), pass...)
+	}
+	tests := []struct {
+		name     string
+		in       []byte
+		wantUser string
+		wantOK   bool
+		wantOut  []byte
+	}{
+		{"valid", append([]byte{0x05, 0x02, 0x00, 0x02}, auth("alice", "pw")...), "alice", true, []byte{0x05, 0x02, 0x01, 0x00}},
+		{"wrong password", append([]byte{0x05, 0x01, 0x02}, auth("alice", "nope")...), "alice", false, []byte{0x05, 0x02, 0x01, 0x01}},
+		{"unknown user", append([]byte{0x05, 0x01, 0x02}, auth("bob", "pw")...), "bob", false, []byte{0x05, 0x02, 0x01, 0x01}},
+		{"user without password", append([]byte{0x05, 0x01, 0x02}, auth("nopass", "")...), "nopass", false, []byte{0x05, 0x02, 0x01, 0x01}},
+		{"no auth offered", []byte{0x05, 0x01, 0x00}, "", false, []byte{0x05, 0xff}},
+		{"SOCKS4", []byte{0x04, 0x01, 0x00, 0x50}, "", false, nil},
+		{"bad auth version", append([]byte{0x05, 0x01, 0x02, 0x02}, auth("alice", "pw")[1:]...), "", false, []byte{0x05, 0x02}},
+		{"truncated methods", []byte{0x05, 0x03, 0x02}, "", false, nil},
+		{"truncated password", append([]byte{0x05, 0x01, 0x02}, auth("alice", "pw")[:8]...), "", false, []byte{0x05, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			user, err := s.negotiate(bufio.NewReader(bytes.NewReader(tt.in)), &out)
+			if (err == nil) != tt.wantOK || user != tt.wantUser {
+				t.Fatalf("negotiate = %q, %v; want %q, ok=%v", user, err, tt.wantUser, tt.wantOK)
+			}
+			if !bytes.Equal(out.Bytes(), tt.wantOut) {
+				t.Fatalf("wrote % x, want % x", out.Bytes(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestSocksServerConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	echoAddr := netAddrPort(echo.Addr())
+
+	s, addr := newTestSocksServer(t, socksServerConfig{}, []socksUser{
+		{Name: "alice", Password: "pw", Allow: []string{"127.0.0.1"}},
+		{Name: "bob", Password: "pw"},
+	})
+
+	conn := dialTestSocks(t, addr, "alice", "pw")
+	if rep, bound := socksTestRequest(t, conn, 0x01, echoAddr); rep != socksRepSucceeded || !bound.Addr().IsLoopback() {
+		t.Fatalf("CONNECT reply = %d, bound %s", rep, bound)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("echo = %q, %v", got, err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	c := s.counter("alice")
+	for (c.up.Load() != 4 || c.down.Load() != 4) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.up.Load() != 4 || c.down.Load() != 4 || c.conns.Load() != 1 {
+		t.Fatalf("counters: up %d, down %d, conns %d; want 4, 4, 1", c.up.Load(), c.down.Load(), c.conns.Load())
+	}
+
+	// Loopback is internal: refused for a user without an allow entry.
+	conn = dialTestSocks(t, addr, "bob", "pw")
+	if rep, _ := socksTestRequest(t, conn, 0x01, echoAddr); rep != socksRepNotAllowed {
+		t.Fatalf("CONNECT to loopback = %d, want %d", rep, socksRepNotAllowed)
+	}
+
+	// A name is checked by the address it resolves to.
+	conn = dialTestSocks(t, addr, "bob", "pw")
+	req := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len("localhost"))}, "localhost"...)
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(req, echoAddr.Port())); err != nil {
+		t.Fatal(err)
+	}
+	if rep, _ := readTestSocksReply(t, conn); rep != socksRepNotAllowed {
+		t.Fatalf("CONNECT to localhost = %d, want %d", rep, socksRepNotAllowed)
+	}
+
+	conn = dialTestSocks(t, addr, "alice", "pw")
+	if rep, _ := socksTestRequest(t, conn, 0x02, echoAddr); rep != socksRepCmdUnsupported {
+		t.Fatalf("BIND reply = %d, want %d", rep, socksRepCmdUnsupported)
+	}
+
+	conn = dialTestSocks(t, addr, "alice", "pw")
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00, 0x05, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if rep, _ := readTestSocksReply(t, conn); rep != socksRepAddrUnsupported {
+		t.Fatalf("unknown address type reply = %d, want %d", rep, socksRepAddrUnsupported)
+	}
+}
+
+func TestParseSocksUDPHeader(t *testing.T) {
+	v4 := []byte{0, 0, 0, 0x01, 192, 0, 2, 1, 0x00, 0x35}
+	v6 := append(append([]byte{0, 0, 0, 0x04}, netip.MustParseAddr("2001:db8::1").AsSlice()...), 0x01, 0xbb)
+	name := append(append([]byte{0, 0, 0, 0x03, 11}, "example.com"...), 0x00, 0x50)
+	with := func(b []byte, payload string) []byte {
+		return append(append([]byte(nil), b...), payload...)
+	}
+	tests := []struct {
+		name    string
+		in      []byte
+		host    string
+		port    uint16
+		payload string
+		wantErr bool
+	}{
+		{"ipv4", with(v4, "query"), "192.0.2.1", 53, "query", false},
+		{"ipv6", with(v6, "x"), "2001:db8::1", 443, "x", false},
+		{"domain", with(name, "GET"), "example.com", 80, "GET", false},
+		{"empty payload", v4, "192.0.2.1", 53, "", false},
+		{"mapped ipv6", append(append([]byte{0, 0, 0, 0x04}, netip.MustParseAddr("::ffff:192.0.2.1").AsSlice()...), 0, 53), "192.0.2.1", 53, "", false},
+		{"empty", nil, "", 0, "", true},
+		{"short header", []byte{0, 0, 0}, "", 0, "", true},
+		{"no address", []byte{0, 0, 0, 0x01}, "", 0, "", true},
+		{"truncated ipv4", v4[:7], "", 0, "", true},
+		{"truncated ipv6", v6[:12], "", 0, "", true},
+		{"missing port", v4[:8], "", 0, "", true},
+		{"half port", v4[:9], "", 0, "", true},
+		{"truncated domain", name[:10], "", 0, "", true},
+		{"domain without length", []byte{0, 0, 0, 0x03}, "", 0, "", true},
+		{"fragment 1", append([]byte{0, 0, 1}, with(v4, "query")[3:]...), "", 0, "", true},
+		{"last fragment", append([]byte{0, 0, 0x81}, with(v4, "query")[3:]...), "", 0, "", true},
+		{"unknown address type", []byte{0, 0, 0, 0x05, 1, 2, 3, 4, 0, 53}, "", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, payload, err := parseSocksUDPHeader(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSocksUDPHeader(% x) = %q, %d, %q; want error", tt.in, host, port, payload)
+				}
+				return
+			}
+			if err != nil || host != tt.host || port != tt.port || string(payload) != tt.payload {
+				t.Fatalf("parseSocksUDPHeader = %q, %d, %q, %v; want %q, %d, %q", host, port, payload, err, tt.host, tt.port, tt.payload)
+			}
+		})
+	}
+}
+
+func TestSocksServerPermits(t *testing.T) {
+	s, _ := newTestSocksServer(t, socksServerConfig{
+		Allow: []string{"10.9.0.0/16"},
+		Deny:  []string{"198.51.100.0/24", "2001:db8:bad::/48"},
+	}, []socksUser{
+		{Name: "alice", Password: "pw", Allow: []string{"192.168.1.0/24", "198.51.100.7"}, Deny: []string{"10.9.1.0/24", "203.0.113.0/24"}},
+		{Name: "bob", Password: "pw"},
+	})
+	s.acl.local = map[netip.Addr]bool{netip.MustParseAddr("203.0.113.250"): true}
+
+	tests := []struct {
+		user, host string
+		want       bool
+	}{
+		{"bob", "93.184.216.34", true},
+		{"bob", "2606:4700::1111", true},
+		{"bob", "127.0.0.1", false},
+		{"bob", "::1", false},
+		{"bob", "10.1.2.3", false},
+		{"bob", "172.16.0.1", false},
+		{"bob", "192.168.1.10", false},
+		{"bob", "100.64.0.1", false},
+		{"bob", "169.254.169.254", false},
+		{"bob", "fe80::1", false},
+		{"bob", "fd00::1", false},
+		{"bob", "0.0.0.0", false},
+		{"bob", "203.0.113.250", false}, // the host's own address
+		{"bob", "::ffff:10.1.2.3", false},
+		{"bob", "::ffff:127.0.0.1", false},
+		{"bob", "10.9.1.1", true}, // server allow wins over the private block
+		{"bob", "198.51.100.7", false},
+		{"bob", "2001:db8:bad::1", false},
+		{"alice", "192.168.1.10", true},
+		{"alice", "::ffff:192.168.1.10", true},
+		{"alice", "192.168.2.10", false},
+		{"alice", "198.51.100.7", true}, // user allow wins over server deny
+		{"alice", "198.51.100.8", false},
+		{"alice", "10.9.1.1", false}, // user deny wins over server allow
+		{"alice", "10.9.2.1", true},
+		{"alice", "203.0.113.5", false},
+		{"unknown", "93.184.216.34", true},
+		{"unknown", "10.1.2.3", false},
+	}
+	for _, tt := range tests {
+		ip, err := s.resolve(context.Background(), tt.user, tt.host)
+		if got := err == nil; got != tt.want {
+			t.Errorf("%s -> %s: resolve = %v, %v; want permitted=%v", tt.user, tt.host, ip, err, tt.want)
+			continue
+		}
+		if err != nil && !errors.Is(err, errSocksDenied) {
+			t.Errorf("%s -> %s: error %v, want errSocksDenied", tt.user, tt.host, err)
+		}
+		if err == nil && ip.Is4In6() {
+			t.Errorf("%s -> %s: resolved to mapped address %v", tt.user, tt.host, ip)
+		}
+	}
+
+	// Names are checked by every address they resolve to; localhost has
+	// only loopback ones.
+	if _, err := s.resolve(context.Background(), "bob", "localhost"); !errors.Is(err, errSocksDenied) {
+		t.Fatalf("resolve localhost = %v, want errSocksDenied", err)
+	}
+}
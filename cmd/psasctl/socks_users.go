@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A SOCKS user lives in two places: the Linux account danted authenticates
+// against and the entry in socks-users.json. The methods below change both
+// sides and undo the completed steps when a later one fails, so the two
+// stores do not drift apart.
+//
+// danted checks passwords against the system on every login, so user
+// changes need no restart. The only restart is the one applyACL does when
+// the rendered ACL rules change; if it fails, applyACL puts danted.conf back
+// and the transaction rolls back the JSON and account steps as well.
+
+// socksTx collects compensating actions for the steps done so far.
+type socksTx struct {
+	undo []func() error
+}
+
+func (tx *socksTx) onRollback(fn func() error) {
+	tx.undo = append(tx.undo, fn)
+}
+
+// fail runs the compensating actions in reverse order and returns err
+// annotated with the rollback outcome.
+func (tx *socksTx) fail(err error) error {
+	if len(tx.undo) == 0 {
+		return err
+	}
+	var rerrs []error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if rerr := tx.undo[i](); rerr != nil {
+			rerrs = append(rerrs, rerr)
+		}
+	}
+	tx.undo = nil
+	if len(rerrs) == 0 {
+		return fmt.Errorf("%w (changes rolled back)", err)
+	}
+	return fmt.Errorf("%w (rollback failed: %v; run psasctl socks users sync)", err, errors.Join(rerrs...))
+}
+
+// linuxUserExists reports whether login is taken by a Linux account that
+// would clash with a new SOCKS user. The built-in server uses no accounts.
+func (s *socksClient) linuxUserExists(login string) bool {
+	return !s.builtin() && osSocksUserExists(login)
+}
+
+// addUser creates the Linux account for u and appends u to users, which must
+// be the list this client last read. An existing account is refused: its
+// password would be changed and could not be restored on rollback.
+func (s *socksClient) addUser(users []socksUser, u socksUser) error {
+	systemUser := socksSystemUser(u)
+	if s.linuxUserExists(systemUser) {
+		return errors.New(uiTextf("linux user already exists: %s", systemUser))
+	}
+	tx := &socksTx{}
+	tx.onRollback(func() error {
+		if !s.linuxUserExists(systemUser) {
+			return nil
+		}
+		return s.deleteLinuxUser(systemUser)
+	})
+	if err := s.ensureLinuxUser(systemUser, u.Password); err != nil {
+		return tx.fail(err)
+	}
+	next := append(append([]socksUser{}, users...), u)
+	if err := s.writeUsers(next); err != nil {
+		return tx.fail(err)
+	}
+	original := append([]socksUser{}, users...)
+	tx.onRollback(func() error { return s.writeUsers(original) })
+	if err := s.applyACL(next, false); err != nil {
+		return tx.fail(err)
+	}
+	return nil
+}
+
+// editUser replaces users[idx] with target, renaming the Linux account and
+// changing its password as needed.
+func (s *socksClient) editUser(users []socksUser, idx int, target socksUser) error {
+	current := users[idx]
+	oldSystemUser := socksSystemUser(current)
+	newSystemUser := socksSystemUser(target)
+	tx := &socksTx{}
+	if newSystemUser != oldSystemUser {
+		if err := s.renameLinuxUser(oldSystemUser, newSystemUser); err != nil {
+			return tx.fail(err)
+		}
+		tx.onRollback(func() error { return s.renameLinuxUser(newSystemUser, oldSystemUser) })
+	}
+	if target.Password != current.Password {
+		if err := s.setLinuxUserPassword(newSystemUser, target.Password); err != nil {
+			return tx.fail(err)
+		}
+		tx.onRollback(func() error { return s.setLinuxUserPassword(newSystemUser, current.Password) })
+	}
+	next := append([]socksUser{}, users...)
+	next[idx] = target
+	if err := s.writeUsers(next); err != nil {
+		return tx.fail(err)
+	}
+	original := append([]socksUser{}, users...)
+	tx.onRollback(func() error { return s.writeUsers(original) })
+	if err := s.applyACL(next, false); err != nil {
+		return tx.fail(err)
+	}
+	return nil
+}
+
+// deleteUser removes users[idx] from the JSON store and then deletes its
+// Linux account; the entry is restored if userdel fails.
+func (s *socksClient) deleteUser(users []socksUser, idx int) error {
+	u := users[idx]
+	tx := &socksTx{}
+	next := make([]socksUser, 0, len(users)-1)
+	next = append(next, users[:idx]...)
+	next = append(next, users[idx+1:]...)
+	if err := s.writeUsers(next); err != nil {
+		return tx.fail(err)
+	}
+	original := append([]socksUser{}, users...)
+	tx.onRollback(func() error { return s.writeUsers(original) })
+	if err := s.applyACL(next, false); err != nil {
+		return tx.fail(err)
+	}
+	tx.onRollback(func() error { return s.applyACL(original, false) })
+	if err := s.deleteLinuxUser(socksSystemUser(u)); err != nil {
+		return tx.fail(err)
+	}
+	return nil
+}
+
+func (s *socksClient) renameLinuxUser(oldLogin, newLogin string) error {
+	if s.builtin() {
+		return nil
+	}
+	if err := runCommand("usermod", "-l", newLogin, oldLogin); err != nil {
+		return fmt.Errorf("usermod -l %s %s: %w", newLogin, oldLogin, err)
+	}
+	return nil
+}
+
+// socksSyncItem is one difference found between socks-users.json and the
+// Linux accounts.
+type socksSyncItem struct {
+	User   string `json:"user"`
+	Issue  string `json:"issue"`
+	Action string `json:"action"`
+	Done   bool   `json:"done"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	socksSyncMissingLinux    = "missing_linux_user"
+	socksSyncOrphanLinux     = "orphan_linux_user"
+	socksSyncOrphanCandidate = "possible_orphan_linux_user"
+)
+
+// socksAccountComment is the GECOS comment of the Linux accounts
+// ensureLinuxUser creates. Without a socks-users.json entry only accounts
+// that carry it are deleted, so an unrelated account that took the name
+// later is left alone.
+const socksAccountComment = "psasctl socks"
+
+type passwdEntry struct {
+	name  string
+	uid   int
+	gecos string
+	home  string
+	shell string
+}
+
+func readPasswd() ([]passwdEntry, error) {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []passwdEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		out = append(out, passwdEntry{name: fields[0], uid: uid, gecos: fields[4], home: fields[5], shell: fields[6]})
+	}
+	return out, sc.Err()
+}
+
+// socksLike reports whether the account looks like one ensureLinuxUser
+// created: regular uid, nologin shell and no home directory.
+func (e passwdEntry) socksLike(uidMin int) bool {
+	if e.uid < uidMin || e.uid == 65534 || validateSocksLogin(e.name) != nil {
+		return false
+	}
+	switch e.shell {
+	case "/usr/sbin/nologin", "/sbin/nologin", "/bin/false", "/usr/bin/false":
+	default:
+		return false
+	}
+	if st, err := os.Stat(e.home); e.home != "" && err == nil && st.IsDir() {
+		return false
+	}
+	return true
+}
+
+// psasCreated reports whether ensureLinuxUser created the account.
+func (e passwdEntry) psasCreated() bool {
+	name, _, _ := strings.Cut(e.gecos, ",")
+	return name == socksAccountComment
+}
+
+// deleteCreatedLinuxUser deletes login when it is an account psasctl
+// created. It is for logins without a socks-users.json entry, where the
+// name may belong to someone else by now; other accounts are kept.
+func (s *socksClient) deleteCreatedLinuxUser(login string) error {
+	if s.builtin() {
+		return nil
+	}
+	entries, err := readPasswd()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.name != login {
+			continue
+		}
+		if !e.psasCreated() {
+			fmt.Fprintf(os.Stderr, "Warning: linux user %s was not created by psasctl; not deleting it\n", login)
+			return nil
+		}
+		return s.deleteLinuxUser(login)
+	}
+	return nil
+}
+
+// checkSocksLinuxAccount refuses logins that are invalid or belong to an
+// existing account that is not a SOCKS one (root, admin users, daemons), so
+// restoring or importing users never resets the password of such accounts.
+func (s *socksClient) checkSocksLinuxAccount(login string) error {
+	if err := validateSocksLogin(login); err != nil {
+		return err
+	}
+	if s.builtin() {
+		return nil
+	}
+	entries, err := readPasswd()
+	if err != nil {
+		return err
+	}
+	uidMin := loginDefsInt("UID_MIN", 1000)
+	for _, e := range entries {
+		if e.name == login && !e.socksLike(uidMin) {
+			return fmt.Errorf("linux user %s exists and is not a SOCKS account", login)
+		}
+	}
+	return nil
+}
+
+// socksOrphanLinuxUsers lists Linux accounts that look like they were created
+// for SOCKS but have no entry in socks-users.json. Accounts without the
+// psasctl comment are only candidates: service accounts made by hand look
+// the same.
+func socksOrphanLinuxUsers(users []socksUser) ([]passwdEntry, error) {
+	known := map[string]bool{}
+	for _, u := range users {
+		known[socksSystemUser(u)] = true
+	}
+	entries, err := readPasswd()
+	if err != nil {
+		return nil, err
+	}
+	uidMin := loginDefsInt("UID_MIN", 1000)
+	var out []passwdEntry
+	for _, e := range entries {
+		if !known[e.name] && e.socksLike(uidMin) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func loginDefsInt(key string, def int) int {
+	raw, err := os.ReadFile("/etc/login.defs")
+	if err != nil {
+		return def
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == key {
+			if v, err := strconv.Atoi(fields[1]); err == nil {
+				return v
+			}
+		}
+	}
+	return def
+}
+
+// syncUsers reconciles socks-users.json with the Linux accounts. JSON entries
+// without an account get the account recreated (or, with prune, are dropped);
+// orphaned accounts are only reported unless removeOrphans is set, and
+// those psasctl did not create are deleted only with confirmUnmarked too.
+// The built-in backend has no accounts, so there is nothing to reconcile.
+func (s *socksClient) syncUsers(dryRun, prune, removeOrphans, confirmUnmarked bool) ([]socksSyncItem, error) {
+	if s.builtin() {
+		return nil, nil
+	}
+	users, err := s.usersList()
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := socksOrphanLinuxUsers(users)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []socksSyncItem
+	kept := make([]socksUser, 0, len(users))
+	changed := false
+	for _, u := range users {
+		systemUser := socksSystemUser(u)
+		if osSocksUserExists(systemUser) {
+			kept = append(kept, u)
+			continue
+		}
+		item := socksSyncItem{User: u.Name, Issue: socksSyncMissingLinux, Action: "create linux user " + systemUser}
+		switch {
+		case prune:
+			item.Action = "remove json entry"
+			item.Done = !dryRun
+			changed = true
+		case dryRun:
+			kept = append(kept, u)
+		default:
+			pass := u.Password
+			if pass == "" {
+				pass = newSecureToken(24)
+				item.Action += " with a new password"
+			}
+			if err := s.ensureLinuxUser(systemUser, pass); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Done = true
+				if pass != u.Password {
+					u.Password = pass
+					changed = true
+				}
+			}
+			kept = append(kept, u)
+		}
+		items = append(items, item)
+	}
+	if changed && !dryRun {
+		if err := s.writeUsers(kept); err != nil {
+			return items, err
+		}
+	}
+
+	for _, e := range orphans {
+		item := socksSyncItem{User: e.name, Issue: socksSyncOrphanLinux, Action: "report only (use --remove-orphans)"}
+		if !e.psasCreated() {
+			item.Issue = socksSyncOrphanCandidate
+		}
+		switch {
+		case !removeOrphans:
+		case !e.psasCreated() && !confirmUnmarked:
+			item.Action = "not created by psasctl; delete with --remove-orphans --yes"
+		default:
+			item.Action = "delete linux user"
+			if !dryRun {
+				if err := s.deleteLinuxUser(e.name); err != nil {
+					item.Error = err.Error()
+				} else {
+					item.Done = true
+				}
+			}
+		}
+		items = append(items, item)
+	}
+
+	if !dryRun && len(items) > 0 {
+		var changes []auditChange
+		var failed []string
+		for _, it := range items {
+			if it.Done {
+				changes = append(changes, auditChange{Field: it.User, New: it.Action})
+			}
+			if it.Error != "" {
+				failed = append(failed, it.User+": "+it.Error)
+			}
+		}
+		var syncErr error
+		if len(failed) > 0 {
+			syncErr = errors.New(strings.Join(failed, "; "))
+		}
+		auditRecord("socks.users.sync", s.users, changes, syncErr)
+	}
+	return items, nil
+}
+
+func runSocksUsersSync(sc *socksClient, args []string) {
+	fs := flag.NewFlagSet("socks users sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report differences")
+	prune := fs.Bool("prune", false, "drop JSON entries without a linux user instead of recreating the user")
+	removeOrphans := fs.Bool("remove-orphans", false, "delete linux users psasctl created for SOCKS that are not in the users file")
+	yes := fs.Bool("yes", false, "with --remove-orphans, also delete listed SOCKS-like accounts psasctl did not create")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("socks users sync takes only flags")
+	}
+	if !*dryRun {
+		must(requireRoot("socks users sync"))
+	}
+
+	items, err := sc.syncUsers(*dryRun, *prune, *removeOrphans, *yes)
+	must(err)
+	failed := 0
+	for _, it := range items {
+		if it.Error != "" {
+			failed++
+		}
+	}
+	if *jsonOut {
+		printJSON(map[string]any{"dry_run": *dryRun, "items": items})
+	} else if len(items) == 0 {
+		fmt.Println("SOCKS users are in sync.")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tISSUE\tACTION\tRESULT")
+		for _, it := range items {
+			result := "ok"
+			switch {
+			case it.Error != "":
+				result = "error: " + it.Error
+			case *dryRun:
+				result = "dry-run"
+			case !it.Done:
+				result = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", it.User, it.Issue, it.Action, result)
+		}
+		must(tw.Flush())
+	}
+	if failed > 0 {
+		fatalf("socks users sync: %d item(s) failed", failed)
+	}
+}
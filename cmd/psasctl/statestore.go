@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State files (socks-users.json, credentials.toml, mtproxy.json, accounts,
+// plans, ...) are shared by the CLI, cron jobs, the agent, the bot and the
+// API server. Every write goes through this layer:
+//
+//   - an exclusive flock on PATH.lock serializes writers across processes
+//     (the lock lives in a sidecar file because the data file is replaced);
+//   - data is written to a temp file in the same directory, fsynced and
+//     renamed over PATH, so readers never see a truncated file;
+//   - the existing file's mode and owner are kept;
+//   - clients that remember what they read get a conflict error instead of
+//     silently overwriting a concurrent change.
+
+const stateLockTimeout = 15 * time.Second
+
+// errStateConflict matches (errors.Is) every *stateConflictError.
+var errStateConflict = errors.New("state file changed since it was read")
+
+type stateConflictError struct {
+	Path string
+}
+
+func (e *stateConflictError) Error() string {
+	return fmt.Sprintf("%s was changed by another process since it was read; re-run the command", e.Path)
+}
+
+func (e *stateConflictError) Is(target error) bool {
+	return target == errStateConflict
+}
+
+// stateVersion identifies the file content a reader has seen.
+type stateVersion struct {
+	Exists bool
+	Sum    [sha256.Size]byte
+}
+
+func stateVersionOf(data []byte, exists bool) stateVersion {
+	if !exists {
+		return stateVersion{}
+	}
+	return stateVersion{Exists: true, Sum: sha256.Sum256(data)}
+}
+
+// stateVersions remembers, per path, the version a client last read or
+// wrote. The zero value is ready to use.
+type stateVersions struct {
+	mu sync.Mutex
+	m  map[string]stateVersion
+}
+
+func (v *stateVersions) remember(path string, ver stateVersion) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.m == nil {
+		v.m = map[string]stateVersion{}
+	}
+	v.m[path] = ver
+}
+
+func (v *stateVersions) lookup(path string) *stateVersion {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ver, ok := v.m[path]
+	if !ok {
+		return nil
+	}
+	return &ver
+}
+
+// readStateFile returns the file content and its version; a missing file
+// is not an error and yields a nil slice.
+func readStateFile(path string) ([]byte, stateVersion, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, stateVersion{}, nil
+	}
+	if err != nil {
+		return nil, stateVersion{}, err
+	}
+	return raw, stateVersionOf(raw, true), nil
+}
+
+// withStateLock runs fn while holding the exclusive lock for path.
+func withStateLock(path string, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f, stateLockTimeout); err != nil {
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	defer unlockFile(f)
+	return fn()
+}
+
+// fileVersions is the stateVersions of the stores that are loaded and saved
+// through package-level functions (accounts.json, plans.json, bot.json,
+// ...) rather than a client.
+var fileVersions stateVersions
+
+// loadStateFile is readStateFile for those stores: it remembers the
+// version, so the next saveStateFile of path detects concurrent changes.
+func loadStateFile(path string) ([]byte, error) {
+	raw, ver, err := readStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileVersions.remember(path, ver)
+	return raw, nil
+}
+
+// saveStateFile replaces path with data unless it changed since this
+// process last loaded or saved it (errStateConflict). A path never loaded
+// is written unconditionally.
+func saveStateFile(path string, data []byte, perm os.FileMode) error {
+	ver, err := commitStateFile(path, perm, fileVersions.lookup(path), func([]byte) ([]byte, error) {
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	fileVersions.remember(path, ver)
+	return nil
+}
+
+// writeStateFile atomically replaces path under its lock, keeping the
+// current mode and owner (perm applies to new files only).
+func writeStateFile(path string, data []byte, perm os.FileMode) error {
+	return withStateLock(path, func() error {
+		return replaceFile(path, data, perm, true)
+	})
+}
+
+// commitStateFile is the read-modify-write primitive. Under the lock it
+// checks that the file still matches expect (when non-nil), then passes
+// the current content to build and atomically writes what build returns.
+// It returns the version of the written data.
+func commitStateFile(path string, perm os.FileMode, expect *stateVersion, build func(current []byte) ([]byte, error)) (stateVersion, error) {
+	var written stateVersion
+	err := withStateLock(path, func() error {
+		current, ver, err := readStateFile(path)
+		if err != nil {
+			return err
+		}
+		if expect != nil && *expect != ver {
+			return &stateConflictError{Path: path}
+		}
+		data, err := build(current)
+		if err != nil {
+			return err
+		}
+		if ver.Exists && bytes.Equal(current, data) {
+			written = ver
+			return nil
+		}
+		if err := replaceFile(path, data, perm, true); err != nil {
+			return err
+		}
+		written = stateVersionOf(data, true)
+		return nil
+	})
+	return written, err
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it over path, so readers never see a partial file. Unlike
+// writeStateFile it always applies perm and takes no lock.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return replaceFile(path, data, perm, false)
+}
+
+func replaceFile(path string, data []byte, perm os.FileMode, keepMode bool) error {
+	dir := filepath.Dir(path)
+	uid, gid, haveOwner := -1, -1, false
+	if info, err := os.Stat(path); err == nil {
+		if keepMode {
+			perm = info.Mode().Perm()
+		}
+		uid, gid, haveOwner = fileOwner(info)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if haveOwner {
+		// Only root can give files away; keep going as the current user otherwise.
+		if err := tmp.Chown(uid, gid); err != nil && os.Geteuid() == 0 {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes the rename durable; errors are ignored because some
+// filesystems do not support fsync on directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
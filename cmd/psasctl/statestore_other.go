@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// psasctl only runs on Linux servers; these stubs keep the package
+// building elsewhere without cross-process locking.
+func lockFile(f *os.File, timeout time.Duration) error { return nil }
+
+func unlockFile(f *os.File) {}
+
+func fileOwner(info os.FileInfo) (int, int, bool) { return -1, -1, false }
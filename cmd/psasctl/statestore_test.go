@@ -0,0 +1,271 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// incrementStateCounter does one read-modify-write of a decimal counter.
+func incrementStateCounter(path string) error {
+	_, err := commitStateFile(path, 0o600, nil, func(current []byte) ([]byte, error) {
+		n := 0
+		if s := strings.TrimSpace(string(current)); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("torn read %q: %w", s, err)
+			}
+		}
+		return []byte(strconv.Itoa(n+1) + "\n"), nil
+	})
+	return err
+}
+
+func runStateCounterWriters(path string, goroutines, increments int) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				if err := incrementStateCounter(path); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// TestStateStoreHelperProcess is the child side of
+// TestCommitStateFileConcurrentWriters; it does nothing in a normal run.
+func TestStateStoreHelperProcess(t *testing.T) {
+	path := os.Getenv("PSAS_STATESTORE_HELPER")
+	if path == "" {
+		t.Skip("helper process")
+	}
+	goroutines, _ := strconv.Atoi(os.Getenv("PSAS_STATESTORE_GOROUTINES"))
+	increments, _ := strconv.Atoi(os.Getenv("PSAS_STATESTORE_INCREMENTS"))
+	if err := runStateCounterWriters(path, goroutines, increments); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitStateFileConcurrentWriters(t *testing.T) {
+	const (
+		procs      = 4
+		goroutines = 4
+		increments = 25
+	)
+	path := filepath.Join(t.TempDir(), "counter")
+
+	var wg sync.WaitGroup
+	procErrs := make([]error, procs)
+	for p := 0; p < procs; p++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestStateStoreHelperProcess$")
+		cmd.Env = append(os.Environ(),
+			"PSAS_STATESTORE_HELPER="+path,
+			"PSAS_STATESTORE_GOROUTINES="+strconv.Itoa(goroutines),
+			"PSAS_STATESTORE_INCREMENTS="+strconv.Itoa(increments),
+		)
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				procErrs[p] = fmt.Errorf("%w: %s", err, out)
+			}
+		}(p)
+	}
+	// The test process writes as well, so in-process and cross-process
+	// writers contend for the same lock.
+	if err := runStateCounterWriters(path, goroutines, increments); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	for p, err := range procErrs {
+		if err != nil {
+			t.Fatalf("helper %d: %v", p, err)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := (procs + 1) * goroutines * increments
+	if got, _ := strconv.Atoi(strings.TrimSpace(string(raw))); got != want {
+		t.Fatalf("counter = %d, want %d (lost updates)", got, want)
+	}
+}
+
+func TestCommitStateFileConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, ver, err := readStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("v2 from another process\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	_, err = commitStateFile(path, 0o600, &ver, func([]byte) ([]byte, error) {
+		called = true
+		return []byte("v3\n"), nil
+	})
+	if !errors.Is(err, errStateConflict) {
+		t.Fatalf("err = %v, want errStateConflict", err)
+	}
+	if called {
+		t.Fatal("build ran despite the conflict")
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "v2 from another process\n" {
+		t.Fatalf("file overwritten on conflict: %q", raw)
+	}
+
+	// A missing file that appeared after the read is a conflict too.
+	missing := filepath.Join(t.TempDir(), "new.json")
+	_, none, _ := readStateFile(missing)
+	if err := os.WriteFile(missing, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commitStateFile(missing, 0o600, &none, func([]byte) ([]byte, error) { return []byte("y"), nil }); !errors.Is(err, errStateConflict) {
+		t.Fatalf("err = %v, want errStateConflict", err)
+	}
+}
+
+func TestCommitStateFileKeepsModeAndOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("old\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	wantUID, wantGID := os.Getuid(), os.Getgid()
+	if os.Geteuid() == 0 {
+		wantUID, wantGID = 4242, 4243
+		if err := os.Chown(path, wantUID, wantGID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := commitStateFile(path, 0o600, nil, func([]byte) ([]byte, error) { return []byte("new\n"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o640 {
+		t.Fatalf("mode = %o, want 640", got)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if int(st.Uid) != wantUID || int(st.Gid) != wantGID {
+		t.Fatalf("owner = %d:%d, want %d:%d", st.Uid, st.Gid, wantUID, wantGID)
+	}
+
+	// perm applies to files that did not exist yet.
+	fresh := filepath.Join(t.TempDir(), "fresh.json")
+	if err := writeStateFile(fresh, []byte("{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(fresh); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("new file mode = %v, %v; want 600", info.Mode().Perm(), err)
+	}
+}
+
+func TestCommitStateFileFailedWriteLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("intact\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	buildErr := errors.New("build failed")
+	if _, err := commitStateFile(path, 0o600, nil, func([]byte) ([]byte, error) { return []byte("partial"), buildErr }); !errors.Is(err, buildErr) {
+		t.Fatalf("err = %v, want %v", err, buildErr)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "intact\n" {
+		t.Fatalf("file changed after failed build: %q", raw)
+	}
+
+	// The rename fails when the target is a directory; the temp file must
+	// not be left behind.
+	target := filepath.Join(dir, "busy")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "keep"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(target, []byte("data\n"), 0o600); err == nil {
+		t.Fatal("writing over a directory succeeded")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Fatalf("temp file left behind: %s", e.Name())
+		}
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "intact\n" {
+		t.Fatalf("file changed: %q", raw)
+	}
+}
+
+func TestSaveStateFileDetectsConcurrentChange(t *testing.T) {
+	t.Setenv("PSAS_ACCOUNTS", filepath.Join(t.TempDir(), "accounts.json"))
+	if err := writeAccounts([]psasAccount{{Name: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := loadAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another process (another `account` command, the bot, ...) saves in
+	// between; this process must not overwrite it.
+	if err := os.WriteFile(accountsPath(), []byte(`[{"name":"alice"},{"name":"bob"}]`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	accounts = append(accounts, psasAccount{Name: "carol"})
+	if err := writeAccounts(accounts); !errors.Is(err, errStateConflict) {
+		t.Fatalf("err = %v, want errStateConflict", err)
+	}
+
+	// After a fresh load the change goes through, and consecutive saves
+	// from the same process do not conflict with themselves.
+	accounts, err = loadAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts = append(accounts, psasAccount{Name: "carol"})
+	if err := writeAccounts(accounts); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAccounts(accounts[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loadAccounts(); len(got) != 1 || got[0].Name != "alice" {
+		t.Fatalf("accounts = %+v", got)
+	}
+}
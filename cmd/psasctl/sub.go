@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultSubListen   = "127.0.0.1:8788"
+	defaultSubPath     = "/psas-sub/"
+	defaultSubUnitPath = "/etc/systemd/system/psas-sub.service"
+)
+
+// subBundle is everything one account gets on its subscription page. It is
+// public to whoever holds the token, so it carries no internal errors.
+type subBundle struct {
+	Account string           `json:"account"`
+	Hiddify *subHiddify      `json:"hiddify,omitempty"`
+	Socks   *socksConnInfo   `json:"socks,omitempty"`
+	Trust   *subTrust        `json:"trust,omitempty"`
+	MTProxy *mtproxyConnInfo `json:"mtproxy,omitempty"`
+}
+
+type subHiddify struct {
+	Enable         bool    `json:"enable"`
+	UsageLimitGB   float64 `json:"usage_limit_GB"`
+	CurrentUsageGB float64 `json:"current_usage_GB"`
+	RemainingDays  int     `json:"remaining_days"`
+	UnlimitedGB    bool    `json:"unlimited_gb"`
+	UnlimitedDays  bool    `json:"unlimited_days"`
+	Links          linkSet `json:"links"`
+}
+
+type subTrust struct {
+	Username  string `json:"username"`
+	Address   string `json:"address"`
+	ConfigURL string `json:"config_url"`
+	config    string
+}
+
+type subServer struct {
+	prefix string
+	host   string
+	mu     sync.Mutex
+	c      *client
+	sc     *socksClient
+	tt     *trustClient
+	mp     *mtproxyClient
+}
+
+func subBaseURL() string {
+	return strings.TrimSpace(os.Getenv("PSAS_SUB_BASE"))
+}
+
+func normalizeSubPath(p string) string {
+	p = "/" + strings.Trim(strings.TrimSpace(p), "/") + "/"
+	if p == "//" {
+		return "/"
+	}
+	return p
+}
+
+func runSub(args []string) {
+	if len(args) == 0 {
+		fatalf("sub requires subcommand: serve|url|revoke|unit|install")
+	}
+	sub := strings.ToLower(strings.TrimSpace(args[0]))
+	subArgs := args[1:]
+	switch sub {
+	case "serve", "run":
+		fs := flag.NewFlagSet("sub serve", flag.ExitOnError)
+		listen := fs.String("listen", defaultSubListen, "listen address: HOST:PORT or unix:/path.sock")
+		path := fs.String("path", defaultSubPath, "URL path prefix (as proxied by nginx/haproxy)")
+		host := fs.String("host", "", "domain for Hiddify links (default: main panel domain)")
+		must(fs.Parse(subArgs))
+		runSubServe(*listen, *path, *host)
+	case "url", "link":
+		fs := flag.NewFlagSet("sub url", flag.ExitOnError)
+		base := fs.String("base", "", "public base URL (default: PSAS_SUB_BASE or https://<main domain>/psas-sub/)")
+		rotate := fs.Bool("rotate", false, "issue a new token (old URL stops working)")
+		qf := bindQRFlags(fs)
+		jsonOut := fs.Bool("json", false, "output JSON")
+		must(fs.Parse(subArgs))
+		if len(fs.Args()) != 1 {
+			fatalf("sub url requires ACCOUNT")
+		}
+		runSubURL(fs.Arg(0), strings.TrimSpace(*base), *rotate, qf, *jsonOut)
+	case "revoke":
+		if len(subArgs) != 1 {
+			fatalf("sub revoke requires ACCOUNT")
+		}
+		must(requireRoot("sub revoke"))
+		accounts, err := loadAccounts()
+		must(err)
+		acc, idx, err := resolveAccount(accounts, subArgs[0])
+		must(err)
+		accounts[idx].SubToken = ""
+		must(writeAccounts(accounts))
+		fmt.Printf("Subscription page revoked: %s\n", acc.Name)
+	case "unit", "install":
+		fs := flag.NewFlagSet("sub "+sub, flag.ExitOnError)
+		listen := fs.String("listen", defaultSubListen, "listen address: HOST:PORT or unix:/path.sock")
+		path := fs.String("path", defaultSubPath, "URL path prefix")
+		host := fs.String("host", "", "domain for Hiddify links")
+		unitPath := fs.String("unit-path", defaultSubUnitPath, "systemd unit file path")
+		noStart := fs.Bool("no-start", false, "write the unit but do not enable/start it")
+		must(fs.Parse(subArgs))
+		runArgs := []string{"sub", "serve", "--listen", *listen, "--path", normalizeSubPath(*path)}
+		if strings.TrimSpace(*host) != "" {
+			runArgs = append(runArgs, "--host", strings.TrimSpace(*host))
+		}
+		unit := renderPSASUnit("PSAS subscription pages", runArgs)
+		if sub == "unit" {
+			fmt.Print(unit)
+			return
+		}
+		must(requireRoot("sub install"))
+		must(installPSASUnit(*unitPath, unit, !*noStart))
+	default:
+		fatalf("unknown sub subcommand: %s", sub)
+	}
+}
+
+func runSubURL(id, base string, rotate bool, qf qrFlags, jsonOut bool) {
+	accounts, err := loadAccounts()
+	must(err)
+	acc, idx, err := resolveAccount(accounts, id)
+	must(err)
+	if acc.SubToken == "" || rotate {
+		must(requireRoot("sub url"))
+		acc.SubToken = newHexToken(24)
+		accounts[idx] = acc
+		must(writeAccounts(accounts))
+	}
+	if base == "" {
+		base = subBaseURL()
+	}
+	if base == "" {
+		c := mustClient(true)
+		host, err := c.mainDomainOrErr()
+		if err != nil {
+			fatalf("cannot build subscription URL: %v (use --base)", err)
+		}
+		base = "https://" + host + defaultSubPath
+	}
+	page := strings.TrimRight(base, "/") + "/" + acc.SubToken
+	if jsonOut {
+		printJSON(map[string]any{
+			"account": acc.Name,
+			"url":     page,
+			"json":    page + "/json",
+			"clash":   page + "/clash",
+			"singbox": page + "/singbox",
+		})
+		return
+	}
+	fmt.Printf("%s: %s\n", uiText("Account"), acc.Name)
+	fmt.Printf("Page    : %s\n", page)
+	fmt.Printf("JSON    : %s/json\n", page)
+	fmt.Printf("Clash   : %s/clash\n", page)
+	fmt.Printf("Sing-box: %s/singbox\n", page)
+	_, err = qf.emit("Subscription page", page, false)
+	must(err)
+}
+
+func runSubServe(listen, path, host string) {
+	s := &subServer{
+		prefix: normalizeSubPath(path),
+		host:   strings.TrimSpace(host),
+		c:      mustClient(false),
+		sc:     newSocksClient(),
+		tt:     newTrustClient(),
+		mp:     newMTProxyClient(),
+	}
+	// Listening is localhost-only: the page is meant to be published through
+	// the existing nginx/haproxy.
+	ln, where, err := apiListen(listen, false)
+	must(err)
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(os.Stderr, "psasctl sub: listening on %s, path %s\n", where, s.prefix)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("%v", err)
+	}
+}
+
+func (s *subServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+s.prefix+"{token}", s.serve)
+	mux.HandleFunc("GET "+s.prefix+"{token}/{format}", s.serve)
+	return mux
+}
+
+func (s *subServer) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Robots-Tag", "noindex, nofollow")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	acc, ok := s.lookup(r.PathValue("token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	b := s.collect(acc)
+	s.mu.Unlock()
+
+	switch r.PathValue("format") {
+	case "":
+		h.Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if err := subPageTemplate.Execute(w, s.pageData(b, subLang(r))); err != nil {
+			subLogf("render %s: %v", acc.Name, err)
+		}
+	case "json":
+		apiWriteJSON(w, http.StatusOK, b)
+	case "clash":
+		h.Set("Content-Type", "text/yaml; charset=utf-8")
+		h.Set("Content-Disposition", `inline; filename="psas.yaml"`)
+		_, _ = io.WriteString(w, renderSubClash(b))
+	case "singbox":
+		out, err := json.MarshalIndent(s.singbox(b), "", "  ")
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		h.Set("Content-Type", "application/json")
+		_, _ = w.Write(append(out, '\n'))
+	case "trust.toml":
+		if b.Trust == nil || b.Trust.config == "" {
+			http.NotFound(w, r)
+			return
+		}
+		h.Set("Content-Type", "application/toml; charset=utf-8")
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", b.Trust.Username+".toml"))
+		_, _ = io.WriteString(w, b.Trust.config)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *subServer) lookup(token string) (psasAccount, bool) {
+	if len(token) < 32 {
+		return psasAccount{}, false
+	}
+	accounts, err := loadAccounts()
+	if err != nil {
+		subLogf("load accounts: %v", err)
+		return psasAccount{}, false
+	}
+	for _, a := range accounts {
+		if a.SubToken != "" && subtle.ConstantTimeCompare([]byte(a.SubToken), []byte(token)) == 1 {
+			return a, true
+		}
+	}
+	return psasAccount{}, false
+}
+
+// collect builds the bundle; service errors are logged, not shown.
+func (s *subServer) collect(acc psasAccount) subBundle {
+	b := subBundle{Account: acc.Name}
+	if acc.HiddifyUUID != "" {
+		if err := s.c.ensureState(); err != nil {
+			subLogf("%s: hiddify: %v", acc.Name, err)
+		} else if u, err := s.c.userShow(acc.HiddifyUUID); err != nil {
+			subLogf("%s: hiddify: %v", acc.Name, err)
+		} else {
+			host := s.host
+			if host == "" {
+				host, err = s.c.mainDomainOrErr()
+			}
+			if err != nil {
+				subLogf("%s: hiddify links: %v", acc.Name, err)
+			} else {
+				b.Hiddify = &subHiddify{
+					Enable:         u.Enable,
+					UsageLimitGB:   u.UsageLimitGB,
+					CurrentUsageGB: u.CurrentUsageGB,
+					RemainingDays:  userRemainingDays(u, time.Now()),
+					UnlimitedGB:    isUnlimitedUsage(u),
+					UnlimitedDays:  isUnlimitedDays(u),
+					Links:          buildLinks(s.c.clientPath(), u.UUID, host),
+				}
+			}
+		}
+	}
+	if acc.SocksLogin != "" {
+		users, err := s.sc.usersList()
+		if err == nil {
+			var u socksUser
+			if u, _, err = resolveSocksUser(users, acc.SocksLogin); err == nil {
+				var cfg socksConnInfo
+				if cfg, err = s.sc.connectionConfig(u, "", 0); err == nil {
+					b.Socks = &cfg
+				}
+			}
+		}
+		if err != nil {
+			subLogf("%s: socks: %v", acc.Name, err)
+		}
+	}
+	if acc.TrustUsername != "" {
+		users, err := s.tt.usersList()
+		if err == nil {
+			var u trustUser
+			if u, _, err = resolveTrustUser(users, acc.TrustUsername); err == nil {
+				var cfg string
+				if cfg, err = s.tt.exportClientConfig(u.Username, ""); err == nil {
+					b.Trust = &subTrust{Username: u.Username, Address: s.tt.lastExportAddress, ConfigURL: s.prefix + acc.SubToken + "/trust.toml", config: cfg}
+				}
+			}
+		}
+		if err != nil {
+			subLogf("%s: trust: %v", acc.Name, err)
+		}
+	}
+	if acc.MTProxy {
+		if info, err := s.mp.connectionInfo("", 0, ""); err != nil {
+			subLogf("%s: mtproxy: %v", acc.Name, err)
+		} else {
+			b.MTProxy = &info
+		}
+	}
+	return b
+}
+
+func subLogf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "psasctl sub: "+format+"\n", args...)
+}
+
+// renderSubClash returns a Clash (mihomo) config: Hiddify is pulled as a
+// proxy provider from /auto/ (which answers Clash clients with Clash YAML),
+// SOCKS5 is added as a static proxy. MTProxy has no Clash equivalent.
+func renderSubClash(b subBundle) string {
+	var sb strings.Builder
+	q := strconv.Quote
+	sb.WriteString("# PSAS subscription: " + b.Account + "\n")
+	sb.WriteString("mixed-port: 7890\nallow-lan: false\nmode: rule\n")
+	var proxies []string
+	if b.Socks != nil {
+		sb.WriteString("proxies:\n")
+		sb.WriteString("  - name: \"PSAS SOCKS5\"\n    type: socks5\n")
+		fmt.Fprintf(&sb, "    server: %s\n    port: %d\n    username: %s\n    password: %s\n    udp: true\n",
+			q(b.Socks.Server), b.Socks.Port, q(b.Socks.Username), q(b.Socks.Password))
+		proxies = append(proxies, q("PSAS SOCKS5"))
+	}
+	if b.Hiddify != nil {
+		sb.WriteString("proxy-providers:\n  hiddify:\n    type: http\n")
+		fmt.Fprintf(&sb, "    url: %s\n    interval: 3600\n    path: ./providers/psas-hiddify.yaml\n", q(b.Hiddify.Links.Auto))
+		sb.WriteString("    health-check:\n      enable: true\n      url: https://www.gstatic.com/generate_204\n      interval: 600\n")
+	}
+	sb.WriteString("proxy-groups:\n  - name: PSAS\n    type: select\n")
+	if b.Hiddify != nil {
+		sb.WriteString("    use:\n      - hiddify\n")
+	}
+	proxies = append(proxies, "DIRECT")
+	sb.WriteString("    proxies:\n")
+	for _, p := range proxies {
+		sb.WriteString("      - " + p + "\n")
+	}
+	sb.WriteString("rules:\n  - MATCH,PSAS\n")
+	return sb.String()
+}
+
+// singbox returns the Hiddify sing-box config with the SOCKS5 outbound added
+// to it, or a minimal SOCKS5-only config when Hiddify is not available.
+func (s *subServer) singbox(b subBundle) map[string]any {
+	var cfg map[string]any
+	if b.Hiddify != nil {
+		var err error
+		if cfg, err = s.fetchHiddifySingbox(b.Hiddify.Links); err != nil {
+			subLogf("%s: hiddify sing-box: %v", b.Account, err)
+		}
+	}
+	if cfg == nil {
+		cfg = map[string]any{
+			"log":       map[string]any{"level": "warn"},
+			"inbounds":  []any{map[string]any{"type": "mixed", "tag": "mixed-in", "listen": "127.0.0.1", "listen_port": 2080}},
+			"outbounds": []any{map[string]any{"type": "selector", "tag": "select", "outbounds": []any{}}, map[string]any{"type": "direct", "tag": "direct"}},
+			"route":     map[string]any{"final": "select"},
+		}
+	}
+	if b.Socks == nil {
+		return cfg
+	}
+	outbounds, _ := cfg["outbounds"].([]any)
+	const tag = "psas-socks5"
+	outbounds = append(outbounds, map[string]any{
+		"type":        "socks",
+		"tag":         tag,
+		"server":      b.Socks.Server,
+		"server_port": b.Socks.Port,
+		"version":     "5",
+		"username":    b.Socks.Username,
+		"password":    b.Socks.Password,
+	})
+	for _, o := range outbounds {
+		ob, ok := o.(map[string]any)
+		if !ok || ob["type"] != "selector" {
+			continue
+		}
+		list, _ := ob["outbounds"].([]any)
+		ob["outbounds"] = append(list, tag)
+		if _, ok := ob["default"]; !ok && len(list) == 0 {
+			ob["default"] = tag
+		}
+		break
+	}
+	cfg["outbounds"] = outbounds
+	return cfg
+}
+
+// fetchHiddifySingbox asks the local panel for the user's sing-box config,
+// presenting the public host so domain-specific settings are preserved.
+func (s *subServer) fetchHiddifySingbox(l linkSet) (map[string]any, error) {
+	path := strings.TrimPrefix(l.Singbox, "https://"+l.Host)
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(s.c.panelAddr, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = l.Host
+	req.Header.Set("User-Agent", "SFA/1.8 (sing-box; psasctl)")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("panel returned %s", resp.Status)
+	}
+	var cfg map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode sing-box config: %w", err)
+	}
+	return cfg, nil
+}
+
+// subLang picks ru/en from ?lang= or Accept-Language.
+func subLang(r *http.Request) string {
+	if v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("lang"))); v == uiLangRU || v == "en" {
+		return v
+	}
+	if strings.HasPrefix(strings.ToLower(r.Header.Get("Accept-Language")), "ru") {
+		return uiLangRU
+	}
+	return "en"
+}
+
+type subPageLink struct {
+	Title string
+	URL   string
+	Href  template.URL
+	QR    template.URL
+}
+
+type subPageData struct {
+	Lang    string
+	Account string
+	T       func(string) string
+	Hiddify *subHiddify
+	Usage   string
+	Days    string
+	Links   []subPageLink
+	Socks   *socksConnInfo
+	Trust   *subTrust
+	MTProxy *mtproxyConnInfo
+}
+
+func (s *subServer) pageData(b subBundle, lang string) subPageData {
+	t := func(v string) string {
+		if lang == uiLangRU {
+			if ru, ok := uiTextRU[v]; ok {
+				return ru
+			}
+		}
+		return v
+	}
+	d := subPageData{Lang: lang, Account: b.Account, T: t, Hiddify: b.Hiddify, Socks: b.Socks, Trust: b.Trust, MTProxy: b.MTProxy}
+	link := func(title, u string, href bool) {
+		l := subPageLink{Title: t(title), URL: u, QR: subQRDataURI(u)}
+		if href {
+			l.Href = template.URL(u)
+		}
+		d.Links = append(d.Links, l)
+	}
+	if h := b.Hiddify; h != nil {
+		if h.UnlimitedGB {
+			d.Usage = fmt.Sprintf("%.2f GB / ∞", h.CurrentUsageGB)
+		} else {
+			u := apiUser{UsageLimitGB: h.UsageLimitGB, CurrentUsageGB: h.CurrentUsageGB}
+			d.Usage = fmt.Sprintf("%.2f / %s GB (%s)", h.CurrentUsageGB, strconv.FormatFloat(h.UsageLimitGB, 'f', -1, 64), formatUsagePercent(u))
+		}
+		if h.UnlimitedDays {
+			d.Days = "∞"
+		} else {
+			d.Days = strconv.Itoa(h.RemainingDays)
+		}
+		link("Hiddify (auto)", h.Links.Auto, true)
+		link("Sing-box", h.Links.Singbox, true)
+		link("Subscription b64", h.Links.Sub64, true)
+	}
+	if b.Socks != nil {
+		link("SOCKS5", b.Socks.URI, false)
+	}
+	if b.MTProxy != nil {
+		link("MTProxy", b.MTProxy.TGLink, true)
+		link("MTProxy (t.me)", b.MTProxy.ShareURL, true)
+	}
+	return d
+}
+
+func subQRDataURI(text string) template.URL {
+	img, err := qrPNG(text, 4)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(img))
+}
+
+var subPageTemplate = template.Must(template.New("sub").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{.Account}}</title>
+<style>
+body { margin: 0; font: 15px/1.45 system-ui, sans-serif; color: #1d2330; background: #f4f6fa; }
+main { max-width: 720px; margin: 0 auto; padding: 20px; }
+h1 { font-size: 20px; } h2 { font-size: 16px; margin: 0 0 8px; }
+section { background: #fff; border: 1px solid #dde2ec; border-radius: 6px; padding: 14px; margin-bottom: 14px; }
+code { font: 12px ui-monospace, monospace; word-break: break-all; }
+img { display: block; width: 180px; height: 180px; image-rendering: pixelated; margin-top: 6px; }
+.off { color: #c0392b; } a { color: #2f6fed; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Account}}</h1>
+{{with .Hiddify}}<section>
+<h2>Hiddify</h2>
+{{if not .Enable}}<p class="off">{{call $.T "User is disabled"}}</p>{{end}}
+<p>{{call $.T "Traffic"}}: {{$.Usage}}<br>{{call $.T "Days left"}}: {{$.Days}}</p>
+</section>{{end}}
+{{range .Links}}<section>
+<h2>{{.Title}}</h2>
+{{if .Href}}<a href="{{.Href}}">{{.URL}}</a>{{else}}<code>{{.URL}}</code>{{end}}
+{{if .QR}}<img src="{{.QR}}" alt="QR">{{end}}
+</section>{{end}}
+{{with .Trust}}<section>
+<h2>TrustTunnel</h2>
+<p>{{call $.T "Login"}}: <code>{{.Username}}</code><br>{{call $.T "Endpoint"}}: <code>{{.Address}}</code></p>
+<a href="{{.ConfigURL}}">{{call $.T "Download client config"}} ({{.Username}}.toml)</a>
+</section>{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// userSelector describes a set of panel users by predicates; all set predicates
+// must match (logical AND).
+type userSelector struct {
+	All            bool
+	NameGlob       string
+	NameRegex      *regexp.Regexp
+	EnabledOnly    bool
+	DisabledOnly   bool
+	Mode           string
+	ExpiringWithin int
+	HasExpiring    bool
+	OverUsage      float64
+	HasOverUsage   bool
+}
+
+type bulkUserResult struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s userSelector) empty() bool {
+	return !s.All && s.NameGlob == "" && s.NameRegex == nil && !s.EnabledOnly && !s.DisabledOnly &&
+		s.Mode == "" && !s.HasExpiring && !s.HasOverUsage
+}
+
+func (s userSelector) match(u apiUser) bool {
+	name := strings.ToLower(strings.TrimSpace(u.Name))
+	if s.NameGlob != "" {
+		if ok, _ := path.Match(strings.ToLower(s.NameGlob), name); !ok {
+			return false
+		}
+	}
+	if s.NameRegex != nil && !s.NameRegex.MatchString(u.Name) {
+		return false
+	}
+	if s.EnabledOnly && !u.Enable {
+		return false
+	}
+	if s.DisabledOnly && u.Enable {
+		return false
+	}
+	if s.Mode != "" && u.Mode != s.Mode {
+		return false
+	}
+	if s.HasExpiring && (isUnlimitedDays(u) || u.RemainingDays > s.ExpiringWithin) {
+		return false
+	}
+	if s.HasOverUsage && (isUnlimitedUsage(u) || userUsagePercent(u) < s.OverUsage) {
+		return false
+	}
+	return true
+}
+
+func (s userSelector) filter(users []apiUser) []apiUser {
+	out := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		if s.match(u) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// bindUserSelectorFlags registers the shared selector flags on fs and returns a
+// function that builds the selector after fs.Parse.
+func bindUserSelectorFlags(fs *flag.FlagSet) func() (userSelector, error) {
+	all := fs.Bool("all", false, "select all users")
+	name := fs.String("name", "", "name glob, e.g. 'team-*' (case-insensitive)")
+	re := fs.String("regex", "", "name regular expression")
+	enabled := fs.Bool("enabled", false, "only enabled users")
+	disabled := fs.Bool("disabled", false, "only disabled users")
+	mode := fs.String("mode", "", "only users with mode: no_reset|daily|weekly|monthly")
+	expiring := fs.String("expiring-within", "", "only users whose package ends within N days, e.g. 7d")
+	overUsage := fs.String("over-usage", "", "only users that used at least this share of their limit, e.g. 80%")
+	return func() (userSelector, error) {
+		sel := userSelector{
+			All:          *all,
+			NameGlob:     strings.TrimSpace(*name),
+			EnabledOnly:  *enabled,
+			DisabledOnly: *disabled,
+			Mode:         strings.TrimSpace(*mode),
+		}
+		if sel.EnabledOnly && sel.DisabledOnly {
+			return sel, errors.New("--enabled and --disabled cannot be used together")
+		}
+		if sel.NameGlob != "" {
+			if _, err := path.Match(sel.NameGlob, ""); err != nil {
+				return sel, fmt.Errorf("invalid --name glob: %w", err)
+			}
+		}
+		if r := strings.TrimSpace(*re); r != "" {
+			compiled, err := regexp.Compile(r)
+			if err != nil {
+				return sel, fmt.Errorf("invalid --regex: %w", err)
+			}
+			sel.NameRegex = compiled
+		}
+		if sel.Mode != "" && !isValidMode(sel.Mode) {
+			return sel, fmt.Errorf("invalid --mode: %s", sel.Mode)
+		}
+		if strings.TrimSpace(*expiring) != "" {
+			days, err := parseDaysValue(*expiring)
+			if err != nil {
+				return sel, err
+			}
+			sel.ExpiringWithin = days
+			sel.HasExpiring = true
+		}
+		if strings.TrimSpace(*overUsage) != "" {
+			pct, err := parsePercentValue(*overUsage)
+			if err != nil {
+				return sel, err
+			}
+			sel.OverUsage = pct
+			sel.HasOverUsage = true
+		}
+		return sel, nil
+	}
+}
+
+func runUsersBulk(c *client, args []string) {
+	if len(args) < 1 {
+		fatalf("users bulk requires action: enable|disable|extend|reset|delete")
+	}
+	action := strings.ToLower(strings.TrimSpace(args[0]))
+	switch action {
+	case "enable", "disable", "extend", "reset", "delete", "del", "rm":
+	default:
+		fatalf("unknown users bulk action: %s (expected enable|disable|extend|reset|delete)", action)
+	}
+	if action == "del" || action == "rm" {
+		action = "delete"
+	}
+
+	fs := flag.NewFlagSet("users bulk "+action, flag.ExitOnError)
+	selector := bindUserSelectorFlags(fs)
+	addDays := fs.Int("days", 0, "extend: days to add to package_days")
+	addGB := fs.Float64("gb", 0, "extend: GB to add to usage_limit_GB")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	dryRun := fs.Bool("dry-run", false, "only print matched users")
+	concurrency := fs.Int("concurrency", 4, "parallel panel API requests")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args[1:]))
+	if len(fs.Args()) != 0 {
+		fatalf("users bulk %s takes only flags", action)
+	}
+	sel, err := selector()
+	must(err)
+	if sel.empty() {
+		fatalf("users bulk %s requires a selector (--name, --regex, --enabled, --disabled, --mode, --expiring-within, --over-usage) or --all", action)
+	}
+	if action == "extend" {
+		if *addDays < 0 || *addGB < 0 {
+			fatalf("--days and --gb must be >= 0")
+		}
+		if *addDays == 0 && *addGB == 0 {
+			fatalf("users bulk extend requires --days and/or --gb")
+		}
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	users, err := c.usersList()
+	must(err)
+	matched := sel.filter(users)
+	if len(matched) == 0 {
+		if *jsonOut {
+			printJSON(map[string]any{"action": action, "matched": []apiUser{}, "results": []bulkUserResult{}})
+			return
+		}
+		fmt.Println("No users match the selector.")
+		return
+	}
+
+	if !*jsonOut {
+		printUsers(matched)
+		fmt.Printf("\n%d user(s) matched for %s.\n", len(matched), describeBulkAction(action, *addDays, *addGB))
+	}
+	if *dryRun {
+		if *jsonOut {
+			printJSON(map[string]any{"action": action, "dry_run": true, "matched": matched})
+		}
+		return
+	}
+	if !*yes {
+		if !isInteractiveTerminal() {
+			fatalf("refusing to run users bulk %s without confirmation; pass --yes", action)
+		}
+		ok, err := promptYesNo(bufio.NewReader(os.Stdin), "Proceed?", false)
+		must(err)
+		if !ok {
+			fmt.Println(uiText("Canceled."))
+			return
+		}
+	}
+
+	results := runBulkUserAction(matched, *concurrency, func(u apiUser) error {
+		switch action {
+		case "enable":
+			_, err := c.userPatch(u, map[string]any{"enable": true})
+			return err
+		case "disable":
+			_, err := c.userPatch(u, map[string]any{"enable": false})
+			return err
+		case "extend":
+			payload := userExtendPayload(u, *addDays, *addGB)
+			if len(payload) == 0 {
+				return nil
+			}
+			_, err := c.userPatch(u, payload)
+			return err
+		case "reset":
+			_, err := c.userPatch(u, userResetPayload())
+			return err
+		case "delete":
+			return c.userDelete(u)
+		}
+		return fmt.Errorf("unsupported action: %s", action)
+	})
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+			continue
+		}
+		ev := notifyEvent{Type: notifyUserUpdated, Source: "hiddify", User: r.Name, UUID: r.UUID, Details: map[string]any{"bulk_action": action}}
+		if action == "delete" {
+			ev.Type = notifyUserDeleted
+		}
+		queueNotify(ev)
+	}
+	flushQueuedNotifications()
+	if *jsonOut {
+		printJSON(map[string]any{
+			"action":    action,
+			"matched":   len(matched),
+			"succeeded": len(results) - failed,
+			"failed":    failed,
+			"results":   results,
+		})
+	} else {
+		fmt.Println()
+		for _, r := range results {
+			if !r.OK {
+				fmt.Printf("FAILED %s (%s): %s\n", r.Name, r.UUID, r.Error)
+			}
+		}
+		fmt.Printf("Bulk %s: %d succeeded, %d failed\n", action, len(results)-failed, failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runBulkUserAction applies fn to every user with at most `workers` calls in
+// flight and returns results in the input order.
+func runBulkUserAction(users []apiUser, workers int, fn func(apiUser) error) []bulkUserResult {
+	results := make([]bulkUserResult, len(users))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, u := range users {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, u apiUser) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := bulkUserResult{UUID: u.UUID, Name: u.Name, OK: true}
+			if err := fn(u); err != nil {
+				res.OK = false
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}(i, u)
+	}
+	wg.Wait()
+	return results
+}
+
+func userExtendPayload(u apiUser, addDays int, addGB float64) map[string]any {
+	payload := map[string]any{}
+	if addDays > 0 && !isUnlimitedDays(u) {
+		payload["package_days"] = u.PackageDays + addDays
+	}
+	if addGB > 0 && !isUnlimitedUsage(u) {
+		payload["usage_limit_GB"] = u.UsageLimitGB + addGB
+	}
+	return payload
+}
+
+// userResetPayload zeroes consumed traffic and clears start_date so the package
+// period starts again on the next connection.
+func userResetPayload() map[string]any {
+	return map[string]any{
+		"current_usage_GB": 0,
+		"start_date":       nil,
+	}
+}
+
+func describeBulkAction(action string, addDays int, addGB float64) string {
+	if action != "extend" {
+		return action
+	}
+	parts := []string{}
+	if addDays > 0 {
+		parts = append(parts, fmt.Sprintf("+%d days", addDays))
+	}
+	if addGB > 0 {
+		parts = append(parts, fmt.Sprintf("+%g GB", addGB))
+	}
+	return "extend (" + strings.Join(parts, ", ") + ")"
+}
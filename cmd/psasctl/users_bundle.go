@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// bundleQRScale is the PNG pixel size of one QR module.
+const bundleQRScale = 8
+
+var bundleNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type bundleFile struct {
+	Name string
+	Data []byte
+}
+
+type bundleTrustInfo struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Address  string `json:"address"`
+	Config   string `json:"config"`
+}
+
+// userBundle is everything handed to one customer; it is also written as
+// bundle.json next to the human-readable files.
+type userBundle struct {
+	User     apiUser          `json:"user"`
+	Links    linkSet          `json:"links"`
+	Socks    *socksConnInfo   `json:"socks,omitempty"`
+	Trust    *bundleTrustInfo `json:"trust,omitempty"`
+	MTProxy  *mtproxyConnInfo `json:"mtproxy,omitempty"`
+	Lang     string           `json:"lang"`
+	Created  string           `json:"created_at"`
+	Files    []string         `json:"files"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+func runUsersBundle(c *client, args []string) {
+	fs := flag.NewFlagSet("users bundle", flag.ExitOnError)
+	outPath := fs.String("out", "", "output directory or .zip file (default: ./<name>-bundle)")
+	asZip := fs.Bool("zip", false, "write a zip archive instead of a directory (.zip is appended to --out if missing)")
+	host := fs.String("host", "", "domain for generated links")
+	socksLogin := fs.String("socks", "", "SOCKS login to include (default: from linked account)")
+	trustUsername := fs.String("trust", "", "TrustTunnel username to include (default: from linked account)")
+	trustAddress := fs.String("trust-address", "", "TrustTunnel endpoint address ip[:port]")
+	socksServer := fs.String("socks-server", "", "SOCKS server host/ip")
+	noMTProxy := fs.Bool("no-mtproxy", false, "do not include MTProxy")
+	lang := fs.String("lang", "", "README language: us|ru (default: current UI language)")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	rest := fs.Args()
+	if len(rest) != 1 {
+		fatalf("users bundle requires USER_ID")
+	}
+	if l := strings.TrimSpace(*lang); l != "" {
+		must(setUILang(l, false))
+	}
+
+	u, err := c.resolveUser(rest[0])
+	must(err)
+	u, err = c.userShow(u.UUID)
+	must(err)
+	u.RemainingDays = userRemainingDays(u, time.Now())
+	h := strings.TrimSpace(*host)
+	if h == "" {
+		h = c.mainDomainRequired()
+	}
+
+	b := userBundle{
+		User:    u,
+		Links:   buildLinks(c.clientPath(), u.UUID, h),
+		Lang:    currentUILang,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// Services linked through `accounts` are included automatically; explicit
+	// flags win and make failures fatal instead of warnings.
+	acc, hasAccount := psasAccount{}, false
+	if accounts, err := loadAccounts(); err == nil {
+		acc, hasAccount = findAccountByUUID(accounts, u.UUID)
+	}
+	socksID := strings.TrimSpace(*socksLogin)
+	if socksID == "" && hasAccount {
+		socksID = acc.SocksLogin
+	}
+	if socksID != "" {
+		info, err := bundleSocksInfo(socksID, strings.TrimSpace(*socksServer))
+		if err != nil && strings.TrimSpace(*socksLogin) != "" {
+			fatalf("socks: %v", err)
+		}
+		if err != nil {
+			b.Warnings = append(b.Warnings, fmt.Sprintf("socks: %v", err))
+		} else {
+			b.Socks = &info
+		}
+	}
+	trustID := strings.TrimSpace(*trustUsername)
+	if trustID == "" && hasAccount {
+		trustID = acc.TrustUsername
+	}
+	if trustID != "" {
+		info, err := bundleTrustConfig(trustID, strings.TrimSpace(*trustAddress))
+		if err != nil && strings.TrimSpace(*trustUsername) != "" {
+			fatalf("trust: %v", err)
+		}
+		if err != nil {
+			b.Warnings = append(b.Warnings, fmt.Sprintf("trust: %v", err))
+		} else {
+			b.Trust = &info
+		}
+	}
+	mp := newMTProxyClient()
+	wantMTProxy := !*noMTProxy && mp.installed()
+	if hasAccount && !acc.MTProxy {
+		wantMTProxy = false
+	}
+	if wantMTProxy {
+		info, err := mp.connectionInfo("", 0, "")
+		if err != nil {
+			b.Warnings = append(b.Warnings, fmt.Sprintf("mtproxy: %v", err))
+		} else {
+			b.MTProxy = &info
+		}
+	}
+
+	files, err := renderUserBundle(&b)
+	must(err)
+
+	target := strings.TrimSpace(*outPath)
+	if target == "" {
+		target = bundleNameRe.ReplaceAllString(u.Name, "_") + "-bundle"
+	}
+	if *asZip && !strings.HasSuffix(strings.ToLower(target), ".zip") {
+		target += ".zip"
+	}
+	if strings.HasSuffix(strings.ToLower(target), ".zip") {
+		must(writeBundleZip(target, files))
+	} else {
+		must(writeBundleDir(target, files))
+	}
+
+	if *jsonOut {
+		printJSON(map[string]any{
+			"out":      target,
+			"files":    b.Files,
+			"warnings": b.Warnings,
+		})
+		return
+	}
+	fmt.Printf("Bundle written: %s\n", target)
+	for _, name := range b.Files {
+		fmt.Printf("  %s\n", name)
+	}
+	for _, w := range b.Warnings {
+		fmt.Println(uiTextf("Warning: %s", w))
+	}
+}
+
+func bundleSocksInfo(login, server string) (socksConnInfo, error) {
+	sc := newSocksClient()
+	users, err := sc.usersList()
+	if err != nil {
+		return socksConnInfo{}, err
+	}
+	u, _, err := resolveSocksUser(users, login)
+	if err != nil {
+		return socksConnInfo{}, err
+	}
+	return sc.connectionConfig(u, server, 0)
+}
+
+func bundleTrustConfig(username, address string) (bundleTrustInfo, error) {
+	tt := newTrustClient()
+	users, err := tt.usersList()
+	if err != nil {
+		return bundleTrustInfo{}, err
+	}
+	u, _, err := resolveTrustUser(users, username)
+	if err != nil {
+		return bundleTrustInfo{}, err
+	}
+	cfg, err := tt.exportClientConfig(u.Username, address)
+	if err != nil {
+		return bundleTrustInfo{}, err
+	}
+	return bundleTrustInfo{Username: u.Username, Password: u.Password, Address: tt.lastExportAddress, Config: cfg}, nil
+}
+
+// renderUserBundle produces the bundle files and records their names in b.Files.
+func renderUserBundle(b *userBundle) ([]bundleFile, error) {
+	var files []bundleFile
+	addQR := func(name, text string) error {
+		data, err := qrPNG(text, bundleQRScale)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files = append(files, bundleFile{Name: name, Data: data})
+		return nil
+	}
+
+	var links strings.Builder
+	fmt.Fprintf(&links, "Hiddify (auto)      : %s\n", b.Links.Auto)
+	fmt.Fprintf(&links, "Subscription b64    : %s\n", b.Links.Sub64)
+	fmt.Fprintf(&links, "Subscription plain  : %s\n", b.Links.Sub)
+	fmt.Fprintf(&links, "Sing-box            : %s\n", b.Links.Singbox)
+	fmt.Fprintf(&links, "Panel URL           : %s\n", b.Links.Panel)
+	files = append(files, bundleFile{Name: "links.txt", Data: []byte(links.String())})
+	if err := addQR("qr-hiddify-auto.png", b.Links.Auto); err != nil {
+		return nil, err
+	}
+	if err := addQR("qr-subscription.png", b.Links.Sub64); err != nil {
+		return nil, err
+	}
+	if err := addQR("qr-singbox.png", b.Links.Singbox); err != nil {
+		return nil, err
+	}
+
+	if b.Socks != nil {
+		files = append(files, bundleFile{Name: "socks5.txt", Data: []byte(renderSocksConnInfo(*b.Socks))})
+		if err := addQR("qr-socks5.png", b.Socks.URI); err != nil {
+			return nil, err
+		}
+	}
+	if b.Trust != nil {
+		files = append(files, bundleFile{Name: "trusttunnel.toml", Data: []byte(b.Trust.Config)})
+	}
+	if b.MTProxy != nil {
+		files = append(files, bundleFile{Name: "mtproxy.txt", Data: []byte(renderMTProxyConnInfo(*b.MTProxy))})
+		if err := addQR("qr-mtproxy.png", b.MTProxy.ShareURL); err != nil {
+			return nil, err
+		}
+	}
+
+	names := []string{"README.txt", "bundle.json"}
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	b.Files = names
+
+	manifest, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	manifest = append(manifest, '\n')
+	head := []bundleFile{
+		{Name: "README.txt", Data: []byte(renderBundleReadme(*b))},
+		{Name: "bundle.json", Data: manifest},
+	}
+	return append(head, files...), nil
+}
+
+func renderBundleReadme(b userBundle) string {
+	var r strings.Builder
+	title := uiTextf("Access bundle for %s", b.User.Name)
+	r.WriteString(title + "\n")
+	r.WriteString(strings.Repeat("=", len([]rune(title))) + "\n\n")
+
+	limit := fmt.Sprintf("%.0f GB", b.User.UsageLimitGB)
+	if isUnlimitedUsage(b.User) {
+		limit = uiText("unlimited")
+	}
+	days := formatRemainingDays(b.User)
+	if isUnlimitedDays(b.User) {
+		days = uiText("unlimited")
+	}
+	fmt.Fprintf(&r, "%s: %s\n", uiText("Traffic limit"), limit)
+	fmt.Fprintf(&r, "%s: %s\n\n", uiText("Days left"), days)
+
+	section := func(name string) {
+		r.WriteString(name + "\n")
+		r.WriteString(strings.Repeat("-", len([]rune(name))) + "\n")
+	}
+
+	section(uiText("1. Hiddify (main VPN)"))
+	r.WriteString(uiText("Install the Hiddify app, then open the link below or scan qr-hiddify-auto.png.") + "\n")
+	fmt.Fprintf(&r, "%s: %s\n", uiText("Import link"), b.Links.Auto)
+	fmt.Fprintf(&r, "%s: %s (qr-subscription.png)\n", uiText("Subscription for other clients"), b.Links.Sub64)
+	fmt.Fprintf(&r, "%s: %s (qr-singbox.png)\n", uiText("Sing-box profile"), b.Links.Singbox)
+	fmt.Fprintf(&r, "%s: %s\n\n", uiText("Personal page"), b.Links.Panel)
+
+	n := 2
+	if b.Socks != nil {
+		section(fmt.Sprintf("%d. SOCKS5", n))
+		n++
+		r.WriteString(uiText("Use in a browser or app proxy settings; see socks5.txt or scan qr-socks5.png.") + "\n")
+		r.WriteString(renderSocksConnInfo(*b.Socks) + "\n")
+	}
+	if b.Trust != nil {
+		section(fmt.Sprintf("%d. TrustTunnel", n))
+		n++
+		r.WriteString(uiText("Import trusttunnel.toml into the TrustTunnel client.") + "\n")
+		fmt.Fprintf(&r, "%s: %s\n", uiText("Username"), b.Trust.Username)
+		fmt.Fprintf(&r, "%s: %s\n\n", uiText("Password"), b.Trust.Password)
+	}
+	if b.MTProxy != nil {
+		section(fmt.Sprintf("%d. Telegram MTProxy", n))
+		r.WriteString(uiText("Open the link on a device with Telegram installed or scan qr-mtproxy.png.") + "\n")
+		fmt.Fprintf(&r, "%s: %s\n", uiText("tg:// link"), b.MTProxy.TGLink)
+		fmt.Fprintf(&r, "%s: %s\n\n", uiText("Share URL"), b.MTProxy.ShareURL)
+	}
+
+	r.WriteString(uiText("Keep this bundle private: it contains personal links and passwords.") + "\n")
+	return r.String()
+}
+
+func writeBundleDir(dir string, files []bundleFile) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBundleZip(path string, files []bundleFile) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	now := time.Now()
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: now}
+		hdr.SetMode(0o600)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			_ = out.Close()
+			return err
+		}
+		if _, err := w.Write(f.Data); err != nil {
+			_ = out.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
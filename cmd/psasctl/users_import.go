@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var userCSVHeader = []string{"uuid", "name", "enable", "usage_limit_GB", "current_usage_GB", "package_days", "start_date", "remaining_days", "last_online", "mode", "auto", "sub64", "sub", "singbox", "panel"}
+
+type userExportRecord struct {
+	User  apiUser `json:"user"`
+	Links linkSet `json:"links"`
+}
+
+// userImportRow is one parsed import entry. Optional fields are pointers so that
+// an upsert only patches what the file actually specifies.
+type userImportRow struct {
+	Row          int
+	UUID         string
+	Name         string
+	Enable       *bool
+	UsageLimitGB *float64
+	PackageDays  *int
+	Mode         string
+	Err          error
+}
+
+type userFieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after"`
+}
+
+type userImportResult struct {
+	Row     int               `json:"row"`
+	Action  string            `json:"action"`
+	UUID    string            `json:"uuid,omitempty"`
+	Name    string            `json:"name,omitempty"`
+	Changes []userFieldChange `json:"changes,omitempty"`
+	Error   string            `json:"error,omitempty"`
+
+	payload map[string]any
+	target  apiUser
+}
+
+func runUsersExport(c *client, args []string) {
+	fs := flag.NewFlagSet("users export", flag.ExitOnError)
+	format := fs.String("format", "", "output format: json|csv (default: by --out extension, else json)")
+	outPath := fs.String("out", "", "write export to file instead of stdout")
+	host := fs.String("host", "", "domain for generated links")
+	enabledOnly := fs.Bool("enabled", false, "export only enabled users")
+	nameFilter := fs.String("name", "", "name contains (case-insensitive)")
+	must(fs.Parse(args))
+	if len(fs.Args()) != 0 {
+		fatalf("users export takes only flags")
+	}
+	f, err := resolveUserFileFormat(*format, *outPath)
+	must(err)
+
+	users, err := c.usersList()
+	must(err)
+	users = filterUsers(users, *nameFilter, *enabledOnly)
+	h := strings.TrimSpace(*host)
+	if h == "" {
+		h = c.mainDomainRequired()
+	}
+	records := make([]userExportRecord, 0, len(users))
+	for _, u := range users {
+		records = append(records, userExportRecord{User: u, Links: buildLinks(c.clientPath(), u.UUID, h)})
+	}
+
+	var w io.Writer = os.Stdout
+	p := strings.TrimSpace(*outPath)
+	if p != "" {
+		file, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		must(err)
+		defer file.Close()
+		w = file
+	}
+	switch f {
+	case "csv":
+		must(writeUsersCSV(w, records))
+	default:
+		b, err := json.MarshalIndent(records, "", "  ")
+		must(err)
+		_, err = w.Write(append(b, '\n'))
+		must(err)
+	}
+	if p != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d users to %s\n", len(records), p)
+	}
+}
+
+func runUsersImport(c *client, args []string) {
+	fs := flag.NewFlagSet("users import", flag.ExitOnError)
+	format := fs.String("format", "", "input format: json|csv (default: by file extension, else json)")
+	dryRun := fs.Bool("dry-run", false, "print planned changes without applying them")
+	upsert := fs.Bool("upsert", false, "update existing users matched by UUID or exact name instead of failing")
+	jsonOut := fs.Bool("json", false, "output JSON")
+	must(fs.Parse(args))
+	rest := fs.Args()
+	if len(rest) != 1 {
+		fatalf("users import requires FILE (use - for stdin)")
+	}
+	f, err := resolveUserFileFormat(*format, rest[0])
+	must(err)
+
+	var r io.Reader = os.Stdin
+	if rest[0] != "-" {
+		file, err := os.Open(rest[0])
+		must(err)
+		defer file.Close()
+		r = file
+	}
+	var rows []userImportRow
+	if f == "csv" {
+		rows, err = parseUsersCSV(r)
+	} else {
+		rows, err = parseUsersJSON(r)
+	}
+	must(err)
+
+	existing, err := c.usersList()
+	must(err)
+	results := planUserImport(rows, existing, *upsert)
+	if !*dryRun {
+		applyUserImport(c, results)
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.Action == "error" {
+			failed++
+		}
+	}
+	if *jsonOut {
+		printJSON(map[string]any{
+			"dry_run": *dryRun,
+			"results": results,
+			"failed":  failed,
+		})
+	} else {
+		printUserImportResults(results, *dryRun)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func resolveUserFileFormat(format, path string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "json":
+		return "json", nil
+	case "csv":
+		return "csv", nil
+	case "":
+		if strings.EqualFold(filepath.Ext(strings.TrimSpace(path)), ".csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	default:
+		return "", fmt.Errorf("invalid --format: %s (expected json|csv)", format)
+	}
+}
+
+func writeUsersCSV(w io.Writer, records []userExportRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(userCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write([]string{
+			r.User.UUID,
+			r.User.Name,
+			strconv.FormatBool(r.User.Enable),
+			strconv.FormatFloat(r.User.UsageLimitGB, 'f', -1, 64),
+			strconv.FormatFloat(r.User.CurrentUsageGB, 'f', -1, 64),
+			strconv.Itoa(r.User.PackageDays),
+			r.User.StartDate,
+			strconv.Itoa(r.User.RemainingDays),
+			r.User.LastOnline,
+			r.User.Mode,
+			r.Links.Auto,
+			r.Links.Sub64,
+			r.Links.Sub,
+			r.Links.Singbox,
+			r.Links.Panel,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func parseUsersCSV(r io.Reader) ([]userImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("csv is empty")
+	}
+	cols := map[string]int{}
+	for i, h := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["name"]; !ok {
+		if _, ok := cols["uuid"]; !ok {
+			return nil, errors.New("csv header must contain at least name or uuid column")
+		}
+	}
+	get := func(rec []string, key string) (string, bool) {
+		i, ok := cols[strings.ToLower(key)]
+		if !ok || i >= len(rec) {
+			return "", false
+		}
+		v := strings.TrimSpace(rec[i])
+		return v, v != ""
+	}
+
+	rows := make([]userImportRow, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		row := userImportRow{Row: i + 2}
+		row.UUID, _ = get(rec, "uuid")
+		row.Name, _ = get(rec, "name")
+		row.Mode, _ = get(rec, "mode")
+		if v, ok := get(rec, "enable"); ok {
+			if b, err := parseBoolLike(v); err != nil {
+				row.Err = fmt.Errorf("enable: %w", err)
+			} else {
+				row.Enable = &b
+			}
+		}
+		if v, ok := get(rec, "usage_limit_gb"); ok {
+			if gb, err := strconv.ParseFloat(v, 64); err != nil {
+				row.Err = fmt.Errorf("usage_limit_GB: %w", err)
+			} else {
+				row.UsageLimitGB = &gb
+			}
+		}
+		if v, ok := get(rec, "package_days"); ok {
+			if days, err := strconv.Atoi(v); err != nil {
+				row.Err = fmt.Errorf("package_days: %w", err)
+			} else {
+				row.PackageDays = &days
+			}
+		}
+		if row.Err == nil && row.UUID == "" && row.Name == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseUsersJSON accepts either a plain array of users or the array produced by
+// `users export` where each item is {"user": ..., "links": ...}.
+func parseUsersJSON(r io.Reader) ([]userImportRow, error) {
+	var items []map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+	rows := make([]userImportRow, 0, len(items))
+	for i, item := range items {
+		row := userImportRow{Row: i + 1}
+		if nested, ok := item["user"]; ok {
+			var inner map[string]json.RawMessage
+			if err := json.Unmarshal(nested, &inner); err != nil {
+				row.Err = fmt.Errorf("user: %w", err)
+				rows = append(rows, row)
+				continue
+			}
+			item = inner
+		}
+		decode := func(key string, dst any) bool {
+			raw, ok := item[key]
+			if !ok || row.Err != nil || string(raw) == "null" {
+				return false
+			}
+			if err := json.Unmarshal(raw, dst); err != nil {
+				row.Err = fmt.Errorf("%s: %w", key, err)
+				return false
+			}
+			return true
+		}
+		decode("uuid", &row.UUID)
+		decode("name", &row.Name)
+		decode("mode", &row.Mode)
+		var enable bool
+		if decode("enable", &enable) {
+			row.Enable = &enable
+		}
+		var gb float64
+		if decode("usage_limit_GB", &gb) {
+			row.UsageLimitGB = &gb
+		}
+		var days int
+		if decode("package_days", &days) {
+			row.PackageDays = &days
+		}
+		row.UUID = strings.TrimSpace(row.UUID)
+		row.Name = strings.TrimSpace(row.Name)
+		row.Mode = strings.TrimSpace(row.Mode)
+		if row.Err == nil && row.UUID == "" && row.Name == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func validateUserImportRow(row userImportRow) error {
+	if row.Err != nil {
+		return row.Err
+	}
+	if row.UUID != "" {
+		if err := validateUUID(row.UUID); err != nil {
+			return err
+		}
+	}
+	if row.Mode != "" && !isValidMode(row.Mode) {
+		return fmt.Errorf("invalid mode: %s", row.Mode)
+	}
+	if row.PackageDays != nil && *row.PackageDays < 1 {
+		return errors.New("package_days must be >= 1")
+	}
+	if row.UsageLimitGB != nil && *row.UsageLimitGB <= 0 {
+		return errors.New("usage_limit_GB must be > 0")
+	}
+	return nil
+}
+
+func findImportTarget(users []apiUser, row userImportRow) (apiUser, bool, error) {
+	if row.UUID != "" {
+		for _, u := range users {
+			if strings.EqualFold(u.UUID, row.UUID) {
+				return u, true, nil
+			}
+		}
+		return apiUser{}, false, nil
+	}
+	var matches []apiUser
+	for _, u := range users {
+		if strings.EqualFold(strings.TrimSpace(u.Name), row.Name) {
+			matches = append(matches, u)
+		}
+	}
+	if len(matches) > 1 {
+		return apiUser{}, false, fmt.Errorf("multiple users have name %q: %s", row.Name, formatUserRefs(matches))
+	}
+	if len(matches) == 1 {
+		return matches[0], true, nil
+	}
+	return apiUser{}, false, nil
+}
+
+func planUserImport(rows []userImportRow, existing []apiUser, upsert bool) []userImportResult {
+	results := make([]userImportResult, 0, len(rows))
+	seen := map[string]int{}
+	for _, row := range rows {
+		res := userImportResult{Row: row.Row, UUID: strings.ToLower(row.UUID), Name: row.Name}
+		fail := func(err error) {
+			res.Action = "error"
+			res.Error = err.Error()
+			results = append(results, res)
+		}
+		if err := validateUserImportRow(row); err != nil {
+			fail(err)
+			continue
+		}
+		key := "name:" + strings.ToLower(row.Name)
+		if row.UUID != "" {
+			key = "uuid:" + strings.ToLower(row.UUID)
+		}
+		if prev, ok := seen[key]; ok {
+			fail(fmt.Errorf("duplicate of row %d", prev))
+			continue
+		}
+		seen[key] = row.Row
+
+		target, found, err := findImportTarget(existing, row)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if found && !upsert {
+			fail(fmt.Errorf("user already exists: %s (%s); use --upsert to update", target.Name, target.UUID))
+			continue
+		}
+		if !found {
+			if row.Name == "" {
+				fail(errors.New("name is required to create a user"))
+				continue
+			}
+			res.Action = "create"
+			if res.UUID == "" {
+				res.UUID = newUUID()
+			}
+			res.payload = map[string]any{
+				"uuid":           res.UUID,
+				"name":           row.Name,
+				"package_days":   30,
+				"usage_limit_GB": 100.0,
+				"mode":           "no_reset",
+				"enable":         true,
+			}
+			if row.PackageDays != nil {
+				res.payload["package_days"] = *row.PackageDays
+			}
+			if row.UsageLimitGB != nil {
+				res.payload["usage_limit_GB"] = *row.UsageLimitGB
+			}
+			if row.Mode != "" {
+				res.payload["mode"] = row.Mode
+			}
+			if row.Enable != nil {
+				res.payload["enable"] = *row.Enable
+			}
+			for _, field := range []string{"name", "package_days", "usage_limit_GB", "mode", "enable"} {
+				res.Changes = append(res.Changes, userFieldChange{Field: field, After: res.payload[field]})
+			}
+			results = append(results, res)
+			continue
+		}
+
+		res.UUID = target.UUID
+		res.Name = target.Name
+		res.target = target
+		res.payload = map[string]any{}
+		if row.UUID != "" && row.Name != "" && row.Name != target.Name {
+			res.payload["name"] = row.Name
+			res.Changes = append(res.Changes, userFieldChange{Field: "name", Before: target.Name, After: row.Name})
+		}
+		if row.PackageDays != nil && *row.PackageDays != target.PackageDays {
+			res.payload["package_days"] = *row.PackageDays
+			res.Changes = append(res.Changes, userFieldChange{Field: "package_days", Before: target.PackageDays, After: *row.PackageDays})
+		}
+		if row.UsageLimitGB != nil && *row.UsageLimitGB != target.UsageLimitGB {
+			res.payload["usage_limit_GB"] = *row.UsageLimitGB
+			res.Changes = append(res.Changes, userFieldChange{Field: "usage_limit_GB", Before: target.UsageLimitGB, After: *row.UsageLimitGB})
+		}
+		if row.Mode != "" && row.Mode != target.Mode {
+			res.payload["mode"] = row.Mode
+			res.Changes = append(res.Changes, userFieldChange{Field: "mode", Before: target.Mode, After: row.Mode})
+		}
+		if row.Enable != nil && *row.Enable != target.Enable {
+			res.payload["enable"] = *row.Enable
+			res.Changes = append(res.Changes, userFieldChange{Field: "enable", Before: target.Enable, After: *row.Enable})
+		}
+		if len(res.payload) == 0 {
+			res.Action = "skip"
+		} else {
+			res.Action = "update"
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+func applyUserImport(c *client, results []userImportResult) {
+	for i := range results {
+		res := &results[i]
+		var err error
+		switch res.Action {
+		case "create":
+			var u apiUser
+			u, err = c.userAdd(res.payload)
+			if err == nil {
+				res.UUID = u.UUID
+			}
+		case "update":
+			_, err = c.userPatch(res.target, res.payload)
+		default:
+			continue
+		}
+		if err != nil {
+			res.Action = "error"
+			res.Error = err.Error()
+			continue
+		}
+		ev := notifyEvent{Type: notifyUserUpdated, Source: "hiddify", User: res.Name, UUID: res.UUID, Details: map[string]any{"import_row": res.Row}}
+		if res.Action == "create" {
+			ev.Type = notifyUserCreated
+		}
+		queueNotify(ev)
+	}
+	flushQueuedNotifications()
+}
+
+func printUserImportResults(results []userImportResult, dryRun bool) {
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tACTION\tUUID\tNAME\tDETAILS")
+	for _, res := range results {
+		counts[res.Action]++
+		details := res.Error
+		if details == "" {
+			parts := make([]string, 0, len(res.Changes))
+			for _, ch := range res.Changes {
+				if res.Action == "create" {
+					parts = append(parts, fmt.Sprintf("%s=%v", ch.Field, ch.After))
+					continue
+				}
+				parts = append(parts, fmt.Sprintf("%s: %v -> %v", ch.Field, ch.Before, ch.After))
+			}
+			details = strings.Join(parts, ", ")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", res.Row, res.Action, res.UUID, res.Name, details)
+	}
+	_ = tw.Flush()
+	fmt.Println()
+	prefix := ""
+	if dryRun {
+		prefix = "Dry run: "
+	}
+	fmt.Printf("%screate=%d update=%d skip=%d error=%d\n", prefix, counts["create"], counts["update"], counts["skip"], counts["error"])
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+const (
+	defaultWebAuthPath = "/etc/psas/web.json"
+	webSessionCookie   = "psas_session"
+	webCSRFCookie      = "psas_csrf"
+	webCSRFHeader      = "X-PSAS-CSRF"
+	webSessionTTL      = 12 * time.Hour
+	webPBKDF2Iter      = 120000
+)
+
+// webAuth is the single web admin account, stored in PSAS_WEB.
+type webAuth struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+}
+
+type webSession struct {
+	User    string
+	CSRF    string
+	Expires time.Time
+}
+
+// webUI holds the in-memory sessions; restarting serve logs everyone out.
+type webUI struct {
+	mu       sync.Mutex
+	sessions map[string]*webSession
+}
+
+func webAuthPath() string {
+	return envOr("PSAS_WEB", defaultWebAuthPath)
+}
+
+func loadWebAuth() (webAuth, error) {
+	var a webAuth
+	raw, err := loadStateFile(webAuthPath())
+	if err != nil {
+		return a, err
+	}
+	if raw == nil {
+		return a, fmt.Errorf("web login is not configured: run psasctl serve passwd")
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return a, fmt.Errorf("parse %s: %w", webAuthPath(), err)
+	}
+	return a, nil
+}
+
+func writeWebAuth(a webAuth) error {
+	raw, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return saveStateFile(webAuthPath(), append(raw, '\n'), 0o600)
+}
+
+// hashWebPassword returns "pbkdf2-sha256$ITER$SALT$HASH" with hex salt/hash.
+func hashWebPassword(password string) string {
+	salt := make([]byte, 16)
+	mustReadRand(salt)
+	key := pbkdf2SHA256([]byte(password), salt, webPBKDF2Iter, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", webPBKDF2Iter, hex.EncodeToString(salt), hex.EncodeToString(key))
+}
+
+func checkWebPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(password), salt, iter, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2SHA256 implements RFC 8018 PBKDF2 with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], block)
+		prf.Write(idx[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+func newWebUI() *webUI {
+	return &webUI{sessions: map[string]*webSession{}}
+}
+
+func (w *webUI) create(user string) (string, *webSession) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for id, sess := range w.sessions {
+		if now.After(sess.Expires) {
+			delete(w.sessions, id)
+		}
+	}
+	id := newHexToken(32)
+	sess := &webSession{User: user, CSRF: newHexToken(32), Expires: now.Add(webSessionTTL)}
+	w.sessions[id] = sess
+	return id, sess
+}
+
+func (w *webUI) session(r *http.Request) *webSession {
+	ck, err := r.Cookie(webSessionCookie)
+	if err != nil || ck.Value == "" {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sess, ok := w.sessions[ck.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.Expires) {
+		delete(w.sessions, ck.Value)
+		return nil
+	}
+	return sess
+}
+
+func (w *webUI) drop(r *http.Request) {
+	ck, err := r.Cookie(webSessionCookie)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.sessions, ck.Value)
+	w.mu.Unlock()
+}
+
+// authorized accepts a session cookie for safe methods and requires the
+// session CSRF token in X-PSAS-CSRF for everything else.
+func (w *webUI) authorized(r *http.Request) bool {
+	sess := w.session(r)
+	if sess == nil {
+		return false
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	got := r.Header.Get(webCSRFHeader)
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRF)) == 1
+}
+
+func webSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func webSetCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: name == webSessionCookie,
+		Secure:   webSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func webHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; style-src 'self'; script-src 'self'; frame-ancestors 'none'; form-action 'self'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+type webLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type webSessionResponse struct {
+	Authenticated bool   `json:"authenticated"`
+	User          string `json:"user,omitempty"`
+	CSRF          string `json:"csrf"`
+	Configured    bool   `json:"configured"`
+}
+
+func (s *apiServer) mountWebUI(mux *http.ServeMux) {
+	sub, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("GET /ui/", webHeaders(http.StripPrefix("/ui/", http.FileServerFS(sub))))
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+	mux.HandleFunc("GET /ui/session", s.webSessionInfo)
+	mux.HandleFunc("POST /ui/login", s.webLogin)
+	mux.HandleFunc("POST /ui/logout", s.webLogout)
+	mux.Handle("GET /ui/qr", webHeaders(http.HandlerFunc(s.webQR)))
+}
+
+func (s *apiServer) webSessionInfo(w http.ResponseWriter, r *http.Request) {
+	_, authErr := loadWebAuth()
+	resp := webSessionResponse{Configured: authErr == nil}
+	if sess := s.web.session(r); sess != nil {
+		resp.Authenticated, resp.User, resp.CSRF = true, sess.User, sess.CSRF
+		apiWriteJSON(w, http.StatusOK, resp)
+		return
+	}
+	// Before login the CSRF token is a double-submit cookie.
+	token := ""
+	if ck, err := r.Cookie(webCSRFCookie); err == nil && len(ck.Value) == 64 {
+		token = ck.Value
+	} else {
+		token = newHexToken(32)
+		webSetCookie(w, r, webCSRFCookie, token, 0)
+	}
+	resp.CSRF = token
+	apiWriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *apiServer) webLogin(w http.ResponseWriter, r *http.Request) {
+	ck, err := r.Cookie(webCSRFCookie)
+	got := r.Header.Get(webCSRFHeader)
+	if err != nil || got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(ck.Value)) != 1 {
+		apiWriteJSON(w, http.StatusForbidden, apiErrorResponse{Error: "invalid CSRF token"})
+		return
+	}
+	var req webLoginRequest
+	if err := apiDecode(r, &req); err != nil {
+		apiWriteJSON(w, http.StatusBadRequest, apiErrorResponse{Error: err.Error()})
+		return
+	}
+	auth, err := loadWebAuth()
+	if err != nil {
+		apiWriteJSON(w, http.StatusServiceUnavailable, apiErrorResponse{Error: err.Error()})
+		return
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(strings.TrimSpace(req.Username)), []byte(auth.Username)) == 1
+	if !checkWebPassword(auth.PasswordHash, req.Password) || !userOK {
+		time.Sleep(time.Second)
+		apiWriteJSON(w, http.StatusUnauthorized, apiErrorResponse{Error: "invalid username or password"})
+		return
+	}
+	id, sess := s.web.create(auth.Username)
+	webSetCookie(w, r, webSessionCookie, id, int(webSessionTTL/time.Second))
+	webSetCookie(w, r, webCSRFCookie, "", -1)
+	apiWriteJSON(w, http.StatusOK, webSessionResponse{Authenticated: true, User: sess.User, CSRF: sess.CSRF, Configured: true})
+}
+
+func (s *apiServer) webLogout(w http.ResponseWriter, r *http.Request) {
+	if !s.web.authorized(r) {
+		apiWriteJSON(w, http.StatusForbidden, apiErrorResponse{Error: "invalid session or CSRF token"})
+		return
+	}
+	s.web.drop(r)
+	webSetCookie(w, r, webSessionCookie, "", -1)
+	apiWriteJSON(w, http.StatusOK, apiOK{OK: true})
+}
+
+func (s *apiServer) webQR(w http.ResponseWriter, r *http.Request) {
+	if s.web.session(r) == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	text := r.URL.Query().Get("text")
+	if text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	img, err := qrPNG(text, 6)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(img)
+}
@@ -0,0 +1,356 @@
+"use strict";
+
+// PSAS web dashboard. Talks to the same /api/v1 endpoints as API clients;
+// the session cookie authenticates and X-PSAS-CSRF guards writes.
+const state = { csrf: "", user: "", tab: "status" };
+
+function h(tag, attrs, ...children) {
+  const el = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (v === null || v === undefined || v === false) continue;
+    if (k.startsWith("on")) el.addEventListener(k.slice(2), v);
+    else if (k === "class") el.className = v;
+    else el.setAttribute(k, v === true ? "" : v);
+  }
+  for (const c of children.flat()) {
+    if (c === null || c === undefined || c === false) continue;
+    el.append(c instanceof Node ? c : document.createTextNode(String(c)));
+  }
+  return el;
+}
+
+async function api(method, path, body) {
+  const opts = { method, headers: { "X-PSAS-CSRF": state.csrf }, credentials: "same-origin" };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, opts);
+  let data = null;
+  try { data = await resp.json(); } catch (e) { data = null; }
+  if (resp.status === 401 && path.startsWith("/api/")) {
+    showLogin("Session expired, sign in again.");
+  }
+  if (!resp.ok) throw new Error((data && data.error) || resp.status + " " + resp.statusText);
+  return data;
+}
+
+function flash(msg, info) {
+  const el = document.getElementById("flash");
+  el.textContent = msg;
+  el.className = info ? "info" : "";
+  el.hidden = !msg;
+  if (msg) setTimeout(() => { if (el.textContent === msg) el.hidden = true; }, 6000);
+}
+
+// run wraps an action: shows errors, optionally confirms, re-renders the tab.
+function run(fn, confirmText) {
+  return async (ev) => {
+    if (ev) ev.preventDefault();
+    if (confirmText && !confirm(confirmText)) return;
+    try {
+      const msg = await fn(ev);
+      if (typeof msg === "string") flash(msg, true);
+      await render();
+    } catch (e) {
+      flash(e.message);
+    }
+  };
+}
+
+function modal(...children) {
+  const body = document.getElementById("modal-body");
+  body.replaceChildren(...children);
+  document.getElementById("modal").showModal();
+}
+
+function qrLink(title, text) {
+  return h("div", { class: "link" },
+    h("strong", {}, title), h("br"), h("code", {}, text),
+    h("img", { src: "/ui/qr?text=" + encodeURIComponent(text), alt: "QR " + title }));
+}
+
+function formData(form) {
+  const out = {};
+  for (const el of form.elements) {
+    if (!el.name) continue;
+    out[el.name] = el.type === "checkbox" ? el.checked : el.value.trim();
+  }
+  return out;
+}
+
+function table(headers, rows) {
+  return h("table", {},
+    h("thead", {}, h("tr", {}, headers.map((t) => h("th", {}, t)))),
+    h("tbody", {}, rows.length ? rows : h("tr", {}, h("td", { colspan: headers.length, class: "muted" }, "No entries"))));
+}
+
+function kvTable(obj, prefix) {
+  const rows = [];
+  const walk = (o, p) => {
+    for (const [k, v] of Object.entries(o || {})) {
+      const key = p ? p + "." + k : k;
+      if (v && typeof v === "object" && !Array.isArray(v)) walk(v, key);
+      else rows.push(h("tr", {}, h("th", {}, key), h("td", {}, Array.isArray(v) ? v.join(", ") : String(v))));
+    }
+  };
+  walk(obj, prefix || "");
+  return h("table", {}, h("tbody", {}, rows));
+}
+
+function serviceButtons(name) {
+  return ["restart", "stop", "start"].map((action) =>
+    h("button", { onclick: run(async () => (await api("POST", `/api/v1/services/${name}/${action}`)).message, `${action} ${name}?`) }, action));
+}
+
+function fmtGB(v) {
+  return v >= 1000000 ? "∞" : Number(v).toFixed(2).replace(/\.00$/, "");
+}
+
+// ---- tabs ----
+
+async function renderStatus() {
+  const st = await api("GET", "/api/v1/status");
+  return [h("h2", {}, "Status"), h("div", { class: "card" }, kvTable(st))];
+}
+
+async function renderHiddify() {
+  const [users, protocols] = await Promise.all([api("GET", "/api/v1/users"), api("GET", "/api/v1/protocols")]);
+  const addForm = h("form", { class: "card row", onsubmit: run(async (ev) => {
+    const f = formData(ev.target);
+    const body = { name: f.name, mode: f.mode, plan: f.plan, unlimited_days: f.unlimited, unlimited_gb: f.unlimited };
+    if (f.days) body.days = Number(f.days);
+    if (f.gb) body.gb = Number(f.gb);
+    const res = await api("POST", "/api/v1/users", body);
+    showUserLinks(res.user, res.links);
+    return "User created: " + res.user.name;
+  }) },
+    h("label", {}, "Name", h("input", { name: "name", required: true })),
+    h("label", {}, "Days", h("input", { name: "days", type: "number", min: 1, placeholder: "30" })),
+    h("label", {}, "GB", h("input", { name: "gb", type: "number", min: 0, step: "any", placeholder: "100" })),
+    h("label", {}, "Mode", modeSelect("no_reset")),
+    h("label", {}, "Plan", h("input", { name: "plan", placeholder: "optional" })),
+    h("label", {}, "Unlimited", h("input", { name: "unlimited", type: "checkbox" })),
+    h("button", { class: "primary" }, "Add user"));
+
+  const rows = users.map((u) => h("tr", {},
+    h("td", {}, u.name),
+    h("td", {}, h("code", {}, u.uuid)),
+    h("td", { class: u.enable ? "ok" : "bad" }, u.enable ? "on" : "off"),
+    h("td", {}, `${fmtGB(u.current_usage_GB)} / ${fmtGB(u.usage_limit_GB)}`),
+    h("td", {}, u.package_days >= 10000 ? "∞" : String(u.remaining_days)),
+    h("td", {}, u.mode),
+    h("td", { class: "actions" },
+      h("button", { onclick: run(async () => { const r = await api("GET", "/api/v1/users/" + u.uuid); showUserLinks(r.user, r.links); }) }, "Links"),
+      h("button", { onclick: () => editUser(u) }, "Edit"),
+      h("button", { onclick: run(async () => { await api("PATCH", "/api/v1/users/" + u.uuid, { enable: !u.enable }); return (u.enable ? "Disabled " : "Enabled ") + u.name; }) }, u.enable ? "Disable" : "Enable"),
+      h("button", { class: "danger", onclick: run(async () => (await api("DELETE", "/api/v1/users/" + u.uuid)).message, `Delete ${u.name}?`) }, "Delete"))));
+
+  const protoRows = protocols.map((p) => h("tr", {},
+    h("td", {}, p.name), h("td", {}, h("code", {}, p.key)),
+    h("td", { class: p.enabled ? "ok" : "bad" }, p.enabled ? "enabled" : "disabled"),
+    h("td", { class: "actions" }, h("button", { onclick: run(async () => (await api("PUT", "/api/v1/protocols/" + p.name, { enabled: !p.enabled })).message) }, p.enabled ? "Disable" : "Enable"))));
+
+  return [
+    h("h2", {}, "Hiddify users"),
+    addForm,
+    table(["Name", "UUID", "Enabled", "Usage GB", "Days left", "Mode", ""], rows),
+    h("h3", {}, "Protocols"),
+    h("div", { class: "toolbar" },
+      h("button", { class: "primary", onclick: run(async () => (await api("POST", "/api/v1/apply")).message, "Apply Hiddify configuration now?") }, "Apply configuration")),
+    table(["Protocol", "Key", "State", ""], protoRows),
+  ];
+}
+
+function modeSelect(current) {
+  return h("select", { name: "mode" }, ["no_reset", "daily", "weekly", "monthly"].map((m) => h("option", { value: m, selected: m === current }, m)));
+}
+
+function showUserLinks(user, links) {
+  modal(h("h2", {}, user.name),
+    qrLink("Hiddify (auto)", links.auto),
+    qrLink("Subscription b64", links.sub64),
+    qrLink("Sing-box", links.singbox),
+    h("p", {}, "Panel: ", h("code", {}, links.panel)));
+}
+
+function editUser(u) {
+  const form = h("form", { class: "row", onsubmit: run(async (ev) => {
+    const f = formData(ev.target);
+    const body = {};
+    if (f.name && f.name !== u.name) body.name = f.name;
+    if (f.mode !== u.mode) body.mode = f.mode;
+    if (f.days) body.days = Number(f.days);
+    if (f.gb) body.gb = Number(f.gb);
+    if (f.add_days) body.add_days = Number(f.add_days);
+    if (f.add_gb) body.add_gb = Number(f.add_gb);
+    if (f.reset_usage) body.reset_usage = true;
+    const res = await api("PATCH", "/api/v1/users/" + u.uuid, body);
+    document.getElementById("modal").close();
+    return `Updated ${res.user.name}: ` + res.changes.map((c) => c.field).join(", ");
+  }) },
+    h("label", {}, "Name", h("input", { name: "name", value: u.name })),
+    h("label", {}, "Mode", modeSelect(u.mode)),
+    h("label", {}, "Days", h("input", { name: "days", type: "number", min: 1, placeholder: String(u.package_days) })),
+    h("label", {}, "GB", h("input", { name: "gb", type: "number", min: 0, step: "any", placeholder: String(u.usage_limit_GB) })),
+    h("label", {}, "+ days", h("input", { name: "add_days", type: "number" })),
+    h("label", {}, "+ GB", h("input", { name: "add_gb", type: "number", step: "any" })),
+    h("label", {}, "Reset usage", h("input", { name: "reset_usage", type: "checkbox" })),
+    h("button", { class: "primary" }, "Save"));
+  modal(h("h2", {}, "Edit " + u.name), form);
+}
+
+// credentialUsers renders the shared SOCKS/TrustTunnel users section.
+function credentialUsers(kind, users, nameOf, onConfig) {
+  const base = `/api/v1/${kind}/users`;
+  const addForm = h("form", { class: "card row", onsubmit: run(async (ev) => {
+    const f = formData(ev.target);
+    const res = await api("POST", base, { name: f.name, password: f.password });
+    await onConfig(nameOf(res.user));
+    return `User created: ${nameOf(res.user)}` + (res.restart_warning ? " (" + res.restart_warning + ")" : "");
+  }) },
+    h("label", {}, "Name", h("input", { name: "name", required: true })),
+    h("label", {}, "Password", h("input", { name: "password", placeholder: "empty = generated" })),
+    h("button", { class: "primary" }, "Add user"));
+  const rows = users.map((u) => {
+    const name = nameOf(u);
+    const path = base + "/" + encodeURIComponent(name);
+    return h("tr", {},
+      h("td", {}, name),
+      h("td", { class: "muted" }, "••••••"),
+      h("td", { class: "actions" },
+        h("button", { onclick: run(() => onConfig(name)) }, "Config"),
+        h("button", { onclick: run(async () => {
+          const next = prompt("New name for " + name, name);
+          if (!next || next === name) return;
+          await api("PATCH", path, { name: next });
+          return `Renamed ${name} → ${next}`;
+        }) }, "Rename"),
+        h("button", { onclick: run(async () => {
+          const pass = prompt("New password for " + name + " (empty = cancel)");
+          if (!pass) return;
+          await api("PATCH", path, { password: pass });
+          return "Password changed for " + name;
+        }) }, "Password"),
+        h("button", { class: "danger", onclick: run(async () => { await api("DELETE", path); return "Deleted " + name; }, `Delete ${name}?`) }, "Delete")));
+  });
+  return [addForm, table(["Name", "Password", ""], rows)];
+}
+
+async function renderSocks() {
+  const [st, users] = await Promise.all([api("GET", "/api/v1/socks/status"), api("GET", "/api/v1/socks/users")]);
+  const showConfig = async (name) => {
+    const cfg = await api("GET", "/api/v1/socks/users/" + encodeURIComponent(name) + "/config");
+    modal(h("h2", {}, "SOCKS5: " + name), kvTable({ server: cfg.server, port: cfg.port, username: cfg.username, password: cfg.password }), qrLink("URI", cfg.uri));
+  };
+  return [
+    h("h2", {}, "SOCKS5 (Dante)"),
+    h("div", { class: "card" }, kvTable(st), h("div", { class: "toolbar" }, serviceButtons("socks"))),
+    credentialUsers("socks", users, (u) => u.name, showConfig),
+  ];
+}
+
+async function renderTrust() {
+  const [st, users] = await Promise.all([api("GET", "/api/v1/trust/status"), api("GET", "/api/v1/trust/users")]);
+  const showConfig = async (name) => {
+    const cfg = await api("GET", "/api/v1/trust/users/" + encodeURIComponent(name) + "/config");
+    const blob = URL.createObjectURL(new Blob([cfg.config], { type: "application/toml" }));
+    modal(h("h2", {}, "TrustTunnel: " + name), h("p", {}, "Endpoint: ", h("code", {}, cfg.address)),
+      h("pre", {}, cfg.config), h("a", { href: blob, download: name + ".toml" }, "Download " + name + ".toml"));
+  };
+  return [
+    h("h2", {}, "TrustTunnel"),
+    h("div", { class: "card" }, kvTable(st), h("div", { class: "toolbar" }, serviceButtons("trust"))),
+    credentialUsers("trust", users, (u) => u.username, showConfig),
+  ];
+}
+
+async function renderMTProxy() {
+  const st = await api("GET", "/api/v1/mtproxy/status");
+  let links = [];
+  try {
+    const cfg = await api("GET", "/api/v1/mtproxy/config");
+    links = [qrLink("tg://", cfg.tg_link), qrLink("Share URL", cfg.share_url)];
+  } catch (e) {
+    links = [h("p", { class: "muted" }, e.message)];
+  }
+  const secretForm = h("form", { class: "row", onsubmit: run(async (ev) => {
+    const f = formData(ev.target);
+    const res = await api("PUT", "/api/v1/mtproxy/secret", { secret: f.secret });
+    return "Secret updated: " + res.secret_masked + (res.restart_warning ? " (" + res.restart_warning + ")" : "");
+  }, "Change the MTProxy secret? Existing links stop working.") },
+    h("label", {}, "Secret", h("input", { name: "secret", placeholder: "empty = regenerate", size: 40 })),
+    h("button", {}, "Set secret"));
+  return [
+    h("h2", {}, "MTProxy"),
+    h("div", { class: "card" }, kvTable(st), h("div", { class: "toolbar" }, serviceButtons("mtproxy"))),
+    h("div", { class: "card" }, links, secretForm),
+  ];
+}
+
+const tabs = { status: renderStatus, hiddify: renderHiddify, socks: renderSocks, trust: renderTrust, mtproxy: renderMTProxy };
+
+async function render() {
+  const view = document.getElementById("view");
+  for (const b of document.querySelectorAll("#tabs button")) b.classList.toggle("active", b.dataset.tab === state.tab);
+  try {
+    view.replaceChildren(...(await tabs[state.tab]()));
+  } catch (e) {
+    view.replaceChildren(h("div", { class: "card bad" }, e.message));
+  }
+}
+
+// ---- session ----
+
+function showLogin(note) {
+  state.user = "";
+  document.getElementById("tabs").hidden = true;
+  document.getElementById("logout").hidden = true;
+  document.getElementById("whoami").textContent = "";
+  document.getElementById("view").replaceChildren();
+  document.getElementById("login").hidden = false;
+  document.getElementById("login-note").textContent = note || "";
+}
+
+function showApp() {
+  document.getElementById("login").hidden = true;
+  document.getElementById("tabs").hidden = false;
+  document.getElementById("logout").hidden = false;
+  document.getElementById("whoami").textContent = state.user;
+  render();
+}
+
+async function init() {
+  for (const b of document.querySelectorAll("#tabs button")) {
+    b.addEventListener("click", () => { state.tab = b.dataset.tab; render(); });
+  }
+  document.getElementById("logout").addEventListener("click", async () => {
+    try { await api("POST", "/ui/logout"); } catch (e) { /* session already gone */ }
+    const s = await api("GET", "/ui/session");
+    state.csrf = s.csrf;
+    showLogin();
+  });
+  document.getElementById("login-form").addEventListener("submit", async (ev) => {
+    ev.preventDefault();
+    try {
+      const s = await api("POST", "/ui/login", formData(ev.target));
+      state.csrf = s.csrf;
+      state.user = s.user;
+      ev.target.reset();
+      flash("");
+      showApp();
+    } catch (e) {
+      flash(e.message);
+    }
+  });
+  const s = await api("GET", "/ui/session");
+  state.csrf = s.csrf;
+  if (s.authenticated) {
+    state.user = s.user;
+    showApp();
+  } else {
+    showLogin(s.configured ? "" : "Web login is not configured yet: run `psasctl serve passwd` on the server.");
+  }
+}
+
+document.addEventListener("DOMContentLoaded", init);
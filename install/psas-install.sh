@@ -368,6 +368,11 @@ EOF
   systemctl enable --now danted
   systemctl restart danted
 
+  # Render per-user ACLs and the default block of internal networks.
+  if command -v psasctl >/dev/null 2>&1; then
+    psasctl socks acl apply || warn "psasctl socks acl apply failed; internal networks are not blocked for SOCKS users"
+  fi
+
   SOCKS_PUBLIC_IP="$(curl -4 -fsSL https://api.ipify.org 2>/dev/null || true)"
   if [[ -z "$SOCKS_PUBLIC_IP" ]]; then
     SOCKS_PUBLIC_IP="$(hostname -I 2>/dev/null | awk '{print $1}')"
@@ -442,6 +447,9 @@ backup_hiddify() {
   local dst="/root/backup-hiddify-before-psas-$(date +%F-%H%M%S)"
   cp -a /opt/hiddify-manager "$dst"
   info "Backup created: $dst"
+  if command -v psasctl >/dev/null 2>&1; then
+    psasctl backup create --keep 0 || warn "psasctl backup create failed"
+  fi
 }
 
 cleanup_legacy() {
@@ -471,6 +479,10 @@ write_sync_cert_script() {
 set -euo pipefail
 
 DOMAIN="${1:-vpn.example.com}"
+if command -v psasctl >/dev/null 2>&1; then
+  exec psasctl cert sync --domain "$DOMAIN" --notify
+fi
+
 SRC_CRT="/etc/letsencrypt/live/${DOMAIN}/fullchain.pem"
 SRC_KEY="/etc/letsencrypt/live/${DOMAIN}/privkey.pem"
 DST_DIR="/opt/hiddify-manager/ssl"